@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx/types"
+)
+
+const (
+	requestIDHeader     = "X-Request-ID"
+	requestIDContextKey = "requestId"
+
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+const (
+	AuditActionRegister = "register"
+	AuditActionSuspend  = "suspend"
+	AuditActionNotify   = "notify"
+)
+
+// RequestID tags every request with the caller's X-Request-ID or a generated one, echoed in the response
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if requestID == "" || len(requestID) > 100 {
+			buf := make([]byte, 16)
+			rand.Read(buf)
+			requestID = hex.EncodeToString(buf)
+		}
+
+		c.Set(requestIDContextKey, requestID)
+		c.Header(requestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// newAuditEntry creates an entry for the action attributed to the authenticated caller of the request
+func newAuditEntry(c *gin.Context, action string) *AuditEntry {
+	actor, actorRole := "", ""
+	if principal := GetPrincipal(c); principal != nil {
+		actor, actorRole = principal.Subject, principal.Role
+	}
+	return NewAuditEntry(actor, actorRole, action, c.GetString(requestIDContextKey))
+}
+
+func toAuditPayload(payload interface{}) types.JSONText {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return types.JSONText("null")
+	}
+	return types.JSONText(data)
+}
+
+func handleGetAuditLog(c *gin.Context, store *Store) {
+	filter := AuditFilter{
+		Actor:        c.Query("actor"),
+		Action:       c.Query("action"),
+		TeacherEmail: c.Query("teacher"),
+		StudentEmail: c.Query("student"),
+		Limit:        defaultAuditPageSize,
+	}
+
+	for param, dest := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := c.Query(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": param + " must be an RFC 3339 timestamp."})
+				return
+			}
+			*dest = &parsed
+		}
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxAuditPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "limit must be between 1 and " + strconv.Itoa(maxAuditPageSize) + "."})
+			return
+		}
+		filter.Limit = limit
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		beforeID, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || beforeID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "cursor is invalid."})
+			return
+		}
+		filter.BeforeID = beforeID
+	}
+
+	// Fetch one extra entry to know whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	entries, err := store.GetAuditEntries(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get audit log."})
+		return
+	}
+
+	response := AuditLogResponse{Entries: entries}
+	if len(entries) > limit {
+		response.Entries = entries[:limit]
+		cursor := strconv.FormatInt(entries[limit-1].ID, 10)
+		response.NextCursor = &cursor
+	}
+
+	c.JSON(http.StatusOK, response)
+}
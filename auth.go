@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	principalContextKey = "principal"
+	apiKeyHeader        = "X-API-Key"
+	apiKeyPrefix        = "gds_"
+
+	AuthMethodAPIKey = "api_key"
+	AuthMethodJWT    = "jwt"
+)
+
+// Principal is the authenticated caller attached to the Gin context by the auth middleware
+type Principal struct {
+	Subject string `json:"subject"`
+	Role    string `json:"role"`
+	Method  string `json:"method"`
+	KeyID   int64  `json:"keyId,omitempty"`
+	// School the caller belongs to, empty for admins who may act in any school and others of the default school
+	School string `json:"school,omitempty"`
+}
+
+type AuthConfig struct {
+	JWTHMACSecret string
+	JWKSFile      string
+	JWTIssuer     string
+	JWTAudience   string
+}
+
+func LoadAuthConfig() AuthConfig {
+	return AuthConfig{
+		JWTHMACSecret: GetEnvOrDefault("AUTH_JWT_HMAC_SECRET", ""),
+		JWKSFile:      GetEnvOrDefault("AUTH_JWKS_FILE", ""),
+		JWTIssuer:     GetEnvOrDefault("AUTH_JWT_ISSUER", ""),
+		JWTAudience:   GetEnvOrDefault("AUTH_JWT_AUDIENCE", ""),
+	}
+}
+
+type Authenticator struct {
+	store      *Store
+	jwtKeys    map[string]interface{}
+	hmacSecret []byte
+	parser     *jwt.Parser
+}
+
+func NewAuthenticator(store *Store, config AuthConfig) (*Authenticator, error) {
+	auth := &Authenticator{store: store}
+
+	if config.JWTHMACSecret != "" {
+		auth.hmacSecret = []byte(config.JWTHMACSecret)
+	}
+
+	if config.JWKSFile != "" {
+		keys, err := LoadJWKSFile(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		auth.jwtKeys = keys
+	}
+
+	parserOptions := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithExpirationRequired(),
+	}
+	if config.JWTIssuer != "" {
+		parserOptions = append(parserOptions, jwt.WithIssuer(config.JWTIssuer))
+	}
+	if config.JWTAudience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(config.JWTAudience))
+	}
+	auth.parser = jwt.NewParser(parserOptions...)
+
+	return auth, nil
+}
+
+// Middleware rejects requests without a valid API key or JWT bearer token
+func (auth *Authenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := auth.Authenticate(c.Request)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="api"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "message": "Authentication is required."})
+			return
+		}
+
+		c.Set(principalContextKey, principal)
+		c.Next()
+	}
+}
+
+func (auth *Authenticator) Authenticate(req *http.Request) (*Principal, error) {
+	if key := req.Header.Get(apiKeyHeader); key != "" {
+		return auth.authenticateAPIKey(key)
+	}
+
+	header := req.Header.Get("Authorization")
+	if header == "" {
+		return nil, errors.New("missing credentials")
+	}
+
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, errors.New("unsupported authorization scheme")
+	}
+
+	// API keys may also be sent as bearer tokens
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		return auth.authenticateAPIKey(token)
+	}
+
+	return auth.authenticateJWT(token)
+}
+
+func (auth *Authenticator) authenticateAPIKey(key string) (*Principal, error) {
+	apiKey, err := auth.store.GetActiveAPIKeyByHash(HashAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == nil {
+		return nil, errors.New("invalid or revoked API key")
+	}
+
+	return &Principal{Subject: apiKey.Subject, Role: apiKey.Role, Method: AuthMethodAPIKey, KeyID: apiKey.ID, School: apiKey.School}, nil
+}
+
+func (auth *Authenticator) authenticateJWT(tokenString string) (*Principal, error) {
+	if auth.hmacSecret == nil && auth.jwtKeys == nil {
+		return nil, errors.New("JWT authentication is not configured")
+	}
+
+	token, err := auth.parser.Parse(tokenString, auth.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := token.Claims.GetSubject()
+	if err != nil || subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	role, _ := claims["role"].(string)
+	if !IsValidRole(role) {
+		return nil, fmt.Errorf("token has an invalid role (%s)", role)
+	}
+
+	school, _ := claims["school"].(string)
+
+	return &Principal{Subject: subject, Role: role, Method: AuthMethodJWT, School: school}, nil
+}
+
+func (auth *Authenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if auth.hmacSecret == nil {
+			return nil, errors.New("HMAC signed tokens are not accepted")
+		}
+		return auth.hmacSecret, nil
+	default:
+		kid, _ := token.Header["kid"].(string)
+		key, exists := auth.jwtKeys[kid]
+		if !exists {
+			return nil, fmt.Errorf("unknown signing key (%s)", kid)
+		}
+		return key, nil
+	}
+}
+
+// GetPrincipal returns the caller attached by the auth middleware, or nil for unauthenticated routes
+func GetPrincipal(c *gin.Context) *Principal {
+	value, exists := c.Get(principalContextKey)
+	if !exists {
+		return nil
+	}
+	principal, _ := value.(*Principal)
+	return principal
+}
+
+// GenerateAPIKey returns a new random API key. Only its hash is ever stored.
+func GenerateAPIKey() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// API keys are high entropy so a plain SHA-256 digest is enough and allows lookups by hash
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWKSFile reads the public RSA and EC keys of a JWKS document, indexed by kid
+func LoadJWKSFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid JWKS file (%s): %w", path, err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, key := range jwks.Keys {
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key (%s) in JWKS file: %w", key.Kid, err)
+		}
+		keys[key.Kid] = publicKey
+	}
+
+	return keys, nil
+}
+
+func (key jwk) publicKey() (interface{}, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := decodeBase64BigInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBase64BigInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", key.Crv)
+		}
+		x, err := decodeBase64BigInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBase64BigInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", key.Kty)
+	}
+}
+
+func decodeBase64BigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestJWKS(t *testing.T, kid string, publicKey *rsa.PublicKey) string {
+	n := base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	jwks := fmt.Sprintf(`{"keys":[{"kid":%q,"kty":"RSA","alg":"RS256","n":%q,"e":%q}]}`, kid, n, e)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, []byte(jwks), 0o600))
+	return path
+}
+
+func TestAuthenticateJWTWithJWKS(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	auth, err := NewAuthenticator(nil, AuthConfig{JWKSFile: writeTestJWKS(t, "key-1", &privateKey.PublicKey), JWTIssuer: "sis"})
+	require.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub":  "teacher@example.com",
+		"role": RoleTeacher,
+		"iss":  "sis",
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "key-1"
+	tokenString, err := token.SignedString(privateKey)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+
+	principal, err := auth.Authenticate(req)
+	require.NoError(t, err)
+	require.Equal(t, &Principal{Subject: "teacher@example.com", Role: RoleTeacher, Method: AuthMethodJWT}, principal)
+}
+
+func TestAuthenticateJWTRejectsWrongIssuer(t *testing.T) {
+	auth, err := NewAuthenticator(nil, AuthConfig{JWTHMACSecret: "secret", JWTIssuer: "sis"})
+	require.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "teacher@example.com",
+		"iss": "someone-else",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	tokenString, _ := token.SignedString([]byte("secret"))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+
+	_, err = auth.Authenticate(req)
+	require.Error(t, err)
+}
+
+func TestAuthenticateJWTRejectsHMACWhenNotConfigured(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	auth, err := NewAuthenticator(nil, AuthConfig{JWKSFile: writeTestJWKS(t, "key-1", &privateKey.PublicKey)})
+	require.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "admin",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	tokenString, _ := token.SignedString([]byte("guessed"))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+
+	_, err = auth.Authenticate(req)
+	require.Error(t, err)
+}
+
+func TestAuthenticateRequiresCredentials(t *testing.T) {
+	auth, err := NewAuthenticator(nil, AuthConfig{})
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+
+	_, err = auth.Authenticate(req)
+	require.Error(t, err)
+}
+
+func TestAuthenticateJWTRejectsUnknownRole(t *testing.T) {
+	auth, err := NewAuthenticator(nil, AuthConfig{JWTHMACSecret: "secret"})
+	require.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":  "teacher@example.com",
+		"role": "superuser",
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	})
+	tokenString, _ := token.SignedString([]byte("secret"))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+
+	_, err = auth.Authenticate(req)
+	require.Error(t, err)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RoleAdmin   = "admin"
+	RoleTeacher = "teacher"
+	RoleAuditor = "auditor"
+	RoleStudent = "student"
+)
+
+var errForbidden = errors.New("forbidden")
+
+func IsValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleTeacher || role == RoleAuditor || role == RoleStudent
+}
+
+// RequireRole rejects callers whose role is not one of the given roles
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := GetPrincipal(c)
+		if principal == nil || !slices.Contains(roles, principal.Role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": errForbidden.Error(), "message": "You are not allowed to perform this action."})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// authorizeAsTeacher checks that a teacher principal only acts as themselves. Other roles are
+// already restricted by RequireRole. Writes a 403 response and returns false if the check fails.
+func authorizeAsTeacher(c *gin.Context, teacherEmail string) bool {
+	principal := GetPrincipal(c)
+	if principal == nil || principal.Role != RoleTeacher || principal.Subject == teacherEmail {
+		return true
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{"error": errForbidden.Error(), "message": fmt.Sprintf("Teachers may only act as themselves, not as %s.", teacherEmail)})
+	return false
+}
+
+// authorizeAsStudent checks that a student principal only reads their own notifications and preferences, the same way
+// as authorizeAsTeacher
+func authorizeAsStudent(c *gin.Context, studentEmail string) bool {
+	principal := GetPrincipal(c)
+	if principal == nil || principal.Role != RoleStudent || principal.Subject == studentEmail {
+		return true
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{"error": errForbidden.Error(), "message": fmt.Sprintf("Students may only act as themselves, not as %s.", studentEmail)})
+	return false
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Notifications are published to the streams of their students as soon as they are saved. The memory broker only
+// reaches streams of the same server, the postgres broker those of every server through LISTEN/NOTIFY.
+
+const (
+	notificationChannel = "student_notifications"
+	// Notifications a stream may fall behind by before it is closed, to resume from the database when the client
+	// reconnects
+	subscriptionBufferSize = 64
+	// pg_notify payloads must be shorter than 8000 bytes
+	notifyMaxIDs = 400
+)
+
+type NotificationBroker interface {
+	Publish(notifications []*StudentNotification) error
+	// Subscribe returns the notifications of the student published from now on until the subscription is closed
+	Subscribe(schoolID string, studentEmail string) *NotificationSubscription
+}
+
+type NotificationSubscription struct {
+	// Closed when the subscription falls behind
+	Notifications <-chan *StudentNotification
+	close         func()
+}
+
+func (subscription *NotificationSubscription) Close() {
+	subscription.close()
+}
+
+func NewNotificationBroker(backend string, store *Store, connStr string) (NotificationBroker, error) {
+	switch backend {
+	case "memory":
+		return NewMemoryBroker(), nil
+	case "postgres":
+		return NewPostgresBroker(store, connStr)
+	default:
+		return nil, fmt.Errorf("unknown notification broker: %s", backend)
+	}
+}
+
+// MemoryBroker delivers notifications to the subscribers of the server
+type MemoryBroker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan *StudentNotification]struct{}
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subscribers: map[string]map[chan *StudentNotification]struct{}{}}
+}
+
+func subscriptionKey(schoolID string, studentEmail string) string {
+	return schoolID + "/" + studentEmail
+}
+
+func (broker *MemoryBroker) Publish(notifications []*StudentNotification) error {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	for _, notification := range notifications {
+		key := subscriptionKey(notification.SchoolID, notification.StudentEmail)
+		for subscriber := range broker.subscribers[key] {
+			select {
+			case subscriber <- notification:
+			default:
+				// Never block publishers on a slow stream
+				broker.remove(key, subscriber)
+			}
+		}
+	}
+	return nil
+}
+
+func (broker *MemoryBroker) Subscribe(schoolID string, studentEmail string) *NotificationSubscription {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	key := subscriptionKey(schoolID, studentEmail)
+	subscriber := make(chan *StudentNotification, subscriptionBufferSize)
+	if broker.subscribers[key] == nil {
+		broker.subscribers[key] = map[chan *StudentNotification]struct{}{}
+	}
+	broker.subscribers[key][subscriber] = struct{}{}
+
+	return &NotificationSubscription{Notifications: subscriber, close: func() {
+		broker.mu.Lock()
+		defer broker.mu.Unlock()
+		broker.remove(key, subscriber)
+	}}
+}
+
+// remove closes the subscriber's channel unless it was already removed. The lock must be held.
+func (broker *MemoryBroker) remove(key string, subscriber chan *StudentNotification) {
+	if _, exists := broker.subscribers[key][subscriber]; !exists {
+		return
+	}
+	delete(broker.subscribers[key], subscriber)
+	if len(broker.subscribers[key]) == 0 {
+		delete(broker.subscribers, key)
+	}
+	close(subscriber)
+}
+
+// PostgresBroker notifies every server of the ids of the notifications published, and each server delivers them to
+// its own subscribers once loaded
+type PostgresBroker struct {
+	store *Store
+	local *MemoryBroker
+}
+
+func NewPostgresBroker(store *Store, connStr string) (*PostgresBroker, error) {
+	listener := pq.NewListener(connStr, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Notification listener: %v", err)
+		}
+	})
+	if err := listener.Listen(notificationChannel); err != nil {
+		return nil, err
+	}
+
+	broker := &PostgresBroker{store: store, local: NewMemoryBroker()}
+	go broker.listen(listener)
+	return broker, nil
+}
+
+func (broker *PostgresBroker) Publish(notifications []*StudentNotification) error {
+	for start := 0; start < len(notifications); start += notifyMaxIDs {
+		ids := []int64{}
+		for _, notification := range notifications[start:min(start+notifyMaxIDs, len(notifications))] {
+			ids = append(ids, notification.ID)
+		}
+		payload, err := json.Marshal(ids)
+		if err != nil {
+			return err
+		}
+		if _, err := broker.store.db.Exec("SELECT pg_notify($1, $2)", notificationChannel, string(payload)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (broker *PostgresBroker) Subscribe(schoolID string, studentEmail string) *NotificationSubscription {
+	return broker.local.Subscribe(schoolID, studentEmail)
+}
+
+func (broker *PostgresBroker) listen(listener *pq.Listener) {
+	for notification := range listener.Notify {
+		// nil after reconnecting, notifications sent in the meantime are resumed from the database by the clients
+		if notification == nil {
+			continue
+		}
+		var ids []int64
+		if err := json.Unmarshal([]byte(notification.Extra), &ids); err != nil {
+			log.Printf("Invalid notification payload: %v", err)
+			continue
+		}
+		notifications, err := broker.store.GetStudentNotificationsByID(ids)
+		if err != nil {
+			log.Printf("Failed to load published notifications: %v", err)
+			continue
+		}
+		broker.local.Publish(notifications)
+	}
+}
+
+// bufferedBroker holds the notifications published in a transaction until it is committed
+type bufferedBroker struct {
+	NotificationBroker
+	mu            sync.Mutex
+	notifications []*StudentNotification
+}
+
+func (broker *bufferedBroker) Publish(notifications []*StudentNotification) error {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	broker.notifications = append(broker.notifications, notifications...)
+	return nil
+}
+
+func (broker *bufferedBroker) flush() {
+	if len(broker.notifications) == 0 {
+		return
+	}
+	if err := broker.NotificationBroker.Publish(broker.notifications); err != nil {
+		log.Printf("Failed to publish notifications: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBroker(t *testing.T) {
+	broker := NewMemoryBroker()
+	subscription := broker.Subscribe(DefaultSchoolID, "student1@example.com")
+	other := broker.Subscribe("other", "student1@example.com")
+	defer other.Close()
+
+	notification := &StudentNotification{ID: 1, SchoolID: DefaultSchoolID, StudentEmail: "student1@example.com", Notification: "Hello"}
+	require.NoError(t, broker.Publish([]*StudentNotification{
+		notification,
+		{ID: 2, SchoolID: DefaultSchoolID, StudentEmail: "student2@example.com", Notification: "Hello"},
+	}))
+	require.Equal(t, notification, <-subscription.Notifications)
+	require.Empty(t, other.Notifications)
+
+	// Closing twice is fine, and nothing is published to closed subscriptions
+	subscription.Close()
+	subscription.Close()
+	require.NoError(t, broker.Publish([]*StudentNotification{notification}))
+	_, open := <-subscription.Notifications
+	require.False(t, open)
+}
+
+func TestMemoryBrokerClosesSlowSubscriptions(t *testing.T) {
+	broker := NewMemoryBroker()
+	subscription := broker.Subscribe(DefaultSchoolID, "student1@example.com")
+
+	for i := 0; i <= subscriptionBufferSize; i++ {
+		require.NoError(t, broker.Publish([]*StudentNotification{{ID: int64(i), SchoolID: DefaultSchoolID, StudentEmail: "student1@example.com"}}))
+	}
+	received := 0
+	for range subscription.Notifications {
+		received++
+	}
+	require.Equal(t, subscriptionBufferSize, received)
+	subscription.Close()
+}
+
+func TestWithTxPublishesOnCommit(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	broker := NewMemoryBroker()
+	store := &Store{db: db, school: DefaultSchoolID, broker: broker}
+	subscription := broker.Subscribe(DefaultSchoolID, "student1@example.com")
+	defer subscription.Close()
+	notification := &StudentNotification{ID: 1, SchoolID: DefaultSchoolID, StudentEmail: "student1@example.com"}
+
+	// Rolled back notifications are never published
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	err := store.WithTx(func(txStore *Store) error {
+		require.NoError(t, txStore.broker.Publish([]*StudentNotification{notification}))
+		return errors.New("failed")
+	})
+	require.Error(t, err)
+	require.Empty(t, subscription.Notifications)
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	err = store.WithTx(func(txStore *Store) error {
+		require.NoError(t, txStore.broker.Publish([]*StudentNotification{notification}))
+		require.Empty(t, subscription.Notifications)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, notification, <-subscription.Notifications)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Classes group the students of a teacher, or of several teachers who share them. Registering a student to a
+// teacher without a class enrolls them in the teacher's default class.
+
+const (
+	AuditActionCreateClass = "create_class"
+	AuditActionUpdateClass = "update_class"
+	AuditActionDeleteClass = "delete_class"
+	AuditActionEnroll      = "enroll"
+	AuditActionUnenroll    = "unenroll"
+)
+
+func registerClassRoutes(v2 *gin.RouterGroup, store *Store, reads gin.HandlerFunc, writes gin.HandlerFunc) {
+	v2.GET("/classes", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleListClasses, store))
+	v2.POST("/classes", writes, RequireRole(RoleAdmin, RoleTeacher), makeHandleFunc(handleCreateClass, store))
+	v2.GET("/classes/:code", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleGetClass, store))
+	v2.PATCH("/classes/:code", writes, RequireRole(RoleAdmin, RoleTeacher), makeHandleFunc(handleUpdateClass, store))
+	v2.DELETE("/classes/:code", writes, RequireRole(RoleAdmin, RoleTeacher), makeHandleFunc(handleDeleteClass, store))
+	v2.GET("/classes/:code/students", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), RequireTerm(store), makeHandleFunc(handleListStudentsOfClass, store))
+	v2.PUT("/classes/:code/students/:student", writes, RequireRole(RoleAdmin, RoleTeacher), RequireTerm(store), makeHandleFunc(handleEnrollStudent, store))
+	v2.DELETE("/classes/:code/students/:student", writes, RequireRole(RoleAdmin, RoleTeacher), RequireTerm(store), makeHandleFunc(handleUnenrollStudent, store))
+}
+
+// validateClassTerm checks that the term of a class is one of the school's terms. Classes may have no term.
+func validateClassTerm(store *Store, code string) *apiError {
+	if code == "" {
+		return nil
+	}
+	term, err := store.GetTerm(code)
+	if err != nil {
+		return newAPIError(http.StatusInternalServerError, err, "failed to get term.")
+	}
+	if term == nil {
+		return newAPIError(http.StatusBadRequest, errNotFound, fmt.Sprintf("Term (%s) does not exist.", code))
+	}
+	return nil
+}
+
+// authorizeClass checks that a teacher principal teaches the class. Other roles are already restricted by RequireRole.
+func authorizeClass(c *gin.Context, class *Class) *apiError {
+	principal := GetPrincipal(c)
+	if principal == nil || principal.Role != RoleTeacher || slices.Contains(class.Teachers, principal.Subject) {
+		return nil
+	}
+	return &apiError{Status: http.StatusForbidden, Message: fmt.Sprintf("Teachers may only access their own classes, not %s.", class.Code), Err: errForbidden}
+}
+
+// getAuthorizedClass returns the class if it exists and the caller may access it
+func getAuthorizedClass(c *gin.Context, store *Store, code string) (*Class, *apiError) {
+	class, err := store.GetClass(code)
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, err, "failed to get class.")
+	}
+	if class == nil {
+		return nil, newAPIError(http.StatusNotFound, errNotFound, "Given class does not exist.")
+	}
+	if apiErr := authorizeClass(c, class); apiErr != nil {
+		return nil, apiErr
+	}
+	return class, nil
+}
+
+// validateClassTeachers returns the teachers of a class sorted and without duplicates
+func validateClassTeachers(c *gin.Context, teacherEmails []string) ([]string, *apiError) {
+	if len(teacherEmails) == 0 {
+		return nil, newAPIError(http.StatusBadRequest, nil, "A class needs at least one teacher.")
+	}
+	for _, teacherEmail := range teacherEmails {
+		if !IsValidEmail(teacherEmail) {
+			return nil, newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("A teacher's email (%s) is invalid.", teacherEmail))
+		}
+	}
+	if apiErr := checkAllowedDomains(c, teacherEmails...); apiErr != nil {
+		return nil, apiErr
+	}
+
+	// Teachers may not create classes they cannot access afterwards
+	if principal := GetPrincipal(c); principal != nil && principal.Role == RoleTeacher && !slices.Contains(teacherEmails, principal.Subject) {
+		return nil, &apiError{Status: http.StatusForbidden, Message: "Teachers must be one of the teachers of their classes.", Err: errForbidden}
+	}
+
+	teacherEmails = slices.Clone(teacherEmails)
+	slices.Sort(teacherEmails)
+	return slices.Compact(teacherEmails), nil
+}
+
+func toTeachers(emails []string) []*Teacher {
+	teachers := []*Teacher{}
+	for _, email := range emails {
+		teachers = append(teachers, NewTeacher(email))
+	}
+	return teachers
+}
+
+func handleListClasses(c *gin.Context, store *Store) {
+	teacherEmail := c.Query("teacher")
+	if principal := GetPrincipal(c); principal != nil && principal.Role == RoleTeacher {
+		if teacherEmail == "" {
+			teacherEmail = principal.Subject
+		}
+		if !authorizeAsTeacher(c, teacherEmail) {
+			return
+		}
+	}
+
+	classes, err := store.ListClasses(teacherEmail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to list classes."})
+		return
+	}
+
+	c.JSON(http.StatusOK, ClassesResponse{Classes: classes})
+}
+
+func handleCreateClass(c *gin.Context, store *Store) {
+	var input CreateClassRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+	if !IsValidClassCode(input.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "code must be 1 to 50 letters, digits, dots, dashes or underscores."})
+		return
+	}
+	teacherEmails, apiErr := validateClassTeachers(c, input.Teachers)
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	class := NewClass(input.Code, input.Name, input.Term, teacherEmails)
+	entry := newAuditEntry(c, AuditActionCreateClass)
+	entry.After = toAuditPayload(class)
+
+	apiErr = withAudit(store, entry, func(txStore *Store) *apiError {
+		if apiErr := validateClassTerm(txStore, class.Term); apiErr != nil {
+			return apiErr
+		}
+		if err := txStore.AddTeachers(toTeachers(class.Teachers)); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to add teachers.")
+		}
+		if err := txStore.AddClass(class); err != nil {
+			if IsUniqueViolation(err) {
+				return newAPIError(http.StatusConflict, err, fmt.Sprintf("Class (%s) already exists.", class.Code))
+			}
+			return newAPIError(http.StatusInternalServerError, err, "failed to add class.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusCreated, class)
+}
+
+func handleGetClass(c *gin.Context, store *Store) {
+	class, apiErr := getAuthorizedClass(c, store, c.Param("code"))
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, class)
+}
+
+func handleUpdateClass(c *gin.Context, store *Store) {
+	var input UpdateClassRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+	if input.Teachers != nil {
+		teacherEmails, apiErr := validateClassTeachers(c, input.Teachers)
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+		input.Teachers = teacherEmails
+	}
+
+	entry := newAuditEntry(c, AuditActionUpdateClass)
+
+	var class *Class
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		var apiErr *apiError
+		class, apiErr = getAuthorizedClass(c, txStore, c.Param("code"))
+		if apiErr != nil {
+			return apiErr
+		}
+		if class.IsDefault {
+			return newAPIError(http.StatusBadRequest, nil, "Default classes cannot be changed.")
+		}
+		entry.Before = toAuditPayload(class)
+
+		if input.Name != nil {
+			class.Name = *input.Name
+		}
+		if input.Term != nil {
+			if apiErr := validateClassTerm(txStore, *input.Term); apiErr != nil {
+				return apiErr
+			}
+			class.Term = *input.Term
+		}
+		if _, err := txStore.UpdateClass(class); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to update class.")
+		}
+
+		if input.Teachers != nil {
+			if err := txStore.AddTeachers(toTeachers(input.Teachers)); err != nil {
+				return newAPIError(http.StatusInternalServerError, err, "failed to add teachers.")
+			}
+			if err := txStore.SetClassTeachers(class.Code, input.Teachers); err != nil {
+				return newAPIError(http.StatusInternalServerError, err, "failed to update class teachers.")
+			}
+			class.Teachers = input.Teachers
+		}
+
+		entry.After = toAuditPayload(class)
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, class)
+}
+
+func handleDeleteClass(c *gin.Context, store *Store) {
+	entry := newAuditEntry(c, AuditActionDeleteClass)
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		class, apiErr := getAuthorizedClass(c, txStore, c.Param("code"))
+		if apiErr != nil {
+			return apiErr
+		}
+		if class.IsDefault {
+			return newAPIError(http.StatusBadRequest, nil, "Default classes are deleted along with their teacher.")
+		}
+
+		students, err := txStore.GetStudentsOfClass(class.Code)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to get students of class.")
+		}
+
+		if _, err := txStore.DeleteClass(class.Code); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to delete class.")
+		}
+
+		entry.StudentEmails = students
+		entry.Before = toAuditPayload(gin.H{"class": class, "students": students})
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func handleListStudentsOfClass(c *gin.Context, store *Store) {
+	class, apiErr := getAuthorizedClass(c, store, c.Param("code"))
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	filter, ok := parseProfileFilter(c)
+	if !ok {
+		return
+	}
+
+	studentEmails, err := store.GetStudentsOfClass(class.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get students of class."})
+		return
+	}
+
+	filter.Emails = studentEmails
+	students, err := store.ListStudents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to list students."})
+		return
+	}
+
+	c.JSON(http.StatusOK, StudentsResponse{Students: students})
+}
+
+func handleEnrollStudent(c *gin.Context, store *Store) {
+	enrollment := NewEnrollment(c.Param("code"), c.Param("student"))
+	if !IsValidEmail(enrollment.StudentEmail) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Student's email (%s) is invalid.", enrollment.StudentEmail)})
+		return
+	}
+	if apiErr := checkAllowedDomains(c, enrollment.StudentEmail); apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	entry := newAuditEntry(c, AuditActionEnroll)
+	entry.StudentEmails = []string{enrollment.StudentEmail}
+	entry.After = toAuditPayload(enrollment)
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		class, apiErr := getAuthorizedClass(c, txStore, enrollment.ClassCode)
+		if apiErr != nil {
+			return apiErr
+		}
+
+		if err := txStore.AddStudent(NewStudent(enrollment.StudentEmail)); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to add student.")
+		}
+		if err := txStore.Enroll([]*Enrollment{enrollment}); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to enroll student.")
+		}
+		if err := enqueueEnrollmentWebhookEvents(txStore, []*Class{class}, []*Enrollment{enrollment}); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to queue webhook event.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// enqueueEnrollmentWebhookEvents queues a student.registered event for each teacher of the classes, as students
+// enrolled in a class are registered to its teachers
+func enqueueEnrollmentWebhookEvents(store *Store, classes []*Class, enrollments []*Enrollment) error {
+	studentsByClass := map[string][]string{}
+	for _, enrollment := range enrollments {
+		studentsByClass[enrollment.ClassCode] = append(studentsByClass[enrollment.ClassCode], enrollment.StudentEmail)
+	}
+
+	for _, class := range classes {
+		students, exists := studentsByClass[class.Code]
+		if !exists {
+			continue
+		}
+
+		for _, teacherEmail := range class.Teachers {
+			registration := WebhookRegistrationData{Teacher: teacherEmail, Students: students}
+			if !class.IsDefault {
+				registration.Class = class.Code
+			}
+			if err := enqueueWebhookEvent(store, WebhookEventStudentRegistered, registration); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func handleUnenrollStudent(c *gin.Context, store *Store) {
+	enrollment := NewEnrollment(c.Param("code"), c.Param("student"))
+
+	entry := newAuditEntry(c, AuditActionUnenroll)
+	entry.StudentEmails = []string{enrollment.StudentEmail}
+	entry.Before = toAuditPayload(enrollment)
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		if _, apiErr := getAuthorizedClass(c, txStore, enrollment.ClassCode); apiErr != nil {
+			return apiErr
+		}
+
+		unenrolled, err := txStore.Unenroll(enrollment)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to unenroll student.")
+		}
+		if !unenrolled {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given student is not enrolled in the class.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Admin commands run against the store instead of starting the server, e.g. `./main apikey create -name ci -subject admin -role admin`
+func runCommand(args []string, store *Store, out io.Writer) error {
+	switch args[0] {
+	case "apikey":
+		return runAPIKeyCommand(args[1:], store, out)
+	case "school":
+		return runSchoolCommand(args[1:], store, out)
+	default:
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+}
+
+func runAPIKeyCommand(args []string, store *Store, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: apikey <create|revoke|list>")
+	}
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		name := flags.String("name", "", "human readable name of the key")
+		subject := flags.String("subject", "", "principal the key authenticates as, the teacher's or student's email for teacher and student keys")
+		role := flags.String("role", "", "one of admin, teacher, auditor or student")
+		school := flags.String("school", "", "school the key is limited to, empty for an admin key that may act in any school or the default school for other roles")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *name == "" || *subject == "" || !IsValidRole(*role) {
+			return errors.New("usage: apikey create -name <name> -subject <subject> -role <admin|teacher|auditor|student> [-school <id>]")
+		}
+		// Only admins may act in any school
+		if *school == "" && *role != RoleAdmin {
+			*school = DefaultSchoolID
+		}
+		if *school != "" {
+			existing, err := store.GetSchool(*school)
+			if err != nil {
+				return err
+			}
+			if existing == nil {
+				return fmt.Errorf("no school with id %s", *school)
+			}
+		}
+
+		key, err := GenerateAPIKey()
+		if err != nil {
+			return err
+		}
+		apiKey := NewAPIKey(*name, *subject, *role, key)
+		apiKey.School = *school
+		if err := store.AddAPIKey(apiKey); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "Created %s API key %d for %s. Store it now, it cannot be shown again:\n%s\n", apiKey.Role, apiKey.ID, apiKey.Subject, key)
+		return nil
+
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New("usage: apikey revoke <id>")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid API key id (%s)", args[1])
+		}
+
+		revoked, err := store.RevokeAPIKey(id)
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return fmt.Errorf("no active API key with id %d", id)
+		}
+
+		fmt.Fprintf(out, "Revoked API key %d\n", id)
+		return nil
+
+	case "list":
+		apiKeys, err := store.ListAPIKeys()
+		if err != nil {
+			return err
+		}
+
+		writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tNAME\tSUBJECT\tROLE\tSCHOOL\tPREFIX\tCREATED\tREVOKED")
+		for _, apiKey := range apiKeys {
+			revokedAt := "-"
+			if apiKey.RevokedAt != nil {
+				revokedAt = apiKey.RevokedAt.Format(time.RFC3339)
+			}
+			school := apiKey.School
+			if school == "" {
+				school = "*"
+			}
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", apiKey.ID, apiKey.Name, apiKey.Subject, apiKey.Role, school, apiKey.KeyPrefix, apiKey.CreatedAt.Format(time.RFC3339), revokedAt)
+		}
+		return writer.Flush()
+
+	default:
+		return fmt.Errorf("unknown apikey command: %s", args[0])
+	}
+}
+
+func runSchoolCommand(args []string, store *Store, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: school <create|list>")
+	}
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("school create", flag.ContinueOnError)
+		id := flags.String("id", "", "short identifier of the school, sent in the X-School-ID header")
+		name := flags.String("name", "", "human readable name of the school")
+		domains := flags.String("domains", "", "comma separated email domains of the school's teachers and students, any domain if empty")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if !IsValidSchoolID(*id) {
+			return errors.New("usage: school create -id <id> [-name <name>] [-domains <domain,...>]")
+		}
+
+		allowedDomains := []string{}
+		for _, domain := range strings.Split(*domains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				allowedDomains = append(allowedDomains, domain)
+			}
+		}
+
+		school := NewSchool(*id, *name, allowedDomains)
+		if err := store.AddSchool(school); err != nil {
+			if IsUniqueViolation(err) {
+				return fmt.Errorf("school %s already exists", school.ID)
+			}
+			return err
+		}
+
+		fmt.Fprintf(out, "Created school %s\n", school.ID)
+		return nil
+
+	case "list":
+		schools, err := store.ListSchools()
+		if err != nil {
+			return err
+		}
+
+		writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tNAME\tALLOWED DOMAINS")
+		for _, school := range schools {
+			allowedDomains := "*"
+			if len(school.AllowedDomains) > 0 {
+				allowedDomains = strings.Join(school.AllowedDomains, ",")
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", school.ID, school.Name, allowedDomains)
+		}
+		return writer.Flush()
+
+	default:
+		return fmt.Errorf("unknown school command: %s", args[0])
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	InclusionReasonRegistered = "registered"
+	InclusionReasonClass      = "class"
+	InclusionReasonMentioned  = "mentioned"
+	// Students of a mentioned class or teacher, or of a group mention
+	InclusionReasonMentionedClass   = "mentioned_class"
+	InclusionReasonMentionedTeacher = "mentioned_teacher"
+	InclusionReasonMentionedGroup   = "mentioned_group"
+	// Unknown students mentioned and added because of unknownMentions auto_register
+	InclusionReasonMentionRegistered = "mention_registered"
+
+	ExclusionReasonSuspended           = "suspended"
+	ExclusionReasonUnregisteredMention = "unregistered_mention"
+)
+
+// explainRecipients explains, for every student registered to the teacher or in the class and every student
+// mentioned directly or through a class, teacher or group, why they are or are not a recipient of the notification.
+// Unknown mentions are explained as the unknownMentions policy handled them: registered or skipped.
+func explainRecipients(store *Store, audience *NotificationAudience, registeredMentions []string, unknownMentions []string, excluded []*ExcludedRecipient) ([]*RecipientExplanation, error) {
+	var rosterEmails []string
+	var err error
+	rosterReason := InclusionReasonRegistered
+	if audience.Class != "" {
+		rosterEmails, err = store.GetStudentsOfClass(audience.Class)
+		rosterReason = InclusionReasonClass
+	} else {
+		rosterEmails, err = store.GetStudentsOfTeacher(audience.Teacher)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	explanations := map[string]*RecipientExplanation{}
+	explain := func(email string) *RecipientExplanation {
+		explanation, ok := explanations[email]
+		if !ok {
+			explanation = &RecipientExplanation{Email: email, InclusionReasons: []string{}, ExclusionReasons: []*ExclusionReason{}}
+			explanations[email] = explanation
+		}
+		return explanation
+	}
+	include := func(emails []string, reason string) {
+		for _, email := range emails {
+			if explanation := explain(email); !slices.Contains(explanation.InclusionReasons, reason) {
+				explanation.InclusionReasons = append(explanation.InclusionReasons, reason)
+			}
+		}
+	}
+
+	include(rosterEmails, rosterReason)
+	include(audience.Students, InclusionReasonMentioned)
+	include(registeredMentions, InclusionReasonMentionRegistered)
+	for _, classCode := range audience.Classes {
+		studentEmails, err := store.GetStudentsOfClass(classCode)
+		if err != nil {
+			return nil, err
+		}
+		include(studentEmails, InclusionReasonMentionedClass)
+	}
+	for _, teacherEmail := range audience.Teachers {
+		studentEmails, err := store.GetStudentsOfTeacher(NewTeacher(teacherEmail))
+		if err != nil {
+			return nil, err
+		}
+		include(studentEmails, InclusionReasonMentionedTeacher)
+	}
+	if audience.AllStudents {
+		students, err := store.ListStudents(ProfileFilter{})
+		if err != nil {
+			return nil, err
+		}
+		for _, student := range students {
+			include([]string{student.Email}, InclusionReasonMentionedGroup)
+		}
+	}
+	for _, email := range unknownMentions {
+		if explanation := explain(email); len(explanation.ExclusionReasons) == 0 {
+			explanation.ExclusionReasons = append(explanation.ExclusionReasons, &ExclusionReason{Reason: ExclusionReasonUnregisteredMention})
+		}
+	}
+
+	knownEmails := []string{}
+	for email, explanation := range explanations {
+		if len(explanation.InclusionReasons) > 0 {
+			knownEmails = append(knownEmails, email)
+		}
+	}
+	suspensions, err := store.GetActiveSuspensions(knownEmails, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	for email, suspension := range suspensions {
+		explanation := explain(email)
+		explanation.ExclusionReasons = append(explanation.ExclusionReasons, &ExclusionReason{Reason: ExclusionReasonSuspended, Until: suspension.SuspendedUntil})
+	}
+	for _, excludedRecipient := range excluded {
+		explanation := explain(excludedRecipient.Email)
+		explanation.ExclusionReasons = append(explanation.ExclusionReasons, &ExclusionReason{Reason: excludedRecipient.Reason})
+	}
+
+	sorted := []*RecipientExplanation{}
+	for _, explanation := range explanations {
+		explanation.Included = len(explanation.ExclusionReasons) == 0
+		sorted = append(sorted, explanation)
+	}
+	slices.SortFunc(sorted, func(a *RecipientExplanation, b *RecipientExplanation) int {
+		return strings.Compare(a.Email, b.Email)
+	})
+	return sorted, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	exportFormatCSV   = "csv"
+	exportFormatJSONL = "jsonl"
+	exportFormatXLSX  = "xlsx"
+
+	mimeCSV   = "text/csv"
+	mimeJSONL = "application/x-ndjson"
+	mimeXLSX  = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+	// Rows written between flushes of the response, so that clients start receiving data early
+	exportFlushInterval = 500
+)
+
+var exportFormatMimeTypes = map[string]string{
+	exportFormatCSV:   mimeCSV,
+	exportFormatJSONL: mimeJSONL,
+	exportFormatXLSX:  mimeXLSX,
+}
+
+// exportWriter writes each record as a row of values (CSV, XLSX) or as the record itself (JSONL)
+type exportWriter interface {
+	Write(record interface{}, values []string) error
+	Close() error
+}
+
+type exportDataset struct {
+	columns []string
+	stream  func(store *Store, teacherEmails []string, write func(record interface{}, values []string) error) error
+}
+
+var exportDatasets = map[string]exportDataset{
+	"registrations": {
+		columns: []string{"teacher", "student"},
+		stream: func(store *Store, teacherEmails []string, write func(record interface{}, values []string) error) error {
+			return store.StreamRegistrations(teacherEmails, func(pair *TeacherStudentPair) error {
+				return write(pair, []string{pair.TeacherEmail, pair.StudentEmail})
+			})
+		},
+	},
+	"students": {
+		columns: []string{"student", "suspended", "suspended_until"},
+		stream: func(store *Store, teacherEmails []string, write func(record interface{}, values []string) error) error {
+			return store.StreamStudents(teacherEmails, func(student *StudentExportRow) error {
+				return write(student, []string{student.Email, strconv.FormatBool(student.Suspended), formatExportTime(student.SuspendedUntil)})
+			})
+		},
+	},
+	"notifications": {
+		columns: []string{"sent_at", "teacher", "notification", "recipients"},
+		stream: func(store *Store, teacherEmails []string, write func(record interface{}, values []string) error) error {
+			return store.StreamNotifications(teacherEmails, func(notification *NotificationExportRow) error {
+				return write(notification, []string{formatExportTime(&notification.SentAt), notification.TeacherEmail, notification.Notification, strings.Join(notification.Recipients, ";")})
+			})
+		},
+	},
+}
+
+// handleExport streams a dataset in the format given by the format query param or, failing that, the Accept header
+func handleExport(c *gin.Context, store *Store) {
+	datasetName := c.DefaultQuery("dataset", "registrations")
+	dataset, ok := exportDatasets[datasetName]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "dataset must be one of registrations, students or notifications."})
+		return
+	}
+
+	format := c.Query("format")
+	if format == "" {
+		switch c.NegotiateFormat(mimeCSV, mimeJSONL, mimeXLSX) {
+		case mimeCSV:
+			format = exportFormatCSV
+		case mimeJSONL:
+			format = exportFormatJSONL
+		case mimeXLSX:
+			format = exportFormatXLSX
+		default:
+			c.JSON(http.StatusNotAcceptable, gin.H{"message": fmt.Sprintf("Accept must allow one of %s, %s or %s.", mimeCSV, mimeJSONL, mimeXLSX)})
+			return
+		}
+	}
+	if _, ok := exportFormatMimeTypes[format]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "format must be one of csv, jsonl or xlsx."})
+		return
+	}
+
+	teacherEmails := c.QueryArray("teacher")
+	for _, teacherEmail := range teacherEmails {
+		if !IsValidEmail(teacherEmail) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Teacher's email (%s) is invalid.", teacherEmail)})
+			return
+		}
+		if !authorizeAsTeacher(c, teacherEmail) {
+			return
+		}
+	}
+
+	// Teachers only ever export their own data
+	if principal := GetPrincipal(c); principal != nil && principal.Role == RoleTeacher && len(teacherEmails) == 0 {
+		teacherEmails = []string{principal.Subject}
+	}
+
+	// Registrations, and the students of teachers, belong to a term
+	if datasetName == "registrations" || (datasetName == "students" && len(teacherEmails) > 0) {
+		if apiErr := requireTerm(store); apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+	}
+
+	c.Header("Content-Type", exportFormatMimeTypes[format])
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, datasetName, format))
+
+	writer, err := newExportWriter(format, c.Writer, dataset.columns)
+	if err == nil {
+		rowsWritten := 0
+		err = dataset.stream(store, teacherEmails, func(record interface{}, values []string) error {
+			if err := writer.Write(record, values); err != nil {
+				return err
+			}
+			rowsWritten++
+			if rowsWritten%exportFlushInterval == 0 {
+				c.Writer.Flush()
+			}
+			return nil
+		})
+		err = errors.Join(err, writer.Close())
+	}
+
+	if err != nil {
+		// Once the body has started the status can no longer change, so the truncated export is only logged
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to export " + datasetName + "."})
+			return
+		}
+		log.Printf("Failed to export %s: %v", datasetName, err)
+		c.Abort()
+	}
+}
+
+func formatExportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func newExportWriter(format string, w io.Writer, columns []string) (exportWriter, error) {
+	switch format {
+	case exportFormatJSONL:
+		return &jsonlExportWriter{encoder: json.NewEncoder(w)}, nil
+	case exportFormatXLSX:
+		return newXLSXExportWriter(w, columns)
+	default:
+		return newCSVExportWriter(w, columns)
+	}
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVExportWriter(w io.Writer, columns []string) (*csvExportWriter, error) {
+	writer := &csvExportWriter{writer: csv.NewWriter(w)}
+	return writer, writer.writer.Write(columns)
+}
+
+// Write prefixes cells that spreadsheets would run as formulas with a quote, so exported notifications cannot inject
+// formulas into the spreadsheet of whoever opens the export
+func (writer *csvExportWriter) Write(record interface{}, values []string) error {
+	escaped := make([]string, len(values))
+	for i, value := range values {
+		escaped[i] = escapeCSVFormula(value)
+	}
+	return writer.writer.Write(escaped)
+}
+
+func escapeCSVFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (writer *csvExportWriter) Close() error {
+	writer.writer.Flush()
+	return writer.writer.Error()
+}
+
+type jsonlExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (writer *jsonlExportWriter) Write(record interface{}, values []string) error {
+	return writer.encoder.Encode(record)
+}
+
+func (writer *jsonlExportWriter) Close() error {
+	return nil
+}
+
+// xlsxExportWriter uses excelize's stream writer, which spills rows to a temporary file instead of keeping them in
+// memory. The workbook can only be written out once complete, so nothing is sent until Close.
+type xlsxExportWriter struct {
+	w      io.Writer
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	row    int
+}
+
+func newXLSXExportWriter(w io.Writer, columns []string) (*xlsxExportWriter, error) {
+	file := excelize.NewFile()
+	stream, err := file.NewStreamWriter("Sheet1")
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	writer := &xlsxExportWriter{w: w, file: file, stream: stream}
+	if err := writer.Write(nil, columns); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (writer *xlsxExportWriter) Write(record interface{}, values []string) error {
+	writer.row++
+	cell, err := excelize.CoordinatesToCellName(1, writer.row)
+	if err != nil {
+		return err
+	}
+
+	row := make([]interface{}, len(values))
+	for i, value := range values {
+		row[i] = value
+	}
+	return writer.stream.SetRow(cell, row)
+}
+
+func (writer *xlsxExportWriter) Close() error {
+	defer writer.file.Close()
+
+	if err := writer.stream.Flush(); err != nil {
+		return err
+	}
+	_, err := writer.file.WriteTo(writer.w)
+	return err
+}
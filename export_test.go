@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xuri/excelize/v2"
+)
+
+func writeExport(t *testing.T, format string) *bytes.Buffer {
+	var buf bytes.Buffer
+	writer, err := newExportWriter(format, &buf, []string{"teacher", "student"})
+	require.NoError(t, err)
+
+	pairs := []*TeacherStudentPair{
+		NewTeacherStudentPair("teacher@example.com", "student1@example.com"),
+		NewTeacherStudentPair("teacher@example.com", "student2@example.com"),
+	}
+	for _, pair := range pairs {
+		require.NoError(t, writer.Write(pair, []string{pair.TeacherEmail, pair.StudentEmail}))
+	}
+	require.NoError(t, writer.Close())
+	return &buf
+}
+
+func TestCSVExportWriter(t *testing.T) {
+	buf := writeExport(t, exportFormatCSV)
+	require.Equal(t, "teacher,student\nteacher@example.com,student1@example.com\nteacher@example.com,student2@example.com\n", buf.String())
+}
+
+func TestCSVExportWriterEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := newExportWriter(exportFormatCSV, &buf, []string{"notification"})
+	require.NoError(t, err)
+	for _, value := range []string{"=HYPERLINK(\"http://example.com\")", "+1", "-1", "@SUM(A1)", "\tTab", "Hello = world", ""} {
+		require.NoError(t, writer.Write(nil, []string{value}))
+	}
+	require.NoError(t, writer.Close())
+	require.Equal(t, "notification\n\"'=HYPERLINK(\"\"http://example.com\"\")\"\n'+1\n'-1\n'@SUM(A1)\n'\tTab\nHello = world\n\n", buf.String())
+}
+
+func TestJSONLExportWriter(t *testing.T) {
+	buf := writeExport(t, exportFormatJSONL)
+	require.Equal(t, `{"teacherEmail":"teacher@example.com","studentEmail":"student1@example.com"}
+{"teacherEmail":"teacher@example.com","studentEmail":"student2@example.com"}
+`, buf.String())
+}
+
+func TestXLSXExportWriter(t *testing.T) {
+	buf := writeExport(t, exportFormatXLSX)
+
+	file, err := excelize.OpenReader(buf)
+	require.NoError(t, err)
+	defer file.Close()
+
+	rows, err := file.GetRows("Sheet1")
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"teacher", "student"},
+		{"teacher@example.com", "student1@example.com"},
+		{"teacher@example.com", "student2@example.com"},
+	}, rows)
+}
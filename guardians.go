@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	AuditActionLinkGuardian   = "link_guardian"
+	AuditActionUnlinkGuardian = "unlink_guardian"
+)
+
+func registerGuardianRoutes(v2 *gin.RouterGroup, store *Store, reads gin.HandlerFunc, writes gin.HandlerFunc) {
+	v2.GET("/students/:email/guardians", reads, RequireRole(RoleAdmin, RoleAuditor), makeHandleFunc(handleListGuardians, store))
+	v2.PUT("/students/:email/guardians/:guardian", writes, RequireRole(RoleAdmin), makeHandleFunc(handleLinkGuardian, store))
+	v2.DELETE("/students/:email/guardians/:guardian", writes, RequireRole(RoleAdmin), makeHandleFunc(handleUnlinkGuardian, store))
+}
+
+func handleListGuardians(c *gin.Context, store *Store) {
+	guardians, err := store.GetGuardiansOfStudent(c.Param("email"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get guardians."})
+		return
+	}
+
+	c.JSON(http.StatusOK, GuardiansResponse{Guardians: guardians})
+}
+
+func handleLinkGuardian(c *gin.Context, store *Store) {
+	var input LinkGuardianRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+	studentEmail := c.Param("email")
+	guardian := NewGuardian(c.Param("guardian"), input.Name, input.Relationship, input.OptedIn)
+	if !IsValidEmail(guardian.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Guardian's email (%s) is invalid.", guardian.Email)})
+		return
+	}
+	if len(guardian.Name) > maxProfileFieldLength || len(guardian.Relationship) > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("name must be at most %d characters and relationship at most 50.", maxProfileFieldLength)})
+		return
+	}
+
+	entry := newAuditEntry(c, AuditActionLinkGuardian)
+	entry.StudentEmails = []string{studentEmail}
+	entry.After = toAuditPayload(guardian)
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		isStudentExists, err := txStore.IfStudentExists(studentEmail)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to get student.")
+		}
+		if !isStudentExists {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given student is not registered.")
+		}
+		if err := txStore.LinkGuardian(studentEmail, guardian); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to link guardian.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, guardian)
+}
+
+func handleUnlinkGuardian(c *gin.Context, store *Store) {
+	studentEmail := c.Param("email")
+	guardianEmail := c.Param("guardian")
+
+	entry := newAuditEntry(c, AuditActionUnlinkGuardian)
+	entry.StudentEmails = []string{studentEmail}
+	entry.Before = toAuditPayload(gin.H{"guardian": guardianEmail})
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		unlinked, err := txStore.UnlinkGuardian(studentEmail, guardianEmail)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to unlink guardian.")
+		}
+		if !unlinked {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given guardian is not linked to the student.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
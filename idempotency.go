@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	idempotencyKeyTTL    = 24 * time.Hour
+	// Larger request bodies are spooled to disk rather than memory
+	idempotencyMemoryBodySize = 1 << 20
+)
+
+// Writer that keeps a copy of the response body so it can be stored for replays
+type recordingResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingResponseWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingResponseWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency stores the first response to a POST carrying an Idempotency-Key header and
+// replays it for retries by the same caller. Must run after the auth middleware.
+func Idempotency(store *Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Idempotency-Key must be at most 255 characters."})
+			return
+		}
+
+		requestHash, removeSpool, err := spoolRequestBody(c.Request)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "Failed to read request body."})
+			return
+		}
+		defer removeSpool()
+
+		record := NewIdempotencyRecord(idempotencyCaller(c), key, requestHash)
+
+		reserved, err := store.ReserveIdempotencyKey(record, time.Now().UTC().Add(-idempotencyKeyTTL))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "Something went wrong when checking the idempotency key."})
+			return
+		}
+
+		if !reserved {
+			replayIdempotentResponse(c, store, record)
+			return
+		}
+
+		// A panicking handler must not leave the key processing until it expires
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if err := store.ReleaseIdempotencyKey(record); err != nil {
+					c.Error(err)
+				}
+				panic(recovered)
+			}
+		}()
+
+		writer := &recordingResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		// Server errors and throttling are not stored so that the request can be retried with the same key
+		if writer.Status() >= http.StatusInternalServerError || writer.Status() == http.StatusTooManyRequests {
+			if err := store.ReleaseIdempotencyKey(record); err != nil {
+				c.Error(err)
+			}
+			return
+		}
+
+		record.StatusCode = writer.Status()
+		record.ContentType = writer.Header().Get("Content-Type")
+		record.ResponseBody = writer.body.Bytes()
+		if err := store.CompleteIdempotencyKey(record); err != nil {
+			c.Error(err)
+		}
+	}
+}
+
+func replayIdempotentResponse(c *gin.Context, store *Store, record *IdempotencyRecord) {
+	stored, err := store.GetIdempotencyRecord(record.Caller, record.Key)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "Something went wrong when checking the idempotency key."})
+		return
+	}
+	if stored == nil {
+		// Released by a failed attempt in the meantime
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "A request with this Idempotency-Key is being retried, try again."})
+		return
+	}
+	if stored.RequestHash != record.RequestHash {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"message": "Idempotency-Key was already used for a different request."})
+		return
+	}
+	if stored.CompletedAt == nil {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "A request with this Idempotency-Key is still being processed."})
+		return
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	if len(stored.ResponseBody) == 0 {
+		c.AbortWithStatus(stored.StatusCode)
+		return
+	}
+	c.Data(stored.StatusCode, stored.ContentType, stored.ResponseBody)
+	c.Abort()
+}
+
+// Keys are scoped per caller and school so that different clients, or one client acting in different schools,
+// can't replay each other's responses
+func idempotencyCaller(c *gin.Context) string {
+	caller := "anonymous:" + c.ClientIP()
+	if principal := GetPrincipal(c); principal != nil {
+		caller = fmt.Sprintf("%s:%s", principal.Method, principal.Subject)
+	}
+	if school := GetSchool(c); school != nil {
+		caller = school.ID + ":" + caller
+	}
+	return caller
+}
+
+// spoolRequestBody hashes the request while copying its body for the handler to read. Small bodies are kept in
+// memory and large ones, such as imports, in a temporary file that the returned function removes. Bodies are cut
+// after the import size limit, which the handlers still enforce.
+func spoolRequestBody(req *http.Request) (string, func(), error) {
+	hash := sha256.New()
+	hash.Write([]byte(req.Method + " " + req.URL.RequestURI() + "\n"))
+	body := io.TeeReader(io.LimitReader(req.Body, maxImportFileSize+1), hash)
+
+	var head bytes.Buffer
+	if _, err := io.CopyN(&head, body, idempotencyMemoryBodySize+1); err != nil && err != io.EOF {
+		return "", nil, err
+	}
+	if head.Len() <= idempotencyMemoryBodySize {
+		req.Body = io.NopCloser(&head)
+		return hex.EncodeToString(hash.Sum(nil)), func() {}, nil
+	}
+
+	file, err := os.CreateTemp("", "idempotency-*")
+	if err != nil {
+		return "", nil, err
+	}
+	remove := func() {
+		file.Close()
+		os.Remove(file.Name())
+	}
+	if _, err := io.Copy(file, io.MultiReader(&head, body)); err != nil {
+		remove()
+		return "", nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		remove()
+		return "", nil, err
+	}
+	req.Body = file
+	return hex.EncodeToString(hash.Sum(nil)), remove, nil
+}
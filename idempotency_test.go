@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpoolRequestBody(t *testing.T) {
+	small, _ := http.NewRequest("POST", "/api/register", strings.NewReader(`{"teacher": "teacher@example.com"}`))
+	smallHash, remove, err := spoolRequestBody(small)
+	require.NoError(t, err)
+	remove()
+	body, _ := io.ReadAll(small.Body)
+	require.Equal(t, `{"teacher": "teacher@example.com"}`, string(body))
+
+	// Large bodies are replayed from a temporary file and hashed the same way
+	upload := bytes.Repeat([]byte("teacher@example.com,student@example.com\n"), 2*idempotencyMemoryBodySize/40)
+	large, _ := http.NewRequest("POST", "/api/import", bytes.NewReader(upload))
+	largeHash, remove, err := spoolRequestBody(large)
+	require.NoError(t, err)
+	body, _ = io.ReadAll(large.Body)
+	require.Equal(t, upload, body)
+	remove()
+
+	again, _ := http.NewRequest("POST", "/api/import", bytes.NewReader(upload))
+	againHash, remove, err := spoolRequestBody(again)
+	require.NoError(t, err)
+	remove()
+	require.Equal(t, largeHash, againHash)
+	require.NotEqual(t, smallHash, largeHash)
+}
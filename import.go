@@ -0,0 +1,374 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx/types"
+)
+
+const (
+	AuditActionImport = "import"
+
+	importChunkSize    = 500
+	maxImportFileSize  = 100 << 20
+	maxImportRowErrors = 1000
+)
+
+// A validated CSV row
+type importRow struct {
+	teacherEmail   string
+	studentEmail   string
+	suspended      bool
+	suspendedUntil *time.Time
+}
+
+// handleImport spools the CSV to a temporary file and starts a job to import it, responding with the job to poll
+func handleImport(c *gin.Context, store *Store) {
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+
+	file, err := os.CreateTemp("", "import-*.csv")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to store uploaded file."})
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileSize)
+	_, copyErr := io.Copy(file, body)
+	closeErr := file.Close()
+	if err := errors.Join(copyErr, closeErr); err != nil {
+		os.Remove(file.Name())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": fmt.Sprintf("failed to read uploaded file, files are limited to %d MB.", maxImportFileSize>>20)})
+		return
+	}
+
+	// Check the header before accepting the job so that the wrong kind of file fails fast
+	if _, err := readImportHeader(file.Name()); err != nil {
+		os.Remove(file.Name())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "The CSV header is invalid."})
+		return
+	}
+
+	jobID, err := newRandomID()
+	if err != nil {
+		os.Remove(file.Name())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to create import job."})
+		return
+	}
+
+	auditEntry := newAuditEntry(c, AuditActionImport)
+	job := NewImportJob(jobID, auditEntry.Actor, dryRun)
+	if err := store.AddImportJob(job); err != nil {
+		os.Remove(file.Name())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to create import job."})
+		return
+	}
+
+	go runImportJob(store, job, file.Name(), GetSchool(c), auditEntry)
+
+	c.Header("Location", "/api/import/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+func handleGetImportJob(c *gin.Context, store *Store) {
+	job, err := store.GetImportJob(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get import job."})
+		return
+	}
+	if job == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errNotFound.Error(), "message": "Given import job does not exist."})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// runImportJob applies the uploaded file in the background so that large files can be polled for progress
+func runImportJob(store *Store, job *ImportJob, path string, school *School, auditTemplate *AuditEntry) {
+	defer os.Remove(path)
+
+	rowErrors := []ImportRowError{}
+	job.Status = ImportJobRunning
+	err := updateImportJob(store, job, rowErrors)
+
+	if err == nil {
+		err = readImportFile(path, school, func(rows []importRow, chunkErrors []ImportRowError) error {
+			if !job.DryRun && len(rows) > 0 {
+				if err := applyImportChunk(store, job, rows, auditTemplate); err != nil {
+					return err
+				}
+			}
+
+			job.RowsProcessed += len(rows) + len(chunkErrors)
+			job.RowsImported += len(rows)
+			job.RowsFailed += len(chunkErrors)
+			for _, rowError := range chunkErrors {
+				if len(rowErrors) < maxImportRowErrors {
+					rowErrors = append(rowErrors, rowError)
+				}
+			}
+			return updateImportJob(store, job, rowErrors)
+		})
+	}
+
+	finishedAt := time.Now().UTC()
+	job.FinishedAt = &finishedAt
+	job.Status = ImportJobSucceeded
+	if err != nil {
+		job.Status = ImportJobFailed
+		job.Message = err.Error()
+	}
+	if err := updateImportJob(store, job, rowErrors); err != nil {
+		log.Printf("Failed to update import job %s: %v", job.ID, err)
+	}
+}
+
+func updateImportJob(store *Store, job *ImportJob, rowErrors []ImportRowError) error {
+	data, err := json.Marshal(rowErrors)
+	if err != nil {
+		return err
+	}
+	job.Errors = types.JSONText(data)
+	return store.UpdateImportJob(job)
+}
+
+// applyImportChunk writes a chunk of rows with the same batched inserts as /api/register, in one transaction
+func applyImportChunk(store *Store, job *ImportJob, rows []importRow, auditTemplate *AuditEntry) error {
+	teachers := []*Teacher{}
+	students := []*Student{}
+	pairs := []*TeacherStudentPair{}
+	suspensions := []*Suspension{}
+	studentEmails := []string{}
+	seenTeachers := map[string]bool{}
+	seenStudents := map[string]bool{}
+
+	for _, row := range rows {
+		if !seenTeachers[row.teacherEmail] {
+			seenTeachers[row.teacherEmail] = true
+			teachers = append(teachers, NewTeacher(row.teacherEmail))
+		}
+		if !seenStudents[row.studentEmail] {
+			seenStudents[row.studentEmail] = true
+			students = append(students, NewStudent(row.studentEmail))
+			studentEmails = append(studentEmails, row.studentEmail)
+
+			if row.suspended {
+				suspension := NewSuspension(row.studentEmail)
+				suspension.SuspendedUntil = row.suspendedUntil
+				suspensions = append(suspensions, suspension)
+			}
+		}
+		pairs = append(pairs, NewTeacherStudentPair(row.teacherEmail, row.studentEmail))
+	}
+
+	entry := *auditTemplate
+	entry.OccurredAt = time.Now().UTC()
+	entry.StudentEmails = studentEmails
+	entry.After = toAuditPayload(gin.H{"importJobId": job.ID, "registrations": pairs, "suspensions": suspensions})
+
+	return store.WithTx(func(txStore *Store) error {
+		if err := txStore.AddTeachers(teachers); err != nil {
+			return fmt.Errorf("failed to add teachers: %w", err)
+		}
+		if err := txStore.AddStudents(students); err != nil {
+			return fmt.Errorf("failed to add students: %w", err)
+		}
+		if err := txStore.Register(pairs); err != nil {
+			return fmt.Errorf("failed to register students to teachers: %w", err)
+		}
+		if len(suspensions) > 0 {
+			if err := txStore.AddSuspensions(suspensions); err != nil {
+				return fmt.Errorf("failed to suspend students: %w", err)
+			}
+		}
+		if err := enqueueImportWebhookEvents(txStore, teachers, pairs, suspensions); err != nil {
+			return fmt.Errorf("failed to queue webhook events: %w", err)
+		}
+		return txStore.AddAuditEntry(&entry)
+	})
+}
+
+// enqueueImportWebhookEvents queues a student.registered event per teacher of the chunk and a student.suspended
+// event per suspension
+func enqueueImportWebhookEvents(store *Store, teachers []*Teacher, pairs []*TeacherStudentPair, suspensions []*Suspension) error {
+	for _, teacher := range teachers {
+		registration := WebhookRegistrationData{Teacher: teacher.Email, Students: []string{}}
+		for _, pair := range pairs {
+			if pair.TeacherEmail == teacher.Email {
+				registration.Students = append(registration.Students, pair.StudentEmail)
+			}
+		}
+		if err := enqueueWebhookEvent(store, WebhookEventStudentRegistered, registration); err != nil {
+			return err
+		}
+	}
+	for _, suspension := range suspensions {
+		if err := enqueueWebhookEvent(store, WebhookEventStudentSuspended, WebhookSuspensionData{Student: suspension.Email, SuspendedUntil: suspension.SuspendedUntil}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Column positions of a CSV file, -1 for the optional columns that are missing
+type importColumns struct {
+	teacher        int
+	student        int
+	suspended      int
+	suspendedUntil int
+}
+
+func readImportHeader(path string) (*importColumns, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, err := csv.NewReader(file).Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	return parseImportHeader(header)
+}
+
+func parseImportHeader(header []string) (*importColumns, error) {
+	columns := &importColumns{teacher: -1, student: -1, suspended: -1, suspendedUntil: -1}
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "teacher":
+			columns.teacher = i
+		case "student":
+			columns.student = i
+		case "suspended":
+			columns.suspended = i
+		case "suspended_until":
+			columns.suspendedUntil = i
+		default:
+			return nil, fmt.Errorf("unknown column %q, expected teacher, student, suspended and suspended_until", name)
+		}
+	}
+
+	if columns.teacher == -1 || columns.student == -1 {
+		return nil, errors.New("the teacher and student columns are required")
+	}
+	return columns, nil
+}
+
+// readImportFile streams the file and calls onChunk with up to importChunkSize valid rows at a time,
+// along with the errors of the invalid rows read since the last chunk. Rows with emails outside the school's
+// allowed domains are invalid.
+func readImportFile(path string, school *School, onChunk func(rows []importRow, rowErrors []ImportRowError) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	columns, err := parseImportHeader(header)
+	if err != nil {
+		return err
+	}
+
+	rows := []importRow{}
+	rowErrors := []ImportRowError{}
+	for rowNumber := 2; ; rowNumber++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			rowErrors = append(rowErrors, ImportRowError{Row: rowNumber, Message: err.Error()})
+		} else if row, err := parseImportRow(record, columns, school); err != nil {
+			rowErrors = append(rowErrors, ImportRowError{Row: rowNumber, Message: err.Error()})
+		} else {
+			rows = append(rows, *row)
+		}
+
+		if len(rows)+len(rowErrors) >= importChunkSize {
+			if err := onChunk(rows, rowErrors); err != nil {
+				return err
+			}
+			rows = []importRow{}
+			rowErrors = []ImportRowError{}
+		}
+	}
+
+	if len(rows)+len(rowErrors) > 0 {
+		return onChunk(rows, rowErrors)
+	}
+	return nil
+}
+
+func parseImportRow(record []string, columns *importColumns, school *School) (*importRow, error) {
+	field := func(column int) string {
+		if column == -1 || column >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[column])
+	}
+
+	row := &importRow{teacherEmail: field(columns.teacher), studentEmail: field(columns.student)}
+	if !IsValidEmail(row.teacherEmail) {
+		return nil, fmt.Errorf("Teacher's email (%s) is invalid.", row.teacherEmail)
+	}
+	if !IsValidEmail(row.studentEmail) {
+		return nil, fmt.Errorf("Student's email (%s) is invalid.", row.studentEmail)
+	}
+	for _, email := range []string{row.teacherEmail, row.studentEmail} {
+		if !school.AllowsEmail(email) {
+			return nil, fmt.Errorf("Email (%s) is not in one of the domains allowed by the school.", email)
+		}
+	}
+
+	if value := field(columns.suspended); value != "" {
+		suspended, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("suspended (%s) must be true or false.", value)
+		}
+		row.suspended = suspended
+	}
+
+	if value := field(columns.suspendedUntil); value != "" {
+		suspendedUntil, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("suspended_until (%s) must be an RFC 3339 timestamp.", value)
+		}
+		if !row.suspended {
+			return nil, errors.New("suspended_until is only allowed for suspended students.")
+		}
+		row.suspendedUntil = &suspendedUntil
+	}
+
+	return row, nil
+}
+
+// newRandomID returns a random hex id, for import jobs and scheduled notifications
+func newRandomID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
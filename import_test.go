@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImportHeader(t *testing.T) {
+	columns, err := parseImportHeader([]string{"Student", " teacher ", "suspended"})
+	require.NoError(t, err)
+	require.Equal(t, &importColumns{teacher: 1, student: 0, suspended: 2, suspendedUntil: -1}, columns)
+
+	_, err = parseImportHeader([]string{"teacher"})
+	require.Error(t, err)
+
+	_, err = parseImportHeader([]string{"teacher", "student", "grade"})
+	require.Error(t, err)
+}
+
+func TestReadImportFileReportsRowErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "import.csv")
+	content := strings.Join([]string{
+		"teacher,student,suspended,suspended_until",
+		"teacher@example.com,student1@example.com,,",
+		"teacher@example.com,not-an-email,,",
+		"teacher@example.com,student2@example.com,true,2030-01-01T00:00:00Z",
+		"teacher@example.com,student3@example.com,maybe,",
+		"teacher@example.com,student4@example.com,false,2030-01-01T00:00:00Z",
+		"teacher@example.com,student5@other.com,,",
+	}, "\n")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	rows := []importRow{}
+	rowErrors := []ImportRowError{}
+	school := NewSchool("school1", "School 1", []string{"example.com"})
+	err := readImportFile(path, school, func(chunkRows []importRow, chunkErrors []ImportRowError) error {
+		rows = append(rows, chunkRows...)
+		rowErrors = append(rowErrors, chunkErrors...)
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Len(t, rows, 2)
+	require.Equal(t, "student1@example.com", rows[0].studentEmail)
+	require.False(t, rows[0].suspended)
+	require.True(t, rows[1].suspended)
+	require.NotNil(t, rows[1].suspendedUntil)
+
+	rowNumbers := []int{}
+	for _, rowError := range rowErrors {
+		rowNumbers = append(rowNumbers, rowError.Row)
+	}
+	require.Equal(t, []int{3, 5, 6, 7}, rowNumbers)
+}
+
+func TestReadImportFileIsChunked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "import.csv")
+	var content strings.Builder
+	content.WriteString("teacher,student\n")
+	for i := 0; i < importChunkSize*2+1; i++ {
+		content.WriteString(fmt.Sprintf("teacher@example.com,student%d@example.com\n", i))
+	}
+	require.NoError(t, os.WriteFile(path, []byte(content.String()), 0o600))
+
+	chunkSizes := []int{}
+	err := readImportFile(path, nil, func(rows []importRow, rowErrors []ImportRowError) error {
+		chunkSizes = append(chunkSizes, len(rows)+len(rowErrors))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{importChunkSize, importChunkSize, 1}, chunkSizes)
+}
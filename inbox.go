@@ -0,0 +1,229 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Students read the notifications they received in their inbox, and teachers see who read those they sent
+
+const (
+	defaultInboxPageSize = 20
+	maxInboxPageSize     = 100
+)
+
+func registerInboxRoutes(api *gin.RouterGroup, store *Store, reads gin.HandlerFunc, writes gin.HandlerFunc) {
+	api.GET("/students/:email/inbox", reads, RequireRole(RoleAdmin, RoleStudent), makeHandleFunc(handleListInbox, store))
+	api.GET("/students/:email/inbox/unread", reads, RequireRole(RoleAdmin, RoleStudent), makeHandleFunc(handleCountUnread, store))
+	api.POST("/students/:email/inbox/read", writes, RequireRole(RoleAdmin, RoleStudent), makeHandleFunc(handleMarkAllRead, store))
+	api.POST("/students/:email/inbox/:id/read", writes, RequireRole(RoleAdmin, RoleStudent), makeHandleFunc(handleMarkRead, store))
+}
+
+func registerReadReceiptRoutes(v2 *gin.RouterGroup, store *Store, reads gin.HandlerFunc) {
+	v2.GET("/notifications/sent", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleListSentNotifications, store))
+	v2.GET("/notifications/sent/:id", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleGetSentNotification, store))
+}
+
+// parsePage reads the limit and cursor params of paginated listings, writing a 400 response if either is invalid
+func parsePage(c *gin.Context) (limit int, beforeID int64, ok bool) {
+	limit = defaultInboxPageSize
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxInboxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "limit must be between 1 and " + strconv.Itoa(maxInboxPageSize) + "."})
+			return 0, 0, false
+		}
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		var err error
+		if beforeID, err = strconv.ParseInt(cursor, 10, 64); err != nil || beforeID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "cursor is invalid."})
+			return 0, 0, false
+		}
+	}
+
+	return limit, beforeID, true
+}
+
+// requireStudent checks that the principal may read the inbox of the student and that the student exists, writing
+// the error response if not
+func requireStudent(c *gin.Context, store *Store, studentEmail string) bool {
+	if !authorizeAsStudent(c, studentEmail) {
+		return false
+	}
+
+	exists, err := store.IfStudentExists(studentEmail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "Something went wrong when checking if student is registered."})
+		return false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": errNotFound.Error(), "message": "Given student does not exist."})
+		return false
+	}
+	return true
+}
+
+func handleListInbox(c *gin.Context, store *Store) {
+	studentEmail := c.Param("email")
+	if !requireStudent(c, store, studentEmail) {
+		return
+	}
+
+	filter := InboxFilter{}
+	if value := c.Query("unread"); value != "" {
+		unreadOnly, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "unread must be true or false."})
+			return
+		}
+		filter.UnreadOnly = unreadOnly
+	}
+	var ok bool
+	if filter.Limit, filter.BeforeID, ok = parsePage(c); !ok {
+		return
+	}
+
+	// Fetch one extra notification to know whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	notifications, err := store.ListInbox(studentEmail, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to list inbox."})
+		return
+	}
+	counts, err := store.CountUnreadByCategory(studentEmail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to count unread notifications."})
+		return
+	}
+
+	response := InboxResponse{Notifications: notifications}
+	for _, count := range counts {
+		response.Unread += count
+	}
+	if len(notifications) > limit {
+		response.Notifications = notifications[:limit]
+		cursor := strconv.FormatInt(notifications[limit-1].ID, 10)
+		response.NextCursor = &cursor
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func handleCountUnread(c *gin.Context, store *Store) {
+	studentEmail := c.Param("email")
+	if !requireStudent(c, store, studentEmail) {
+		return
+	}
+
+	counts, err := store.CountUnreadByCategory(studentEmail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to count unread notifications."})
+		return
+	}
+
+	response := UnreadCountResponse{Categories: counts}
+	for _, count := range counts {
+		response.Unread += count
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// handleMarkRead marks a notification as read, reading it again keeps when it was first read
+func handleMarkRead(c *gin.Context, store *Store) {
+	studentEmail := c.Param("email")
+	if !authorizeAsStudent(c, studentEmail) {
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": errNotFound.Error(), "message": "Given notification does not exist."})
+		return
+	}
+
+	notification, err := store.MarkStudentNotificationRead(studentEmail, id, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to mark notification as read."})
+		return
+	}
+	if notification == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errNotFound.Error(), "message": "Given notification does not exist."})
+		return
+	}
+
+	c.JSON(http.StatusOK, notification)
+}
+
+// handleMarkAllRead marks every unread notification as read, or only those up to the upTo id so that notifications
+// received since the client last listed the inbox stay unread
+func handleMarkAllRead(c *gin.Context, store *Store) {
+	studentEmail := c.Param("email")
+	if !requireStudent(c, store, studentEmail) {
+		return
+	}
+	var upToID int64
+	if value := c.Query("upTo"); value != "" {
+		var err error
+		if upToID, err = strconv.ParseInt(value, 10, 64); err != nil || upToID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "upTo must be the id of a notification."})
+			return
+		}
+	}
+
+	marked, err := store.MarkAllStudentNotificationsRead(studentEmail, upToID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to mark notifications as read."})
+		return
+	}
+
+	c.JSON(http.StatusOK, MarkReadResponse{Marked: marked})
+}
+
+func handleListSentNotifications(c *gin.Context, store *Store) {
+	// Teachers only see their own
+	teacherEmail := c.Query("teacher")
+	if principal := GetPrincipal(c); principal != nil && principal.Role == RoleTeacher {
+		teacherEmail = principal.Subject
+	}
+	limit, beforeID, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	// Fetch one extra notification to know whether there is a next page
+	notifications, err := store.ListSentNotifications(teacherEmail, beforeID, limit+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to list sent notifications."})
+		return
+	}
+
+	response := SentNotificationsResponse{SentNotifications: notifications}
+	if len(notifications) > limit {
+		response.SentNotifications = notifications[:limit]
+		cursor := strconv.FormatInt(notifications[limit-1].FirstID, 10)
+		response.NextCursor = &cursor
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// handleGetSentNotification returns a sent notification with the read receipts of its recipients
+func handleGetSentNotification(c *gin.Context, store *Store) {
+	notification, err := store.GetSentNotification(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get sent notification."})
+		return
+	}
+	principal := GetPrincipal(c)
+	if notification == nil || (principal != nil && principal.Role == RoleTeacher && principal.Subject != notification.Teacher) {
+		c.JSON(http.StatusNotFound, gin.H{"error": errNotFound.Error(), "message": "Given notification does not exist."})
+		return
+	}
+
+	c.JSON(http.StatusOK, notification)
+}
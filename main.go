@@ -1,10 +1,9 @@
 package main
 
 import (
-	"fmt"
 	"log"
 	"net/http"
-	"regexp"
+	"os"
 
 	"github.com/gin-gonic/gin"
 )
@@ -26,70 +25,80 @@ func main() {
 		log.Fatal(initErr)
 	}
 
+	// Run admin commands instead of the server if any are given
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:], store, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Jobs that were running when the server last stopped will never finish
+	if err := store.FailInterruptedImportJobs(); err != nil {
+		log.Fatal(err)
+	}
+
+	go runScheduler(store, LoadSchedulerInterval())
+	go runWebhookDispatcher(store, LoadWebhookConfig())
+
 	// Setup and run the server
 	router := SetupRouter(store)
 	router.Run(":8080")
 }
 
 func SetupRouter(store *Store) *gin.Engine {
+	authenticator, err := NewAuthenticator(store, LoadAuthConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rateLimitConfig := LoadRateLimitConfig()
+	rateLimiter, err := NewRateLimiter(rateLimitConfig, store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The school, term and idempotency key are only resolved once the request got past the rate limiter
+	scoped := []gin.HandlerFunc{ResolveSchool(store), ResolveTerm(store), Idempotency(store)}
+	reads := Throttled(RateLimitMiddleware(rateLimiter, rateLimitConfig, RouteClassRead), scoped...)
+	writes := Throttled(RateLimitMiddleware(rateLimiter, rateLimitConfig, RouteClassWrite), scoped...)
+	notifications := Throttled(RateLimitMiddleware(rateLimiter, rateLimitConfig, RouteClassNotification), scoped...)
+
 	router := gin.Default()
-	router.POST("/api/register", makeHandleFunc(handleRegister, store))
-	router.GET("/api/commonstudents", makeHandleFunc(handleCommonStudents, store))
-	router.POST("/api/suspend", makeHandleFunc(handleSuspension, store))
-	router.POST("/api/retrievefornotifications", makeHandleFunc(handleRetrieveNotifications, store))
+	router.Use(RequestID())
+	registerDocsRoutes(router)
+
+	api := router.Group("/api", authenticator.Middleware())
+	api.POST("/register", Deprecated("/api/v2/registrations"), writes, RequireRole(RoleAdmin, RoleTeacher), RequireTerm(store), makeHandleFunc(handleRegister, store))
+	api.GET("/commonstudents", Deprecated("/api/v2/students"), reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleCommonStudents, store))
+	api.POST("/suspend", Deprecated("/api/v2/students/{email}/suspensions"), writes, RequireRole(RoleAdmin), makeHandleFunc(handleSuspension, store))
+	api.POST("/retrievefornotifications", Deprecated("/api/v2/notifications"), notifications, RequireRole(RoleAdmin, RoleTeacher), RequireTerm(store), makeHandleFunc(handleRetrieveNotifications, store))
+	api.GET("/audit", reads, RequireRole(RoleAdmin, RoleAuditor), makeHandleFunc(handleGetAuditLog, store))
+	api.POST("/import", writes, RequireRole(RoleAdmin), RequireTerm(store), makeHandleFunc(handleImport, store))
+	api.GET("/import/:id", reads, RequireRole(RoleAdmin), makeHandleFunc(handleGetImportJob, store))
+	api.GET("/export", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleExport, store))
+
+	registerStreamRoutes(api, store, reads)
+	registerInboxRoutes(api, store, reads, writes)
+
+	registerV2Routes(api, store, reads, writes, notifications)
 
 	return router
 }
 
 func handleRegister(c *gin.Context, store *Store) {
-	var input struct {
-		Teacher  string   `json:"teacher" binding:"required"`
-		Students []string `json:"students"`
-	}
+	var input RegisterRequest
 
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
 		return
 	}
 
-	// validate emails and create new teacher and student instances
-	var teacher *Teacher
-	if IsValidEmail(input.Teacher) {
-		teacher = NewTeacher(input.Teacher)
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Teacher's email (%s) is invalid.", input.Teacher)})
-		return
-
-	}
-
-	students := []*Student{}
-	for _, studentEmail := range input.Students {
-		if IsValidEmail(studentEmail) {
-			students = append(students, NewStudent(studentEmail))
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("A student's email (%s) is invalid.", studentEmail)})
-			return
-		}
-	}
-
-	// Add teacher if does not exist
-	if err := store.AddTeacher(teacher); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to add teacher."})
+	if !authorizeAsTeacher(c, input.Teacher) {
 		return
 	}
 
-	if err := store.AddStudents(students); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to add students."})
-		return
-	}
-
-	// Register students to Teacher
-	teacherStudentPairs := []*TeacherStudentPair{}
-	for _, studentEmail := range input.Students {
-		teacherStudentPairs = append(teacherStudentPairs, NewTeacherStudentPair(input.Teacher, studentEmail))
-	}
-	if err := store.Register(teacherStudentPairs); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to register students to teachers."})
+	if apiErr := registerStudents(c, store, input); apiErr != nil {
+		apiErr.respond(c)
 		return
 	}
 
@@ -103,58 +112,25 @@ func handleCommonStudents(c *gin.Context, store *Store) {
 		return
 	}
 
-	// Validate emails and create teacher instances
-	teachers := []*Teacher{}
-	for _, teacherEmail := range teacherEmails {
-		if IsValidEmail(teacherEmail) {
-			teachers = append(teachers, NewTeacher(teacherEmail))
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("A teacher's email (%s) is invalid.", teacherEmail)})
-			return
-		}
-	}
-
-	students, err := store.GetCommonStudents(teachers)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get common students."})
+	students, apiErr := getCommonStudents(c, store, teacherEmails, c.Query("class"))
+	if apiErr != nil {
+		apiErr.respond(c)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"students": students})
+	c.JSON(http.StatusOK, CommonStudentsResponse{Students: students})
 }
 
 func handleSuspension(c *gin.Context, store *Store) {
-	var input struct {
-		Student string `json:"student" binding:"required"`
-	}
+	var input SuspendRequest
 
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "student field is missing or invalid."})
 		return
 	}
 
-	// Check if student is registered
-	isStudentExists, err := store.IfStudentExists(input.Student)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "Something went wrong when checking if student is registered."})
-		return
-	}
-	if !isStudentExists {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Given student is not registered."})
-		return
-	}
-
-	// validate email and create new Suspension instance
-	var suspension *Suspension
-	if IsValidEmail(input.Student) {
-		suspension = NewSuspension(input.Student)
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Student's email (%s) is invalid.", input.Student)})
-		return
-	}
-
-	if err := store.AddSuspension(suspension); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to suspend student."})
+	if apiErr := suspendStudent(c, store, input.Student); apiErr != nil {
+		apiErr.respond(c)
 		return
 	}
 
@@ -162,94 +138,38 @@ func handleSuspension(c *gin.Context, store *Store) {
 }
 
 func handleRetrieveNotifications(c *gin.Context, store *Store) {
-	var input struct {
-		Teacher      string `json:"teacher" binding:"required"`
-		Notification string `json:"notification" binding:"required"`
-	}
+	var input RetrieveNotificationsRequest
 
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
 		return
 	}
 
-	// Check if teacher is registered
-	isTeacherExists, err := store.IfTeacherExists(input.Teacher)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "Something went wrong when checking if teacher is registered."})
-		return
-	}
-	if !isTeacherExists {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Given teacher is not registered."})
+	if !authorizeAsTeacher(c, input.Teacher) {
 		return
 	}
 
-	// validate emails and create new teacher and student instances
-	var teacher *Teacher
-	if IsValidEmail(input.Teacher) {
-		teacher = NewTeacher(input.Teacher)
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Teacher's email (%s) is invalid.", input.Teacher)})
+	response, apiErr := retrieveNotificationRecipients(c, store, input)
+	if apiErr != nil {
+		apiErr.respond(c)
 		return
 	}
 
-	// Handling of mentioned emails
-	// Get all mentioned emails
-	emailPattern := `@\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`
-
-	re := regexp.MustCompile(emailPattern)
-	emails := re.FindAllString(input.Notification, -1)
-
-	notifiableEmailsMap := map[string]bool{}
-
-	for _, email := range emails {
-		// Strip the @ of the mention
-		email = email[1:]
-		// Check if mentioned email is a student
-		isStudentExists, err := store.IfStudentExists(email)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": fmt.Sprintf("Something went wrong when checking if %s is registered.", email)})
-			return
-		}
-		if !isStudentExists {
-			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Student (%s) mentioned is not registered.", email)})
-			return
-		}
-
-		// Check if mentioned email is suspended
-		isSuspended, err := store.IsSuspended(email)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": fmt.Sprintf("Something went wrong when checking if %s is suspended.", email)})
-			return
-		}
-		if !isSuspended {
-			notifiableEmailsMap[email] = true
-		}
-	}
-
-	// Handling of students registered to teacher
-	studentEmails, err := store.GetNotifiableStudentsOfTeacher(teacher)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "Something went wrong when getting notifiable students"})
-		return
-	}
-
-	for _, studentEmail := range studentEmails {
-		notifiableEmailsMap[studentEmail] = true
-	}
-
-	// Get final slice of notifiable emails
-	notifiableEmails := []string{}
-	for notifiableEmail := range notifiableEmailsMap {
-		notifiableEmails = append(notifiableEmails, notifiableEmail)
-	}
-
-	c.JSON(http.StatusOK, gin.H{"recipients": notifiableEmails})
+	c.JSON(http.StatusOK, response)
 }
 
-// Function to convert API Handlers to Gin Handle Funcs because of the store param
+// Function to convert API Handlers to Gin Handle Funcs because of the store param.
+// Handlers get a store limited to the school of the request.
 func makeHandleFunc(apiHandler apiHandler, store *Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if school := GetSchool(c); school != nil {
+			scopedStore := store.ForSchool(school.ID)
+			if term := GetTerm(c); term != nil {
+				scopedStore = scopedStore.ForTerm(term.Code)
+			}
+			apiHandler(c, scopedStore)
+			return
+		}
 		apiHandler(c, store)
 	}
 }
@@ -1,17 +1,23 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
 )
 
 // Helper function to create a new Store connected to your test DB
@@ -34,14 +40,47 @@ func newTestStore() *Store {
 func cleanUp(store *Store) {
 	store.db.Exec("DROP TABLE students CASCADE")
 	store.db.Exec("DROP TABLE teachers CASCADE")
-	store.db.Exec("DROP TABLE registered")
+	store.db.Exec("DROP VIEW registered")
+	store.db.Exec("DROP TABLE enrollments")
+	store.db.Exec("DROP TABLE class_teachers")
+	store.db.Exec("DROP TABLE classes")
 	store.db.Exec("DROP TABLE suspensions")
+	store.db.Exec("DROP TABLE api_keys")
+	store.db.Exec("DROP TABLE idempotency_keys")
+	store.db.Exec("DROP TABLE rate_limit_buckets")
+	store.db.Exec("DROP TABLE audit_log")
+	store.db.Exec("DROP TABLE import_jobs")
+	store.db.Exec("DROP TABLE student_guardians")
+	store.db.Exec("DROP TABLE guardians")
+	store.db.Exec("DROP TABLE notification_preferences")
+	store.db.Exec("DROP TABLE scheduled_notifications")
+	store.db.Exec("DROP TABLE recurring_notifications")
+	store.db.Exec("DROP TABLE holidays")
+	store.db.Exec("DROP TABLE notification_templates")
+	store.db.Exec("DROP TABLE student_notifications")
+	store.db.Exec("DROP TABLE webhook_deliveries")
+	store.db.Exec("DROP TABLE webhooks")
+	store.db.Exec("DROP TABLE terms")
+	store.db.Exec("DROP TABLE schools")
+}
+
+// Helper function to create an API key for the given subject and role and return the plaintext key
+func newTestAPIKey(store *Store, subject string, role string) string {
+	key, err := GenerateAPIKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := store.AddAPIKey(NewAPIKey("test", subject, role, key)); err != nil {
+		log.Fatal(err)
+	}
+	return key
 }
 
 func TestHandleRegister(t *testing.T) {
 	store := newTestStore()
 	defer store.db.Close()
 	router := SetupRouter(store)
+	apiKey := newTestAPIKey(store, "admin", RoleAdmin)
 
 	teacherEmail := "teacher@example.com"
 	studentEmail1 := "student1@example.com"
@@ -59,6 +98,8 @@ func TestHandleRegister(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/api/register", bytes.NewBuffer(data))
 	req.Header.Set("Content-Type", "application/json")
 
+	req.Header.Set(apiKeyHeader, apiKey)
+
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -70,6 +111,7 @@ func TestHandleCommonStudents(t *testing.T) {
 	store := newTestStore()
 	defer store.db.Close()
 	router := SetupRouter(store)
+	apiKey := newTestAPIKey(store, "admin", RoleAdmin)
 
 	teacherEmail1 := "teacher1@example.com"
 	teacherEmail2 := "teacher2@example.com"
@@ -78,11 +120,16 @@ func TestHandleCommonStudents(t *testing.T) {
 
 	store.db.Exec("INSERT INTO teachers (email) VALUES ($1), ($2)", teacherEmail1, teacherEmail2)
 	store.db.Exec("INSERT INTO students (email) VALUES ($1), ($2)", studentEmail1, studentEmail2)
-	store.db.Exec("INSERT INTO registered (student_email, teacher_email) VALUES ($1, $2), ($3, $4), ($5, $6)",
-		studentEmail1, teacherEmail1, studentEmail2, teacherEmail1, studentEmail1, teacherEmail2)
+	store.Register([]*TeacherStudentPair{
+		NewTeacherStudentPair(teacherEmail1, studentEmail1),
+		NewTeacherStudentPair(teacherEmail1, studentEmail2),
+		NewTeacherStudentPair(teacherEmail2, studentEmail1),
+	})
 
 	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/commonstudents?teacher=%s&teacher=%s", teacherEmail1, teacherEmail2), nil)
 
+	req.Header.Set(apiKeyHeader, apiKey)
+
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -102,6 +149,7 @@ func TestHandleSuspension(t *testing.T) {
 	store := newTestStore()
 	defer store.db.Close()
 	router := SetupRouter(store)
+	apiKey := newTestAPIKey(store, "admin", RoleAdmin)
 
 	studentEmail1 := "student1@example.com"
 	store.db.Exec("INSERT INTO students (email) VALUES ($1)", studentEmail1)
@@ -116,6 +164,8 @@ func TestHandleSuspension(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/api/suspend", bytes.NewBuffer(data))
 	req.Header.Set("Content-Type", "application/json")
 
+	req.Header.Set(apiKeyHeader, apiKey)
+
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -127,6 +177,7 @@ func TestRetrieveNotificationNoSuspensionsWithDupes(t *testing.T) {
 	store := newTestStore()
 	defer store.db.Close()
 	router := SetupRouter(store)
+	apiKey := newTestAPIKey(store, "admin", RoleAdmin)
 
 	teacherEmail1 := "teacher1@example.com"
 	studentEmail1 := "student1@example.com"
@@ -135,8 +186,10 @@ func TestRetrieveNotificationNoSuspensionsWithDupes(t *testing.T) {
 
 	store.db.Exec("INSERT INTO teachers (email) VALUES ($1)", teacherEmail1)
 	store.db.Exec("INSERT INTO students (email) VALUES ($1), ($2), ($3)", studentEmail1, studentEmail2, studentEmail3)
-	store.db.Exec("INSERT INTO registered (student_email, teacher_email) VALUES ($1, $2), ($3, $4)",
-		studentEmail1, teacherEmail1, studentEmail2, teacherEmail1)
+	store.Register([]*TeacherStudentPair{
+		NewTeacherStudentPair(teacherEmail1, studentEmail1),
+		NewTeacherStudentPair(teacherEmail1, studentEmail2),
+	})
 
 	notification := fmt.Sprintf("Hello, @%s and @%s", studentEmail2, studentEmail3)
 	input := struct {
@@ -150,6 +203,8 @@ func TestRetrieveNotificationNoSuspensionsWithDupes(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/api/retrievefornotifications", bytes.NewBuffer(data))
 	req.Header.Set("Content-Type", "application/json")
 
+	req.Header.Set(apiKeyHeader, apiKey)
+
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -179,6 +234,7 @@ func TestRetrieveNotificationWithSuspensions(t *testing.T) {
 	store := newTestStore()
 	defer store.db.Close()
 	router := SetupRouter(store)
+	apiKey := newTestAPIKey(store, "admin", RoleAdmin)
 
 	teacherEmail1 := "teacher1@example.com"
 	studentEmail1 := "student1@example.com"
@@ -187,8 +243,10 @@ func TestRetrieveNotificationWithSuspensions(t *testing.T) {
 
 	store.db.Exec("INSERT INTO teachers (email) VALUES ($1)", teacherEmail1)
 	store.db.Exec("INSERT INTO students (email) VALUES ($1), ($2), ($3)", studentEmail1, studentEmail2, studentEmail3)
-	store.db.Exec("INSERT INTO registered (student_email, teacher_email) VALUES ($1, $2), ($3, $4)",
-		studentEmail1, teacherEmail1, studentEmail2, teacherEmail1)
+	store.Register([]*TeacherStudentPair{
+		NewTeacherStudentPair(teacherEmail1, studentEmail1),
+		NewTeacherStudentPair(teacherEmail1, studentEmail2),
+	})
 	store.db.Exec("INSERT INTO suspensions (student_email, suspended_at) VALUES ($1, $2), ($3, $2)", studentEmail2, time.Now().UTC(), studentEmail3)
 
 	notification := fmt.Sprintf("Hello, @%s", studentEmail3)
@@ -203,6 +261,8 @@ func TestRetrieveNotificationWithSuspensions(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/api/retrievefornotifications", bytes.NewBuffer(data))
 	req.Header.Set("Content-Type", "application/json")
 
+	req.Header.Set(apiKeyHeader, apiKey)
+
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -217,3 +277,1618 @@ func TestRetrieveNotificationWithSuspensions(t *testing.T) {
 	require.Equal(t, http.StatusOK, w.Code)
 	cleanUp(store)
 }
+
+func TestUnauthenticatedRequestIsRejected(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+
+	req, _ := http.NewRequest("GET", "/api/commonstudents?teacher=teacher@example.com", nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	cleanUp(store)
+}
+
+func TestRevokedAPIKeyIsRejected(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+
+	key, _ := GenerateAPIKey()
+	apiKey := NewAPIKey("test", "admin", RoleAdmin, key)
+	store.AddAPIKey(apiKey)
+	store.RevokeAPIKey(apiKey.ID)
+
+	req, _ := http.NewRequest("GET", "/api/commonstudents?teacher=teacher@example.com", nil)
+	req.Header.Set(apiKeyHeader, key)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	cleanUp(store)
+}
+
+func TestHMACBearerTokenIsAccepted(t *testing.T) {
+	t.Setenv("AUTH_JWT_HMAC_SECRET", "test-secret")
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":  "admin",
+		"role": RoleAdmin,
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	})
+	tokenString, _ := token.SignedString([]byte("test-secret"))
+
+	req, _ := http.NewRequest("GET", "/api/commonstudents?teacher=teacher@example.com", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	cleanUp(store)
+}
+
+func TestTeacherCannotNotifyAsAnotherTeacher(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+
+	teacherEmail1 := "teacher1@example.com"
+	teacherEmail2 := "teacher2@example.com"
+	apiKey := newTestAPIKey(store, teacherEmail1, RoleTeacher)
+
+	store.db.Exec("INSERT INTO teachers (email) VALUES ($1), ($2)", teacherEmail1, teacherEmail2)
+
+	input := struct {
+		Teacher      string `json:"teacher"`
+		Notification string `json:"notification"`
+	}{
+		Teacher:      teacherEmail2,
+		Notification: "Hello",
+	}
+	data, _ := json.Marshal(input)
+	req, _ := http.NewRequest("POST", "/api/retrievefornotifications", bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(apiKeyHeader, apiKey)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusForbidden, w.Code)
+	cleanUp(store)
+}
+
+func TestTeacherCanRegisterToThemselves(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+
+	teacherEmail := "teacher@example.com"
+	apiKey := newTestAPIKey(store, teacherEmail, RoleTeacher)
+
+	input := struct {
+		Teacher  string   `json:"teacher"`
+		Students []string `json:"students"`
+	}{
+		Teacher:  teacherEmail,
+		Students: []string{"student1@example.com"},
+	}
+	data, _ := json.Marshal(input)
+	req, _ := http.NewRequest("POST", "/api/register", bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(apiKeyHeader, apiKey)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusNoContent, w.Code)
+	cleanUp(store)
+}
+
+func TestOnlyAdminsCanSuspend(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+
+	studentEmail1 := "student1@example.com"
+	store.db.Exec("INSERT INTO students (email) VALUES ($1)", studentEmail1)
+
+	for _, role := range []string{RoleTeacher, RoleAuditor} {
+		apiKey := newTestAPIKey(store, "teacher@example.com", role)
+
+		data, _ := json.Marshal(map[string]string{"student": studentEmail1})
+		req, _ := http.NewRequest("POST", "/api/suspend", bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, apiKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusForbidden, w.Code)
+	}
+	cleanUp(store)
+}
+
+func TestIdempotentRetryReplaysStoredResponse(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	apiKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	teacherEmail1 := "teacher1@example.com"
+	studentEmail1 := "student1@example.com"
+	studentEmail2 := "student2@example.com"
+
+	store.db.Exec("INSERT INTO teachers (email) VALUES ($1)", teacherEmail1)
+	store.db.Exec("INSERT INTO students (email) VALUES ($1), ($2)", studentEmail1, studentEmail2)
+	store.Register([]*TeacherStudentPair{NewTeacherStudentPair(teacherEmail1, studentEmail1)})
+
+	data, _ := json.Marshal(map[string]string{"teacher": teacherEmail1, "notification": "Hello"})
+	sendNotification := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/retrievefornotifications", bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, apiKey)
+		req.Header.Set(idempotencyKeyHeader, "retry-1")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := sendNotification()
+	require.Equal(t, http.StatusOK, first.Code)
+
+	// A student registered after the first attempt must not change the replayed response
+	store.Register([]*TeacherStudentPair{NewTeacherStudentPair(teacherEmail1, studentEmail2)})
+
+	second := sendNotification()
+	require.Equal(t, http.StatusOK, second.Code)
+	require.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+	require.Equal(t, first.Body.Bytes(), second.Body.Bytes())
+	cleanUp(store)
+}
+
+func TestIdempotencyKeyReusedWithDifferentBody(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	apiKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	register := func(studentEmail string) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(map[string]interface{}{"teacher": "teacher@example.com", "students": []string{studentEmail}})
+		req, _ := http.NewRequest("POST", "/api/register", bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, apiKey)
+		req.Header.Set(idempotencyKeyHeader, "register-1")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusNoContent, register("student1@example.com").Code)
+	require.Equal(t, http.StatusUnprocessableEntity, register("student2@example.com").Code)
+	cleanUp(store)
+}
+
+func TestRegisterIsAudited(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	apiKey := newTestAPIKey(store, "admin", RoleAdmin)
+	auditorKey := newTestAPIKey(store, "auditor", RoleAuditor)
+
+	teacherEmail := "teacher@example.com"
+	studentEmail1 := "student1@example.com"
+
+	data, _ := json.Marshal(map[string]interface{}{"teacher": teacherEmail, "students": []string{studentEmail1}})
+	req, _ := http.NewRequest("POST", "/api/register", bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(apiKeyHeader, apiKey)
+	req.Header.Set(requestIDHeader, "request-1")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/audit?action=register&student=%s", studentEmail1), nil)
+	req.Header.Set(apiKeyHeader, auditorKey)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var res struct {
+		Entries    []*AuditEntry `json:"entries"`
+		NextCursor *string       `json:"nextCursor"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, res.Entries, 1)
+	require.Nil(t, res.NextCursor)
+	require.Equal(t, "admin", res.Entries[0].Actor)
+	require.Equal(t, teacherEmail, res.Entries[0].TeacherEmail)
+	require.Equal(t, "request-1", res.Entries[0].RequestID)
+	require.JSONEq(t, `{"registeredStudents":[]}`, res.Entries[0].Before.String())
+	cleanUp(store)
+}
+
+func TestAuditLogIsPaginated(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	apiKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	for i := 0; i < 3; i++ {
+		store.AddAuditEntry(NewAuditEntry("admin", RoleAdmin, AuditActionSuspend, ""))
+	}
+
+	req, _ := http.NewRequest("GET", "/api/audit?limit=2", nil)
+	req.Header.Set(apiKeyHeader, apiKey)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var firstPage struct {
+		Entries    []*AuditEntry `json:"entries"`
+		NextCursor *string       `json:"nextCursor"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &firstPage)
+	require.Len(t, firstPage.Entries, 2)
+	require.NotNil(t, firstPage.NextCursor)
+
+	req, _ = http.NewRequest("GET", "/api/audit?limit=2&cursor="+*firstPage.NextCursor, nil)
+	req.Header.Set(apiKeyHeader, apiKey)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var secondPage struct {
+		Entries    []*AuditEntry `json:"entries"`
+		NextCursor *string       `json:"nextCursor"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &secondPage)
+	require.Len(t, secondPage.Entries, 1)
+	require.Nil(t, secondPage.NextCursor)
+	require.Less(t, secondPage.Entries[0].ID, firstPage.Entries[1].ID)
+	cleanUp(store)
+}
+
+func TestAuditLogIsAppendOnly(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+
+	entry := NewAuditEntry("admin", RoleAdmin, AuditActionSuspend, "")
+	require.NoError(t, store.AddAuditEntry(entry))
+
+	_, err := store.db.Exec("DELETE FROM audit_log WHERE id=$1", entry.ID)
+	require.Error(t, err)
+	cleanUp(store)
+}
+
+func TestV1RoutesAreDeprecated(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	apiKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	req, _ := http.NewRequest("GET", "/api/commonstudents?teacher=teacher@example.com", nil)
+	req.Header.Set(apiKeyHeader, apiKey)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, fmt.Sprintf("@%d", v1DeprecatedAt.Unix()), w.Header().Get("Deprecation"))
+	require.Equal(t, `</api/v2/students>; rel="successor-version"`, w.Header().Get("Link"))
+	cleanUp(store)
+}
+
+func TestV2RegisterAndUnregisterStudent(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	apiKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	teacherEmail := "teacher@example.com"
+	studentEmail := "student1@example.com"
+
+	send := func(method string, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set(apiKeyHeader, apiKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	studentPath := fmt.Sprintf("/api/v2/teachers/%s/students/%s", teacherEmail, studentEmail)
+	require.Equal(t, http.StatusNoContent, send("PUT", studentPath).Code)
+
+	w := send("GET", fmt.Sprintf("/api/v2/teachers/%s/students", teacherEmail))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"students":[{"email":"student1@example.com","active":true}]}`, w.Body.String())
+
+	require.Equal(t, http.StatusNoContent, send("DELETE", studentPath).Code)
+	require.Equal(t, http.StatusNotFound, send("DELETE", studentPath).Code)
+
+	entries, _ := store.GetAuditEntries(AuditFilter{Action: AuditActionUnregister, Limit: 10})
+	require.Len(t, entries, 1)
+	cleanUp(store)
+}
+
+func TestV2SuspendAndUnsuspendStudent(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	apiKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	studentEmail := "student1@example.com"
+	store.db.Exec("INSERT INTO students (email) VALUES ($1)", studentEmail)
+
+	send := func(method string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, fmt.Sprintf("/api/v2/students/%s/suspensions", studentEmail), nil)
+		req.Header.Set(apiKeyHeader, apiKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusCreated, send("POST").Code)
+	require.Equal(t, http.StatusNoContent, send("DELETE").Code)
+	require.Equal(t, http.StatusNotFound, send("DELETE").Code)
+
+	w := send("GET")
+	var res SuspensionsResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	require.Len(t, res.Suspensions, 1)
+	require.NotNil(t, res.Suspensions[0].SuspendedUntil)
+	cleanUp(store)
+}
+
+func TestImportCSV(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	apiKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	csv := "teacher,student,suspended\n" +
+		"teacher@example.com,student1@example.com,false\n" +
+		"teacher@example.com,student2@example.com,true\n" +
+		"teacher@example.com,invalid,false\n"
+
+	importCSV := func(path string) *ImportJob {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(csv))
+		req.Header.Set("Content-Type", "text/csv")
+		req.Header.Set(apiKeyHeader, apiKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		var job ImportJob
+		json.Unmarshal(w.Body.Bytes(), &job)
+
+		// Poll until the background job finishes
+		for i := 0; i < 50; i++ {
+			req, _ = http.NewRequest("GET", "/api/import/"+job.ID, nil)
+			req.Header.Set(apiKeyHeader, apiKey)
+
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+			json.Unmarshal(w.Body.Bytes(), &job)
+			if job.FinishedAt != nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		return &job
+	}
+
+	job := importCSV("/api/import?dryRun=true")
+	require.Equal(t, ImportJobSucceeded, job.Status)
+	require.Equal(t, 2, job.RowsImported)
+	students, _ := store.GetStudentsOfTeacher(NewTeacher("teacher@example.com"))
+	require.Empty(t, students)
+
+	job = importCSV("/api/import")
+	require.Equal(t, ImportJobSucceeded, job.Status)
+	require.Equal(t, 3, job.RowsProcessed)
+	require.Equal(t, 1, job.RowsFailed)
+	require.JSONEq(t, `[{"row":4,"message":"Student's email (invalid) is invalid."}]`, job.Errors.String())
+
+	students, _ = store.GetStudentsOfTeacher(NewTeacher("teacher@example.com"))
+	require.Len(t, students, 2)
+	suspended, _ := store.IsSuspended("student2@example.com")
+	require.True(t, suspended)
+	cleanUp(store)
+}
+
+func TestExportIsScopedToTeacher(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	teacherKey := newTestAPIKey(store, "teacher1@example.com", RoleTeacher)
+
+	store.AddTeachers([]*Teacher{NewTeacher("teacher1@example.com"), NewTeacher("teacher2@example.com")})
+	store.AddStudents([]*Student{NewStudent("student1@example.com"), NewStudent("student2@example.com")})
+	store.Register([]*TeacherStudentPair{
+		NewTeacherStudentPair("teacher1@example.com", "student1@example.com"),
+		NewTeacherStudentPair("teacher2@example.com", "student2@example.com"),
+	})
+	store.AddSuspension(NewSuspension("student1@example.com"))
+
+	export := func(path string, accept string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", accept)
+		req.Header.Set(apiKeyHeader, teacherKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := export("/api/export", "text/csv")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "teacher,student\nteacher1@example.com,student1@example.com\n", w.Body.String())
+
+	w = export("/api/export?dataset=students&format=jsonl", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"email":"student1@example.com","suspended":true,"suspendedUntil":null}`, w.Body.String())
+
+	w = export("/api/export?teacher=teacher2@example.com", "")
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	w = export("/api/export", "application/pdf")
+	require.Equal(t, http.StatusNotAcceptable, w.Code)
+	cleanUp(store)
+}
+
+func TestV2UpdateAndSearchProfiles(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	apiKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	send := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, apiKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/api/v2/registrations", `{
+		"teacher": "teacher@example.com",
+		"students": ["student1@example.com", "student2@example.com"],
+		"teacherProfile": {"givenName": "Ken"},
+		"studentProfiles": {"student1@example.com": {"givenName": "Jon", "familyName": "Tan", "sisId": "S1", "gradeLevel": "7"}}
+	}`)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	w = send("PATCH", "/api/v2/students/student2@example.com", `{"givenName": "Hon", "sisId": "S1"}`)
+	require.Equal(t, http.StatusConflict, w.Code)
+
+	w = send("PATCH", "/api/v2/students/student2@example.com", `{"givenName": "Hon", "active": false}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"email":"student2@example.com","givenName":"Hon","active":false}`, w.Body.String())
+
+	w = send("GET", "/api/v2/students?q=jon%20tan", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"students":[{"email":"student1@example.com","givenName":"Jon","familyName":"Tan","sisId":"S1","active":true,"gradeLevel":"7"}]}`, w.Body.String())
+
+	w = send("GET", "/api/v2/teachers/teacher@example.com/students?active=false", "")
+	require.JSONEq(t, `{"students":[{"email":"student2@example.com","givenName":"Hon","active":false}]}`, w.Body.String())
+
+	w = send("GET", "/api/v2/teachers?q=ken", "")
+	require.JSONEq(t, `{"teachers":[{"email":"teacher@example.com","givenName":"Ken","active":true}]}`, w.Body.String())
+
+	require.Equal(t, http.StatusNotFound, send("PATCH", "/api/v2/students/missing@example.com", `{}`).Code)
+
+	// Creating is audited with the profile, and rolled back with the audit entry on conflicts
+	require.Equal(t, http.StatusConflict, send("POST", "/api/v2/students", `{"email": "student3@example.com", "sisId": "S1"}`).Code)
+	require.Equal(t, http.StatusCreated, send("POST", "/api/v2/students", `{"email": "student3@example.com", "givenName": "Ann"}`).Code)
+	require.Equal(t, http.StatusCreated, send("POST", "/api/v2/teachers", `{"email": "teacher2@example.com"}`).Code)
+	w = send("GET", "/api/audit?action="+AuditActionCreateStudent, "")
+	require.Equal(t, 1, strings.Count(w.Body.String(), `"givenName":"Ann"`))
+	require.Contains(t, send("GET", "/api/audit?action="+AuditActionCreateTeacher, "").Body.String(), "teacher2@example.com")
+	cleanUp(store)
+}
+
+func TestV2Classes(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	teacherKey := newTestAPIKey(store, "teacher1@example.com", RoleTeacher)
+
+	send := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, teacherKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	require.NoError(t, store.ForSchool(DefaultSchoolID).AddTerm(NewTerm("2024-T1", "Term 1", "2024-01-01", "2099-12-31")))
+	w := send("POST", "/api/v2/registrations", `{"teacher": "teacher1@example.com", "students": ["student1@example.com", "student2@example.com"]}`)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	w = send("POST", "/api/v2/classes", `{"code": "3A-MATH", "name": "Maths", "term": "2024-T1", "teachers": ["teacher2@example.com"]}`)
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	// The term of a class must be one of the school's terms
+	w = send("POST", "/api/v2/classes", `{"code": "3A-MATH", "name": "Maths", "term": "2024-T9", "teachers": ["teacher1@example.com"]}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.JSONEq(t, `{"error":"not found","message":"Term (2024-T9) does not exist."}`, w.Body.String())
+
+	w = send("POST", "/api/v2/classes", `{"code": "3A-MATH", "name": "Maths", "term": "2024-T1", "teachers": ["teacher2@example.com", "teacher1@example.com"]}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.JSONEq(t, `{"code":"3A-MATH","name":"Maths","term":"2024-T1","teachers":["teacher1@example.com","teacher2@example.com"],"isDefault":false}`, w.Body.String())
+	require.Equal(t, http.StatusBadRequest, send("PATCH", "/api/v2/classes/3A-MATH", `{"term": "2024-T9"}`).Code)
+
+	w = send("PUT", "/api/v2/classes/3A-MATH/students/student2@example.com", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	w = send("GET", "/api/commonstudents?teacher=teacher1@example.com&class=3A-MATH", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"students":["student2@example.com"]}`, w.Body.String())
+
+	w = send("POST", "/api/v2/notifications", `{"teacher": "teacher1@example.com", "notification": "Hello", "class": "3A-MATH"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"recipients":["student2@example.com"]}`, w.Body.String())
+
+	// The other teacher of the class now shares its students
+	w = send("GET", "/api/commonstudents?teacher=teacher1@example.com&teacher=teacher2@example.com", "")
+	require.JSONEq(t, `{"students":["student2@example.com"]}`, w.Body.String())
+
+	w = send("DELETE", "/api/v2/classes/teacher1@example.com", "")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = send("DELETE", "/api/v2/classes/3A-MATH/students/student2@example.com", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, http.StatusNotFound, send("DELETE", "/api/v2/classes/3A-MATH/students/student2@example.com", "").Code)
+
+	w = send("DELETE", "/api/v2/classes/3A-MATH", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, http.StatusNotFound, send("GET", "/api/v2/classes/3A-MATH", "").Code)
+	cleanUp(store)
+}
+
+func TestSchoolsAreIsolated(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	adminKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	require.NoError(t, store.AddSchool(NewSchool("school2", "Second school", []string{"school2.edu"})))
+	key, err := GenerateAPIKey()
+	require.NoError(t, err)
+	school2Key := NewAPIKey("test", "admin2", RoleAdmin, key)
+	school2Key.School = "school2"
+	require.NoError(t, store.AddAPIKey(school2Key))
+
+	send := func(apiKey string, schoolID string, method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, apiKey)
+		if schoolID != "" {
+			req.Header.Set(schoolHeader, schoolID)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(adminKey, "", "POST", "/api/register", `{"teacher": "teacher@school2.edu", "students": ["student1@school2.edu"]}`)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	// The same teacher email in another school is another teacher
+	w = send(key, "", "POST", "/api/register", `{"teacher": "teacher@school2.edu", "students": ["student2@school2.edu"]}`)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	w = send(key, "", "GET", "/api/commonstudents?teacher=teacher@school2.edu", "")
+	require.JSONEq(t, `{"students":["student2@school2.edu"]}`, w.Body.String())
+
+	w = send(adminKey, "school2", "POST", "/api/retrievefornotifications", `{"teacher": "teacher@school2.edu", "notification": "Hello @student1@school2.edu"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = send(adminKey, "", "POST", "/api/retrievefornotifications", `{"teacher": "teacher@school2.edu", "notification": "Hello @student1@school2.edu"}`)
+	require.JSONEq(t, `{"recipients":["student1@school2.edu"]}`, w.Body.String())
+
+	w = send(key, "", "POST", "/api/register", `{"teacher": "teacher@school2.edu", "students": ["student3@example.com"]}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	require.Equal(t, http.StatusForbidden, send(key, DefaultSchoolID, "GET", "/api/v2/school", "").Code)
+
+	// Only admins without a school may choose one
+	teacherKey := newTestAPIKey(store, "teacher@example.com", RoleTeacher)
+	require.Equal(t, http.StatusForbidden, send(teacherKey, "school2", "GET", "/api/commonstudents?teacher=teacher@school2.edu", "").Code)
+	require.Equal(t, http.StatusBadRequest, send(adminKey, "missing", "GET", "/api/v2/school", "").Code)
+
+	w = send(key, "", "GET", "/api/v2/school", "")
+	require.JSONEq(t, `{"id":"school2","name":"Second school","allowedDomains":["school2.edu"]}`, w.Body.String())
+	cleanUp(store)
+}
+
+func TestV2TermsAndRollover(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	adminKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	send := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, adminKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/api/v2/terms", `{"code": "2020-T1", "name": "Term 1", "startsOn": "2020-01-01", "endsOn": "2020-06-30"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	// Once its only term is over, no term is current and requests on enrollments must give one
+	w = send("GET", "/api/v2/terms", "")
+	require.JSONEq(t, `{"terms":[{"code":"2020-T1","name":"Term 1","startsOn":"2020-01-01","endsOn":"2020-06-30","current":false}]}`, w.Body.String())
+	w = send("GET", "/api/commonstudents?teacher=teacher@example.com", "")
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.JSONEq(t, `{"error":"no current term","message":"No term is current, give one with ?term=."}`, w.Body.String())
+	require.Equal(t, http.StatusOK, send("GET", "/api/commonstudents?teacher=teacher@example.com&term=2020-T1", "").Code)
+
+	w = send("POST", "/api/v2/terms", `{"code": "2020-T2", "startsOn": "2020-06-01", "endsOn": "2099-12-31"}`)
+	require.Equal(t, http.StatusConflict, w.Code)
+	w = send("POST", "/api/v2/terms", `{"code": "2020-T2", "startsOn": "2020-07-01", "endsOn": "2099-12-31"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = send("GET", "/api/v2/terms", "")
+	require.JSONEq(t, `{"terms":[
+		{"code":"2020-T1","name":"Term 1","startsOn":"2020-01-01","endsOn":"2020-06-30","current":false},
+		{"code":"2020-T2","name":"","startsOn":"2020-07-01","endsOn":"2099-12-31","current":true}
+	]}`, w.Body.String())
+
+	w = send("POST", "/api/v2/registrations?term=2020-T1", `{"teacher": "teacher@example.com", "students": ["student1@example.com", "student2@example.com"]}`)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	w = send("POST", "/api/v2/classes?term=2020-T1", `{"code": "3A-MATH", "teachers": ["teacher@example.com"]}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, http.StatusNoContent, send("PUT", "/api/v2/classes/3A-MATH/students/student3@example.com?term=2020-T1", "").Code)
+	require.Equal(t, http.StatusOK, send("PATCH", "/api/v2/students/student2@example.com", `{"active": false}`).Code)
+
+	// Requests default to the current term, which has no registrations yet
+	w = send("GET", "/api/commonstudents?teacher=teacher@example.com", "")
+	require.JSONEq(t, `{"students":[]}`, w.Body.String())
+	w = send("GET", "/api/commonstudents?teacher=teacher@example.com&term=2020-T1", "")
+	require.JSONEq(t, `{"students":["student1@example.com","student2@example.com","student3@example.com"]}`, w.Body.String())
+	require.Equal(t, http.StatusBadRequest, send("GET", "/api/commonstudents?teacher=teacher@example.com&term=missing", "").Code)
+
+	w = send("POST", "/api/v2/terms/2020-T2/rollover", `{"classes": {"3A-MATH": "archive"}, "dryRun": true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"from":"2020-T1","to":"2020-T2","dryRun":true,"classes":[
+		{"code":"3A-MATH","action":"archive","students":1,"copied":0,"skipped":[]},
+		{"code":"teacher@example.com","action":"copy","students":2,"copied":1,"skipped":["student2@example.com"]}
+	]}`, w.Body.String())
+	w = send("GET", "/api/commonstudents?teacher=teacher@example.com", "")
+	require.JSONEq(t, `{"students":[]}`, w.Body.String())
+
+	w = send("POST", "/api/v2/terms/2020-T2/rollover", `{"classes": {"3A-MATH": "archive"}}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = send("GET", "/api/commonstudents?teacher=teacher@example.com", "")
+	require.JSONEq(t, `{"students":["student1@example.com"]}`, w.Body.String())
+
+	require.Equal(t, http.StatusBadRequest, send("POST", "/api/v2/terms/2020-T2/rollover", `{"from": "2020-T2"}`).Code)
+	require.Equal(t, http.StatusNotFound, send("POST", "/api/v2/terms/missing/rollover", `{}`).Code)
+	cleanUp(store)
+}
+
+func TestNotificationGuardians(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	adminKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	send := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, adminKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/api/register", `{"teacher": "teacher@example.com", "students": ["student1@example.com", "student2@example.com"]}`)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	w = send("PUT", "/api/v2/students/student1@example.com/guardians/parent1@example.com", `{"name": "Mei Tan", "relationship": "mother", "optedIn": true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"email":"parent1@example.com","name":"Mei Tan","relationship":"mother","optedIn":true}`, w.Body.String())
+	require.Equal(t, http.StatusOK, send("PUT", "/api/v2/students/student1@example.com/guardians/parent2@example.com", `{"relationship": "father"}`).Code)
+	require.Equal(t, http.StatusOK, send("PUT", "/api/v2/students/student2@example.com/guardians/parent3@example.com", `{"optedIn": true}`).Code)
+	require.Equal(t, http.StatusNotFound, send("PUT", "/api/v2/students/missing@example.com/guardians/parent1@example.com", `{}`).Code)
+
+	w = send("GET", "/api/v2/students/student1@example.com/guardians", "")
+	require.JSONEq(t, `{"guardians":[
+		{"email":"parent1@example.com","name":"Mei Tan","relationship":"mother","optedIn":true},
+		{"email":"parent2@example.com","name":"","relationship":"father","optedIn":false}
+	]}`, w.Body.String())
+
+	// Guardians of suspended students are not notified either
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/suspend", `{"student": "student2@example.com"}`).Code)
+
+	w = send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "Hello", "includeGuardians": true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"recipients":["student1@example.com"],"guardianRecipients":["parent1@example.com"]}`, w.Body.String())
+
+	w = send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "Hello"}`)
+	require.JSONEq(t, `{"recipients":["student1@example.com"]}`, w.Body.String())
+
+	require.Equal(t, http.StatusNoContent, send("DELETE", "/api/v2/students/student1@example.com/guardians/parent1@example.com", "").Code)
+	require.Equal(t, http.StatusNotFound, send("DELETE", "/api/v2/students/student1@example.com/guardians/parent1@example.com", "").Code)
+	cleanUp(store)
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	adminKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	send := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, adminKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/api/register", `{"teacher": "teacher@example.com", "students": ["student1@example.com", "student2@example.com", "student3@example.com"]}`)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	w = send("GET", "/api/v2/students/student1@example.com/preferences", "")
+	require.JSONEq(t, `{"channels":["email","sms","push"],"mutedCategories":[],"timeZone":"UTC"}`, w.Body.String())
+
+	w = send("PUT", "/api/v2/students/student1@example.com/preferences", `{"mutedCategories": ["sports"]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, http.StatusOK, send("PUT", "/api/v2/students/student2@example.com/preferences", `{"channels": ["push"]}`).Code)
+	require.Equal(t, http.StatusBadRequest, send("PUT", "/api/v2/students/student2@example.com/preferences", `{"channels": ["pager"]}`).Code)
+	require.Equal(t, http.StatusNotFound, send("PUT", "/api/v2/students/missing@example.com/preferences", `{}`).Code)
+
+	// Students manage only their own preferences
+	studentKey := newTestAPIKey(store, "student1@example.com", RoleStudent)
+	sendAsStudent := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, studentKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	w = sendAsStudent("GET", "/api/v2/students/student1@example.com/preferences", "")
+	require.JSONEq(t, `{"channels":["email","sms","push"],"mutedCategories":["sports"],"timeZone":"UTC"}`, w.Body.String())
+	require.Equal(t, http.StatusForbidden, sendAsStudent("GET", "/api/v2/students/student2@example.com/preferences", "").Code)
+	require.Equal(t, http.StatusForbidden, sendAsStudent("PUT", "/api/v2/students/student2@example.com/preferences", `{}`).Code)
+
+	w = send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "Match on Friday", "category": "sports"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"recipients":["student3@example.com"],"excluded":[
+		{"email":"student1@example.com","reason":"muted_category"},
+		{"email":"student2@example.com","reason":"channel_disabled"}
+	]}`, w.Body.String())
+
+	w = send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "School closed", "category": "sports", "urgent": true}`)
+	require.JSONEq(t, `{"recipients":["student1@example.com","student2@example.com","student3@example.com"]}`, w.Body.String())
+	cleanUp(store)
+}
+
+func TestRetrieveNotificationsVerbose(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	adminKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	send := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, adminKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/register", `{"teacher": "teacher@example.com", "students": ["student1@example.com", "student2@example.com", "student3@example.com"]}`).Code)
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/register", `{"teacher": "other@example.com", "students": ["student4@example.com"]}`).Code)
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/suspend", `{"student": "student2@example.com"}`).Code)
+	require.Equal(t, http.StatusOK, send("PUT", "/api/v2/students/student3@example.com/preferences", `{"channels": []}`).Code)
+
+	// Verbose mode does not skip unknown mentions, which fail the request by default
+	notification := `{"teacher": "teacher@example.com", "notification": "Hello @student1@example.com @student4@example.com @missing@example.com", "verbose": true}`
+	w := send("POST", "/api/retrievefornotifications", notification)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "Student (missing@example.com) mentioned is not registered.")
+
+	w = send("POST", "/api/retrievefornotifications", strings.Replace(notification, `"verbose": true`, `"verbose": true, "unknownMentions": "skip"`, 1))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{
+		"recipients":["student1@example.com","student4@example.com"],
+		"excluded":[{"email":"student3@example.com","reason":"channel_disabled"}],
+		"warnings":[{"code":"unknown_mention_skipped","mention":"missing@example.com","message":"Student (missing@example.com) mentioned is not registered."}],
+		"explanations":[
+			{"email":"missing@example.com","included":false,"inclusionReasons":[],"exclusionReasons":[{"reason":"unregistered_mention"}]},
+			{"email":"student1@example.com","included":true,"inclusionReasons":["registered","mentioned"],"exclusionReasons":[]},
+			{"email":"student2@example.com","included":false,"inclusionReasons":["registered"],"exclusionReasons":[{"reason":"suspended"}]},
+			{"email":"student3@example.com","included":false,"inclusionReasons":["registered"],"exclusionReasons":[{"reason":"channel_disabled"}]},
+			{"email":"student4@example.com","included":true,"inclusionReasons":["mentioned"],"exclusionReasons":[]}
+		],
+		"mentions":[
+			{"kind":"student","value":"student1@example.com","text":"@student1@example.com","start":6,"end":27},
+			{"kind":"student","value":"student4@example.com","text":"@student4@example.com","start":28,"end":49},
+			{"kind":"student","value":"missing@example.com","text":"@missing@example.com","start":50,"end":70}
+		]
+	}`, w.Body.String())
+
+	// Without verbose, the same request fails the same way
+	w = send("POST", "/api/retrievefornotifications", strings.Replace(notification, `"verbose": true`, `"verbose": false`, 1))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	cleanUp(store)
+}
+
+func TestRetrieveNotificationsUnknownMentions(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	adminKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	send := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, adminKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/register", `{"teacher": "teacher@example.com", "students": ["student1@example.com"]}`).Code)
+
+	notification := `{"teacher": "teacher@example.com", "notification": "Hello @student2@example.com @class:NOPE", "unknownMentions": "%s"}`
+	require.Equal(t, http.StatusBadRequest, send("POST", "/api/retrievefornotifications", fmt.Sprintf(notification, "strict")).Code)
+	require.Equal(t, http.StatusBadRequest, send("POST", "/api/retrievefornotifications", fmt.Sprintf(notification, "lenient")).Code)
+
+	w := send("POST", "/api/retrievefornotifications", fmt.Sprintf(notification, "skip"))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{
+		"recipients":["student1@example.com"],
+		"warnings":[
+			{"code":"unknown_mention_skipped","mention":"student2@example.com","message":"Student (student2@example.com) mentioned is not registered."},
+			{"code":"unknown_mention_skipped","mention":"NOPE","message":"Class (NOPE) mentioned does not exist."}
+		]
+	}`, w.Body.String())
+
+	// Auto-registered students are notified, and only registered to the teacher with registerMentioned
+	w = send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "Hello @student2@example.com", "unknownMentions": "auto_register"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{
+		"recipients":["student1@example.com","student2@example.com"],
+		"warnings":[{"code":"mention_registered","mention":"student2@example.com","message":"Student (student2@example.com) mentioned was not registered and has been added."}]
+	}`, w.Body.String())
+	w = send("GET", "/api/commonstudents?teacher=teacher@example.com", "")
+	require.JSONEq(t, `{"students":["student1@example.com"]}`, w.Body.String())
+
+	w = send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "Hello @student3@example.com", "unknownMentions": "auto_register", "registerMentioned": true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = send("GET", "/api/commonstudents?teacher=teacher@example.com", "")
+	require.JSONEq(t, `{"students":["student1@example.com","student3@example.com"]}`, w.Body.String())
+
+	// Verbose mode explains the students auto_register added
+	w = send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "Hello @student5@example.com", "unknownMentions": "auto_register", "verbose": true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{
+		"recipients":["student1@example.com","student3@example.com","student5@example.com"],
+		"warnings":[{"code":"mention_registered","mention":"student5@example.com","message":"Student (student5@example.com) mentioned was not registered and has been added."}],
+		"explanations":[
+			{"email":"student1@example.com","included":true,"inclusionReasons":["registered"],"exclusionReasons":[]},
+			{"email":"student3@example.com","included":true,"inclusionReasons":["registered"],"exclusionReasons":[]},
+			{"email":"student5@example.com","included":true,"inclusionReasons":["mentioned","mention_registered"],"exclusionReasons":[]}
+		],
+		"mentions":[{"kind":"student","value":"student5@example.com","text":"@student5@example.com","start":6,"end":27}]
+	}`, w.Body.String())
+
+	// Students are not added when the notification is rejected
+	w = send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "class": "NOPE", "notification": "Hello @student4@example.com", "unknownMentions": "auto_register"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "Hello @student4@example.com"}`)
+	require.JSONEq(t, `{"message":"Student (student4@example.com) mentioned is not registered."}`, w.Body.String())
+	cleanUp(store)
+}
+
+func TestRetrieveNotificationsGroupMentions(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	adminKey := newTestAPIKey(store, "admin", RoleAdmin)
+
+	send := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, adminKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/register", `{"teacher": "teacher@example.com", "students": ["student1@example.com"]}`).Code)
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/register", `{"teacher": "other@example.com", "students": ["student2@example.com", "student3@example.com"]}`).Code)
+	require.Equal(t, http.StatusCreated, send("POST", "/api/v2/classes", `{"code": "3A-MATH", "teachers": ["other@example.com"]}`).Code)
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/v2/registrations", `{"teacher": "other@example.com", "students": ["student4@example.com"], "class": "3A-MATH"}`).Code)
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/suspend", `{"student": "student3@example.com"}`).Code)
+
+	w := send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "Hello @teacher:other@example.com and @class:3A-MATH, not \\@student5@example.com"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"recipients":["student1@example.com","student2@example.com","student4@example.com"]}`, w.Body.String())
+
+	w = send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "Exams are over @all-suspended-excluded!"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"recipients":["student1@example.com","student2@example.com","student4@example.com"]}`, w.Body.String())
+
+	w = send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "Hello @class:NOPE"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "Hello @teacher:nobody@example.com"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = send("POST", "/api/v2/notifications/mentions", `{"text": "Hi @\\"Tan\\" <student1@example.com>"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"mentions":[{"kind":"student","value":"student1@example.com","name":"Tan","text":"@\\"Tan\\" <student1@example.com>","start":3,"end":32}]}`, w.Body.String())
+	cleanUp(store)
+}
+
+func TestScheduledNotifications(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	adminKey := newTestAPIKey(store, "admin", RoleAdmin)
+	otherTeacherKey := newTestAPIKey(store, "other@example.com", RoleTeacher)
+
+	send := func(method string, path string, body string, key string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, key)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/register", `{"teacher": "teacher@example.com", "students": ["student1@example.com", "student2@example.com"]}`, adminKey).Code)
+
+	sendAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	w := send("POST", "/api/v2/notifications/scheduled", `{"teacher": "teacher@example.com", "notification": "Hello", "sendAt": "2020-01-01T07:00:00Z"}`, adminKey)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = send("POST", "/api/v2/notifications/scheduled", fmt.Sprintf(`{"teacher": "teacher@example.com", "notification": "Hello", "sendAt": "%s"}`, sendAt), adminKey)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var scheduled ScheduledNotification
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &scheduled))
+	require.Equal(t, ScheduledNotificationPending, scheduled.Status)
+	path := "/api/v2/notifications/scheduled/" + scheduled.ID
+
+	w = send("PUT", path, fmt.Sprintf(`{"teacher": "teacher@example.com", "notification": "Hello again", "sendAt": "%s"}`, sendAt), adminKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "Hello again")
+
+	// Other teachers cannot see or change it
+	require.Equal(t, http.StatusNotFound, send("GET", path, "", otherTeacherKey).Code)
+	require.Equal(t, http.StatusNotFound, send("DELETE", path, "", otherTeacherKey).Code)
+	w = send("GET", "/api/v2/notifications/scheduled", "", otherTeacherKey)
+	require.JSONEq(t, `{"scheduledNotifications":[]}`, w.Body.String())
+
+	// Suspensions in force when it is sent apply
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/suspend", `{"student": "student2@example.com"}`, adminKey).Code)
+	_, err := store.db.Exec("UPDATE scheduled_notifications SET send_at=$1", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	count, err := sendDueNotifications(store, time.Now().UTC())
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	w = send("GET", path, "", adminKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &scheduled))
+	require.Equal(t, ScheduledNotificationSent, scheduled.Status)
+	require.JSONEq(t, `{"recipients":["student1@example.com"]}`, string(scheduled.Result))
+	require.Equal(t, http.StatusConflict, send("DELETE", path, "", adminKey).Code)
+
+	w = send("POST", "/api/v2/notifications/scheduled", fmt.Sprintf(`{"teacher": "teacher@example.com", "notification": "Bye", "sendAt": "%s"}`, sendAt), adminKey)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &scheduled))
+	require.Equal(t, http.StatusNoContent, send("DELETE", "/api/v2/notifications/scheduled/"+scheduled.ID, "", adminKey).Code)
+	w = send("GET", "/api/v2/notifications/scheduled?status=all", "", adminKey)
+	require.Contains(t, w.Body.String(), `"status":"cancelled"`)
+	cleanUp(store)
+}
+
+func TestRecurringNotifications(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	adminKey := newTestAPIKey(store, "admin", RoleAdmin)
+	otherTeacherKey := newTestAPIKey(store, "other@example.com", RoleTeacher)
+
+	send := func(method string, path string, body string, key string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, key)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/register", `{"teacher": "teacher@example.com", "students": ["student1@example.com"]}`, adminKey).Code)
+
+	w := send("POST", "/api/v2/notifications/recurring", `{"teacher": "teacher@example.com", "notification": "Homework", "schedule": "0 25 * * *"}`, adminKey)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "Schedule is invalid")
+	w = send("POST", "/api/v2/notifications/recurring", `{"teacher": "teacher@example.com", "notification": "Homework", "schedule": "0 7 * * *", "timeZone": "Mars/Olympus"}`, adminKey)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Daily at 7am in Singapore, twice
+	w = send("POST", "/api/v2/notifications/recurring", `{"teacher": "teacher@example.com", "notification": "Homework", "schedule": "FREQ=DAILY;BYHOUR=7;BYMINUTE=0", "timeZone": "Asia/Singapore", "count": 2}`, adminKey)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var recurring RecurringNotification
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &recurring))
+	require.Equal(t, 1, recurring.Occurrences)
+	require.NotNil(t, recurring.NextAt)
+	first := *recurring.NextAt
+	require.Equal(t, 7, first.In(time.FixedZone("SGT", 8*60*60)).Hour())
+	path := "/api/v2/notifications/recurring/" + recurring.ID
+	require.Equal(t, http.StatusNotFound, send("GET", path, "", otherTeacherKey).Code)
+
+	// Its occurrence is a pending scheduled notification
+	w = send("GET", "/api/v2/notifications/scheduled", "", adminKey)
+	var scheduled ScheduledNotificationsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &scheduled))
+	require.Len(t, scheduled.ScheduledNotifications, 1)
+	require.Equal(t, recurring.ID, scheduled.ScheduledNotifications[0].RecurringID)
+	require.True(t, first.Equal(scheduled.ScheduledNotifications[0].SendAt))
+
+	// A holiday on the day cancels the occurrence, which no longer counts
+	holiday := first.In(time.FixedZone("SGT", 8*60*60)).Format(time.DateOnly)
+	require.Equal(t, http.StatusForbidden, send("PUT", "/api/v2/holidays/"+holiday, `{"name": "Founders' Day"}`, otherTeacherKey).Code)
+	require.Equal(t, http.StatusBadRequest, send("PUT", "/api/v2/holidays/tomorrow", `{"name": "Founders' Day"}`, adminKey).Code)
+	require.Equal(t, http.StatusOK, send("PUT", "/api/v2/holidays/"+holiday, `{"name": "Founders' Day"}`, adminKey).Code)
+	w = send("GET", "/api/v2/holidays", "", otherTeacherKey)
+	require.JSONEq(t, fmt.Sprintf(`{"holidays":[{"date":"%s","name":"Founders' Day"}]}`, holiday), w.Body.String())
+	w = send("GET", "/api/v2/notifications/scheduled?status=cancelled", "", adminKey)
+	require.Contains(t, w.Body.String(), "Cancelled for Founders' Day")
+
+	// Once the cancelled occurrence is due, the next one is scheduled the day after
+	count, err := advanceRecurringNotifications(store, first)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	w = send("GET", path, "", adminKey)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &recurring))
+	require.Equal(t, 1, recurring.Occurrences)
+	require.True(t, first.Add(24*time.Hour).Equal(*recurring.NextAt))
+
+	// Cancelling it cancels its pending occurrence
+	require.Equal(t, http.StatusNoContent, send("DELETE", path, "", adminKey).Code)
+	require.Equal(t, http.StatusConflict, send("DELETE", path, "", adminKey).Code)
+	w = send("GET", "/api/v2/notifications/scheduled", "", adminKey)
+	require.JSONEq(t, `{"scheduledNotifications":[]}`, w.Body.String())
+	w = send("GET", "/api/v2/notifications/recurring?status=cancelled", "", adminKey)
+	require.Contains(t, w.Body.String(), recurring.ID)
+
+	require.Equal(t, http.StatusNoContent, send("DELETE", "/api/v2/holidays/"+holiday, "", adminKey).Code)
+	require.Equal(t, http.StatusNotFound, send("DELETE", "/api/v2/holidays/"+holiday, "", adminKey).Code)
+	cleanUp(store)
+}
+
+func TestNotificationTemplates(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	adminKey := newTestAPIKey(store, "admin", RoleAdmin)
+	teacherKey := newTestAPIKey(store, "teacher@example.com", RoleTeacher)
+
+	send := func(method string, path string, body string, key string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, key)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/register", `{"teacher": "teacher@example.com", "students": ["student1@example.com", "student2@example.com"]}`, adminKey).Code)
+	require.Equal(t, http.StatusOK, send("PATCH", "/api/v2/students/student1@example.com", `{"givenName": "Ah Kow", "familyName": "Tan"}`, adminKey).Code)
+
+	w := send("POST", "/api/v2/templates", `{"name": "Homework", "body": "{{range .Vars}}{{end}}"}`, teacherKey)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = send("POST", "/api/v2/templates", `{"name": "Homework", "body": "Hi {{.Student.Name}}, {{.Vars.subject}} homework is due. @student1@example.com"}`, teacherKey)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var notificationTemplate NotificationTemplate
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &notificationTemplate))
+	require.Equal(t, "teacher@example.com", notificationTemplate.CreatedBy)
+	require.Equal(t, http.StatusConflict, send("POST", "/api/v2/templates", `{"name": "Homework", "body": "Hi"}`, adminKey).Code)
+
+	w = send("POST", "/api/v2/templates/preview", fmt.Sprintf(`{"templateId": "%s", "student": "student1@example.com", "variables": {"subject": "Maths"}}`, notificationTemplate.ID), teacherKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"notification":"Hi Ah Kow, Maths homework is due. @student1@example.com"`)
+	w = send("POST", "/api/v2/templates/preview", fmt.Sprintf(`{"templateId": "%s"}`, notificationTemplate.ID), teacherKey)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Rendered for each recipient
+	notification := fmt.Sprintf(`{"teacher": "teacher@example.com", "templateId": "%s", "variables": {"subject": "Maths"}}`, notificationTemplate.ID)
+	w = send("POST", "/api/retrievefornotifications", notification, adminKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{
+		"recipients":["student1@example.com","student2@example.com"],
+		"renderedNotifications":[
+			{"recipient":"student1@example.com","notification":"Hi Ah Kow, Maths homework is due. @student1@example.com"},
+			{"recipient":"student2@example.com","notification":"Hi student2@example.com, Maths homework is due. @student1@example.com"}
+		]
+	}`, w.Body.String())
+	w = send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "templateId": "nope"}`, adminKey)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com"}`, adminKey)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Only admins and their creator may change them
+	otherTeacherKey := newTestAPIKey(store, "other@example.com", RoleTeacher)
+	path := "/api/v2/templates/" + notificationTemplate.ID
+	require.Equal(t, http.StatusForbidden, send("PUT", path, `{"name": "Homework", "body": "Hi"}`, otherTeacherKey).Code)
+	require.Equal(t, http.StatusOK, send("PUT", path, `{"name": "Homework", "body": "Hi {{.Student.GivenName}}"}`, teacherKey).Code)
+	require.Equal(t, http.StatusForbidden, send("DELETE", path, "", otherTeacherKey).Code)
+	require.Equal(t, http.StatusNoContent, send("DELETE", path, "", adminKey).Code)
+	require.Equal(t, http.StatusNotFound, send("GET", path, "", adminKey).Code)
+	cleanUp(store)
+}
+
+func TestNotificationStreams(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	server := httptest.NewServer(SetupRouter(store))
+	defer server.Close()
+	adminKey := newTestAPIKey(store, "admin", RoleAdmin)
+	studentKey := newTestAPIKey(store, "student1@example.com", RoleStudent)
+
+	send := func(method string, path string, body string, key string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, key)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	notify := func(notification string) {
+		resp := send("POST", "/api/retrievefornotifications", fmt.Sprintf(`{"teacher": "teacher@example.com", "notification": "%s"}`, notification), adminKey)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp := send("POST", "/api/register", `{"teacher": "teacher@example.com", "students": ["student1@example.com", "student2@example.com"]}`, adminKey)
+	resp.Body.Close()
+	notify("First")
+
+	// Students may only stream their own notifications
+	resp = send("GET", "/api/students/student2@example.com/notifications/stream", "", studentKey)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Events are sent from the start, then as they are published
+	resp = send("GET", "/api/students/student1@example.com/notifications/stream", "", studentKey)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() (string, StudentNotification) {
+		var id string
+		var notification StudentNotification
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimSpace(strings.TrimPrefix(line, "id: "))
+			case strings.HasPrefix(line, "data: "):
+				require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &notification))
+			case line == "\n" && id != "":
+				return id, notification
+			}
+		}
+	}
+	firstID, notification := readEvent()
+	require.Equal(t, "First", notification.Notification)
+	require.Equal(t, "teacher@example.com", notification.Teacher)
+	notify("Second")
+	secondID, notification := readEvent()
+	require.Equal(t, "Second", notification.Notification)
+	resp.Body.Close()
+
+	// Reconnecting clients resume after the last event they received
+	req, _ := http.NewRequest("GET", server.URL+"/api/students/student1@example.com/notifications/stream", nil)
+	req.Header.Set(apiKeyHeader, studentKey)
+	req.Header.Set(lastEventIDHeader, firstID)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	reader = bufio.NewReader(resp.Body)
+	_, notification = readEvent()
+	require.Equal(t, "Second", notification.Notification)
+	resp.Body.Close()
+
+	// Notifications created just before the last event are sent again, in case they committed after it
+	req.Header.Set(lastEventIDHeader, secondID)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	reader = bufio.NewReader(resp.Body)
+	id, notification := readEvent()
+	require.Equal(t, firstID, id)
+	require.Equal(t, "First", notification.Notification)
+	resp.Body.Close()
+
+	// WebSockets get the same notifications
+	config, err := websocket.NewConfig("ws"+strings.TrimPrefix(server.URL, "http")+"/api/students/student1@example.com/notifications/ws?lastEventId="+firstID, server.URL)
+	require.NoError(t, err)
+	config.Header.Set(apiKeyHeader, studentKey)
+	conn, err := websocket.DialConfig(config)
+	require.NoError(t, err)
+	defer conn.Close()
+	var message StreamMessage
+	require.NoError(t, websocket.JSON.Receive(conn, &message))
+	require.Equal(t, StreamMessageNotification, message.Type)
+	require.Equal(t, "Second", message.Notification.Notification)
+	notify("Third")
+	require.NoError(t, websocket.JSON.Receive(conn, &message))
+	require.Equal(t, "Third", message.Notification.Notification)
+	cleanUp(store)
+}
+
+func TestStudentInbox(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	adminKey := newTestAPIKey(store, "admin", RoleAdmin)
+	teacherKey := newTestAPIKey(store, "teacher@example.com", RoleTeacher)
+	otherTeacherKey := newTestAPIKey(store, "teacher2@example.com", RoleTeacher)
+	studentKey := newTestAPIKey(store, "student1@example.com", RoleStudent)
+
+	send := func(method string, path string, body string, key string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, key)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	listInbox := func(query string) InboxResponse {
+		w := send("GET", "/api/students/student1@example.com/inbox"+query, "", studentKey)
+		require.Equal(t, http.StatusOK, w.Code)
+		var inbox InboxResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &inbox))
+		return inbox
+	}
+
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/register", `{"teacher": "teacher@example.com", "students": ["student1@example.com", "student2@example.com"]}`, adminKey).Code)
+	for _, notification := range []string{"First", "Second", "Third"} {
+		w := send("POST", "/api/retrievefornotifications", fmt.Sprintf(`{"teacher": "teacher@example.com", "notification": "%s", "category": "homework"}`, notification), adminKey)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// Students may only read their own inbox
+	require.Equal(t, http.StatusForbidden, send("GET", "/api/students/student2@example.com/inbox", "", studentKey).Code)
+	require.Equal(t, http.StatusNotFound, send("GET", "/api/students/nobody@example.com/inbox", "", adminKey).Code)
+	require.Equal(t, http.StatusBadRequest, send("GET", "/api/students/student1@example.com/inbox?limit=0", "", studentKey).Code)
+
+	// Newest first, in pages
+	inbox := listInbox("?limit=2")
+	require.Equal(t, 3, inbox.Unread)
+	require.Len(t, inbox.Notifications, 2)
+	require.Equal(t, "Third", inbox.Notifications[0].Notification)
+	require.Nil(t, inbox.Notifications[0].ReadAt)
+	require.NotNil(t, inbox.NextCursor)
+	inbox = listInbox("?limit=2&cursor=" + *inbox.NextCursor)
+	require.Len(t, inbox.Notifications, 1)
+	require.Equal(t, "First", inbox.Notifications[0].Notification)
+	require.Nil(t, inbox.NextCursor)
+	first := inbox.Notifications[0]
+
+	// Marking as read keeps when it was first read
+	w := send("POST", fmt.Sprintf("/api/students/student1@example.com/inbox/%d/read", first.ID), "", studentKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	var read StudentNotification
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &read))
+	require.NotNil(t, read.ReadAt)
+	w = send("POST", fmt.Sprintf("/api/students/student1@example.com/inbox/%d/read", first.ID), "", studentKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	var readAgain StudentNotification
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &readAgain))
+	require.True(t, read.ReadAt.Equal(*readAgain.ReadAt))
+	require.Equal(t, http.StatusNotFound, send("POST", fmt.Sprintf("/api/students/student2@example.com/inbox/%d/read", first.ID), "", adminKey).Code)
+
+	inbox = listInbox("?unread=true")
+	require.Equal(t, 2, inbox.Unread)
+	require.Len(t, inbox.Notifications, 2)
+	w = send("GET", "/api/students/student1@example.com/inbox/unread", "", studentKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"unread":2,"categories":{"homework":2}}`, w.Body.String())
+
+	// Read receipts are visible to the sending teacher
+	w = send("GET", "/api/v2/notifications/sent", "", teacherKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	var sent SentNotificationsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sent))
+	require.Len(t, sent.SentNotifications, 3)
+	require.Equal(t, "First", sent.SentNotifications[2].Notification)
+	require.Equal(t, 2, sent.SentNotifications[2].Recipients)
+	require.Equal(t, 1, sent.SentNotifications[2].Read)
+	require.Equal(t, 0, sent.SentNotifications[0].Read)
+
+	w = send("GET", "/api/v2/notifications/sent/"+first.NotificationID, "", teacherKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	var receipts SentNotification
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &receipts))
+	require.Len(t, receipts.Receipts, 2)
+	require.Equal(t, "student1@example.com", receipts.Receipts[0].StudentEmail)
+	require.NotNil(t, receipts.Receipts[0].ReadAt)
+	require.Nil(t, receipts.Receipts[1].ReadAt)
+	require.Equal(t, http.StatusNotFound, send("GET", "/api/v2/notifications/sent/"+first.NotificationID, "", otherTeacherKey).Code)
+	w = send("GET", "/api/v2/notifications/sent", "", otherTeacherKey)
+	require.JSONEq(t, `{"sentNotifications":[],"nextCursor":null}`, w.Body.String())
+
+	// Marking all as read leaves those received after upTo unread
+	w = send("POST", fmt.Sprintf("/api/students/student1@example.com/inbox/read?upTo=%d", inbox.Notifications[1].ID), "", studentKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"marked":1}`, w.Body.String())
+	w = send("POST", "/api/students/student1@example.com/inbox/read", "", studentKey)
+	require.JSONEq(t, `{"marked":1}`, w.Body.String())
+	require.Equal(t, 0, listInbox("").Unread)
+	cleanUp(store)
+}
+
+func TestWebhooks(t *testing.T) {
+	store := newTestStore()
+	defer store.db.Close()
+	router := SetupRouter(store)
+	adminKey := newTestAPIKey(store, "admin", RoleAdmin)
+	config := WebhookConfig{Timeout: 5 * time.Second, MaxAttempts: 3, RetryDelay: time.Minute, MaxRetryDelay: time.Hour}
+	client := NewWebhookClient(config)
+	secret := "0123456789abcdef0123"
+
+	send := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(apiKeyHeader, adminKey)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Receives events with a valid signature, responding with status
+	var mu sync.Mutex
+	received := []WebhookEvent{}
+	status := http.StatusOK
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get(webhookSignatureHeader) != "sha256="+SignWebhookPayload(secret, r.Header.Get(webhookTimestampHeader), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event WebhookEvent
+		json.Unmarshal(body, &event)
+		received = append(received, event)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+	receivedTypes := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		types := []string{}
+		for _, event := range received {
+			types = append(types, event.Type)
+		}
+		return types
+	}
+
+	require.Equal(t, http.StatusBadRequest, send("POST", "/api/v2/webhooks", `{"url": "ftp://example.com", "events": ["student.registered"]}`).Code)
+	require.Equal(t, http.StatusBadRequest, send("POST", "/api/v2/webhooks", `{"url": "https://example.com", "events": ["student.deleted"]}`).Code)
+	w := send("POST", "/api/v2/webhooks", fmt.Sprintf(`{"url": "%s", "events": ["student.registered", "student.suspended", "student.unsuspended"], "secret": "%s"}`, receiver.URL, secret))
+	require.Equal(t, http.StatusCreated, w.Code)
+	var webhook Webhook
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &webhook))
+	require.Equal(t, secret, webhook.Secret)
+	require.True(t, webhook.Active)
+
+	// Secrets are only returned when set, and never audited
+	w = send("GET", "/api/v2/webhooks/"+webhook.ID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, w.Body.String(), secret)
+	require.NotContains(t, send("GET", "/api/audit?action="+AuditActionCreateWebhook, "").Body.String(), secret)
+
+	// Only subscribed events are queued
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/register", `{"teacher": "teacher@example.com", "students": ["student1@example.com", "student2@example.com"]}`).Code)
+	require.Equal(t, http.StatusNoContent, send("POST", "/api/suspend", `{"student": "student2@example.com"}`).Code)
+	require.Equal(t, http.StatusNoContent, send("DELETE", "/api/v2/students/student2@example.com/suspensions", "").Code)
+	require.Equal(t, http.StatusOK, send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "Hello"}`).Code)
+
+	count, err := deliverDueWebhooks(store, client, config, time.Now().UTC())
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	require.ElementsMatch(t, []string{WebhookEventStudentRegistered, WebhookEventStudentSuspended, WebhookEventStudentUnsuspended}, receivedTypes())
+	for _, event := range received {
+		require.Equal(t, DefaultSchoolID, event.School)
+		if event.Type == WebhookEventStudentRegistered {
+			require.Equal(t, map[string]interface{}{"teacher": "teacher@example.com", "students": []interface{}{"student1@example.com", "student2@example.com"}}, event.Data)
+		}
+	}
+
+	w = send("GET", "/api/v2/webhooks/"+webhook.ID+"/deliveries?status=delivered", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var deliveries WebhookDeliveriesResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &deliveries))
+	require.Len(t, deliveries.Deliveries, 3)
+	require.Equal(t, 1, deliveries.Deliveries[0].Attempts)
+	require.Equal(t, http.StatusOK, deliveries.Deliveries[0].ResponseStatus)
+
+	// Enrolling in a class registers the student to its teachers
+	require.Equal(t, http.StatusCreated, send("POST", "/api/v2/classes", `{"code": "3A-MATH", "teachers": ["teacher@example.com"]}`).Code)
+	require.Equal(t, http.StatusNoContent, send("PUT", "/api/v2/classes/3A-MATH/students/student3@example.com", "").Code)
+	count, err = deliverDueWebhooks(store, client, config, time.Now().UTC())
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.Equal(t, map[string]interface{}{"teacher": "teacher@example.com", "students": []interface{}{"student3@example.com"}, "class": "3A-MATH"}, received[len(received)-1].Data)
+
+	// Failed deliveries are retried later
+	w = send("PATCH", "/api/v2/webhooks/"+webhook.ID, `{"events": ["student.notified"]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, w.Body.String(), secret)
+	status = http.StatusInternalServerError
+	require.Equal(t, http.StatusOK, send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "Hello again"}`).Code)
+	count, err = deliverDueWebhooks(store, client, config, time.Now().UTC())
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	w = send("GET", "/api/v2/webhooks/"+webhook.ID+"/deliveries?status=pending", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &deliveries))
+	require.Len(t, deliveries.Deliveries, 1)
+	pending := deliveries.Deliveries[0]
+	require.Equal(t, WebhookEventStudentNotified, pending.EventType)
+	require.Equal(t, 1, pending.Attempts)
+	require.Equal(t, http.StatusInternalServerError, pending.ResponseStatus)
+	require.NotNil(t, pending.NextAttemptAt)
+	count, err = deliverDueWebhooks(store, client, config, time.Now().UTC())
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	// Redeliveries send the same event as a new delivery
+	status = http.StatusOK
+	w = send("POST", fmt.Sprintf("/api/v2/webhooks/%s/deliveries/%d/redeliver", webhook.ID, pending.ID), "")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var redelivery WebhookDelivery
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &redelivery))
+	require.Equal(t, pending.ID, *redelivery.RedeliveryOf)
+	require.Equal(t, pending.EventID, redelivery.EventID)
+	count, err = deliverDueWebhooks(store, client, config, time.Now().UTC())
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.Equal(t, pending.EventID, received[len(received)-1].ID)
+	require.Equal(t, http.StatusNotFound, send("POST", fmt.Sprintf("/api/v2/webhooks/%s/deliveries/999999/redeliver", webhook.ID), "").Code)
+
+	// Inactive webhooks get no new events
+	require.Equal(t, http.StatusOK, send("PATCH", "/api/v2/webhooks/"+webhook.ID, `{"active": false}`).Code)
+	require.Equal(t, http.StatusOK, send("POST", "/api/retrievefornotifications", `{"teacher": "teacher@example.com", "notification": "Bye"}`).Code)
+	w = send("GET", "/api/v2/webhooks/"+webhook.ID+"/deliveries", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &deliveries))
+	require.Len(t, deliveries.Deliveries, 6)
+
+	require.Equal(t, http.StatusNoContent, send("DELETE", "/api/v2/webhooks/"+webhook.ID, "").Code)
+	require.Equal(t, http.StatusNotFound, send("GET", "/api/v2/webhooks/"+webhook.ID, "").Code)
+	cleanUp(store)
+}
+
+// seedMentionBenchmark registers a class sized roster to a teacher, some of them suspended, and returns the mentions
+// of a notification to the whole year group, half of whom are not registered to the teacher
+func seedMentionBenchmark(b *testing.B) (*Store, *Teacher, []string) {
+	store := newTestStore().ForSchool(DefaultSchoolID)
+	teacher := NewTeacher("teacher@example.com")
+
+	students := []*Student{}
+	pairs := []*TeacherStudentPair{}
+	suspensions := []*Suspension{}
+	mentions := []string{}
+	for i := 0; i < 400; i++ {
+		email := fmt.Sprintf("student%d@example.com", i)
+		students = append(students, NewStudent(email))
+		if i%2 == 0 {
+			pairs = append(pairs, NewTeacherStudentPair(teacher.Email, email))
+		}
+		if i%10 == 0 {
+			suspensions = append(suspensions, NewSuspension(email))
+		}
+		if i < 200 {
+			mentions = append(mentions, email)
+		}
+	}
+
+	if err := store.AddTeacher(teacher); err != nil {
+		b.Fatal(err)
+	}
+	if err := store.AddStudents(students); err != nil {
+		b.Fatal(err)
+	}
+	if err := store.Register(pairs); err != nil {
+		b.Fatal(err)
+	}
+	if err := store.AddSuspensions(suspensions); err != nil {
+		b.Fatal(err)
+	}
+	return store, teacher, mentions
+}
+
+// BenchmarkMentionsPerEmail resolves 200 mentions the way notifications used to, two queries per mention and one for
+// the roster
+func BenchmarkMentionsPerEmail(b *testing.B) {
+	store, teacher, mentions := seedMentionBenchmark(b)
+	defer store.db.Close()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, email := range mentions {
+			if _, err := store.IfStudentExists(email); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := store.IsSuspended(email); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if _, err := store.GetStudentsOfTeacher(teacher); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(2*len(mentions)+1), "queries/op")
+	cleanUp(store)
+}
+
+// BenchmarkMentionsBatched resolves the same mentions with ResolveMentions and GetNotificationRecipients
+func BenchmarkMentionsBatched(b *testing.B) {
+	store, teacher, mentions := seedMentionBenchmark(b)
+	defer store.db.Close()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		now := time.Now().UTC()
+		if _, err := store.ResolveMentions(mentions, now); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := store.GetNotificationRecipients(&NotificationAudience{Teacher: teacher, Students: mentions}, now); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(2, "queries/op")
+	cleanUp(store)
+}
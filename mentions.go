@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Mentions in notifications address students besides those registered to the teacher:
+//
+//	@student@example.com            the student
+//	@"Jon Tan" <student@example.com> the student, with a display name
+//	@class:3A-MATH                  the students of the class
+//	@teacher:teacher@example.com    the students registered to the teacher
+//	@all-suspended-excluded         every student of the school
+//
+// Suspended students are never notified. A mention must not follow a letter, digit or another email character, and
+// \@ is never a mention.
+
+const (
+	MentionKindStudent = "student"
+	MentionKindClass   = "class"
+	MentionKindTeacher = "teacher"
+	MentionKindGroup   = "group"
+
+	GroupAllSuspendedExcluded = "all-suspended-excluded"
+
+	// Policies for mentions of unknown students, see RetrieveNotificationsRequest
+	UnknownMentionsStrict       = "strict"
+	UnknownMentionsSkip         = "skip"
+	UnknownMentionsAutoRegister = "auto_register"
+
+	WarningUnknownMentionSkipped = "unknown_mention_skipped"
+	WarningMentionRegistered     = "mention_registered"
+
+	classMentionPrefix   = "class:"
+	teacherMentionPrefix = "teacher:"
+)
+
+var mentionGroups = []string{GroupAllSuspendedExcluded}
+
+// ParseMentions returns the mentions of the text in order
+func ParseMentions(text string) []*Mention {
+	runes := []rune(text)
+	mentions := []*Mention{}
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == '\\' && i+1 < len(runes) && runes[i+1] == '@' {
+			// Skip the escaped @
+			i++
+			continue
+		}
+		if runes[i] != '@' || (i > 0 && isEmailRune(runes[i-1])) {
+			continue
+		}
+		if mention := parseMentionAt(runes, i); mention != nil {
+			mentions = append(mentions, mention)
+			i = mention.End - 1
+		}
+	}
+	return mentions
+}
+
+// parseMentionAt returns the mention starting with the @ at start, or nil if there is none
+func parseMentionAt(runes []rune, start int) *Mention {
+	rest := runes[start+1:]
+	newMention := func(kind string, value string, length int) *Mention {
+		end := start + 1 + length
+		return &Mention{Kind: kind, Value: value, Text: string(runes[start:end]), Start: start, End: end}
+	}
+
+	switch {
+	case len(rest) > 0 && rest[0] == '"':
+		name, email, length := scanNamedEmail(rest)
+		if email == "" {
+			return nil
+		}
+		mention := newMention(MentionKindStudent, email, length)
+		mention.Name = name
+		return mention
+	case hasRunePrefix(rest, classMentionPrefix):
+		prefixLength := len(classMentionPrefix)
+		length := prefixLength + countRunes(rest[prefixLength:], isClassCodeRune)
+		// Sentences may end right after a mention
+		for length > prefixLength && rest[length-1] == '.' {
+			length--
+		}
+		code := string(rest[prefixLength:length])
+		if !IsValidClassCode(code) {
+			return nil
+		}
+		return newMention(MentionKindClass, code, length)
+	case hasRunePrefix(rest, teacherMentionPrefix):
+		email, length := scanEmail(rest[len(teacherMentionPrefix):])
+		if email == "" {
+			return nil
+		}
+		return newMention(MentionKindTeacher, email, len(teacherMentionPrefix)+length)
+	}
+
+	if email, length := scanEmail(rest); email != "" {
+		return newMention(MentionKindStudent, email, length)
+	}
+	length := countRunes(rest, func(r rune) bool { return r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r) })
+	if group := string(rest[:length]); slices.Contains(mentionGroups, group) {
+		return newMention(MentionKindGroup, group, length)
+	}
+	return nil
+}
+
+// scanEmail returns the email at the start of the runes and its length, or "" if there is none. Trailing dots and
+// dashes are left out, so that punctuation after a mention is not part of it.
+func scanEmail(runes []rune) (string, int) {
+	localLength := countRunes(runes, isEmailLocalRune)
+	if localLength == 0 || localLength == len(runes) || runes[localLength] != '@' {
+		return "", 0
+	}
+
+	domainStart := localLength + 1
+	length := domainStart + countRunes(runes[domainStart:], func(r rune) bool { return r == '.' || r == '-' || isASCIIAlphanumeric(r) })
+	for length > domainStart && (runes[length-1] == '.' || runes[length-1] == '-') {
+		length--
+	}
+
+	// The domain needs a top level domain of at least two letters
+	domain := string(runes[domainStart:length])
+	dot := strings.LastIndex(domain, ".")
+	if dot <= 0 || len(domain)-dot-1 < 2 || strings.IndexFunc(domain[dot+1:], func(r rune) bool { return !unicode.IsLetter(r) }) != -1 {
+		return "", 0
+	}
+
+	email := string(runes[:length])
+	if !IsValidEmail(email) {
+		return "", 0
+	}
+	return email, length
+}
+
+// scanNamedEmail returns the name and email of a "Name" <email> at the start of the runes and its length, or an
+// empty email if there is none
+func scanNamedEmail(runes []rune) (string, string, int) {
+	nameEnd := slices.Index(runes[1:], '"')
+	if nameEnd == -1 {
+		return "", "", 0
+	}
+	nameEnd++
+	name := string(runes[1:nameEnd])
+	if strings.ContainsRune(name, '\n') {
+		return "", "", 0
+	}
+
+	i := nameEnd + 1
+	for i < len(runes) && runes[i] == ' ' {
+		i++
+	}
+	if i == len(runes) || runes[i] != '<' {
+		return "", "", 0
+	}
+	email, length := scanEmail(runes[i+1:])
+	end := i + 1 + length
+	if email == "" || end == len(runes) || runes[end] != '>' {
+		return "", "", 0
+	}
+	return name, email, end + 1
+}
+
+func countRunes(runes []rune, matches func(r rune) bool) int {
+	count := 0
+	for count < len(runes) && matches(runes[count]) {
+		count++
+	}
+	return count
+}
+
+func hasRunePrefix(runes []rune, prefix string) bool {
+	return strings.HasPrefix(string(runes[:min(len(runes), len(prefix))]), prefix)
+}
+
+func isASCIIAlphanumeric(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+func isEmailLocalRune(r rune) bool {
+	return isASCIIAlphanumeric(r) || strings.ContainsRune("._%+-", r)
+}
+
+// Mentions may not follow these, so that the domain of an email in the text is not taken for a mention
+func isEmailRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._%+-@\\", r)
+}
+
+func isClassCodeRune(r rune) bool {
+	return isASCIIAlphanumeric(r) || r == '_' || r == '.' || r == '-'
+}
+
+func handleParseMentions(c *gin.Context, store *Store) {
+	var input ParseMentionsRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+
+	c.JSON(http.StatusOK, MentionsResponse{Mentions: ParseMentions(input.Text)})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMentions(t *testing.T) {
+	mentions := ParseMentions(`Hi @student1@example.com, @"Tan Ah Kow" <student2@example.com> and @class:3A-MATH. See @teacher:teacher@example.com!`)
+	require.Equal(t, []*Mention{
+		{Kind: MentionKindStudent, Value: "student1@example.com", Text: "@student1@example.com", Start: 3, End: 24},
+		{Kind: MentionKindStudent, Value: "student2@example.com", Name: "Tan Ah Kow", Text: `@"Tan Ah Kow" <student2@example.com>`, Start: 26, End: 62},
+		{Kind: MentionKindClass, Value: "3A-MATH", Text: "@class:3A-MATH", Start: 67, End: 81},
+		{Kind: MentionKindTeacher, Value: "teacher@example.com", Text: "@teacher:teacher@example.com", Start: 87, End: 115},
+	}, mentions)
+
+	mentions = ParseMentions("Everyone @all-suspended-excluded: exams are over.")
+	require.Equal(t, []*Mention{{Kind: MentionKindGroup, Value: GroupAllSuspendedExcluded, Text: "@all-suspended-excluded", Start: 9, End: 32}}, mentions)
+}
+
+func TestParseMentionsOffsetsAreInCharacters(t *testing.T) {
+	mentions := ParseMentions("Félicitations @student1@example.com")
+	require.Len(t, mentions, 1)
+	require.Equal(t, 14, mentions[0].Start)
+	require.Equal(t, 35, mentions[0].End)
+
+	// Characters outside the Basic Multilingual Plane count once, not as two UTF-16 code units
+	mentions = ParseMentions("🎉 @student1@example.com")
+	require.Len(t, mentions, 1)
+	require.Equal(t, 2, mentions[0].Start)
+	require.Equal(t, 23, mentions[0].End)
+	require.Equal(t, "@student1@example.com", string([]rune("🎉 @student1@example.com")[mentions[0].Start:mentions[0].End]))
+}
+
+func TestParseMentionsIgnoresNonMentions(t *testing.T) {
+	for _, text := range []string{
+		// Escaped
+		`Write to \@student1@example.com`,
+		// Part of an email
+		"Write to admin@student1@example.com",
+		// Unknown group and missing top level domain
+		"Hi @everyone and @student1@example",
+		// Unclosed display names
+		`@"Tan Ah Kow student2@example.com`,
+		`@"Tan Ah Kow" student2@example.com`,
+		"@class: and @teacher:nobody",
+	} {
+		require.Empty(t, ParseMentions(text), text)
+	}
+}
+
+func TestParseMentionsTrailingPunctuation(t *testing.T) {
+	mentions := ParseMentions("Ask @student1@example.com. Or @student2@example.com-- or @class:3A-MATH...")
+	values := []string{}
+	for _, mention := range mentions {
+		values = append(values, mention.Value)
+	}
+	require.Equal(t, []string{"student1@example.com", "student2@example.com", "3A-MATH"}, values)
+}
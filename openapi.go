@@ -0,0 +1,1148 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+type apiParam struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	Array       bool
+	Format      string
+}
+
+// apiOperation documents one route registered in SetupRouter
+type apiOperation struct {
+	Method  string
+	Path    string
+	Summary string
+	Roles   []string
+	Params  []apiParam
+	Request interface{}
+	// Media type of a raw, non JSON request body such as an uploaded file
+	RequestMediaType string
+	Status           int
+	Response         interface{}
+	// Media types of a raw, non JSON response body such as a downloaded file
+	ResponseMediaTypes []string
+	Idempotent         bool
+	RateLimited        string
+	Deprecated         bool
+}
+
+// Every route in SetupRouter must be listed here, TestOpenAPIMatchesRoutes fails otherwise
+var apiOperations = []apiOperation{
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/register",
+		Deprecated:  true,
+		Summary:     "Register one or more students to a teacher",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Request:     RegisterRequest{},
+		Status:      http.StatusNoContent,
+		Idempotent:  true,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:     http.MethodGet,
+		Path:       "/api/commonstudents",
+		Deprecated: true,
+		Summary:    "Retrieve students common to all of the given teachers",
+		Roles:      []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Params: []apiParam{
+			{Name: "teacher", In: "query", Description: "Teacher email, may be repeated", Required: true, Array: true, Format: "email"},
+			classQueryParam,
+		},
+		Status:      http.StatusOK,
+		Response:    CommonStudentsResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/suspend",
+		Deprecated:  true,
+		Summary:     "Suspend a student",
+		Roles:       []string{RoleAdmin},
+		Request:     SuspendRequest{},
+		Status:      http.StatusNoContent,
+		Idempotent:  true,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/retrievefornotifications",
+		Deprecated:  true,
+		Summary:     "Retrieve the students who can receive a teacher's notification",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Request:     RetrieveNotificationsRequest{},
+		Status:      http.StatusOK,
+		Response:    RetrieveNotificationsResponse{},
+		Idempotent:  true,
+		RateLimited: RouteClassNotification,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v2/notifications/sent",
+		Summary: "List sent notifications, newest first, with how many recipients read them, teachers only see their own",
+		Roles:   []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Params: append([]apiParam{
+			{Name: "teacher", In: "query", Description: "Only list notifications of this teacher", Format: "email"},
+		}, pageParams...),
+		Status:      http.StatusOK,
+		Response:    SentNotificationsResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/notifications/sent/:id",
+		Summary:     "Get a sent notification with the read receipts of its recipients",
+		Roles:       []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Params:      []apiParam{{Name: "id", In: "path", Description: "notificationId shared by the recipients"}},
+		Status:      http.StatusOK,
+		Response:    SentNotification{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/notifications/mentions",
+		Summary:     "Find the mentions of a notification, with their offsets to highlight them",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Request:     ParseMentionsRequest{},
+		Status:      http.StatusOK,
+		Response:    MentionsResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v2/notifications/scheduled",
+		Summary: "List scheduled notifications by send time, teachers only see their own",
+		Roles:   []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Params: []apiParam{
+			{Name: "status", In: "query", Description: "pending (default), sent, failed, cancelled or all"},
+			{Name: "teacher", In: "query", Description: "Only list notifications of this teacher", Format: "email"},
+		},
+		Status:      http.StatusOK,
+		Response:    ScheduledNotificationsResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/notifications/scheduled",
+		Summary:     "Schedule a notification, its recipients are resolved when it is sent",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Request:     ScheduleNotificationRequest{},
+		Status:      http.StatusCreated,
+		Response:    ScheduledNotification{},
+		Idempotent:  true,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/notifications/scheduled/:id",
+		Summary:     "Get a scheduled notification, with its result once sent",
+		Roles:       []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Params:      []apiParam{scheduledNotificationPathParam},
+		Status:      http.StatusOK,
+		Response:    ScheduledNotification{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/api/v2/notifications/scheduled/:id",
+		Summary:     "Replace a pending scheduled notification",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Params:      []apiParam{scheduledNotificationPathParam},
+		Request:     ScheduleNotificationRequest{},
+		Status:      http.StatusOK,
+		Response:    ScheduledNotification{},
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v2/notifications/scheduled/:id",
+		Summary:     "Cancel a pending scheduled notification",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Params:      []apiParam{scheduledNotificationPathParam},
+		Status:      http.StatusNoContent,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v2/notifications/recurring",
+		Summary: "List recurring notifications by creation, teachers only see their own",
+		Roles:   []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Params: []apiParam{
+			{Name: "status", In: "query", Description: "active (default), finished, cancelled or all"},
+			{Name: "teacher", In: "query", Description: "Only list notifications of this teacher", Format: "email"},
+		},
+		Status:      http.StatusOK,
+		Response:    RecurringNotificationsResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/notifications/recurring",
+		Summary:     "Create a notification sent on a cron or RRULE schedule, skipping holidays",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Request:     RecurringNotificationRequest{},
+		Status:      http.StatusCreated,
+		Response:    RecurringNotification{},
+		Idempotent:  true,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/notifications/recurring/:id",
+		Summary:     "Get a recurring notification, with its next occurrence",
+		Roles:       []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Params:      []apiParam{recurringNotificationPathParam},
+		Status:      http.StatusOK,
+		Response:    RecurringNotification{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v2/notifications/recurring/:id",
+		Summary:     "Cancel an active recurring notification and its pending occurrence",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Params:      []apiParam{recurringNotificationPathParam},
+		Status:      http.StatusNoContent,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/holidays",
+		Summary:     "List the school's holidays by date",
+		Roles:       []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Status:      http.StatusOK,
+		Response:    HolidaysResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/api/v2/holidays/:date",
+		Summary:     "Add or rename a holiday, cancelling the recurring notifications scheduled on it",
+		Roles:       []string{RoleAdmin},
+		Params:      []apiParam{holidayPathParam},
+		Request:     SetHolidayRequest{},
+		Status:      http.StatusOK,
+		Response:    Holiday{},
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v2/holidays/:date",
+		Summary:     "Delete a holiday",
+		Roles:       []string{RoleAdmin},
+		Params:      []apiParam{holidayPathParam},
+		Status:      http.StatusNoContent,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/templates",
+		Summary:     "List the school's notification templates by name",
+		Roles:       []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Status:      http.StatusOK,
+		Response:    TemplatesResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/templates",
+		Summary:     "Create a notification template",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Request:     TemplateRequest{},
+		Status:      http.StatusCreated,
+		Response:    NotificationTemplate{},
+		Idempotent:  true,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/templates/preview",
+		Summary:     "Render a template or a template body as it would be for a student, without sending it",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Request:     PreviewTemplateRequest{},
+		Status:      http.StatusOK,
+		Response:    PreviewTemplateResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/templates/:id",
+		Summary:     "Get a notification template",
+		Roles:       []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Params:      []apiParam{templatePathParam},
+		Status:      http.StatusOK,
+		Response:    NotificationTemplate{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/api/v2/templates/:id",
+		Summary:     "Replace a notification template, teachers may only replace their own",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Params:      []apiParam{templatePathParam},
+		Request:     TemplateRequest{},
+		Status:      http.StatusOK,
+		Response:    NotificationTemplate{},
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v2/templates/:id",
+		Summary:     "Delete a notification template, teachers may only delete their own",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Params:      []apiParam{templatePathParam},
+		Status:      http.StatusNoContent,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/audit",
+		Summary: "List audit log entries, newest first",
+		Roles:   []string{RoleAdmin, RoleAuditor},
+		Params: []apiParam{
+			{Name: "actor", In: "query"},
+			{Name: "action", In: "query"},
+			{Name: "teacher", In: "query", Format: "email"},
+			{Name: "student", In: "query", Format: "email"},
+			{Name: "since", In: "query", Format: "date-time"},
+			{Name: "until", In: "query", Format: "date-time"},
+			{Name: "limit", In: "query", Description: "Page size, 1 to 200"},
+			{Name: "cursor", In: "query", Description: "nextCursor of the previous page"},
+		},
+		Status:      http.StatusOK,
+		Response:    AuditLogResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:  http.MethodPost,
+		Path:    "/api/import",
+		Summary: "Start a bulk import of teachers, students, registrations and suspensions from a CSV file",
+		Roles:   []string{RoleAdmin},
+		Params: []apiParam{
+			{Name: "dryRun", In: "query", Description: "Validate the file without writing anything"},
+		},
+		RequestMediaType: "text/csv",
+		Status:           http.StatusAccepted,
+		Response:         ImportJob{},
+		Idempotent:       true,
+		RateLimited:      RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/import/:id",
+		Summary:     "Get the progress and row errors of an import job",
+		Roles:       []string{RoleAdmin},
+		Params:      []apiParam{{Name: "id", In: "path"}},
+		Status:      http.StatusOK,
+		Response:    ImportJob{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/export",
+		Summary: "Download registrations, students or notification history as CSV, JSONL or XLSX",
+		Roles:   []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Params: []apiParam{
+			{Name: "dataset", In: "query", Description: "registrations (default), students or notifications"},
+			{Name: "format", In: "query", Description: "csv, jsonl or xlsx, overrides the Accept header"},
+			{Name: "teacher", In: "query", Description: "Teacher email, may be repeated. Teachers default to themselves", Array: true, Format: "email"},
+		},
+		Status:             http.StatusOK,
+		ResponseMediaTypes: []string{mimeCSV, mimeJSONL, mimeXLSX},
+		RateLimited:        RouteClassRead,
+	},
+
+	// v2
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/teachers",
+		Summary:     "List teachers",
+		Roles:       []string{RoleAdmin, RoleAuditor},
+		Params:      profileSearchParams,
+		Status:      http.StatusOK,
+		Response:    TeachersResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/teachers",
+		Summary:     "Create a teacher",
+		Roles:       []string{RoleAdmin},
+		Request:     CreateTeacherRequest{},
+		Status:      http.StatusCreated,
+		Response:    Teacher{},
+		Idempotent:  true,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodPatch,
+		Path:        "/api/v2/teachers/:email",
+		Summary:     "Update a teacher's profile",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Params:      []apiParam{emailPathParam},
+		Request:     ProfileUpdate{},
+		Status:      http.StatusOK,
+		Response:    Teacher{},
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v2/teachers/:email",
+		Summary:     "Delete a teacher and their registrations",
+		Roles:       []string{RoleAdmin},
+		Params:      []apiParam{emailPathParam},
+		Status:      http.StatusNoContent,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/teachers/:email/students",
+		Summary:     "List the students registered to a teacher",
+		Roles:       []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Params:      append([]apiParam{emailPathParam}, studentSearchParams...),
+		Status:      http.StatusOK,
+		Response:    StudentsResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/api/v2/teachers/:email/students/:student",
+		Summary:     "Register a student to a teacher",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Params:      []apiParam{emailPathParam, studentPathParam},
+		Status:      http.StatusNoContent,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v2/teachers/:email/students/:student",
+		Summary:     "Unregister a student from a teacher",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Params:      []apiParam{emailPathParam, studentPathParam},
+		Status:      http.StatusNoContent,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v2/students",
+		Summary: "List students, or the students common to all of the given teachers",
+		Roles:   []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Params: append([]apiParam{
+			{Name: "teacher", In: "query", Description: "Teacher email, may be repeated. Required for teachers.", Array: true, Format: "email"},
+			classQueryParam,
+		}, studentSearchParams...),
+		Status:      http.StatusOK,
+		Response:    StudentsResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/students",
+		Summary:     "Create a student",
+		Roles:       []string{RoleAdmin},
+		Request:     CreateStudentRequest{},
+		Status:      http.StatusCreated,
+		Response:    Student{},
+		Idempotent:  true,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodPatch,
+		Path:        "/api/v2/students/:email",
+		Summary:     "Update a student's profile",
+		Roles:       []string{RoleAdmin},
+		Params:      []apiParam{emailPathParam},
+		Request:     StudentProfileUpdate{},
+		Status:      http.StatusOK,
+		Response:    Student{},
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v2/students/:email",
+		Summary:     "Delete a student with their registrations and suspensions",
+		Roles:       []string{RoleAdmin},
+		Params:      []apiParam{emailPathParam},
+		Status:      http.StatusNoContent,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/students/:email/suspensions",
+		Summary:     "List a student's suspensions, newest first",
+		Roles:       []string{RoleAdmin, RoleAuditor},
+		Params:      []apiParam{emailPathParam},
+		Status:      http.StatusOK,
+		Response:    SuspensionsResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/students/:email/suspensions",
+		Summary:     "Suspend a student",
+		Roles:       []string{RoleAdmin},
+		Params:      []apiParam{emailPathParam},
+		Status:      http.StatusCreated,
+		Idempotent:  true,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v2/students/:email/suspensions",
+		Summary:     "Lift a student's current suspension",
+		Roles:       []string{RoleAdmin},
+		Params:      []apiParam{emailPathParam},
+		Status:      http.StatusNoContent,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/registrations",
+		Summary:     "Register one or more students to a teacher",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Request:     RegisterRequest{},
+		Status:      http.StatusNoContent,
+		Idempotent:  true,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/notifications",
+		Summary:     "Send a notification and retrieve its recipients",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Request:     RetrieveNotificationsRequest{},
+		Status:      http.StatusOK,
+		Response:    RetrieveNotificationsResponse{},
+		Idempotent:  true,
+		RateLimited: RouteClassNotification,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v2/classes",
+		Summary: "List classes, teachers only see their own",
+		Roles:   []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Params: []apiParam{
+			{Name: "teacher", In: "query", Description: "Only list the classes of this teacher", Format: "email"},
+		},
+		Status:      http.StatusOK,
+		Response:    ClassesResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/classes",
+		Summary:     "Create a class",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Request:     CreateClassRequest{},
+		Status:      http.StatusCreated,
+		Response:    Class{},
+		Idempotent:  true,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/classes/:code",
+		Summary:     "Get a class",
+		Roles:       []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Params:      []apiParam{classCodePathParam},
+		Status:      http.StatusOK,
+		Response:    Class{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPatch,
+		Path:        "/api/v2/classes/:code",
+		Summary:     "Rename a class or change its term or teachers",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Params:      []apiParam{classCodePathParam},
+		Request:     UpdateClassRequest{},
+		Status:      http.StatusOK,
+		Response:    Class{},
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v2/classes/:code",
+		Summary:     "Delete a class with its enrollments",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Params:      []apiParam{classCodePathParam},
+		Status:      http.StatusNoContent,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/classes/:code/students",
+		Summary:     "List the students enrolled in a class",
+		Roles:       []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Params:      []apiParam{classCodePathParam},
+		Status:      http.StatusOK,
+		Response:    StudentsResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/api/v2/classes/:code/students/:student",
+		Summary:     "Enroll a student in a class",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Params:      []apiParam{classCodePathParam, studentPathParam},
+		Status:      http.StatusNoContent,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v2/classes/:code/students/:student",
+		Summary:     "Remove a student from a class",
+		Roles:       []string{RoleAdmin, RoleTeacher},
+		Params:      []apiParam{classCodePathParam, studentPathParam},
+		Status:      http.StatusNoContent,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/school",
+		Summary:     "Get the school of the request and its configuration",
+		Roles:       []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Status:      http.StatusOK,
+		Response:    School{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPatch,
+		Path:        "/api/v2/school",
+		Summary:     "Rename the school of the request or change its allowed email domains",
+		Roles:       []string{RoleAdmin},
+		Request:     UpdateSchoolRequest{},
+		Status:      http.StatusOK,
+		Response:    School{},
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/students/:email/guardians",
+		Summary:     "List the guardians of a student",
+		Roles:       []string{RoleAdmin, RoleAuditor},
+		Params:      []apiParam{emailPathParam},
+		Status:      http.StatusOK,
+		Response:    GuardiansResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/api/v2/students/:email/guardians/:guardian",
+		Summary:     "Link a guardian to a student, or change their name, relationship or opt-in",
+		Roles:       []string{RoleAdmin},
+		Params:      []apiParam{emailPathParam, guardianPathParam},
+		Request:     LinkGuardianRequest{},
+		Status:      http.StatusOK,
+		Response:    Guardian{},
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v2/students/:email/guardians/:guardian",
+		Summary:     "Unlink a guardian from a student",
+		Roles:       []string{RoleAdmin},
+		Params:      []apiParam{emailPathParam, guardianPathParam},
+		Status:      http.StatusNoContent,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/students/:email/preferences",
+		Summary:     "Get the notification preferences of a student",
+		Roles:       []string{RoleAdmin, RoleAuditor, RoleStudent},
+		Params:      []apiParam{emailPathParam},
+		Status:      http.StatusOK,
+		Response:    NotificationPreferences{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/api/v2/students/:email/preferences",
+		Summary:     "Replace the notification preferences of a student",
+		Roles:       []string{RoleAdmin, RoleStudent},
+		Params:      []apiParam{emailPathParam},
+		Request:     NotificationPreferences{},
+		Status:      http.StatusOK,
+		Response:    NotificationPreferences{},
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/students/:email/notifications/stream",
+		Summary: "Stream the notifications of a student as Server-Sent Events with the notification as JSON data, resuming after Last-Event-ID",
+		Roles:   []string{RoleAdmin, RoleStudent},
+		Params: []apiParam{
+			emailPathParam,
+			{Name: lastEventIDHeader, In: "header", Description: "Id of the last notification received"},
+			{Name: lastEventIDQueryParam, In: "query", Description: "Same as Last-Event-ID, for clients that cannot set headers"},
+		},
+		Status:             http.StatusOK,
+		ResponseMediaTypes: []string{"text/event-stream"},
+		RateLimited:        RouteClassRead,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/students/:email/notifications/ws",
+		Summary: "Stream the notifications of a student over a WebSocket, as the JSON messages described, resuming after lastEventId",
+		Roles:   []string{RoleAdmin, RoleStudent},
+		Params: []apiParam{
+			emailPathParam,
+			{Name: lastEventIDQueryParam, In: "query", Description: "Id of the last notification received"},
+		},
+		Status:      http.StatusSwitchingProtocols,
+		Response:    StreamMessage{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/students/:email/inbox",
+		Summary: "List the notifications of a student, newest first, with how many are unread",
+		Roles:   []string{RoleAdmin, RoleStudent},
+		Params: append([]apiParam{
+			emailPathParam,
+			{Name: "unread", In: "query", Description: "true to only list unread notifications"},
+		}, pageParams...),
+		Status:      http.StatusOK,
+		Response:    InboxResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/students/:email/inbox/unread",
+		Summary:     "Count the unread notifications of a student, in total and by category",
+		Roles:       []string{RoleAdmin, RoleStudent},
+		Params:      []apiParam{emailPathParam},
+		Status:      http.StatusOK,
+		Response:    UnreadCountResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:  http.MethodPost,
+		Path:    "/api/students/:email/inbox/read",
+		Summary: "Mark the unread notifications of a student as read",
+		Roles:   []string{RoleAdmin, RoleStudent},
+		Params: []apiParam{
+			emailPathParam,
+			{Name: "upTo", In: "query", Description: "Only mark notifications up to this id, such as the newest one listed"},
+		},
+		Status:      http.StatusOK,
+		Response:    MarkReadResponse{},
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/students/:email/inbox/:id/read",
+		Summary:     "Mark a notification of a student as read, keeping when it was first read",
+		Roles:       []string{RoleAdmin, RoleStudent},
+		Params:      []apiParam{emailPathParam, {Name: "id", In: "path", Description: "Notification id within the inbox"}},
+		Status:      http.StatusOK,
+		Response:    StudentNotification{},
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/webhooks",
+		Summary:     "List the school's webhooks, without their secrets",
+		Roles:       []string{RoleAdmin, RoleAuditor},
+		Status:      http.StatusOK,
+		Response:    WebhooksResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/webhooks",
+		Summary:     "Subscribe a URL to events, returning the secret signing its deliveries",
+		Roles:       []string{RoleAdmin},
+		Request:     CreateWebhookRequest{},
+		Status:      http.StatusCreated,
+		Response:    Webhook{},
+		Idempotent:  true,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/webhooks/:id",
+		Summary:     "Get a webhook, without its secret",
+		Roles:       []string{RoleAdmin, RoleAuditor},
+		Params:      []apiParam{webhookPathParam},
+		Status:      http.StatusOK,
+		Response:    Webhook{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPatch,
+		Path:        "/api/v2/webhooks/:id",
+		Summary:     "Update the given fields of a webhook, returning the secret only if it was changed",
+		Roles:       []string{RoleAdmin},
+		Params:      []apiParam{webhookPathParam},
+		Request:     UpdateWebhookRequest{},
+		Status:      http.StatusOK,
+		Response:    Webhook{},
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v2/webhooks/:id",
+		Summary:     "Delete a webhook with its deliveries",
+		Roles:       []string{RoleAdmin},
+		Params:      []apiParam{webhookPathParam},
+		Status:      http.StatusNoContent,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/v2/webhooks/:id/deliveries",
+		Summary: "List the deliveries of a webhook, newest first, with the outcome of their last attempt",
+		Roles:   []string{RoleAdmin, RoleAuditor},
+		Params: append([]apiParam{
+			webhookPathParam,
+			{Name: "status", In: "query", Description: "pending, delivered or failed"},
+		}, pageParams...),
+		Status:      http.StatusOK,
+		Response:    WebhookDeliveriesResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/webhooks/:id/deliveries/:delivery",
+		Summary:     "Get a delivery of a webhook",
+		Roles:       []string{RoleAdmin, RoleAuditor},
+		Params:      []apiParam{webhookPathParam, webhookDeliveryPathParam},
+		Status:      http.StatusOK,
+		Response:    WebhookDelivery{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/webhooks/:id/deliveries/:delivery/redeliver",
+		Summary:     "Send the event of a delivery again as a new delivery",
+		Roles:       []string{RoleAdmin},
+		Params:      []apiParam{webhookPathParam, webhookDeliveryPathParam},
+		Status:      http.StatusCreated,
+		Response:    WebhookDelivery{},
+		Idempotent:  true,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v2/terms",
+		Summary:     "List the school's terms by start date",
+		Roles:       []string{RoleAdmin, RoleTeacher, RoleAuditor},
+		Status:      http.StatusOK,
+		Response:    TermsResponse{},
+		RateLimited: RouteClassRead,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/terms",
+		Summary:     "Create a term, which may not overlap with other terms",
+		Roles:       []string{RoleAdmin},
+		Request:     CreateTermRequest{},
+		Status:      http.StatusCreated,
+		Response:    Term{},
+		Idempotent:  true,
+		RateLimited: RouteClassWrite,
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/terms/:code/rollover",
+		Summary:     "Copy or archive the class rosters of a term into this term, or preview it with dryRun",
+		Roles:       []string{RoleAdmin},
+		Params:      []apiParam{{Name: "code", In: "path", Description: "Term to roll over into"}},
+		Request:     RolloverRequest{},
+		Status:      http.StatusOK,
+		Response:    RolloverReport{},
+		Idempotent:  true,
+		RateLimited: RouteClassWrite,
+	},
+}
+
+var (
+	emailPathParam                 = apiParam{Name: "email", In: "path", Format: "email"}
+	studentPathParam               = apiParam{Name: "student", In: "path", Description: "Student email", Format: "email"}
+	classCodePathParam             = apiParam{Name: "code", In: "path", Description: "Class code"}
+	guardianPathParam              = apiParam{Name: "guardian", In: "path", Description: "Guardian email", Format: "email"}
+	scheduledNotificationPathParam = apiParam{Name: "id", In: "path", Description: "Scheduled notification id"}
+	recurringNotificationPathParam = apiParam{Name: "id", In: "path", Description: "Recurring notification id"}
+	holidayPathParam               = apiParam{Name: "date", In: "path", Description: "Date of the holiday", Format: "date"}
+	templatePathParam              = apiParam{Name: "id", In: "path", Description: "Template id"}
+	webhookPathParam               = apiParam{Name: "id", In: "path", Description: "Webhook id"}
+	webhookDeliveryPathParam       = apiParam{Name: "delivery", In: "path", Description: "Delivery id"}
+	classQueryParam                = apiParam{Name: "class", In: "query", Description: "Only include students enrolled in this class"}
+
+	profileSearchParams = []apiParam{
+		{Name: "q", In: "query", Description: "Search emails, names and SIS IDs"},
+		{Name: "active", In: "query", Description: "true or false"},
+		{Name: "homeroom", In: "query"},
+	}
+	pageParams = []apiParam{
+		{Name: "limit", In: "query", Description: "Page size, 1 to 100"},
+		{Name: "cursor", In: "query", Description: "nextCursor of the previous page"},
+	}
+	studentSearchParams = append([]apiParam{{Name: "gradeLevel", In: "query"}}, profileSearchParams...)
+)
+
+func registerDocsRoutes(router *gin.Engine) {
+	spec := BuildOpenAPISpec(apiOperations)
+
+	router.GET("/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, spec)
+	})
+	router.GET("/docs", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+	})
+}
+
+var ginPathParam = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// Converts Gin path params (/students/:email) to OpenAPI ones (/students/{email})
+func toOpenAPIPath(path string) string {
+	return ginPathParam.ReplaceAllString(path, "{$1}")
+}
+
+// BuildOpenAPISpec generates an OpenAPI 3.1 document from the operations and their Go request/response types
+func BuildOpenAPISpec(operations []apiOperation) gin.H {
+	generator := &schemaGenerator{schemas: gin.H{}}
+	errorSchema := generator.schemaFor(reflect.TypeOf(ErrorResponse{}))
+	errorResponse := func(description string) gin.H {
+		return gin.H{
+			"description": description,
+			"content":     gin.H{"application/json": gin.H{"schema": errorSchema}},
+		}
+	}
+
+	paths := gin.H{}
+	for _, op := range operations {
+		path := toOpenAPIPath(op.Path)
+		if paths[path] == nil {
+			paths[path] = gin.H{}
+		}
+
+		responses := gin.H{
+			"400": errorResponse("Invalid request"),
+			"401": errorResponse("Missing or invalid credentials"),
+			"403": errorResponse("The caller's role may not perform this action"),
+			"500": errorResponse("Internal error"),
+		}
+		success := gin.H{"description": http.StatusText(op.Status)}
+		if op.Response != nil {
+			success["content"] = gin.H{"application/json": gin.H{"schema": generator.schemaFor(reflect.TypeOf(op.Response))}}
+		} else if len(op.ResponseMediaTypes) > 0 {
+			content := gin.H{}
+			for _, mediaType := range op.ResponseMediaTypes {
+				content[mediaType] = gin.H{"schema": gin.H{"type": "string"}}
+			}
+			success["content"] = content
+		}
+		responses[fmt.Sprint(op.Status)] = success
+		if op.RateLimited != "" {
+			responses["429"] = errorResponse("Rate limited, see the Retry-After header")
+		}
+		if op.Idempotent {
+			responses["409"] = errorResponse("A request with the same Idempotency-Key is in progress")
+			responses["422"] = errorResponse("The Idempotency-Key was used for a different request")
+		}
+
+		parameters := []gin.H{}
+		for _, param := range op.Params {
+			schema := gin.H{"type": "string"}
+			if param.Format != "" {
+				schema["format"] = param.Format
+			}
+			if param.Array {
+				schema = gin.H{"type": "array", "items": schema}
+			}
+			parameter := gin.H{"name": param.Name, "in": param.In, "required": param.Required || param.In == "path", "schema": schema}
+			if param.Description != "" {
+				parameter["description"] = param.Description
+			}
+			parameters = append(parameters, parameter)
+		}
+		// Every route acts in the caller's school, which callers without one choose with this header
+		parameters = append(parameters, gin.H{"name": schoolHeader, "in": "header", "required": false, "schema": gin.H{"type": "string"},
+			"description": "School to act in, only for callers who do not belong to one. Defaults to the default school."})
+		parameters = append(parameters, gin.H{"name": termQueryParam, "in": "query", "required": false, "schema": gin.H{"type": "string"},
+			"description": "Term whose registrations and enrollments to act on. Defaults to the current term, and required between terms."})
+		if op.Idempotent {
+			parameters = append(parameters, gin.H{"name": idempotencyKeyHeader, "in": "header", "required": false, "schema": gin.H{"type": "string", "maxLength": 255}})
+		}
+
+		operation := gin.H{
+			"summary":     op.Summary,
+			"description": fmt.Sprintf("Allowed roles: %s.", strings.Join(op.Roles, ", ")),
+			"parameters":  parameters,
+			"responses":   responses,
+		}
+		if op.Deprecated {
+			operation["deprecated"] = true
+		}
+		if op.Request != nil {
+			operation["requestBody"] = gin.H{
+				"required": true,
+				"content":  gin.H{"application/json": gin.H{"schema": generator.schemaFor(reflect.TypeOf(op.Request))}},
+			}
+		} else if op.RequestMediaType != "" {
+			operation["requestBody"] = gin.H{
+				"required": true,
+				"content":  gin.H{op.RequestMediaType: gin.H{"schema": gin.H{"type": "string"}}},
+			}
+		}
+
+		paths[path].(gin.H)[strings.ToLower(op.Method)] = operation
+	}
+
+	return gin.H{
+		"openapi": "3.1.0",
+		"info": gin.H{
+			"title":   "Golang API Server",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": gin.H{
+			"schemas": generator.schemas,
+			"securitySchemes": gin.H{
+				"apiKey":     gin.H{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+				"bearerAuth": gin.H{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+		"security": []gin.H{{"apiKey": []string{}}, {"bearerAuth": []string{}}},
+	}
+}
+
+type schemaGenerator struct {
+	schemas gin.H
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	jsonTextType  = reflect.TypeOf(types.JSONText{})
+	stringArrType = reflect.TypeOf(pq.StringArray{})
+)
+
+// schemaFor returns the schema of a type, adding named structs to the components and referencing them
+func (generator *schemaGenerator) schemaFor(t reflect.Type) gin.H {
+	switch t {
+	case timeType:
+		return gin.H{"type": "string", "format": "date-time"}
+	case jsonTextType:
+		return gin.H{}
+	case stringArrType:
+		return gin.H{"type": "array", "items": gin.H{"type": "string"}}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return generator.nullable(generator.schemaFor(t.Elem()))
+	case reflect.Struct:
+		if _, exists := generator.schemas[t.Name()]; !exists {
+			// Reserve the name first in case the struct refers to itself
+			generator.schemas[t.Name()] = nil
+			generator.schemas[t.Name()] = generator.structSchema(t)
+		}
+		return gin.H{"$ref": "#/components/schemas/" + t.Name()}
+	case reflect.Slice, reflect.Array:
+		// Slices of pointers never contain nulls
+		elem := t.Elem()
+		if elem.Kind() == reflect.Pointer {
+			elem = elem.Elem()
+		}
+		return gin.H{"type": "array", "items": generator.schemaFor(elem)}
+	case reflect.Map:
+		return gin.H{"type": "object", "additionalProperties": generator.schemaFor(t.Elem())}
+	case reflect.String:
+		return gin.H{"type": "string"}
+	case reflect.Bool:
+		return gin.H{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return gin.H{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return gin.H{"type": "number"}
+	default:
+		return gin.H{}
+	}
+}
+
+func (generator *schemaGenerator) nullable(schema gin.H) gin.H {
+	if schemaType, isString := schema["type"].(string); isString {
+		nullableSchema := gin.H{}
+		for key, value := range schema {
+			nullableSchema[key] = value
+		}
+		nullableSchema["type"] = []string{schemaType, "null"}
+		return nullableSchema
+	}
+	return gin.H{"oneOf": []gin.H{schema, {"type": "null"}}}
+}
+
+func (generator *schemaGenerator) structSchema(t reflect.Type) gin.H {
+	properties := gin.H{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+
+		// Embedded structs are flattened like encoding/json does
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := generator.structSchema(field.Type)
+			for embeddedName, schema := range embedded["properties"].(gin.H) {
+				properties[embeddedName] = schema
+			}
+			if embeddedRequired, ok := embedded["required"].([]string); ok {
+				required = append(required, embeddedRequired...)
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := generator.schemaFor(field.Type)
+		if format := field.Tag.Get("format"); format != "" {
+			if items, isArray := schema["items"].(gin.H); isArray {
+				items["format"] = format
+			} else {
+				schema["format"] = format
+			}
+		}
+		if description := field.Tag.Get("description"); description != "" {
+			schema["description"] = description
+		}
+		properties[name] = schema
+
+		if slices.Contains(strings.Split(field.Tag.Get("binding"), ","), "required") {
+			required = append(required, name)
+		}
+	}
+
+	schema := gin.H{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
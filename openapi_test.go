@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	router := SetupRouter(&Store{})
+
+	routes := []string{}
+	for _, route := range router.Routes() {
+		if strings.HasPrefix(route.Path, "/api/") {
+			routes = append(routes, route.Method+" "+toOpenAPIPath(route.Path))
+		}
+	}
+
+	documented := []string{}
+	spec := BuildOpenAPISpec(apiOperations)
+	for path, pathItem := range spec["paths"].(gin.H) {
+		for method := range pathItem.(gin.H) {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	sort.Strings(routes)
+	sort.Strings(documented)
+	require.Equal(t, routes, documented, "routes in SetupRouter and apiOperations have diverged")
+}
+
+func TestOpenAPIDocumentIsServed(t *testing.T) {
+	router := SetupRouter(&Store{})
+
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var spec struct {
+		OpenAPI    string `json:"openapi"`
+		Components struct {
+			Schemas map[string]struct {
+				Required []string `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &spec)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "3.1.0", spec.OpenAPI)
+	// A templateId may be given instead of the notification
+	require.Equal(t, []string{"teacher"}, spec.Components.Schemas["RetrieveNotificationsRequest"].Required)
+	require.Contains(t, spec.Components.Schemas, "AuditEntry")
+}
+
+func TestToOpenAPIPath(t *testing.T) {
+	require.Equal(t, "/api/v2/students/{email}/suspensions", toOpenAPIPath("/api/v2/students/:email/suspensions"))
+}
+
+func TestOpenAPIFlattensEmbeddedStructs(t *testing.T) {
+	generator := &schemaGenerator{schemas: gin.H{}}
+	schema := generator.structSchema(reflect.TypeOf(CreateStudentRequest{}))
+
+	properties := schema["properties"].(gin.H)
+	require.Contains(t, properties, "givenName")
+	require.Contains(t, properties, "gradeLevel")
+	require.NotContains(t, properties, "StudentProfileUpdate")
+	require.Equal(t, []string{"email"}, schema["required"])
+}
+
+func TestOpenAPIDescribesFields(t *testing.T) {
+	generator := &schemaGenerator{schemas: gin.H{}}
+	schema := generator.structSchema(reflect.TypeOf(Mention{}))
+
+	properties := schema["properties"].(gin.H)
+	require.Contains(t, properties["start"].(gin.H)["description"], "Unicode code points")
+	require.NotContains(t, properties["kind"], "description")
+}
@@ -0,0 +1,641 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Operations shared by the v1 and v2 handlers. They return an *apiError instead of writing
+// the response so that each API version can respond with its own success format.
+
+type apiError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func newAPIError(status int, err error, message string) *apiError {
+	return &apiError{Status: status, Message: message, Err: err}
+}
+
+func (apiErr *apiError) respond(c *gin.Context) {
+	body := gin.H{"message": apiErr.Message}
+	if apiErr.Err != nil {
+		body["error"] = apiErr.Err.Error()
+	}
+	c.JSON(apiErr.Status, body)
+}
+
+// Writes the action's audit entry in the transaction of the change
+func withAudit(store *Store, entry *AuditEntry, fn func(txStore *Store) *apiError) *apiError {
+	return withTx(store, func(txStore *Store) *apiError {
+		if apiErr := fn(txStore); apiErr != nil {
+			return apiErr
+		}
+		if err := txStore.AddAuditEntry(entry); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to write audit log.")
+		}
+		return nil
+	})
+}
+
+// Runs fn in a transaction, or in the one of the store, rolling back on any error
+func withTx(store *Store, fn func(txStore *Store) *apiError) *apiError {
+	var apiErr *apiError
+	err := store.WithTx(func(txStore *Store) error {
+		if apiErr = fn(txStore); apiErr != nil {
+			// Roll back client errors too
+			if apiErr.Err == nil {
+				return errors.New(apiErr.Message)
+			}
+			return apiErr.Err
+		}
+		return nil
+	})
+	if apiErr == nil && err != nil {
+		apiErr = newAPIError(http.StatusInternalServerError, err, "failed to save changes.")
+	}
+	return apiErr
+}
+
+func registerStudents(c *gin.Context, store *Store, input RegisterRequest) *apiError {
+	// validate emails and create new teacher and student instances
+	var teacher *Teacher
+	if IsValidEmail(input.Teacher) {
+		teacher = NewTeacher(input.Teacher)
+	} else {
+		return newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Teacher's email (%s) is invalid.", input.Teacher))
+	}
+
+	students := []*Student{}
+	for _, studentEmail := range input.Students {
+		if IsValidEmail(studentEmail) {
+			students = append(students, NewStudent(studentEmail))
+		} else {
+			return newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("A student's email (%s) is invalid.", studentEmail))
+		}
+	}
+
+	if apiErr := checkAllowedDomains(c, append([]string{input.Teacher}, input.Students...)...); apiErr != nil {
+		return apiErr
+	}
+
+	if input.TeacherProfile != nil {
+		if apiErr := validateProfileUpdate(input.TeacherProfile); apiErr != nil {
+			return apiErr
+		}
+	}
+	for studentEmail, update := range input.StudentProfiles {
+		if !slices.Contains(input.Students, studentEmail) {
+			return newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("A student profile (%s) was given for a student who is not being registered.", studentEmail))
+		}
+		if apiErr := validateStudentProfileUpdate(update); apiErr != nil {
+			return apiErr
+		}
+	}
+
+	entry := newAuditEntry(c, AuditActionRegister)
+	entry.TeacherEmail = teacher.Email
+	entry.StudentEmails = input.Students
+
+	// The registration and its audit entry are written in one transaction
+	return withAudit(store, entry, func(txStore *Store) *apiError {
+		alreadyRegistered, err := txStore.GetRegisteredStudents(teacher, input.Students)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to get registered students.")
+		}
+
+		// Add teacher if does not exist
+		if err := txStore.AddTeacher(teacher); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to add teacher.")
+		}
+
+		if err := txStore.AddStudents(students); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to add students.")
+		}
+
+		after := gin.H{"registeredStudents": input.Students}
+		if input.Class != "" {
+			// Enroll students in the given class of the teacher
+			if _, apiErr := getClassOfTeacher(txStore, input.Class, teacher.Email); apiErr != nil {
+				return apiErr
+			}
+
+			enrollments := []*Enrollment{}
+			for _, studentEmail := range input.Students {
+				enrollments = append(enrollments, NewEnrollment(input.Class, studentEmail))
+			}
+			if err := txStore.Enroll(enrollments); err != nil {
+				return newAPIError(http.StatusInternalServerError, err, "failed to enroll students in class.")
+			}
+			after["class"] = input.Class
+		} else {
+			// Register students to Teacher
+			teacherStudentPairs := []*TeacherStudentPair{}
+			for _, studentEmail := range input.Students {
+				teacherStudentPairs = append(teacherStudentPairs, NewTeacherStudentPair(input.Teacher, studentEmail))
+			}
+			if err := txStore.Register(teacherStudentPairs); err != nil {
+				return newAPIError(http.StatusInternalServerError, err, "failed to register students to teachers.")
+			}
+		}
+
+		if input.TeacherProfile != nil {
+			updated, err := txStore.UpdateTeacher(teacher.Email, input.TeacherProfile)
+			if err != nil {
+				return profileUpdateError(err)
+			}
+			after["teacher"] = updated
+		}
+		if len(input.StudentProfiles) > 0 {
+			updatedStudents := []*Student{}
+			for _, studentEmail := range input.Students {
+				if update, ok := input.StudentProfiles[studentEmail]; ok && update != nil {
+					updated, err := txStore.UpdateStudent(studentEmail, update)
+					if err != nil {
+						return profileUpdateError(err)
+					}
+					updatedStudents = append(updatedStudents, updated)
+				}
+			}
+			after["students"] = updatedStudents
+		}
+
+		registration := WebhookRegistrationData{Teacher: teacher.Email, Students: input.Students, Class: input.Class}
+		if err := enqueueWebhookEvent(txStore, WebhookEventStudentRegistered, registration); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to queue webhook event.")
+		}
+
+		entry.Before = toAuditPayload(gin.H{"registeredStudents": alreadyRegistered})
+		entry.After = toAuditPayload(after)
+		return nil
+	})
+}
+
+const (
+	maxProfileFieldLength = 100
+	maxGradeLevelLength   = 10
+)
+
+func validateProfileUpdate(update *ProfileUpdate) *apiError {
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"givenName", update.GivenName},
+		{"familyName", update.FamilyName},
+		{"preferredName", update.PreferredName},
+		{"sisId", update.SISID},
+		{"homeroom", update.Homeroom},
+	}
+	for _, field := range fields {
+		if field.value != nil && len(*field.value) > maxProfileFieldLength {
+			return newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("%s must be at most %d characters.", field.name, maxProfileFieldLength))
+		}
+	}
+	return nil
+}
+
+func validateStudentProfileUpdate(update *StudentProfileUpdate) *apiError {
+	if update == nil {
+		return nil
+	}
+	if update.GradeLevel != nil && len(*update.GradeLevel) > maxGradeLevelLength {
+		return newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("gradeLevel must be at most %d characters.", maxGradeLevelLength))
+	}
+	return validateProfileUpdate(&update.ProfileUpdate)
+}
+
+func profileUpdateError(err error) *apiError {
+	if IsUniqueViolation(err) {
+		return newAPIError(http.StatusConflict, err, "The SIS ID is already used by someone else.")
+	}
+	return newAPIError(http.StatusInternalServerError, err, "failed to update profile.")
+}
+
+// getClassOfTeacher returns the class, or a 400 error if it does not exist or the teacher does not teach it
+func getClassOfTeacher(store *Store, classCode string, teacherEmail string) (*Class, *apiError) {
+	class, err := store.GetClass(classCode)
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, err, "failed to get class.")
+	}
+	if class == nil {
+		return nil, newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Class (%s) does not exist.", classCode))
+	}
+	if !slices.Contains(class.Teachers, teacherEmail) {
+		return nil, newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Teacher (%s) does not teach class (%s).", teacherEmail, classCode))
+	}
+	return class, nil
+}
+
+// getCommonStudents returns the students common to all of the teachers, only those enrolled in the class if one is given
+func getCommonStudents(c *gin.Context, store *Store, teacherEmails []string, classCode string) ([]string, *apiError) {
+	// Validate emails and create teacher instances
+	teachers := []*Teacher{}
+	for _, teacherEmail := range teacherEmails {
+		if IsValidEmail(teacherEmail) {
+			teachers = append(teachers, NewTeacher(teacherEmail))
+		} else {
+			return nil, newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("A teacher's email (%s) is invalid.", teacherEmail))
+		}
+	}
+
+	// Teachers may only look up students in common with their own classes
+	if principal := GetPrincipal(c); principal != nil && principal.Role == RoleTeacher && !slices.Contains(teacherEmails, principal.Subject) {
+		return nil, &apiError{Status: http.StatusForbidden, Message: "Teachers may only look up their own students.", Err: errForbidden}
+	}
+
+	if classCode != "" {
+		class, err := store.GetClass(classCode)
+		if err != nil {
+			return nil, newAPIError(http.StatusInternalServerError, err, "failed to get class.")
+		}
+		if class == nil {
+			return nil, newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Class (%s) does not exist.", classCode))
+		}
+	}
+
+	if apiErr := requireTerm(store); apiErr != nil {
+		return nil, apiErr
+	}
+	students, err := store.GetCommonStudents(teachers, classCode)
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, err, "failed to get common students.")
+	}
+
+	return students, nil
+}
+
+func suspendStudent(c *gin.Context, store *Store, studentEmail string) *apiError {
+	// Check if student is registered
+	isStudentExists, err := store.IfStudentExists(studentEmail)
+	if err != nil {
+		return newAPIError(http.StatusInternalServerError, err, "Something went wrong when checking if student is registered.")
+	}
+	if !isStudentExists {
+		return newAPIError(http.StatusBadRequest, nil, "Given student is not registered.")
+	}
+
+	// validate email and create new Suspension instance
+	var suspension *Suspension
+	if IsValidEmail(studentEmail) {
+		suspension = NewSuspension(studentEmail)
+	} else {
+		return newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Student's email (%s) is invalid.", studentEmail))
+	}
+
+	entry := newAuditEntry(c, AuditActionSuspend)
+	entry.StudentEmails = []string{suspension.Email}
+
+	// The suspension and its audit entry are written in one transaction
+	return withAudit(store, entry, func(txStore *Store) *apiError {
+		wasSuspended, err := txStore.IsSuspended(suspension.Email)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to suspend student.")
+		}
+
+		if err := txStore.AddSuspension(suspension); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to suspend student.")
+		}
+		if err := enqueueWebhookEvent(txStore, WebhookEventStudentSuspended, WebhookSuspensionData{Student: suspension.Email, SuspendedUntil: suspension.SuspendedUntil}); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to queue webhook event.")
+		}
+
+		entry.Before = toAuditPayload(gin.H{"suspended": wasSuspended})
+		entry.After = toAuditPayload(gin.H{"suspended": true, "suspension": suspension})
+		return nil
+	})
+}
+
+func retrieveNotificationRecipients(c *gin.Context, store *Store, input RetrieveNotificationsRequest) (*RetrieveNotificationsResponse, *apiError) {
+	return sendNotification(store, GetSchool(c), newAuditEntry(c, AuditActionNotify), input)
+}
+
+// sendNotification resolves the recipients of the notification and audits it with a copy of the audit template, so
+// that notifications scheduled for later are sent the same way outside of a request. It runs in one transaction so
+// that mentioned students are only auto registered if the notification is sent.
+func sendNotification(store *Store, school *School, auditTemplate *AuditEntry, input RetrieveNotificationsRequest) (*RetrieveNotificationsResponse, *apiError) {
+	var response *RetrieveNotificationsResponse
+	apiErr := withTx(store, func(txStore *Store) *apiError {
+		var apiErr *apiError
+		response, apiErr = resolveAndSendNotification(txStore, school, auditTemplate, input)
+		return apiErr
+	})
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return response, nil
+}
+
+func resolveAndSendNotification(store *Store, school *School, auditTemplate *AuditEntry, input RetrieveNotificationsRequest) (*RetrieveNotificationsResponse, *apiError) {
+	// Check if teacher is registered
+	isTeacherExists, err := store.IfTeacherExists(input.Teacher)
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, err, "Something went wrong when checking if teacher is registered.")
+	}
+	if !isTeacherExists {
+		return nil, newAPIError(http.StatusBadRequest, nil, "Given teacher is not registered.")
+	}
+
+	// validate emails and create new teacher and student instances
+	var teacher *Teacher
+	if IsValidEmail(input.Teacher) {
+		teacher = NewTeacher(input.Teacher)
+	} else {
+		return nil, newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Teacher's email (%s) is invalid.", input.Teacher))
+	}
+
+	// Templates are rendered once without a student for the mentions and the audit log, then for each recipient
+	var tmpl *template.Template
+	var data *templateData
+	if input.TemplateID != "" {
+		if input.Notification != "" {
+			return nil, newAPIError(http.StatusBadRequest, nil, "Give either a notification or a templateId, not both.")
+		}
+		var apiErr *apiError
+		if tmpl, apiErr = loadTemplate(store, input.TemplateID); apiErr != nil {
+			return nil, apiErr
+		}
+		if data, apiErr = newTemplateData(store, school, teacher.Email, input.Class, input.Variables); apiErr != nil {
+			return nil, apiErr
+		}
+		notification, err := renderTemplate(tmpl, data)
+		if err != nil {
+			return nil, newAPIError(http.StatusBadRequest, err, "Template could not be rendered: "+err.Error())
+		}
+		input.Notification = notification
+	}
+
+	// Sort the mentions by kind, leaving out repeated ones
+	parsedMentions := ParseMentions(input.Notification)
+	audience := &NotificationAudience{Teacher: teacher, Class: input.Class}
+	mentions := []string{}
+	for _, mention := range parsedMentions {
+		switch mention.Kind {
+		case MentionKindStudent:
+			if !slices.Contains(mentions, mention.Value) {
+				mentions = append(mentions, mention.Value)
+			}
+		case MentionKindClass:
+			if !slices.Contains(audience.Classes, mention.Value) {
+				audience.Classes = append(audience.Classes, mention.Value)
+			}
+		case MentionKindTeacher:
+			if !slices.Contains(audience.Teachers, mention.Value) {
+				audience.Teachers = append(audience.Teachers, mention.Value)
+			}
+		case MentionKindGroup:
+			audience.AllStudents = true
+		}
+	}
+
+	// Check that every mentioned email is a student in one query. Kept to explain the recipients in verbose mode.
+	audience.Students = []string{}
+	unknownStudents := []string{}
+	now := time.Now().UTC()
+	if len(mentions) > 0 {
+		statuses, err := store.ResolveMentions(mentions, now)
+		if err != nil {
+			return nil, newAPIError(http.StatusInternalServerError, err, "Something went wrong when checking if mentioned students are registered.")
+		}
+		for _, status := range statuses {
+			if status.Exists {
+				audience.Students = append(audience.Students, status.Email)
+			} else {
+				unknownStudents = append(unknownStudents, status.Email)
+			}
+		}
+	}
+
+	policy := input.UnknownMentions
+	if policy == "" {
+		policy = UnknownMentionsStrict
+	}
+	warnings := []*NotificationWarning{}
+	registeredMentions, unknownMentions := []string{}, []string{}
+	if len(unknownStudents) > 0 {
+		switch {
+		case policy == UnknownMentionsAutoRegister:
+			if apiErr := registerMentionedStudents(store, school, auditTemplate, teacher, unknownStudents, input.RegisterMentioned); apiErr != nil {
+				return nil, apiErr
+			}
+			for _, email := range unknownStudents {
+				warnings = append(warnings, &NotificationWarning{Code: WarningMentionRegistered, Mention: email, Message: fmt.Sprintf("Student (%s) mentioned was not registered and has been added.", email)})
+			}
+			audience.Students = append(audience.Students, unknownStudents...)
+			registeredMentions = unknownStudents
+		case policy == UnknownMentionsSkip:
+			for _, email := range unknownStudents {
+				warnings = append(warnings, &NotificationWarning{Code: WarningUnknownMentionSkipped, Mention: email, Message: fmt.Sprintf("Student (%s) mentioned is not registered.", email)})
+			}
+			unknownMentions = unknownStudents
+		default:
+			return nil, newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Student (%s) mentioned is not registered.", unknownStudents[0]))
+		}
+	}
+
+	// Classes and teachers cannot be added, so their unknown mentions are skipped unless strict
+	unknownClasses, unknownTeachers, apiErr := findUnknownGroupMentions(store, audience)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if policy == UnknownMentionsStrict {
+		if len(unknownClasses) > 0 {
+			return nil, newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Class (%s) mentioned does not exist.", unknownClasses[0]))
+		}
+		if len(unknownTeachers) > 0 {
+			return nil, newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Teacher (%s) mentioned is not registered.", unknownTeachers[0]))
+		}
+	}
+	for _, classCode := range unknownClasses {
+		warnings = append(warnings, &NotificationWarning{Code: WarningUnknownMentionSkipped, Mention: classCode, Message: fmt.Sprintf("Class (%s) mentioned does not exist.", classCode)})
+	}
+	for _, teacherEmail := range unknownTeachers {
+		warnings = append(warnings, &NotificationWarning{Code: WarningUnknownMentionSkipped, Mention: teacherEmail, Message: fmt.Sprintf("Teacher (%s) mentioned is not registered.", teacherEmail)})
+	}
+	audience.Classes = slices.DeleteFunc(audience.Classes, func(classCode string) bool { return slices.Contains(unknownClasses, classCode) })
+	audience.Teachers = slices.DeleteFunc(audience.Teachers, func(teacherEmail string) bool { return slices.Contains(unknownTeachers, teacherEmail) })
+
+	// Students registered to teacher, or only those of the given class, and those mentioned, less suspended students
+	if input.Class != "" {
+		if _, apiErr := getClassOfTeacher(store, input.Class, teacher.Email); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	notifiableEmails, err := store.GetNotificationRecipients(audience, now)
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, err, "Something went wrong when getting notifiable students")
+	}
+
+	notifiableEmails, excluded, err := applyPreferences(store, notifiableEmails, input, now)
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, err, "Something went wrong when getting notification preferences")
+	}
+	response := &RetrieveNotificationsResponse{Recipients: notifiableEmails, Excluded: excluded, Warnings: warnings}
+	if input.Verbose {
+		response.Explanations, err = explainRecipients(store, audience, registeredMentions, unknownMentions, excluded)
+		if err != nil {
+			return nil, newAPIError(http.StatusInternalServerError, err, "Something went wrong when explaining recipients")
+		}
+		response.Mentions = parsedMentions
+	}
+
+	if tmpl != nil {
+		var apiErr *apiError
+		if response.RenderedNotifications, apiErr = renderForRecipients(store, tmpl, data, notifiableEmails); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+
+	// Save what each recipient receives, which publishes it to their streams
+	notificationID, err := newRandomID()
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, err, "failed to save notifications.")
+	}
+	studentNotifications := []*StudentNotification{}
+	for i, email := range notifiableEmails {
+		text := input.Notification
+		if response.RenderedNotifications != nil {
+			text = response.RenderedNotifications[i].Notification
+		}
+		studentNotifications = append(studentNotifications, &StudentNotification{NotificationID: notificationID, StudentEmail: email,
+			Teacher: teacher.Email, Notification: text, Category: input.Category, Urgent: input.Urgent, CreatedAt: now})
+	}
+
+	// Guardians get the notifications of their students who are notified, so never those of suspended students
+	if input.IncludeGuardians && len(notifiableEmails) > 0 {
+		response.GuardianRecipients, err = store.GetOptedInGuardians(notifiableEmails)
+		if err != nil {
+			return nil, newAPIError(http.StatusInternalServerError, err, "Something went wrong when getting guardians")
+		}
+	}
+
+	entry := *auditTemplate
+	entry.Action = AuditActionNotify
+	entry.OccurredAt = time.Now().UTC()
+	entry.TeacherEmail = teacher.Email
+	entry.StudentEmails = notifiableEmails
+	after := gin.H{"notification": input.Notification, "recipients": notifiableEmails}
+	if input.Class != "" {
+		after["class"] = input.Class
+	}
+	if input.TemplateID != "" {
+		after["templateId"] = input.TemplateID
+		after["variables"] = input.Variables
+	}
+	if len(audience.Classes) > 0 || len(audience.Teachers) > 0 || audience.AllStudents {
+		after["mentionedClasses"] = audience.Classes
+		after["mentionedTeachers"] = audience.Teachers
+		after["mentionedAll"] = audience.AllStudents
+	}
+	if input.IncludeGuardians {
+		after["guardianRecipients"] = response.GuardianRecipients
+	}
+	if input.Category != "" {
+		after["category"] = input.Category
+	}
+	if input.Urgent {
+		after["urgent"] = true
+	}
+	if len(excluded) > 0 {
+		after["excluded"] = excluded
+	}
+	if len(warnings) > 0 {
+		after["warnings"] = warnings
+	}
+	entry.After = toAuditPayload(after)
+
+	// The notifications, their webhook event and the audit entry are written with the auto registered students
+	apiErr = withAudit(store, &entry, func(txStore *Store) *apiError {
+		if err := txStore.AddStudentNotifications(studentNotifications); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to save notifications.")
+		}
+		if len(notifiableEmails) > 0 {
+			notified := WebhookNotificationData{NotificationID: notificationID, Teacher: teacher.Email, Notification: input.Notification,
+				Category: input.Category, Urgent: input.Urgent, Recipients: notifiableEmails}
+			if err := enqueueWebhookEvent(txStore, WebhookEventStudentNotified, notified); err != nil {
+				return newAPIError(http.StatusInternalServerError, err, "failed to queue webhook event.")
+			}
+		}
+		return nil
+	})
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	return response, nil
+}
+
+// findUnknownGroupMentions returns the mentioned classes and teachers that do not exist
+func findUnknownGroupMentions(store *Store, audience *NotificationAudience) ([]string, []string, *apiError) {
+	unknownClasses := []string{}
+	unknownTeachers := []string{}
+	if len(audience.Classes) > 0 {
+		classes, err := store.GetExistingClasses(audience.Classes)
+		if err != nil {
+			return nil, nil, newAPIError(http.StatusInternalServerError, err, "Something went wrong when checking if mentioned classes exist.")
+		}
+		for _, classCode := range audience.Classes {
+			if !slices.Contains(classes, classCode) {
+				unknownClasses = append(unknownClasses, classCode)
+			}
+		}
+	}
+	if len(audience.Teachers) > 0 {
+		teachers, err := store.GetExistingTeachers(audience.Teachers)
+		if err != nil {
+			return nil, nil, newAPIError(http.StatusInternalServerError, err, "Something went wrong when checking if mentioned teachers are registered.")
+		}
+		for _, teacherEmail := range audience.Teachers {
+			if !slices.Contains(teachers, teacherEmail) {
+				unknownTeachers = append(unknownTeachers, teacherEmail)
+			}
+		}
+	}
+	return unknownClasses, unknownTeachers, nil
+}
+
+// registerMentionedStudents adds the mentioned students, and registers them to the teacher if asked to
+func registerMentionedStudents(store *Store, school *School, auditTemplate *AuditEntry, teacher *Teacher, studentEmails []string, registerToTeacher bool) *apiError {
+	if apiErr := checkSchoolAllowsEmails(school, studentEmails...); apiErr != nil {
+		return apiErr
+	}
+
+	students := []*Student{}
+	teacherStudentPairs := []*TeacherStudentPair{}
+	for _, studentEmail := range studentEmails {
+		students = append(students, NewStudent(studentEmail))
+		teacherStudentPairs = append(teacherStudentPairs, NewTeacherStudentPair(teacher.Email, studentEmail))
+	}
+
+	entry := *auditTemplate
+	entry.Action = AuditActionRegister
+	entry.StudentEmails = studentEmails
+	after := gin.H{"mentionedStudents": studentEmails}
+	if registerToTeacher {
+		entry.TeacherEmail = teacher.Email
+		after["registeredStudents"] = studentEmails
+	}
+	entry.After = toAuditPayload(after)
+
+	return withAudit(store, &entry, func(txStore *Store) *apiError {
+		if err := txStore.AddStudents(students); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to add mentioned students.")
+		}
+		if registerToTeacher {
+			if err := txStore.Register(teacherStudentPairs); err != nil {
+				return newAPIError(http.StatusInternalServerError, err, "failed to register mentioned students to teacher.")
+			}
+			if err := enqueueWebhookEvent(txStore, WebhookEventStudentRegistered, WebhookRegistrationData{Teacher: teacher.Email, Students: studentEmails}); err != nil {
+				return newAPIError(http.StatusInternalServerError, err, "failed to queue webhook event.")
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+	// Time zones of quiet hours must load without the system's zoneinfo
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultNotificationChannel = "email"
+
+	ExclusionReasonMutedCategory   = "muted_category"
+	ExclusionReasonChannelDisabled = "channel_disabled"
+	ExclusionReasonQuietHours      = "quiet_hours"
+
+	AuditActionSetPreferences = "set_notification_preferences"
+
+	quietHoursLayout = "15:04"
+)
+
+var NotificationChannels = []string{"email", "sms", "push"}
+
+func registerPreferenceRoutes(v2 *gin.RouterGroup, store *Store, reads gin.HandlerFunc, writes gin.HandlerFunc) {
+	v2.GET("/students/:email/preferences", reads, RequireRole(RoleAdmin, RoleAuditor, RoleStudent), makeHandleFunc(handleGetPreferences, store))
+	v2.PUT("/students/:email/preferences", writes, RequireRole(RoleAdmin, RoleStudent), makeHandleFunc(handleSetPreferences, store))
+}
+
+// ExclusionReason returns why a non-urgent notification of the category sent through the channel at the given time
+// should not reach the student, or "" if it should
+func (preferences *NotificationPreferences) ExclusionReason(category string, channel string, now time.Time) string {
+	if category != "" && slices.Contains(preferences.MutedCategories, category) {
+		return ExclusionReasonMutedCategory
+	}
+	if !slices.Contains(preferences.Channels, channel) {
+		return ExclusionReasonChannelDisabled
+	}
+	if preferences.inQuietHours(now) {
+		return ExclusionReasonQuietHours
+	}
+	return ""
+}
+
+func (preferences *NotificationPreferences) inQuietHours(now time.Time) bool {
+	if preferences.QuietHoursStart == "" || preferences.QuietHoursEnd == "" {
+		return false
+	}
+	location, err := time.LoadLocation(preferences.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	// HH:MM strings compare in time order
+	local := now.In(location).Format(quietHoursLayout)
+	start, end := preferences.QuietHoursStart, preferences.QuietHoursEnd
+	if start <= end {
+		return local >= start && local < end
+	}
+	return local >= start || local < end
+}
+
+// validatePreferences fills in the defaults of the preferences and checks them
+func validatePreferences(preferences *NotificationPreferences) *apiError {
+	if preferences.Channels == nil {
+		preferences.Channels = slices.Clone(NotificationChannels)
+	}
+	if preferences.MutedCategories == nil {
+		preferences.MutedCategories = pq.StringArray{}
+	}
+	if preferences.TimeZone == "" {
+		preferences.TimeZone = "UTC"
+	}
+
+	for _, channel := range preferences.Channels {
+		if !slices.Contains(NotificationChannels, channel) {
+			return newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Channel (%s) must be one of %v.", channel, NotificationChannels))
+		}
+	}
+	for _, category := range preferences.MutedCategories {
+		if category == "" || len(category) > 50 {
+			return newAPIError(http.StatusBadRequest, nil, "Muted categories must be 1 to 50 characters.")
+		}
+	}
+	if (preferences.QuietHoursStart == "") != (preferences.QuietHoursEnd == "") {
+		return newAPIError(http.StatusBadRequest, nil, "quietHoursStart and quietHoursEnd must be given together.")
+	}
+	for _, quietHour := range []string{preferences.QuietHoursStart, preferences.QuietHoursEnd} {
+		// Only zero padded hours compare in time order, so 9:00 is rejected
+		if parsed, err := time.Parse(quietHoursLayout, quietHour); quietHour != "" && (err != nil || parsed.Format(quietHoursLayout) != quietHour) {
+			return newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Quiet hours (%s) must be formatted as HH:MM.", quietHour))
+		}
+	}
+	if _, err := time.LoadLocation(preferences.TimeZone); err != nil {
+		return newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Time zone (%s) is unknown.", preferences.TimeZone))
+	}
+	return nil
+}
+
+// applyPreferences splits the students into those to notify and those excluded by their preferences.
+// Urgent notifications reach every student.
+func applyPreferences(store *Store, studentEmails []string, input RetrieveNotificationsRequest, now time.Time) ([]string, []*ExcludedRecipient, error) {
+	excluded := []*ExcludedRecipient{}
+	if input.Urgent || len(studentEmails) == 0 {
+		return studentEmails, excluded, nil
+	}
+
+	preferences, err := store.GetNotificationPreferences(studentEmails)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	channel := input.Channel
+	if channel == "" {
+		channel = DefaultNotificationChannel
+	}
+	recipients := []string{}
+	for _, studentEmail := range studentEmails {
+		studentPreferences, ok := preferences[studentEmail]
+		if !ok {
+			recipients = append(recipients, studentEmail)
+			continue
+		}
+		if reason := studentPreferences.ExclusionReason(input.Category, channel, now); reason != "" {
+			excluded = append(excluded, &ExcludedRecipient{Email: studentEmail, Reason: reason})
+			continue
+		}
+		recipients = append(recipients, studentEmail)
+	}
+	return recipients, excluded, nil
+}
+
+func handleGetPreferences(c *gin.Context, store *Store) {
+	studentEmail := c.Param("email")
+	if !authorizeAsStudent(c, studentEmail) {
+		return
+	}
+	preferences, err := store.GetNotificationPreferences([]string{studentEmail})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get preferences."})
+		return
+	}
+
+	if studentPreferences, ok := preferences[studentEmail]; ok {
+		c.JSON(http.StatusOK, studentPreferences)
+		return
+	}
+
+	isStudentExists, err := store.IfStudentExists(studentEmail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get student."})
+		return
+	}
+	if !isStudentExists {
+		c.JSON(http.StatusNotFound, gin.H{"error": errNotFound.Error(), "message": "Given student is not registered."})
+		return
+	}
+	c.JSON(http.StatusOK, NewNotificationPreferences())
+}
+
+func handleSetPreferences(c *gin.Context, store *Store) {
+	if !authorizeAsStudent(c, c.Param("email")) {
+		return
+	}
+	var preferences NotificationPreferences
+
+	if err := c.ShouldBindJSON(&preferences); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+	if apiErr := validatePreferences(&preferences); apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+	studentEmail := c.Param("email")
+
+	entry := newAuditEntry(c, AuditActionSetPreferences)
+	entry.StudentEmails = []string{studentEmail}
+	entry.After = toAuditPayload(preferences)
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		isStudentExists, err := txStore.IfStudentExists(studentEmail)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to get student.")
+		}
+		if !isStudentExists {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given student is not registered.")
+		}
+		if err := txStore.SetNotificationPreferences(studentEmail, &preferences); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to save preferences.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExclusionReason(t *testing.T) {
+	preferences := NewNotificationPreferences()
+	preferences.Channels = pq.StringArray{"email"}
+	preferences.MutedCategories = pq.StringArray{"sports"}
+	preferences.QuietHoursStart = "22:00"
+	preferences.QuietHoursEnd = "07:00"
+	preferences.TimeZone = "Asia/Singapore"
+
+	noon := time.Date(2026, 3, 2, 4, 0, 0, 0, time.UTC)
+	require.Equal(t, "", preferences.ExclusionReason("", "email", noon))
+	require.Equal(t, ExclusionReasonMutedCategory, preferences.ExclusionReason("sports", "email", noon))
+	require.Equal(t, ExclusionReasonChannelDisabled, preferences.ExclusionReason("exams", "sms", noon))
+
+	// Quiet hours wrap past midnight in the student's time zone
+	require.Equal(t, ExclusionReasonQuietHours, preferences.ExclusionReason("", "email", time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC)))
+	require.Equal(t, ExclusionReasonQuietHours, preferences.ExclusionReason("", "email", time.Date(2026, 3, 2, 22, 59, 0, 0, time.UTC)))
+	require.Equal(t, "", preferences.ExclusionReason("", "email", time.Date(2026, 3, 2, 23, 0, 0, 0, time.UTC)))
+
+	preferences.QuietHoursStart, preferences.QuietHoursEnd = "12:00", "13:00"
+	require.Equal(t, ExclusionReasonQuietHours, preferences.ExclusionReason("", "email", noon))
+	require.Equal(t, "", preferences.ExclusionReason("", "email", noon.Add(time.Hour)))
+}
+
+func TestValidatePreferences(t *testing.T) {
+	preferences := &NotificationPreferences{}
+	require.Nil(t, validatePreferences(preferences))
+	require.Equal(t, NewNotificationPreferences(), preferences)
+
+	require.NotNil(t, validatePreferences(&NotificationPreferences{Channels: pq.StringArray{"pager"}}))
+	require.NotNil(t, validatePreferences(&NotificationPreferences{QuietHoursStart: "22:00"}))
+	require.NotNil(t, validatePreferences(&NotificationPreferences{QuietHoursStart: "25:00", QuietHoursEnd: "07:00"}))
+	require.NotNil(t, validatePreferences(&NotificationPreferences{QuietHoursStart: "9:00", QuietHoursEnd: "07:00"}))
+	require.NotNil(t, validatePreferences(&NotificationPreferences{TimeZone: "Mars/Olympus"}))
+
+	// No channels at all mutes every non-urgent notification
+	preferences = &NotificationPreferences{Channels: pq.StringArray{}}
+	require.Nil(t, validatePreferences(preferences))
+	require.Empty(t, preferences.Channels)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Route classes that are limited separately so that e.g. notifications can't starve reads
+const (
+	RouteClassRead         = "read"
+	RouteClassWrite        = "write"
+	RouteClassNotification = "notification"
+)
+
+// RateLimit is a token bucket that holds up to Capacity tokens and is refilled at Capacity per Period
+type RateLimit struct {
+	Capacity int
+	Period   time.Duration
+}
+
+func (limit RateLimit) refillRate() float64 {
+	return float64(limit.Capacity) / limit.Period.Seconds()
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+	// Time until the bucket is full again
+	Reset time.Duration
+}
+
+type RateLimiter interface {
+	Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+type RateLimitConfig struct {
+	Backend string
+	Limits  map[string]RateLimit
+}
+
+func LoadRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Backend: GetEnvOrDefault("RATE_LIMIT_BACKEND", "memory"),
+		Limits: map[string]RateLimit{
+			RouteClassRead:         {Capacity: getEnvInt("RATE_LIMIT_READS_PER_MINUTE", 120), Period: time.Minute},
+			RouteClassWrite:        {Capacity: getEnvInt("RATE_LIMIT_WRITES_PER_MINUTE", 60), Period: time.Minute},
+			RouteClassNotification: {Capacity: getEnvInt("RATE_LIMIT_NOTIFICATIONS_PER_MINUTE", 30), Period: time.Minute},
+		},
+	}
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(GetEnvOrDefault(key, strconv.Itoa(defaultValue)))
+	if err != nil {
+		log.Fatalf("Env var %v must be an integer", key)
+	}
+	return value
+}
+
+func NewRateLimiter(config RateLimitConfig, store *Store) (RateLimiter, error) {
+	switch config.Backend {
+	case "memory":
+		return NewMemoryRateLimiter(), nil
+	case "postgres":
+		return &PostgresRateLimiter{store: store}, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend: %s", config.Backend)
+	}
+}
+
+// RateLimitMiddleware limits each caller per route class. Must run after the auth middleware.
+func RateLimitMiddleware(limiter RateLimiter, config RateLimitConfig, routeClass string) gin.HandlerFunc {
+	limit, exists := config.Limits[routeClass]
+	if !exists || limit.Capacity <= 0 {
+		return func(c *gin.Context) {}
+	}
+
+	return func(c *gin.Context) {
+		result, err := limiter.Take(rateLimitKey(c, routeClass), limit, time.Now().UTC())
+		if err != nil {
+			// Fail open, the limiter must not take the API down with it
+			c.Error(err)
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Capacity))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Capacity, int(limit.Period.Seconds())))
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limited", "message": "Too many requests, try again later."})
+		}
+	}
+}
+
+// Throttled runs the rate limiter of a route before the middlewares that hit the database, so that throttled requests
+// cost no more than the authentication and never reach the idempotency store. Only the last middleware may call
+// c.Next, the others return and leave the rest of the chain to Gin.
+func Throttled(limiter gin.HandlerFunc, scoped ...gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, handler := range append([]gin.HandlerFunc{limiter}, scoped...) {
+			if c.IsAborted() {
+				return
+			}
+			handler(c)
+		}
+	}
+}
+
+// Buckets are per API key or token subject, falling back to the client IP
+func rateLimitKey(c *gin.Context, routeClass string) string {
+	principal := GetPrincipal(c)
+	if principal == nil {
+		return fmt.Sprintf("ip:%s:%s", c.ClientIP(), routeClass)
+	}
+	if principal.Method == AuthMethodAPIKey {
+		return fmt.Sprintf("key:%d:%s", principal.KeyID, routeClass)
+	}
+	return fmt.Sprintf("%s:%s:%s", principal.Method, principal.Subject, routeClass)
+}
+
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
+
+// takeToken refills a bucket for the time elapsed since updatedAt and takes a token if one is available.
+// Shared by all backends so they behave the same.
+func takeToken(tokens float64, updatedAt time.Time, limit RateLimit, now time.Time) (float64, RateLimitResult) {
+	rate := limit.refillRate()
+	capacity := float64(limit.Capacity)
+
+	elapsed := now.Sub(updatedAt).Seconds()
+	if elapsed > 0 {
+		tokens = math.Min(capacity, tokens+elapsed*rate)
+	}
+
+	result := RateLimitResult{Allowed: tokens >= 1}
+	if result.Allowed {
+		tokens--
+	} else {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	result.Remaining = int(math.Floor(tokens))
+	result.Reset = time.Duration((capacity - tokens) / rate * float64(time.Second))
+
+	return tokens, result
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryRateLimiter keeps buckets in process, only suitable when running a single replica
+type MemoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{buckets: map[string]*memoryBucket{}}
+}
+
+func (limiter *MemoryRateLimiter) Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limiter.sweep(now)
+
+	bucket, exists := limiter.buckets[key]
+	if !exists {
+		bucket = &memoryBucket{tokens: float64(limit.Capacity), updatedAt: now}
+		limiter.buckets[key] = bucket
+	}
+
+	tokens, result := takeToken(bucket.tokens, bucket.updatedAt, limit, now)
+	bucket.tokens = tokens
+	bucket.updatedAt = now
+
+	return result, nil
+}
+
+// Drop buckets that have been idle long enough to be full again. None of the limits have a
+// period longer than an hour, so idle buckets past that are equivalent to new ones.
+func (limiter *MemoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < time.Minute {
+		return
+	}
+	limiter.lastSweep = now
+
+	for key, bucket := range limiter.buckets {
+		if now.Sub(bucket.updatedAt) > time.Hour {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+// PostgresRateLimiter shares buckets between replicas through the rate_limit_buckets table
+type PostgresRateLimiter struct {
+	store *Store
+}
+
+func (limiter *PostgresRateLimiter) Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	return limiter.store.TakeRateLimitToken(key, limit, now)
+}
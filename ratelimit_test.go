@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRateLimiterExhaustsAndRefills(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	limit := RateLimit{Capacity: 2, Period: time.Minute}
+	now := time.Now()
+
+	first, _ := limiter.Take("key", limit, now)
+	second, _ := limiter.Take("key", limit, now)
+	third, _ := limiter.Take("key", limit, now)
+
+	require.True(t, first.Allowed)
+	require.Equal(t, 1, first.Remaining)
+	require.True(t, second.Allowed)
+	require.Equal(t, 0, second.Remaining)
+	require.False(t, third.Allowed)
+	require.Equal(t, 30*time.Second, third.RetryAfter)
+	require.Equal(t, time.Minute, third.Reset)
+
+	// One token is refilled every 30 seconds
+	refilled, _ := limiter.Take("key", limit, now.Add(30*time.Second))
+	require.True(t, refilled.Allowed)
+}
+
+func TestMemoryRateLimiterSeparatesKeys(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	limit := RateLimit{Capacity: 1, Period: time.Minute}
+	now := time.Now()
+
+	first, _ := limiter.Take("key:1:read", limit, now)
+	other, _ := limiter.Take("key:2:read", limit, now)
+
+	require.True(t, first.Allowed)
+	require.True(t, other.Allowed)
+}
+
+func TestRateLimitMiddlewareSetsHeaders(t *testing.T) {
+	config := RateLimitConfig{Limits: map[string]RateLimit{RouteClassRead: {Capacity: 1, Period: time.Minute}}}
+	router := gin.New()
+	router.GET("/", RateLimitMiddleware(NewMemoryRateLimiter(), config, RouteClassRead), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "60", w.Header().Get("Retry-After"))
+}
+
+func TestThrottledSkipsScopedMiddlewaresWhenLimited(t *testing.T) {
+	config := RateLimitConfig{Limits: map[string]RateLimit{RouteClassWrite: {Capacity: 1, Period: time.Minute}}}
+	var scoped []string
+	router := gin.New()
+	router.POST("/", Throttled(RateLimitMiddleware(NewMemoryRateLimiter(), config, RouteClassWrite),
+		func(c *gin.Context) { scoped = append(scoped, "school") },
+		func(c *gin.Context) {
+			scoped = append(scoped, "idempotency")
+			c.Next()
+			scoped = append(scoped, "done")
+		},
+	), func(c *gin.Context) {
+		scoped = append(scoped, "handler")
+		c.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/", nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, []string{"school", "idempotency", "handler", "done"}, scoped)
+
+	scoped = nil
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Empty(t, scoped)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedules of recurring notifications, given as a cron expression or an RRULE (RFC 5545). Both are wall-clock times
+// in a time zone, so a 7am notification stays at 7am across daylight saving changes.
+
+// Occurrences further away than this are never found, so that schedules matching no day end
+const maxScheduleSearchDays = 5 * 366
+
+type Schedule interface {
+	// Next returns the first occurrence after the given time, or false if there is none
+	Next(after time.Time) (time.Time, bool)
+}
+
+// ParseSchedule parses a cron expression of five fields (minute, hour, day of month, month and day of week) or an
+// RRULE with FREQ DAILY, WEEKLY or MONTHLY. RRULEs start at startsAt, which also gives their default day and time.
+func ParseSchedule(expression string, location *time.Location, startsAt time.Time) (Schedule, error) {
+	expression = strings.TrimSpace(expression)
+	if strings.HasPrefix(strings.ToUpper(expression), "RRULE:") || strings.Contains(strings.ToUpper(expression), "FREQ=") {
+		return parseRRule(expression, location, startsAt)
+	}
+	return parseCron(expression, location)
+}
+
+// A schedule of every matching day at every combination of its hours and minutes
+type daySchedule struct {
+	location   *time.Location
+	matchesDay func(day time.Time) bool
+	hours      []int
+	minutes    []int
+	notBefore  time.Time
+}
+
+func (schedule *daySchedule) Next(after time.Time) (time.Time, bool) {
+	from := after
+	if from.Before(schedule.notBefore) {
+		from = schedule.notBefore
+	}
+	local := from.In(schedule.location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, schedule.location)
+
+	for i := 0; i < maxScheduleSearchDays; i++ {
+		if schedule.matchesDay(day) {
+			for _, hour := range schedule.hours {
+				for _, minute := range schedule.minutes {
+					occurrence := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, schedule.location)
+					if occurrence.After(after) && !occurrence.Before(schedule.notBefore) {
+						return occurrence, true
+					}
+				}
+			}
+		}
+		day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, schedule.location)
+	}
+	return time.Time{}, false
+}
+
+var (
+	cronMonthNames   = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+	cronWeekdayNames = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+	rruleWeekdays    = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+)
+
+func parseCron(expression string, location *time.Location) (Schedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, errors.New("cron expressions must have five fields: minute, hour, day of month, month and day of week")
+	}
+
+	minutes, err := parseCronField(fields[0], 0, 59, nil)
+	if err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	hours, err := parseCronField(fields[1], 0, 23, nil)
+	if err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	daysOfMonth, err := parseCronField(fields[2], 1, 31, nil)
+	if err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	months, err := parseCronField(fields[3], 1, 12, cronMonthNames)
+	if err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	// Both 0 and 7 are Sunday
+	daysOfWeek, err := parseCronField(fields[4], 0, 7, cronWeekdayNames)
+	if err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	if slices.Contains(daysOfWeek, 7) && !slices.Contains(daysOfWeek, 0) {
+		daysOfWeek = append(daysOfWeek, 0)
+	}
+
+	// Like cron, a day matches either of the day fields when neither starts with *
+	dayOfMonthRestricted, dayOfWeekRestricted := !strings.HasPrefix(fields[2], "*"), !strings.HasPrefix(fields[4], "*")
+	matchesDay := func(day time.Time) bool {
+		if !slices.Contains(months, int(day.Month())) {
+			return false
+		}
+		dayOfMonthMatches := slices.Contains(daysOfMonth, day.Day())
+		dayOfWeekMatches := slices.Contains(daysOfWeek, int(day.Weekday()))
+		if dayOfMonthRestricted && dayOfWeekRestricted {
+			return dayOfMonthMatches || dayOfWeekMatches
+		}
+		return dayOfMonthMatches && dayOfWeekMatches
+	}
+
+	return &daySchedule{location: location, matchesDay: matchesDay, hours: hours, minutes: minutes}, nil
+}
+
+// parseCronField returns the sorted values of a comma separated list of *, values, ranges and steps such as */15 or
+// 1-5/2. Names, if given, stand for the values from min.
+func parseCronField(field string, min int, max int, names []string) ([]int, error) {
+	parseValue := func(value string) (int, error) {
+		if index := slices.Index(names, strings.ToUpper(value)); index != -1 {
+			return min + index, nil
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil || number < min || number > max {
+			return 0, fmt.Errorf("%s must be from %d to %d", value, min, max)
+		}
+		return number, nil
+	}
+
+	values := []int{}
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return nil, fmt.Errorf("step %s must be a positive number", stepPart)
+			}
+		}
+
+		start, end := min, max
+		if rangePart != "*" {
+			startPart, endPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = parseValue(startPart); err != nil {
+				return nil, err
+			}
+			end = start
+			if isRange {
+				if end, err = parseValue(endPart); err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				end = max
+			}
+			if end < start {
+				return nil, fmt.Errorf("range %s must not end before it starts", rangePart)
+			}
+		}
+
+		for value := start; value <= end; value += step {
+			if !slices.Contains(values, value) {
+				values = append(values, value)
+			}
+		}
+	}
+	slices.Sort(values)
+	return values, nil
+}
+
+func parseRRule(expression string, location *time.Location, startsAt time.Time) (Schedule, error) {
+	rule := expression
+	if strings.HasPrefix(strings.ToUpper(rule), "RRULE:") {
+		rule = rule[len("RRULE:"):]
+	}
+	parts := map[string]string{}
+	for _, part := range strings.Split(rule, ";") {
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			return nil, fmt.Errorf("RRULE part %s must be KEY=VALUE", part)
+		}
+		parts[strings.ToUpper(key)] = strings.ToUpper(value)
+	}
+
+	start := startsAt.In(location)
+	frequency := parts["FREQ"]
+	interval := 1
+	hours := []int{start.Hour()}
+	minutes := []int{start.Minute()}
+	weekdays := []int{}
+	monthDays := []int{start.Day()}
+	var err error
+	for key, value := range parts {
+		switch key {
+		case "FREQ":
+			if !slices.Contains([]string{"DAILY", "WEEKLY", "MONTHLY"}, value) {
+				return nil, fmt.Errorf("FREQ must be DAILY, WEEKLY or MONTHLY, not %s", value)
+			}
+		case "INTERVAL":
+			if interval, err = strconv.Atoi(value); err != nil || interval < 1 {
+				return nil, fmt.Errorf("INTERVAL %s must be a positive number", value)
+			}
+		case "BYHOUR":
+			if hours, err = parseCronField(value, 0, 23, nil); err != nil {
+				return nil, fmt.Errorf("BYHOUR: %w", err)
+			}
+		case "BYMINUTE":
+			if minutes, err = parseCronField(value, 0, 59, nil); err != nil {
+				return nil, fmt.Errorf("BYMINUTE: %w", err)
+			}
+		case "BYMONTHDAY":
+			if monthDays, err = parseCronField(value, 1, 31, nil); err != nil {
+				return nil, fmt.Errorf("BYMONTHDAY: %w", err)
+			}
+		case "BYDAY":
+			for _, weekday := range strings.Split(value, ",") {
+				index := slices.Index(rruleWeekdays, weekday)
+				if index == -1 {
+					return nil, fmt.Errorf("BYDAY %s must be one of %v", weekday, rruleWeekdays)
+				}
+				weekdays = append(weekdays, index)
+			}
+		case "COUNT", "UNTIL":
+			return nil, fmt.Errorf("%s is not supported, set count or endsOn instead", key)
+		default:
+			return nil, fmt.Errorf("%s is not supported", key)
+		}
+	}
+	if frequency == "" {
+		return nil, errors.New("RRULEs must have a FREQ")
+	}
+	if _, hasMonthDay := parts["BYMONTHDAY"]; hasMonthDay && frequency != "MONTHLY" {
+		return nil, errors.New("BYMONTHDAY is only supported with FREQ=MONTHLY")
+	}
+	if len(weekdays) > 0 && frequency == "MONTHLY" {
+		return nil, errors.New("BYDAY is only supported with FREQ=DAILY or WEEKLY")
+	}
+	if len(weekdays) == 0 && frequency == "WEEKLY" {
+		weekdays = []int{int(start.Weekday())}
+	}
+
+	startDay := civilDay(start)
+	// Weeks start on Monday, as with the default WKST
+	startWeek := startDay - (int(start.Weekday())+6)%7
+	matchesDay := func(day time.Time) bool {
+		if len(weekdays) > 0 && !slices.Contains(weekdays, int(day.Weekday())) {
+			return false
+		}
+		switch frequency {
+		case "DAILY":
+			return (civilDay(day)-startDay)%interval == 0
+		case "WEEKLY":
+			week := civilDay(day) - (int(day.Weekday())+6)%7
+			return ((week-startWeek)/7)%interval == 0
+		default:
+			months := (day.Year()-start.Year())*12 + int(day.Month()) - int(start.Month())
+			return months%interval == 0 && slices.Contains(monthDays, day.Day())
+		}
+	}
+
+	return &daySchedule{location: location, matchesDay: matchesDay, hours: hours, minutes: minutes, notBefore: startsAt}, nil
+}
+
+// civilDay returns the number of days from the Unix epoch to the date of the time, ignoring its time zone offset
+func civilDay(t time.Time) int {
+	return int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
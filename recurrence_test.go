@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func occurrences(t *testing.T, schedule Schedule, after time.Time, count int) []time.Time {
+	times := []time.Time{}
+	for len(times) < count {
+		next, ok := schedule.Next(after)
+		require.True(t, ok)
+		times = append(times, next)
+		after = next
+	}
+	return times
+}
+
+func TestCronSchedule(t *testing.T) {
+	singapore, err := time.LoadLocation("Asia/Singapore")
+	require.NoError(t, err)
+
+	// Mondays at 7am, Sunday 2026-03-01 being the first day
+	schedule, err := ParseSchedule("0 7 * * MON", singapore, time.Time{})
+	require.NoError(t, err)
+	after := time.Date(2026, 3, 1, 12, 0, 0, 0, singapore)
+	require.Equal(t, []time.Time{
+		time.Date(2026, 3, 2, 7, 0, 0, 0, singapore),
+		time.Date(2026, 3, 9, 7, 0, 0, 0, singapore),
+	}, occurrences(t, schedule, after, 2))
+
+	schedule, err = ParseSchedule("*/30 8-9 1,15 * *", singapore, time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, []time.Time{
+		time.Date(2026, 3, 15, 8, 0, 0, 0, singapore),
+		time.Date(2026, 3, 15, 8, 30, 0, 0, singapore),
+		time.Date(2026, 3, 15, 9, 0, 0, 0, singapore),
+		time.Date(2026, 3, 15, 9, 30, 0, 0, singapore),
+		time.Date(2026, 4, 1, 8, 0, 0, 0, singapore),
+	}, occurrences(t, schedule, after, 5))
+
+	// Either day field matches when both are restricted
+	schedule, err = ParseSchedule("0 7 13 * 5", singapore, time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, []time.Time{
+		time.Date(2026, 3, 6, 7, 0, 0, 0, singapore),
+		time.Date(2026, 3, 13, 7, 0, 0, 0, singapore),
+		time.Date(2026, 3, 20, 7, 0, 0, 0, singapore),
+	}, occurrences(t, schedule, after, 3))
+
+	// Schedules matching no day end
+	schedule, err = ParseSchedule("0 7 31 2 *", singapore, time.Time{})
+	require.NoError(t, err)
+	_, ok := schedule.Next(after)
+	require.False(t, ok)
+
+	for _, expression := range []string{"0 7 * *", "60 7 * * *", "0 7 * * FUNDAY", "0 7 5-1 * *", "*/0 * * * *"} {
+		_, err := ParseSchedule(expression, singapore, time.Time{})
+		require.Error(t, err, expression)
+	}
+}
+
+func TestCronScheduleKeepsWallClockTime(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	require.NoError(t, err)
+
+	// Clocks go forward on 2026-03-29
+	schedule, err := ParseSchedule("0 7 * * *", london, time.Time{})
+	require.NoError(t, err)
+	times := occurrences(t, schedule, time.Date(2026, 3, 28, 0, 0, 0, 0, london), 2)
+	require.Equal(t, 7, times[0].In(london).Hour())
+	require.Equal(t, 7, times[1].In(london).Hour())
+	require.Equal(t, 23*time.Hour, times[1].Sub(times[0]))
+}
+
+func TestRRuleSchedule(t *testing.T) {
+	singapore, err := time.LoadLocation("Asia/Singapore")
+	require.NoError(t, err)
+	startsAt := time.Date(2026, 3, 2, 7, 30, 0, 0, singapore)
+
+	// Every other week on Monday and Wednesday at the start's time
+	schedule, err := ParseSchedule("RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE", singapore, startsAt)
+	require.NoError(t, err)
+	require.Equal(t, []time.Time{
+		startsAt,
+		time.Date(2026, 3, 4, 7, 30, 0, 0, singapore),
+		time.Date(2026, 3, 16, 7, 30, 0, 0, singapore),
+		time.Date(2026, 3, 18, 7, 30, 0, 0, singapore),
+	}, occurrences(t, schedule, startsAt.Add(-time.Hour), 4))
+
+	schedule, err = ParseSchedule("FREQ=MONTHLY;BYMONTHDAY=1,15;BYHOUR=9;BYMINUTE=0", singapore, startsAt)
+	require.NoError(t, err)
+	require.Equal(t, []time.Time{
+		time.Date(2026, 3, 15, 9, 0, 0, 0, singapore),
+		time.Date(2026, 4, 1, 9, 0, 0, 0, singapore),
+	}, occurrences(t, schedule, startsAt, 2))
+
+	schedule, err = ParseSchedule("FREQ=DAILY;INTERVAL=3", singapore, startsAt)
+	require.NoError(t, err)
+	require.Equal(t, []time.Time{
+		time.Date(2026, 3, 5, 7, 30, 0, 0, singapore),
+		time.Date(2026, 3, 8, 7, 30, 0, 0, singapore),
+	}, occurrences(t, schedule, startsAt, 2))
+
+	for _, expression := range []string{"FREQ=YEARLY", "FREQ=WEEKLY;COUNT=3", "FREQ=WEEKLY;BYDAY=XX", "FREQ=DAILY;BYMONTHDAY=1", "INTERVAL=2", "FREQ"} {
+		_, err := ParseSchedule(expression, singapore, startsAt)
+		require.Error(t, err, expression)
+	}
+}
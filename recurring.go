@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	AuditActionCreateRecurringNotification = "create_recurring_notification"
+	AuditActionCancelRecurringNotification = "cancel_recurring_notification"
+	AuditActionSetHoliday                  = "set_holiday"
+	AuditActionDeleteHoliday               = "delete_holiday"
+)
+
+var recurringNotificationStatuses = []string{RecurringNotificationActive, RecurringNotificationFinished, RecurringNotificationCancelled}
+
+func registerRecurringNotificationRoutes(v2 *gin.RouterGroup, store *Store, reads gin.HandlerFunc, writes gin.HandlerFunc) {
+	v2.GET("/notifications/recurring", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleListRecurringNotifications, store))
+	v2.POST("/notifications/recurring", writes, RequireRole(RoleAdmin, RoleTeacher), makeHandleFunc(handleCreateRecurringNotification, store))
+	v2.GET("/notifications/recurring/:id", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleGetRecurringNotification, store))
+	v2.DELETE("/notifications/recurring/:id", writes, RequireRole(RoleAdmin, RoleTeacher), makeHandleFunc(handleCancelRecurringNotification, store))
+	v2.GET("/holidays", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleListHolidays, store))
+	v2.PUT("/holidays/:date", writes, RequireRole(RoleAdmin), makeHandleFunc(handleSetHoliday, store))
+	v2.DELETE("/holidays/:date", writes, RequireRole(RoleAdmin), makeHandleFunc(handleDeleteHoliday, store))
+}
+
+// advanceRecurringNotifications schedules the following occurrence of every recurring notification of all schools
+// whose pending occurrence is due at the given time, each in its own transaction, and returns how many were advanced
+func advanceRecurringNotifications(store *Store, now time.Time) (int, error) {
+	count := 0
+	for {
+		advanced := false
+		err := store.WithTx(func(txStore *Store) error {
+			notification, err := txStore.ClaimDueRecurringNotification(now)
+			if err != nil || notification == nil {
+				return err
+			}
+			advanced = true
+			return scheduleNextOccurrence(txStore.ForSchool(notification.SchoolID), notification, *notification.NextAt)
+		})
+		if err != nil || !advanced {
+			return count, err
+		}
+		count++
+	}
+}
+
+// nextOccurrence returns the first occurrence of the notification after the given time that is not on a holiday of
+// its school, or nil once it has ended
+func nextOccurrence(store *Store, notification *RecurringNotification, after time.Time) (*time.Time, error) {
+	if notification.Count > 0 && notification.Occurrences >= notification.Count {
+		return nil, nil
+	}
+	location, err := time.LoadLocation(notification.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := ParseSchedule(notification.Schedule, location, notification.StartsAt)
+	if err != nil {
+		return nil, err
+	}
+	holidays, err := store.ListHolidays()
+	if err != nil {
+		return nil, err
+	}
+
+	if after.Before(notification.StartsAt) {
+		after = notification.StartsAt.Add(-time.Nanosecond)
+	}
+	for {
+		occurrence, found := schedule.Next(after)
+		if !found {
+			return nil, nil
+		}
+		day := occurrence.In(location).Format(time.DateOnly)
+		if notification.EndsOn != "" && day > notification.EndsOn {
+			return nil, nil
+		}
+		isHoliday := slices.ContainsFunc(holidays, func(holiday *Holiday) bool { return holiday.Date == day })
+		if !isHoliday {
+			occurrence = occurrence.UTC()
+			return &occurrence, nil
+		}
+		after = occurrence
+	}
+}
+
+// scheduleNextOccurrence schedules the first occurrence after the given time as a pending notification in the term
+// current at that time, or finishes the recurring notification if it has ended
+func scheduleNextOccurrence(store *Store, notification *RecurringNotification, after time.Time) error {
+	next, err := nextOccurrence(store, notification, after)
+	if err != nil {
+		return err
+	}
+	notification.NextAt = next
+	if next == nil {
+		notification.Status = RecurringNotificationFinished
+		return store.UpdateRecurringNotificationProgress(notification)
+	}
+
+	term, err := store.GetCurrentTerm(*next)
+	if err != nil {
+		return err
+	}
+	termCode := ""
+	if term != nil {
+		termCode = term.Code
+	}
+	id, err := newRandomID()
+	if err != nil {
+		return err
+	}
+	occurrence := &ScheduledNotification{
+		ID:            id,
+		Teacher:       notification.Teacher,
+		Term:          termCode,
+		Request:       notification.Request,
+		SendAt:        *next,
+		Status:        ScheduledNotificationPending,
+		CreatedBy:     notification.CreatedBy,
+		CreatedByRole: notification.CreatedByRole,
+		RequestID:     notification.RequestID,
+		CreatedAt:     time.Now().UTC(),
+		RecurringID:   notification.ID,
+	}
+	if err := store.AddScheduledNotification(occurrence); err != nil {
+		return err
+	}
+	notification.Occurrences++
+	return store.UpdateRecurringNotificationProgress(notification)
+}
+
+// getOwnRecurringNotification returns the recurring notification, or a 404 error if it does not exist or belongs to
+// another teacher than the teacher making the request
+func getOwnRecurringNotification(c *gin.Context, store *Store) (*RecurringNotification, *apiError) {
+	notification, err := store.GetRecurringNotification(c.Param("id"))
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, err, "failed to get recurring notification.")
+	}
+	principal := GetPrincipal(c)
+	if notification == nil || (principal != nil && principal.Role == RoleTeacher && principal.Subject != notification.Teacher) {
+		return nil, newAPIError(http.StatusNotFound, errNotFound, "Given recurring notification does not exist.")
+	}
+	return notification, nil
+}
+
+func handleListRecurringNotifications(c *gin.Context, store *Store) {
+	status := c.DefaultQuery("status", RecurringNotificationActive)
+	if status != "all" && !slices.Contains(recurringNotificationStatuses, status) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("status must be all or one of %v.", recurringNotificationStatuses)})
+		return
+	}
+	if status == "all" {
+		status = ""
+	}
+
+	// Teachers only see their own
+	teacherEmail := c.Query("teacher")
+	if principal := GetPrincipal(c); principal != nil && principal.Role == RoleTeacher {
+		teacherEmail = principal.Subject
+	}
+
+	notifications, err := store.ListRecurringNotifications(teacherEmail, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to list recurring notifications."})
+		return
+	}
+
+	c.JSON(http.StatusOK, RecurringNotificationsResponse{RecurringNotifications: notifications})
+}
+
+func handleGetRecurringNotification(c *gin.Context, store *Store) {
+	notification, apiErr := getOwnRecurringNotification(c, store)
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, notification)
+}
+
+func handleCreateRecurringNotification(c *gin.Context, store *Store) {
+	var input RecurringNotificationRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+
+	if !authorizeAsTeacher(c, input.Teacher) {
+		return
+	}
+	if apiErr := validateNotificationSender(store, input.RetrieveNotificationsRequest); apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+	if input.EndsOn != "" {
+		if _, err := time.Parse(time.DateOnly, input.EndsOn); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "endsOn must be a date such as 2026-12-31."})
+			return
+		}
+	}
+
+	id, err := newRandomID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to create recurring notification."})
+		return
+	}
+	entry := newAuditEntry(c, AuditActionCreateRecurringNotification)
+	notification, err := NewRecurringNotification(id, input, entry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to create recurring notification."})
+		return
+	}
+	location, err := time.LoadLocation(notification.TimeZone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": fmt.Sprintf("Time zone (%s) is invalid.", notification.TimeZone)})
+		return
+	}
+	if _, err := ParseSchedule(notification.Schedule, location, notification.StartsAt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "Schedule is invalid: " + err.Error()})
+		return
+	}
+	entry.TeacherEmail = notification.Teacher
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		if err := txStore.AddRecurringNotification(notification); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to create recurring notification.")
+		}
+		if err := scheduleNextOccurrence(txStore, notification, time.Now().UTC()); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to schedule recurring notification.")
+		}
+		if notification.NextAt == nil {
+			return newAPIError(http.StatusBadRequest, nil, "The schedule has no occurrence before it ends.")
+		}
+		entry.After = toAuditPayload(notification)
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Header("Location", "/api/v2/notifications/recurring/"+notification.ID)
+	c.JSON(http.StatusCreated, notification)
+}
+
+func handleCancelRecurringNotification(c *gin.Context, store *Store) {
+	notification, apiErr := getOwnRecurringNotification(c, store)
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	entry := newAuditEntry(c, AuditActionCancelRecurringNotification)
+	entry.TeacherEmail = notification.Teacher
+	entry.Before = toAuditPayload(gin.H{"id": notification.ID, "status": notification.Status})
+	entry.After = toAuditPayload(gin.H{"id": notification.ID, "status": RecurringNotificationCancelled})
+
+	apiErr = withAudit(store, entry, func(txStore *Store) *apiError {
+		cancelled, err := txStore.CancelRecurringNotification(notification.ID)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to cancel recurring notification.")
+		}
+		if !cancelled {
+			return newAPIError(http.StatusConflict, nil, "Only active recurring notifications can be cancelled.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func handleListHolidays(c *gin.Context, store *Store) {
+	holidays, err := store.ListHolidays()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to list holidays."})
+		return
+	}
+
+	c.JSON(http.StatusOK, HolidaysResponse{Holidays: holidays})
+}
+
+// handleSetHoliday adds or renames a holiday, and cancels the occurrences of recurring notifications already
+// scheduled on it
+func handleSetHoliday(c *gin.Context, store *Store) {
+	var input SetHolidayRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+
+	date := c.Param("date")
+	if _, err := time.Parse(time.DateOnly, date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "The date must be a date such as 2026-12-25."})
+		return
+	}
+	if len(input.Name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "The name must be at most 100 characters."})
+		return
+	}
+
+	holiday := &Holiday{Date: date, Name: input.Name}
+	entry := newAuditEntry(c, AuditActionSetHoliday)
+	entry.After = toAuditPayload(holiday)
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		if err := txStore.SetHoliday(holiday); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to set holiday.")
+		}
+		message := "Cancelled for the holiday on " + date
+		if holiday.Name != "" {
+			message = "Cancelled for " + holiday.Name
+		}
+		if _, err := txStore.CancelOccurrencesOnHoliday(date, message); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to cancel notifications on holiday.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, holiday)
+}
+
+func handleDeleteHoliday(c *gin.Context, store *Store) {
+	date := c.Param("date")
+	if _, err := time.Parse(time.DateOnly, date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "The date must be a date such as 2026-12-25."})
+		return
+	}
+
+	entry := newAuditEntry(c, AuditActionDeleteHoliday)
+	entry.Before = toAuditPayload(gin.H{"date": date})
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		deleted, err := txStore.DeleteHoliday(date)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to delete holiday.")
+		}
+		if !deleted {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given holiday does not exist.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
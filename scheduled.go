@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx/types"
+)
+
+const (
+	AuditActionScheduleNotification        = "schedule_notification"
+	AuditActionUpdateScheduledNotification = "update_scheduled_notification"
+	AuditActionCancelScheduledNotification = "cancel_scheduled_notification"
+)
+
+const (
+	// Server errors are retried after scheduledRetryDelay, doubling up to scheduledMaxRetryDelay, until the
+	// notification fails after scheduledMaxAttempts
+	scheduledMaxAttempts   = 5
+	scheduledRetryDelay    = time.Minute
+	scheduledMaxRetryDelay = time.Hour
+)
+
+var scheduledNotificationStatuses = []string{ScheduledNotificationPending, ScheduledNotificationSent, ScheduledNotificationFailed, ScheduledNotificationCancelled}
+
+func LoadSchedulerInterval() time.Duration {
+	return time.Duration(getEnvInt("SCHEDULER_INTERVAL_SECONDS", 30)) * time.Second
+}
+
+func registerScheduledNotificationRoutes(v2 *gin.RouterGroup, store *Store, reads gin.HandlerFunc, writes gin.HandlerFunc) {
+	v2.GET("/notifications/scheduled", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleListScheduledNotifications, store))
+	v2.POST("/notifications/scheduled", writes, RequireRole(RoleAdmin, RoleTeacher), makeHandleFunc(handleScheduleNotification, store))
+	v2.GET("/notifications/scheduled/:id", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleGetScheduledNotification, store))
+	v2.PUT("/notifications/scheduled/:id", writes, RequireRole(RoleAdmin, RoleTeacher), makeHandleFunc(handleUpdateScheduledNotification, store))
+	v2.DELETE("/notifications/scheduled/:id", writes, RequireRole(RoleAdmin, RoleTeacher), makeHandleFunc(handleCancelScheduledNotification, store))
+}
+
+// runScheduler sends the scheduled notifications that are due every interval, then schedules the following
+// occurrences of recurring notifications. Every server runs it, each notification is sent by whichever claims it first.
+func runScheduler(store *Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now().UTC()
+		if _, err := sendDueNotifications(store, now); err != nil {
+			log.Printf("Failed to send scheduled notifications: %v", err)
+		}
+		if _, err := advanceRecurringNotifications(store, now); err != nil {
+			log.Printf("Failed to schedule recurring notifications: %v", err)
+		}
+	}
+}
+
+// sendDueNotifications sends the notifications of all schools due at the given time, each in its own transaction,
+// and returns how many were sent or failed. Server errors leave the notification pending to retry later with backoff,
+// so that one failing notification does not hold up the others.
+func sendDueNotifications(store *Store, now time.Time) (int, error) {
+	count := 0
+	for {
+		var claimed *ScheduledNotification
+		var failure *apiError
+		err := store.WithTx(func(txStore *Store) error {
+			notification, err := txStore.ClaimDueScheduledNotification(now)
+			if err != nil || notification == nil {
+				return err
+			}
+			claimed = notification
+
+			response, apiErr := sendScheduledNotification(txStore, notification)
+			if apiErr != nil {
+				failure = apiErr
+				return errors.New(apiErr.Message)
+			}
+			result, err := json.Marshal(response)
+			if err != nil {
+				return err
+			}
+			return txStore.MarkScheduledNotificationSent(notification.ID, types.JSONText(result), now)
+		})
+		if claimed == nil {
+			return count, err
+		}
+
+		switch {
+		case err == nil:
+		// Client errors, such as a mention of a student who was removed since, will not go away by retrying
+		case (failure != nil && failure.Status < http.StatusInternalServerError) || claimed.Attempts+1 >= scheduledMaxAttempts:
+			if err := store.MarkScheduledNotificationFailed(claimed.ID, err.Error()); err != nil {
+				return count, err
+			}
+		default:
+			log.Printf("Failed to send scheduled notification %s: %v", claimed.ID, err)
+			if err := store.RetryScheduledNotification(claimed.ID, err.Error(), now.Add(scheduledRetryDelayAfter(claimed.Attempts+1))); err != nil {
+				return count, err
+			}
+			continue
+		}
+		count++
+	}
+}
+
+// scheduledRetryDelayAfter returns how long to wait before sending a notification again after its failed attempts
+func scheduledRetryDelayAfter(attempts int) time.Duration {
+	delay := scheduledRetryDelay
+	for i := 1; i < attempts && delay < scheduledMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, scheduledMaxRetryDelay)
+}
+
+// sendScheduledNotification sends the notification as its creator in its school, and in its term if it has one
+func sendScheduledNotification(store *Store, notification *ScheduledNotification) (*RetrieveNotificationsResponse, *apiError) {
+	school, err := store.GetSchool(notification.SchoolID)
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, err, "failed to get school.")
+	}
+	if school == nil {
+		return nil, newAPIError(http.StatusBadRequest, errNotFound, "The school of the notification does not exist.")
+	}
+
+	var input RetrieveNotificationsRequest
+	if err := json.Unmarshal(notification.Request, &input); err != nil {
+		return nil, newAPIError(http.StatusBadRequest, err, "The scheduled notification is invalid.")
+	}
+
+	// Notifications scheduled without a term are sent in the term current when they are sent
+	schoolStore := store.ForSchool(school.ID)
+	termCode := notification.Term
+	if termCode == "" {
+		term, err := schoolStore.GetCurrentTerm(time.Now().UTC())
+		if err != nil {
+			return nil, newAPIError(http.StatusInternalServerError, err, "failed to get current term.")
+		}
+		if term != nil {
+			termCode = term.Code
+		}
+	}
+	if apiErr := requireTerm(schoolStore.ForTerm(termCode)); apiErr != nil {
+		return nil, apiErr
+	}
+
+	auditTemplate := NewAuditEntry(notification.CreatedBy, notification.CreatedByRole, AuditActionNotify, notification.RequestID)
+	return sendNotification(schoolStore.ForTerm(termCode), school, auditTemplate, input)
+}
+
+// validateScheduledNotification fails fast on what would make the notification fail when it is sent. Mentions are
+// only checked then, since students may be registered in the meantime.
+func validateScheduledNotification(store *Store, input ScheduleNotificationRequest) *apiError {
+	if !input.SendAt.After(time.Now()) {
+		return newAPIError(http.StatusBadRequest, nil, "sendAt must be in the future.")
+	}
+	return validateNotificationSender(store, input.RetrieveNotificationsRequest)
+}
+
+// validateNotificationSender checks that the teacher exists and teaches the class, and that the template exists, if any
+func validateNotificationSender(store *Store, input RetrieveNotificationsRequest) *apiError {
+	if input.TemplateID != "" {
+		if _, apiErr := loadTemplate(store, input.TemplateID); apiErr != nil {
+			return apiErr
+		}
+	}
+	if !IsValidEmail(input.Teacher) {
+		return newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Teacher's email (%s) is invalid.", input.Teacher))
+	}
+
+	isTeacherExists, err := store.IfTeacherExists(input.Teacher)
+	if err != nil {
+		return newAPIError(http.StatusInternalServerError, err, "Something went wrong when checking if teacher is registered.")
+	}
+	if !isTeacherExists {
+		return newAPIError(http.StatusBadRequest, nil, "Given teacher is not registered.")
+	}
+	if input.Class != "" {
+		if _, apiErr := getClassOfTeacher(store, input.Class, input.Teacher); apiErr != nil {
+			return apiErr
+		}
+	}
+	return nil
+}
+
+// getOwnScheduledNotification returns the scheduled notification, or a 404 error if it does not exist or belongs to
+// another teacher than the teacher making the request
+func getOwnScheduledNotification(c *gin.Context, store *Store) (*ScheduledNotification, *apiError) {
+	notification, err := store.GetScheduledNotification(c.Param("id"))
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, err, "failed to get scheduled notification.")
+	}
+	principal := GetPrincipal(c)
+	if notification == nil || (principal != nil && principal.Role == RoleTeacher && principal.Subject != notification.Teacher) {
+		return nil, newAPIError(http.StatusNotFound, errNotFound, "Given scheduled notification does not exist.")
+	}
+	return notification, nil
+}
+
+// explicitTermCodeOf returns the term given with ?term=, or "" when the request only falls back to the current term
+func explicitTermCodeOf(c *gin.Context) string {
+	if c.Query(termQueryParam) == "" {
+		return ""
+	}
+	if term := GetTerm(c); term != nil {
+		return term.Code
+	}
+	return ""
+}
+
+func handleListScheduledNotifications(c *gin.Context, store *Store) {
+	status := c.DefaultQuery("status", ScheduledNotificationPending)
+	if status != "all" && !slices.Contains(scheduledNotificationStatuses, status) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("status must be all or one of %v.", scheduledNotificationStatuses)})
+		return
+	}
+	if status == "all" {
+		status = ""
+	}
+
+	// Teachers only see their own
+	teacherEmail := c.Query("teacher")
+	if principal := GetPrincipal(c); principal != nil && principal.Role == RoleTeacher {
+		teacherEmail = principal.Subject
+	}
+
+	notifications, err := store.ListScheduledNotifications(teacherEmail, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to list scheduled notifications."})
+		return
+	}
+
+	c.JSON(http.StatusOK, ScheduledNotificationsResponse{ScheduledNotifications: notifications})
+}
+
+func handleGetScheduledNotification(c *gin.Context, store *Store) {
+	notification, apiErr := getOwnScheduledNotification(c, store)
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, notification)
+}
+
+func handleScheduleNotification(c *gin.Context, store *Store) {
+	var input ScheduleNotificationRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+
+	if !authorizeAsTeacher(c, input.Teacher) {
+		return
+	}
+	if apiErr := validateScheduledNotification(store, input); apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	id, err := newRandomID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to schedule notification."})
+		return
+	}
+	entry := newAuditEntry(c, AuditActionScheduleNotification)
+	notification, err := NewScheduledNotification(id, explicitTermCodeOf(c), input, entry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to schedule notification."})
+		return
+	}
+	entry.TeacherEmail = notification.Teacher
+	entry.After = toAuditPayload(notification)
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		if err := txStore.AddScheduledNotification(notification); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to schedule notification.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Header("Location", "/api/v2/notifications/scheduled/"+notification.ID)
+	c.JSON(http.StatusCreated, notification)
+}
+
+func handleUpdateScheduledNotification(c *gin.Context, store *Store) {
+	var input ScheduleNotificationRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+
+	existing, apiErr := getOwnScheduledNotification(c, store)
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+	if !authorizeAsTeacher(c, input.Teacher) {
+		return
+	}
+	if apiErr := validateScheduledNotification(store, input); apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	// The notification keeps its term unless another one is given
+	term := existing.Term
+	if code := explicitTermCodeOf(c); code != "" {
+		term = code
+	}
+	entry := newAuditEntry(c, AuditActionUpdateScheduledNotification)
+	updated, err := NewScheduledNotification(existing.ID, term, input, entry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to update scheduled notification."})
+		return
+	}
+	updated.CreatedBy, updated.CreatedByRole, updated.RequestID, updated.CreatedAt = existing.CreatedBy, existing.CreatedByRole, existing.RequestID, existing.CreatedAt
+	entry.TeacherEmail = updated.Teacher
+	entry.Before = toAuditPayload(existing)
+	entry.After = toAuditPayload(updated)
+
+	apiErr = withAudit(store, entry, func(txStore *Store) *apiError {
+		changed, err := txStore.UpdateScheduledNotification(updated)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to update scheduled notification.")
+		}
+		if !changed {
+			return newAPIError(http.StatusConflict, nil, "Only pending scheduled notifications can be changed.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+func handleCancelScheduledNotification(c *gin.Context, store *Store) {
+	notification, apiErr := getOwnScheduledNotification(c, store)
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	entry := newAuditEntry(c, AuditActionCancelScheduledNotification)
+	entry.TeacherEmail = notification.Teacher
+	entry.Before = toAuditPayload(gin.H{"id": notification.ID, "status": notification.Status})
+	entry.After = toAuditPayload(gin.H{"id": notification.ID, "status": ScheduledNotificationCancelled})
+
+	apiErr = withAudit(store, entry, func(txStore *Store) *apiError {
+		cancelled, err := txStore.CancelScheduledNotification(notification.ID)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to cancel scheduled notification.")
+		}
+		if !cancelled {
+			return newAPIError(http.StatusConflict, nil, "Only pending scheduled notifications can be cancelled.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
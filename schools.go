@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	schoolHeader     = "X-School-ID"
+	schoolContextKey = "school"
+
+	AuditActionUpdateSchool = "update_school"
+)
+
+// ResolveSchool attaches the school of the request to the Gin context. Callers that belong to a school always act in
+// it, admins without one choose one with the X-School-ID header or get the default school, and everyone else is
+// limited to the default school. Must run after the auth middleware.
+func ResolveSchool(store *Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		schoolID := c.GetHeader(schoolHeader)
+		if ownSchool := principalSchool(GetPrincipal(c)); ownSchool != "" {
+			if schoolID != "" && schoolID != ownSchool {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": errForbidden.Error(), "message": fmt.Sprintf("You may only act in your own school, not %s.", schoolID)})
+				return
+			}
+			schoolID = ownSchool
+		}
+		if schoolID == "" {
+			schoolID = DefaultSchoolID
+		}
+
+		school, err := store.GetSchool(schoolID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get school."})
+			return
+		}
+		if school == nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errNotFound.Error(), "message": fmt.Sprintf("School (%s) does not exist.", schoolID)})
+			return
+		}
+
+		c.Set(schoolContextKey, school)
+	}
+}
+
+// principalSchool returns the school the caller is limited to, or empty for admins who may act in any school
+func principalSchool(principal *Principal) string {
+	if principal == nil {
+		return ""
+	}
+	if principal.School == "" && principal.Role != RoleAdmin {
+		return DefaultSchoolID
+	}
+	return principal.School
+}
+
+// GetSchool returns the school attached by ResolveSchool, or nil for routes without it
+func GetSchool(c *gin.Context) *School {
+	value, exists := c.Get(schoolContextKey)
+	if !exists {
+		return nil
+	}
+	school, _ := value.(*School)
+	return school
+}
+
+// AllowsEmail reports whether the email is in one of the school's allowed domains
+func (school *School) AllowsEmail(email string) bool {
+	if school == nil || len(school.AllowedDomains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at == -1 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, allowedDomain := range school.AllowedDomains {
+		if strings.EqualFold(domain, allowedDomain) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAllowedDomains returns a 400 error for the first email outside the allowed domains of the request's school.
+// Only emails of teachers and students being created need checking, others cannot exist in the school.
+func checkAllowedDomains(c *gin.Context, emails ...string) *apiError {
+	return checkSchoolAllowsEmails(GetSchool(c), emails...)
+}
+
+func checkSchoolAllowsEmails(school *School, emails ...string) *apiError {
+	for _, email := range emails {
+		if !school.AllowsEmail(email) {
+			return newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Email (%s) is not in one of the domains allowed by the school (%s).", email, strings.Join(school.AllowedDomains, ", ")))
+		}
+	}
+	return nil
+}
+
+func registerSchoolRoutes(v2 *gin.RouterGroup, store *Store, reads gin.HandlerFunc, writes gin.HandlerFunc) {
+	v2.GET("/school", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleGetSchool, store))
+	v2.PATCH("/school", writes, RequireRole(RoleAdmin), makeHandleFunc(handleUpdateSchool, store))
+}
+
+func handleGetSchool(c *gin.Context, store *Store) {
+	c.JSON(http.StatusOK, GetSchool(c))
+}
+
+func handleUpdateSchool(c *gin.Context, store *Store) {
+	var input UpdateSchoolRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+	if input.Name != nil && len(*input.Name) > maxProfileFieldLength {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("name must be at most %d characters.", maxProfileFieldLength)})
+		return
+	}
+	for _, domain := range input.AllowedDomains {
+		if !IsValidEmail("user@" + domain) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("An allowed domain (%s) is invalid.", domain)})
+			return
+		}
+	}
+
+	school := *GetSchool(c)
+	entry := newAuditEntry(c, AuditActionUpdateSchool)
+	entry.Before = toAuditPayload(school)
+
+	if input.Name != nil {
+		school.Name = *input.Name
+	}
+	if input.AllowedDomains != nil {
+		school.AllowedDomains = input.AllowedDomains
+	}
+	entry.After = toAuditPayload(school)
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		if _, err := txStore.UpdateSchool(&school); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to update school.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, school)
+}
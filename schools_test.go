@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchoolAllowsEmail(t *testing.T) {
+	school := NewSchool("school1", "School", []string{"school.edu", "Staff.School.edu"})
+
+	require.True(t, school.AllowsEmail("student@school.edu"))
+	require.True(t, school.AllowsEmail("teacher@staff.school.edu"))
+	require.False(t, school.AllowsEmail("student@other.edu"))
+	require.False(t, school.AllowsEmail("student@sub.school.edu"))
+
+	// Schools without allowed domains accept any email
+	require.True(t, NewSchool("school2", "School", nil).AllowsEmail("student@other.edu"))
+	require.True(t, (*School)(nil).AllowsEmail("student@other.edu"))
+}
+
+func TestPrincipalSchool(t *testing.T) {
+	require.Equal(t, "school1", principalSchool(&Principal{Role: RoleTeacher, School: "school1"}))
+	require.Equal(t, "school1", principalSchool(&Principal{Role: RoleAdmin, School: "school1"}))
+
+	// Only admins may act in any school, everyone else is limited to the default school
+	require.Equal(t, "", principalSchool(&Principal{Role: RoleAdmin}))
+	require.Equal(t, DefaultSchoolID, principalSchool(&Principal{Role: RoleTeacher}))
+	require.Equal(t, DefaultSchoolID, principalSchool(&Principal{Role: RoleStudent}))
+	require.Equal(t, DefaultSchoolID, principalSchool(&Principal{Role: RoleAuditor}))
+}
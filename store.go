@@ -1,18 +1,37 @@
 package main
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
 )
 
 type Store struct {
 	db *sqlx.DB
+	// Set on stores returned by WithTx so that their queries run inside the transaction
+	tx *sqlx.Tx
+	// School whose data the store reads and writes, see ForSchool
+	school string
+	// Term whose enrollments the store reads and writes, see ForTerm
+	term string
+	// Publishes the notifications the store adds to streams, buffered until commit in transactions
+	broker NotificationBroker
+}
+
+// Subset of sqlx shared by *sqlx.DB and *sqlx.Tx
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Select(dest interface{}, query string, args ...interface{}) error
+	Get(dest interface{}, query string, args ...interface{}) error
+	Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
 }
 
 func NewStore() (*Store, error) {
@@ -37,74 +56,702 @@ func NewStore() (*Store, error) {
 	}
 
 	log.Printf("Connected to Postgres database: %v", dbName)
-	return &Store{db: db}, nil
+	store := &Store{db: db}
+	store.broker, err = NewNotificationBroker(GetEnvOrDefault("NOTIFICATION_BROKER", "memory"), store, connStr)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// ForSchool returns a store whose queries only see and change the data of the given school
+func (store *Store) ForSchool(schoolID string) *Store {
+	return &Store{db: store.db, tx: store.tx, school: schoolID, broker: store.broker}
+}
+
+// ForTerm returns a store whose registrations and enrollments are those of the given term of its school.
+// Schools without terms use the empty term.
+func (store *Store) ForTerm(termCode string) *Store {
+	return &Store{db: store.db, tx: store.tx, school: store.school, term: termCode, broker: store.broker}
+}
+
+func (store *Store) conn() queryer {
+	if store.tx != nil {
+		return store.tx
+	}
+	return store.db
+}
+
+// WithTx runs fn with a store whose queries all run in one transaction, committed if fn returns nil.
+// Nested calls reuse the outer transaction.
+func (store *Store) WithTx(fn func(txStore *Store) error) error {
+	if store.tx != nil {
+		return fn(store)
+	}
+
+	tx, err := store.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Notifications are only published once they are committed
+	var buffered *bufferedBroker
+	if store.broker != nil {
+		buffered = &bufferedBroker{NotificationBroker: store.broker}
+	}
+	txStore := &Store{db: store.db, tx: tx, school: store.school, term: store.term}
+	if buffered != nil {
+		txStore.broker = buffered
+	}
+	if err := fn(txStore); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if buffered != nil {
+		buffered.flush()
+	}
+	return nil
 }
 
 func (store *Store) Init() error {
+	// Every other table references schools, so they must exist first
+	if err := store.createSchoolTable(); err != nil {
+		return err
+	}
+
 	err1 := store.createStudentTable()
 	err2 := store.createTeacherTable()
-	err3 := store.createRegisteredTable()
+	err3 := store.createClassTables()
 	err4 := store.createSuspensionTable()
-	err := errors.Join(err1, err2, err3, err4)
+	err5 := store.createAPIKeyTable()
+	err6 := store.createIdempotencyKeyTable()
+	err7 := store.createRateLimitBucketTable()
+	err8 := store.createAuditLogTable()
+	err9 := store.createImportJobTable()
+	err10 := store.createTermTable()
+	err11 := store.createGuardianTables()
+	err12 := store.createNotificationPreferenceTable()
+	err13 := store.createScheduledNotificationTable()
+	err14 := store.createRecurringNotificationTables()
+	err15 := store.createTemplateTable()
+	err16 := store.createStudentNotificationTable()
+	err17 := store.createWebhookTables()
+	err := errors.Join(err1, err2, err3, err4, err5, err6, err7, err8, err9, err10, err11, err12, err13, err14, err15, err16, err17)
 	return err
 }
 
+func (store *Store) createSchoolTable() error {
+	query := `CREATE TABLE IF NOT EXISTS schools(
+		id VARCHAR(50) PRIMARY KEY,
+		name VARCHAR(100) NOT NULL DEFAULT '',
+		allowed_domains TEXT[] NOT NULL DEFAULT '{}'
+	)`
+
+	if _, err := store.db.Exec(query); err != nil {
+		return err
+	}
+
+	defaultQuery := `INSERT INTO schools (id, name) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+
+	if _, err := store.db.Exec(defaultQuery, DefaultSchoolID, "Default school"); err != nil {
+		return err
+	}
+
+	return store.migrateToSchools()
+}
+
+// Tables created before schools were keyed by email alone. Their rows are moved into the default school and their
+// keys extended with the school, so that each school can have its own teachers and students with the same emails.
+func (store *Store) migrateToSchools() error {
+	query := `SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema=current_schema() AND table_name='teachers')
+	AND NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema=current_schema() AND table_name='teachers' AND column_name='school_id')`
+
+	var needsMigration bool
+	if err := store.db.Get(&needsMigration, query); err != nil {
+		return err
+	}
+	if !needsMigration {
+		return nil
+	}
+
+	migrationQueries := []string{}
+	for _, table := range []string{"teachers", "students", "classes", "class_teachers", "enrollments", "suspensions", "audit_log", "import_jobs"} {
+		migrationQueries = append(migrationQueries,
+			fmt.Sprintf(`ALTER TABLE IF EXISTS %s ADD COLUMN school_id VARCHAR(50) NOT NULL DEFAULT '%s' REFERENCES schools(id)`, table, DefaultSchoolID),
+			fmt.Sprintf(`ALTER TABLE IF EXISTS %s ALTER COLUMN school_id DROP DEFAULT`, table))
+	}
+	migrationQueries = append(migrationQueries,
+		// Existing keys stay limited to the default school
+		fmt.Sprintf(`ALTER TABLE IF EXISTS api_keys ADD COLUMN school_id VARCHAR(50) NOT NULL DEFAULT '%s'`, DefaultSchoolID),
+		`ALTER TABLE IF EXISTS api_keys ALTER COLUMN school_id SET DEFAULT ''`,
+		// Idempotency callers are prefixed with their school
+		`ALTER TABLE IF EXISTS idempotency_keys ALTER COLUMN caller TYPE VARCHAR(255)`,
+		// Dropping the primary keys also drops the foreign keys referencing them, which are added back below
+		`ALTER TABLE teachers DROP CONSTRAINT teachers_pkey CASCADE, ADD PRIMARY KEY (school_id, email)`,
+		`ALTER TABLE students DROP CONSTRAINT students_pkey CASCADE, ADD PRIMARY KEY (school_id, email)`,
+		`DROP INDEX IF EXISTS teachers_sis_id_key`,
+		`DROP INDEX IF EXISTS students_sis_id_key`,
+		`ALTER TABLE IF EXISTS classes DROP CONSTRAINT classes_pkey CASCADE, DROP CONSTRAINT classes_default_for_teacher_key,
+		ADD PRIMARY KEY (school_id, code), ADD UNIQUE (school_id, default_for_teacher),
+		ADD FOREIGN KEY (school_id, default_for_teacher) REFERENCES teachers(school_id, email) ON DELETE CASCADE`,
+		`ALTER TABLE IF EXISTS class_teachers DROP CONSTRAINT class_teachers_pkey, ADD PRIMARY KEY (school_id, class_code, teacher_email),
+		ADD FOREIGN KEY (school_id, class_code) REFERENCES classes(school_id, code) ON DELETE CASCADE,
+		ADD FOREIGN KEY (school_id, teacher_email) REFERENCES teachers(school_id, email) ON DELETE CASCADE`,
+		`ALTER TABLE IF EXISTS enrollments DROP CONSTRAINT enrollments_pkey, ADD PRIMARY KEY (school_id, class_code, student_email),
+		ADD FOREIGN KEY (school_id, class_code) REFERENCES classes(school_id, code) ON DELETE CASCADE,
+		ADD FOREIGN KEY (school_id, student_email) REFERENCES students(school_id, email) ON DELETE CASCADE`,
+		`ALTER TABLE IF EXISTS suspensions DROP CONSTRAINT suspensions_pkey, ADD PRIMARY KEY (school_id, student_email, suspended_at),
+		ADD FOREIGN KEY (school_id, student_email) REFERENCES students(school_id, email) ON DELETE CASCADE`,
+	)
+
+	return store.WithTx(func(txStore *Store) error {
+		for _, migrationQuery := range migrationQueries {
+			if _, err := txStore.conn().Exec(migrationQuery); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (store *Store) createStudentTable() error {
 	query := `CREATE TABLE IF NOT EXISTS students(
-		email VARCHAR(50) PRIMARY KEY
+		school_id VARCHAR(50) REFERENCES schools(id),
+		email VARCHAR(50),
+		PRIMARY KEY (school_id, email)
 	)`
 
-	_, err := store.db.Exec(query)
-	return err
+	if _, err := store.db.Exec(query); err != nil {
+		return err
+	}
+
+	return store.addProfileColumns("students", "grade_level VARCHAR(10) NOT NULL DEFAULT ''")
 }
 
 func (store *Store) createTeacherTable() error {
 	query := `CREATE TABLE IF NOT EXISTS teachers(
-		email VARCHAR(50) PRIMARY KEY
+		school_id VARCHAR(50) REFERENCES schools(id),
+		email VARCHAR(50),
+		PRIMARY KEY (school_id, email)
 	)`
 
-	_, err := store.db.Exec(query)
+	if _, err := store.db.Exec(query); err != nil {
+		return err
+	}
+
+	return store.addProfileColumns("teachers")
+}
+
+// Profile columns are added apart from CREATE TABLE so that tables created before them are migrated too
+func (store *Store) addProfileColumns(table string, extraColumns ...string) error {
+	columns := append([]string{
+		"given_name VARCHAR(100) NOT NULL DEFAULT ''",
+		"family_name VARCHAR(100) NOT NULL DEFAULT ''",
+		"preferred_name VARCHAR(100) NOT NULL DEFAULT ''",
+		"sis_id VARCHAR(100) NOT NULL DEFAULT ''",
+		"homeroom VARCHAR(100) NOT NULL DEFAULT ''",
+		"active BOOLEAN NOT NULL DEFAULT TRUE",
+	}, extraColumns...)
+
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString("ALTER TABLE " + table)
+	for _, column := range columns {
+		queryBuilder.WriteString(" ADD COLUMN IF NOT EXISTS " + column + ",")
+	}
+
+	// drop last comma
+	query := queryBuilder.String()
+	query = query[:len(query)-1]
+
+	if _, err := store.db.Exec(query); err != nil {
+		return err
+	}
+
+	// SIS IDs are optional but must identify a single person of the school when given
+	indexQuery := fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %s_school_sis_id_key ON %s (school_id, sis_id) WHERE sis_id <> ''`, table, table)
+
+	_, err := store.db.Exec(indexQuery)
 	return err
 }
 
-func (store *Store) createRegisteredTable() error {
-	query := `CREATE TABLE IF NOT EXISTS registered(
-		student_email  VARCHAR(50),
-		teacher_email  VARCHAR(50),
-		PRIMARY KEY (student_email, teacher_email),
-		FOREIGN KEY (student_email) REFERENCES students(email) ON DELETE CASCADE,
-		FOREIGN KEY (teacher_email) REFERENCES teachers(email) ON DELETE CASCADE
+// createClassTables creates classes, their teachers and their enrolled students, and the registered view over them
+func (store *Store) createClassTables() error {
+	classQuery := `CREATE TABLE IF NOT EXISTS classes(
+		school_id VARCHAR(50) REFERENCES schools(id),
+		code VARCHAR(100),
+		name VARCHAR(100) NOT NULL DEFAULT '',
+		term VARCHAR(50) NOT NULL DEFAULT '',
+		default_for_teacher VARCHAR(50),
+		PRIMARY KEY (school_id, code),
+		UNIQUE (school_id, default_for_teacher),
+		FOREIGN KEY (school_id, default_for_teacher) REFERENCES teachers(school_id, email) ON DELETE CASCADE
 	)`
 
-	_, err := store.db.Exec(query)
+	if _, err := store.db.Exec(classQuery); err != nil {
+		return err
+	}
+
+	classTeacherQuery := `CREATE TABLE IF NOT EXISTS class_teachers(
+		school_id VARCHAR(50) REFERENCES schools(id),
+		class_code VARCHAR(100),
+		teacher_email VARCHAR(50),
+		PRIMARY KEY (school_id, class_code, teacher_email),
+		FOREIGN KEY (school_id, class_code) REFERENCES classes(school_id, code) ON DELETE CASCADE,
+		FOREIGN KEY (school_id, teacher_email) REFERENCES teachers(school_id, email) ON DELETE CASCADE
+	)`
+
+	if _, err := store.db.Exec(classTeacherQuery); err != nil {
+		return err
+	}
+
+	enrollmentQuery := `CREATE TABLE IF NOT EXISTS enrollments(
+		school_id VARCHAR(50) REFERENCES schools(id),
+		class_code VARCHAR(100),
+		student_email VARCHAR(50),
+		term VARCHAR(50) NOT NULL DEFAULT '',
+		PRIMARY KEY (school_id, class_code, student_email, term),
+		FOREIGN KEY (school_id, class_code) REFERENCES classes(school_id, code) ON DELETE CASCADE,
+		FOREIGN KEY (school_id, student_email) REFERENCES students(school_id, email) ON DELETE CASCADE
+	)`
+
+	if _, err := store.db.Exec(enrollmentQuery); err != nil {
+		return err
+	}
+
+	if err := store.migrateRegisteredTable(); err != nil {
+		return err
+	}
+	if err := store.migrateEnrollmentTerms(); err != nil {
+		return err
+	}
+
+	// A student is registered to a teacher in a term when enrolled in any of the teacher's classes in that term
+	viewQuery := `CREATE OR REPLACE VIEW registered AS
+	SELECT DISTINCT class_teachers.teacher_email, enrollments.student_email, enrollments.school_id, enrollments.term FROM enrollments
+	JOIN class_teachers ON class_teachers.school_id=enrollments.school_id AND class_teachers.class_code=enrollments.class_code`
+
+	_, err := store.db.Exec(viewQuery)
+	return err
+}
+
+// registered used to be a table of teacher and student pairs, from before schools. Its pairs are moved into the
+// default class of each teacher of the default school before it is replaced by a view.
+func (store *Store) migrateRegisteredTable() error {
+	query := `SELECT table_type FROM information_schema.tables WHERE table_schema=current_schema() AND table_name='registered'`
+
+	tableTypes := []string{}
+	if err := store.db.Select(&tableTypes, query); err != nil {
+		return err
+	}
+	if len(tableTypes) == 0 || tableTypes[0] != "BASE TABLE" {
+		return nil
+	}
+
+	return store.WithTx(func(txStore *Store) error {
+		migrationQueries := []string{
+			`INSERT INTO classes (school_id, code, name, default_for_teacher)
+			SELECT DISTINCT $1::varchar, teacher_email, teacher_email, teacher_email FROM registered ON CONFLICT DO NOTHING`,
+			`INSERT INTO class_teachers (school_id, class_code, teacher_email)
+			SELECT DISTINCT $1::varchar, teacher_email, teacher_email FROM registered ON CONFLICT DO NOTHING`,
+			`INSERT INTO enrollments (school_id, class_code, student_email)
+			SELECT $1::varchar, teacher_email, student_email FROM registered ON CONFLICT DO NOTHING`,
+		}
+		for _, migrationQuery := range migrationQueries {
+			if _, err := txStore.conn().Exec(migrationQuery, DefaultSchoolID); err != nil {
+				return err
+			}
+		}
+		_, err := txStore.conn().Exec(`DROP TABLE registered`)
+		return err
+	})
+}
+
+// Enrollments from before terms are kept in the empty term, which schools without terms keep using
+func (store *Store) migrateEnrollmentTerms() error {
+	query := `SELECT NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema=current_schema() AND table_name='enrollments' AND column_name='term')`
+
+	var needsMigration bool
+	if err := store.db.Get(&needsMigration, query); err != nil {
+		return err
+	}
+	if !needsMigration {
+		return nil
+	}
+
+	migrationQuery := `ALTER TABLE enrollments ADD COLUMN term VARCHAR(50) NOT NULL DEFAULT '',
+	DROP CONSTRAINT enrollments_pkey, ADD PRIMARY KEY (school_id, class_code, student_email, term)`
+
+	_, err := store.db.Exec(migrationQuery)
 	return err
 }
 
 func (store *Store) createSuspensionTable() error {
 	query := `CREATE TABLE IF NOT EXISTS suspensions(
+		school_id VARCHAR(50) REFERENCES schools(id),
 		student_email  VARCHAR(50),
 		suspended_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 		suspended_until TIMESTAMPTZ,
-		PRIMARY KEY (student_email, suspended_at),
-		FOREIGN KEY (student_email) REFERENCES students(email) ON DELETE CASCADE
+		PRIMARY KEY (school_id, student_email, suspended_at),
+		FOREIGN KEY (school_id, student_email) REFERENCES students(school_id, email) ON DELETE CASCADE
+	)`
+
+	_, err := store.db.Exec(query)
+	return err
+}
+
+func (store *Store) createAPIKeyTable() error {
+	query := `CREATE TABLE IF NOT EXISTS api_keys(
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		subject VARCHAR(100) NOT NULL,
+		role VARCHAR(20) NOT NULL,
+		key_prefix VARCHAR(20) NOT NULL,
+		key_hash CHAR(64) NOT NULL UNIQUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		revoked_at TIMESTAMPTZ,
+		school_id VARCHAR(50) NOT NULL DEFAULT ''
+	)`
+
+	_, err := store.db.Exec(query)
+	return err
+}
+
+func (store *Store) createIdempotencyKeyTable() error {
+	query := `CREATE TABLE IF NOT EXISTS idempotency_keys(
+		caller VARCHAR(255),
+		idempotency_key VARCHAR(255),
+		request_hash CHAR(64) NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		content_type VARCHAR(100) NOT NULL DEFAULT '',
+		response_body BYTEA,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		completed_at TIMESTAMPTZ,
+		PRIMARY KEY (caller, idempotency_key)
+	)`
+
+	_, err := store.db.Exec(query)
+	return err
+}
+
+func (store *Store) createRateLimitBucketTable() error {
+	query := `CREATE TABLE IF NOT EXISTS rate_limit_buckets(
+		bucket_key VARCHAR(255) PRIMARY KEY,
+		tokens DOUBLE PRECISION NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	)`
+
+	_, err := store.db.Exec(query)
+	return err
+}
+
+func (store *Store) createAuditLogTable() error {
+	query := `CREATE TABLE IF NOT EXISTS audit_log(
+		id BIGSERIAL PRIMARY KEY,
+		occurred_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		actor VARCHAR(100) NOT NULL,
+		actor_role VARCHAR(20) NOT NULL,
+		action VARCHAR(50) NOT NULL,
+		teacher_email VARCHAR(50) NOT NULL DEFAULT '',
+		student_emails TEXT[] NOT NULL DEFAULT '{}',
+		before JSONB NOT NULL DEFAULT 'null',
+		after JSONB NOT NULL DEFAULT 'null',
+		request_id VARCHAR(100) NOT NULL DEFAULT '',
+		school_id VARCHAR(50) NOT NULL REFERENCES schools(id)
+	)`
+
+	if _, err := store.db.Exec(query); err != nil {
+		return err
+	}
+
+	// Reject any change to existing entries, the log is append-only
+	functionQuery := `CREATE OR REPLACE FUNCTION reject_audit_log_change() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'audit_log is append-only';
+	END;
+	$$ LANGUAGE plpgsql`
+
+	if _, err := store.db.Exec(functionQuery); err != nil {
+		return err
+	}
+
+	triggerQuery := `CREATE OR REPLACE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION reject_audit_log_change()`
+
+	_, err := store.db.Exec(triggerQuery)
+	return err
+}
+
+func (store *Store) createImportJobTable() error {
+	query := `CREATE TABLE IF NOT EXISTS import_jobs(
+		id VARCHAR(32) PRIMARY KEY,
+		status VARCHAR(20) NOT NULL,
+		dry_run BOOLEAN NOT NULL,
+		created_by VARCHAR(100) NOT NULL,
+		rows_processed INTEGER NOT NULL DEFAULT 0,
+		rows_imported INTEGER NOT NULL DEFAULT 0,
+		rows_failed INTEGER NOT NULL DEFAULT 0,
+		errors JSONB NOT NULL DEFAULT '[]',
+		message TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		finished_at TIMESTAMPTZ,
+		school_id VARCHAR(50) NOT NULL REFERENCES schools(id)
 	)`
 
 	_, err := store.db.Exec(query)
 	return err
 }
 
+func (store *Store) createTermTable() error {
+	query := `CREATE TABLE IF NOT EXISTS terms(
+		school_id VARCHAR(50) REFERENCES schools(id),
+		code VARCHAR(50),
+		name VARCHAR(100) NOT NULL DEFAULT '',
+		starts_on DATE NOT NULL,
+		ends_on DATE NOT NULL CHECK (ends_on >= starts_on),
+		PRIMARY KEY (school_id, code)
+	)`
+
+	_, err := store.db.Exec(query)
+	return err
+}
+
+// createGuardianTables creates guardians and their links to students, which hold the relationship and opt-in
+func (store *Store) createGuardianTables() error {
+	guardianQuery := `CREATE TABLE IF NOT EXISTS guardians(
+		school_id VARCHAR(50) REFERENCES schools(id),
+		email VARCHAR(50),
+		name VARCHAR(100) NOT NULL DEFAULT '',
+		PRIMARY KEY (school_id, email)
+	)`
+
+	if _, err := store.db.Exec(guardianQuery); err != nil {
+		return err
+	}
+
+	studentGuardianQuery := `CREATE TABLE IF NOT EXISTS student_guardians(
+		school_id VARCHAR(50) REFERENCES schools(id),
+		student_email VARCHAR(50),
+		guardian_email VARCHAR(50),
+		relationship VARCHAR(50) NOT NULL DEFAULT '',
+		opted_in BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (school_id, student_email, guardian_email),
+		FOREIGN KEY (school_id, student_email) REFERENCES students(school_id, email) ON DELETE CASCADE,
+		FOREIGN KEY (school_id, guardian_email) REFERENCES guardians(school_id, email) ON DELETE CASCADE
+	)`
+
+	_, err := store.db.Exec(studentGuardianQuery)
+	return err
+}
+
+func (store *Store) createNotificationPreferenceTable() error {
+	query := `CREATE TABLE IF NOT EXISTS notification_preferences(
+		school_id VARCHAR(50) REFERENCES schools(id),
+		student_email VARCHAR(50),
+		channels TEXT[] NOT NULL,
+		muted_categories TEXT[] NOT NULL DEFAULT '{}',
+		quiet_hours_start VARCHAR(5) NOT NULL DEFAULT '',
+		quiet_hours_end VARCHAR(5) NOT NULL DEFAULT '',
+		time_zone VARCHAR(50) NOT NULL DEFAULT 'UTC',
+		PRIMARY KEY (school_id, student_email),
+		FOREIGN KEY (school_id, student_email) REFERENCES students(school_id, email) ON DELETE CASCADE
+	)`
+
+	_, err := store.db.Exec(query)
+	return err
+}
+
+func (store *Store) createScheduledNotificationTable() error {
+	query := `CREATE TABLE IF NOT EXISTS scheduled_notifications(
+		id VARCHAR(32) PRIMARY KEY,
+		school_id VARCHAR(50) NOT NULL REFERENCES schools(id),
+		teacher_email VARCHAR(50) NOT NULL,
+		term VARCHAR(50) NOT NULL DEFAULT '',
+		request JSONB NOT NULL,
+		send_at TIMESTAMPTZ NOT NULL,
+		status VARCHAR(20) NOT NULL,
+		created_by VARCHAR(100) NOT NULL,
+		created_by_role VARCHAR(20) NOT NULL,
+		request_id VARCHAR(100) NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		sent_at TIMESTAMPTZ,
+		result JSONB NOT NULL DEFAULT 'null',
+		message TEXT NOT NULL DEFAULT '',
+		recurring_id VARCHAR(32) NOT NULL DEFAULT '',
+		attempts INT NOT NULL DEFAULT 0
+	)`
+
+	if _, err := store.db.Exec(query); err != nil {
+		return err
+	}
+
+	// Tables created before recurring notifications and retries
+	columnQuery := `ALTER TABLE scheduled_notifications ADD COLUMN IF NOT EXISTS recurring_id VARCHAR(32) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0`
+
+	if _, err := store.db.Exec(columnQuery); err != nil {
+		return err
+	}
+
+	// The scheduler only looks for pending notifications that are due
+	indexQuery := `CREATE INDEX IF NOT EXISTS scheduled_notifications_due ON scheduled_notifications (send_at) WHERE status = 'pending'`
+
+	_, err := store.db.Exec(indexQuery)
+	return err
+}
+
+func (store *Store) createRecurringNotificationTables() error {
+	query := `CREATE TABLE IF NOT EXISTS recurring_notifications(
+		id VARCHAR(32) PRIMARY KEY,
+		school_id VARCHAR(50) NOT NULL REFERENCES schools(id),
+		teacher_email VARCHAR(50) NOT NULL,
+		request JSONB NOT NULL,
+		schedule VARCHAR(200) NOT NULL,
+		time_zone VARCHAR(50) NOT NULL,
+		starts_at TIMESTAMPTZ NOT NULL,
+		ends_on DATE,
+		max_occurrences INTEGER NOT NULL DEFAULT 0,
+		occurrences INTEGER NOT NULL DEFAULT 0,
+		next_at TIMESTAMPTZ,
+		status VARCHAR(20) NOT NULL,
+		created_by VARCHAR(100) NOT NULL,
+		created_by_role VARCHAR(20) NOT NULL,
+		request_id VARCHAR(100) NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`
+
+	if _, err := store.db.Exec(query); err != nil {
+		return err
+	}
+
+	holidayQuery := `CREATE TABLE IF NOT EXISTS holidays(
+		school_id VARCHAR(50) REFERENCES schools(id),
+		date DATE,
+		name VARCHAR(100) NOT NULL DEFAULT '',
+		PRIMARY KEY (school_id, date)
+	)`
+
+	_, err := store.db.Exec(holidayQuery)
+	return err
+}
+
+func (store *Store) createTemplateTable() error {
+	query := `CREATE TABLE IF NOT EXISTS notification_templates(
+		id VARCHAR(32) PRIMARY KEY,
+		school_id VARCHAR(50) NOT NULL REFERENCES schools(id),
+		name VARCHAR(100) NOT NULL,
+		body TEXT NOT NULL,
+		created_by VARCHAR(100) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (school_id, name)
+	)`
+
+	_, err := store.db.Exec(query)
+	return err
+}
+
+func (store *Store) createStudentNotificationTable() error {
+	query := `CREATE TABLE IF NOT EXISTS student_notifications(
+		id BIGSERIAL PRIMARY KEY,
+		notification_id VARCHAR(32) NOT NULL,
+		school_id VARCHAR(50) NOT NULL REFERENCES schools(id),
+		student_email VARCHAR(50) NOT NULL,
+		teacher_email VARCHAR(50) NOT NULL,
+		notification TEXT NOT NULL,
+		category VARCHAR(50) NOT NULL DEFAULT '',
+		urgent BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		read_at TIMESTAMPTZ
+	)`
+
+	if _, err := store.db.Exec(query); err != nil {
+		return err
+	}
+
+	// Read state was added after the table
+	columnQuery := `ALTER TABLE student_notifications ADD COLUMN IF NOT EXISTS read_at TIMESTAMPTZ`
+
+	if _, err := store.db.Exec(columnQuery); err != nil {
+		return err
+	}
+
+	// Streams resume from the last notification of the student they received, and inboxes page through them
+	indexQuery := `CREATE INDEX IF NOT EXISTS student_notifications_student ON student_notifications (school_id, student_email, id)`
+
+	if _, err := store.db.Exec(indexQuery); err != nil {
+		return err
+	}
+
+	// Read receipts are listed per notification
+	receiptIndexQuery := `CREATE INDEX IF NOT EXISTS student_notifications_notification ON student_notifications (school_id, notification_id)`
+
+	_, err := store.db.Exec(receiptIndexQuery)
+	return err
+}
+
+func (store *Store) createWebhookTables() error {
+	query := `CREATE TABLE IF NOT EXISTS webhooks(
+		id VARCHAR(32) PRIMARY KEY,
+		school_id VARCHAR(50) NOT NULL REFERENCES schools(id),
+		url VARCHAR(500) NOT NULL,
+		event_types TEXT[] NOT NULL,
+		secret VARCHAR(200) NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_by VARCHAR(100) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`
+
+	if _, err := store.db.Exec(query); err != nil {
+		return err
+	}
+
+	// The outbox of webhook events, one row per subscribed webhook, kept as the delivery log once sent
+	deliveryQuery := `CREATE TABLE IF NOT EXISTS webhook_deliveries(
+		id BIGSERIAL PRIMARY KEY,
+		school_id VARCHAR(50) NOT NULL REFERENCES schools(id),
+		webhook_id VARCHAR(32) NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+		event_id VARCHAR(32) NOT NULL,
+		event_type VARCHAR(50) NOT NULL,
+		payload JSONB NOT NULL,
+		status VARCHAR(20) NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ,
+		last_attempt_at TIMESTAMPTZ,
+		response_status INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		redelivery_of BIGINT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		delivered_at TIMESTAMPTZ
+	)`
+
+	if _, err := store.db.Exec(deliveryQuery); err != nil {
+		return err
+	}
+
+	// The dispatcher claims pending deliveries by when they are due
+	indexQuery := `CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending'`
+
+	_, err := store.db.Exec(indexQuery)
+	return err
+}
+
 func (store *Store) AddTeacher(teacher *Teacher) error {
-	query := `INSERT INTO teachers (email) VALUES ($1) ON CONFLICT DO NOTHING`
+	query := `INSERT INTO teachers (school_id, email) VALUES ($1, $2) ON CONFLICT DO NOTHING`
 
-	_, err := store.db.Exec(query, teacher.Email)
+	_, err := store.conn().Exec(query, store.school, teacher.Email)
 	return err
 }
 
 func (store *Store) IfStudentExists(email string) (bool, error) {
 	var studentToFind []string
-	query := `SELECT email FROM students WHERE email = $1`
+	query := `SELECT email FROM students WHERE school_id=$1 AND email = $2`
 
-	err := store.db.Select(&studentToFind, query, email)
+	err := store.conn().Select(&studentToFind, query, store.school, email)
 	if err != nil {
 		return false, err
 	}
@@ -114,9 +761,9 @@ func (store *Store) IfStudentExists(email string) (bool, error) {
 
 func (store *Store) IfTeacherExists(email string) (bool, error) {
 	var teacherToFind []string
-	query := `SELECT email FROM teachers WHERE email = $1`
+	query := `SELECT email FROM teachers WHERE school_id=$1 AND email = $2`
 
-	err := store.db.Select(&teacherToFind, query, email)
+	err := store.conn().Select(&teacherToFind, query, store.school, email)
 	if err != nil {
 		return false, err
 	}
@@ -126,62 +773,130 @@ func (store *Store) IfTeacherExists(email string) (bool, error) {
 
 func (store *Store) AddStudents(students []*Student) error {
 	var queryBuilder strings.Builder
-	queryBuilder.WriteString("INSERT INTO students (email) VALUES ")
-	params := make([]interface{}, len(students))
-	for i, student := range students {
-		params[i] = student.Email
+	queryBuilder.WriteString("INSERT INTO students (school_id, email) VALUES ")
+	params := []interface{}{store.school}
+	for _, student := range students {
+		params = append(params, student.Email)
 
-		queryBuilder.WriteString(fmt.Sprintf("($%d),", i+1))
+		queryBuilder.WriteString(fmt.Sprintf("($1, $%d),", len(params)))
 	}
 
 	// drop last comma
 	query := queryBuilder.String()
 	query = query[:len(query)-1] + " ON CONFLICT DO NOTHING"
 
-	_, err := store.db.Exec(query, params...)
+	_, err := store.conn().Exec(query, params...)
 	return err
 }
 
+// Register enrolls each student in the default class of their teacher, which is created if needed
 func (store *Store) Register(teacherStudentPairs []*TeacherStudentPair) error {
-	var queryBuilder strings.Builder
-	queryBuilder.WriteString("INSERT INTO registered (student_email, teacher_email) VALUES ")
-	params := make([]interface{}, len(teacherStudentPairs)*2)
-	for i, pair := range teacherStudentPairs {
-		pos := i * 2
-		params[pos] = pair.StudentEmail
-		params[pos+1] = pair.TeacherEmail
+	teacherEmails := []string{}
+	enrollments := []*Enrollment{}
+	for _, pair := range teacherStudentPairs {
+		if !slices.Contains(teacherEmails, pair.TeacherEmail) {
+			teacherEmails = append(teacherEmails, pair.TeacherEmail)
+		}
+		enrollments = append(enrollments, NewEnrollment(DefaultClassCode(pair.TeacherEmail), pair.StudentEmail))
+	}
+
+	return store.WithTx(func(txStore *Store) error {
+		if err := txStore.AddDefaultClasses(teacherEmails); err != nil {
+			return err
+		}
+		return txStore.Enroll(enrollments)
+	})
+}
+
+// AddDefaultClasses creates the default class of each teacher that does not have one yet
+func (store *Store) AddDefaultClasses(teacherEmails []string) error {
+	var classBuilder, classTeacherBuilder strings.Builder
+	classBuilder.WriteString("INSERT INTO classes (school_id, code, name, default_for_teacher) VALUES ")
+	classTeacherBuilder.WriteString("INSERT INTO class_teachers (school_id, class_code, teacher_email) VALUES ")
+	params := []interface{}{store.school}
+	for _, teacherEmail := range teacherEmails {
+		params = append(params, DefaultClassCode(teacherEmail), teacherEmail)
+		pos := len(params) - 1
 
-		queryBuilder.WriteString(fmt.Sprintf("($%d, $%d),", pos+1, pos+2))
+		classBuilder.WriteString(fmt.Sprintf("($1, $%d, $%d, $%d),", pos, pos+1, pos+1))
+		classTeacherBuilder.WriteString(fmt.Sprintf("($1, $%d, $%d),", pos, pos+1))
 	}
 
-	// drop last comma
-	query := queryBuilder.String()
-	query = query[:len(query)-1] + " ON CONFLICT DO NOTHING"
+	// drop last commas
+	classQuery := classBuilder.String()
+	classQuery = classQuery[:len(classQuery)-1] + " ON CONFLICT DO NOTHING"
+	classTeacherQuery := classTeacherBuilder.String()
+	classTeacherQuery = classTeacherQuery[:len(classTeacherQuery)-1] + " ON CONFLICT DO NOTHING"
 
-	_, err := store.db.Exec(query, params...)
+	if _, err := store.conn().Exec(classQuery, params...); err != nil {
+		return err
+	}
+	_, err := store.conn().Exec(classTeacherQuery, params...)
 	return err
 }
 
-func (store *Store) GetCommonStudents(teachers []*Teacher) ([]string, error) {
+// Enrollments inserted per statement, two parameters each
+const enrollChunkSize = 10000
+
+func (store *Store) Enroll(enrollments []*Enrollment) error {
+	// Postgres takes at most 65535 parameters per statement, so a school rolled over at once is inserted in chunks
+	for start := 0; start < len(enrollments); start += enrollChunkSize {
+		end := min(start+enrollChunkSize, len(enrollments))
+
+		var queryBuilder strings.Builder
+		queryBuilder.WriteString("INSERT INTO enrollments (school_id, term, class_code, student_email) VALUES ")
+		params := []interface{}{store.school, store.term}
+		for _, enrollment := range enrollments[start:end] {
+			params = append(params, enrollment.ClassCode, enrollment.StudentEmail)
+
+			queryBuilder.WriteString(fmt.Sprintf("($1, $2, $%d, $%d),", len(params)-1, len(params)))
+		}
+
+		// drop last comma
+		query := queryBuilder.String()
+		query = query[:len(query)-1] + " ON CONFLICT DO NOTHING"
+
+		if _, err := store.conn().Exec(query, params...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unenroll returns false if the student was not enrolled in the class
+func (store *Store) Unenroll(enrollment *Enrollment) (bool, error) {
+	query := `DELETE FROM enrollments WHERE school_id=$1 AND term=$2 AND class_code=$3 AND student_email=$4`
+
+	return store.execAffectsRows(query, store.school, store.term, enrollment.ClassCode, enrollment.StudentEmail)
+}
+
+// GetCommonStudents returns the students registered to all of the teachers, only those enrolled in the class if one is given
+func (store *Store) GetCommonStudents(teachers []*Teacher, classCode string) ([]string, error) {
 	var queryBuilder strings.Builder
-	queryBuilder.WriteString(`SELECT student_email AS email FROM registered WHERE teacher_email IN (`)
-	params := make([]interface{}, len(teachers))
-	for i, teacher := range teachers {
-		params[i] = teacher.Email
+	queryBuilder.WriteString(`SELECT student_email AS email FROM registered WHERE school_id=$1 AND term=$2 AND teacher_email IN (`)
+	params := []interface{}{store.school, store.term}
+	for _, teacher := range teachers {
+		params = append(params, teacher.Email)
 
-		queryBuilder.WriteString(fmt.Sprintf("$%d,", i+1))
+		queryBuilder.WriteString(fmt.Sprintf("$%d,", len(params)))
 	}
 
 	query := queryBuilder.String()
+	query = query[:len(query)-1] + ")"
+
+	if classCode != "" {
+		params = append(params, classCode)
+		query += fmt.Sprintf(" AND student_email IN (SELECT student_email FROM enrollments WHERE school_id=$1 AND term=$2 AND class_code=$%d)", len(params))
+	}
 
-	// remove the last comma and finish the query
-	query = query[:len(query)-1] + fmt.Sprintf(") GROUP BY student_email HAVING COUNT(DISTINCT teacher_email) = $%d;", len(params)+1)
+	// finish the query
+	query += fmt.Sprintf(" GROUP BY student_email HAVING COUNT(DISTINCT teacher_email) = $%d;", len(params)+1)
 
 	// Append param for the HAVING condition
 	params = append(params, len(teachers))
 	students := []string{}
 
-	err := store.db.Select(&students, query, params...)
+	err := store.conn().Select(&students, query, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -189,23 +904,36 @@ func (store *Store) GetCommonStudents(teachers []*Teacher) ([]string, error) {
 }
 
 func (store *Store) AddSuspension(suspension *Suspension) error {
-	query := `INSERT INTO suspensions (student_email, suspended_at) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	query := `INSERT INTO suspensions (school_id, student_email, suspended_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
 
-	_, err := store.db.Exec(query, suspension.Email, suspension.SuspendedAt)
+	_, err := store.conn().Exec(query, store.school, suspension.Email, suspension.SuspendedAt)
 	return err
 }
 
-func (store *Store) GetNotifiableStudentsOfTeacher(teacher *Teacher) ([]string, error) {
-	query := `SELECT student_email FROM registered WHERE teacher_email=$1 AND student_email NOT IN ( 
-		SELECT students.email FROM teachers
-		JOIN registered ON teachers.email=registered.teacher_email 
-		JOIN students ON students.email=registered.student_email 
-		JOIN suspensions ON students.email=suspensions.student_email
-		WHERE teachers.email=$1 AND suspensions.suspended_at <= $2 AND (suspensions.suspended_until >= $2 OR suspensions.suspended_until IS NULL))`
+// GetNotificationRecipients returns, in one query, the students registered to the teacher, or only those in the class
+// if one is given, together with the mentioned students and the students of the mentioned classes and teachers, or
+// every student if @all-suspended-excluded was mentioned, less those suspended at the given time. Mentions of unknown
+// students are left out, see ResolveMentions.
+func (store *Store) GetNotificationRecipients(audience *NotificationAudience, now time.Time) ([]string, error) {
+	rosterQuery := `SELECT student_email FROM registered WHERE school_id=$1 AND term=$2 AND teacher_email=$3`
+	params := []interface{}{store.school, store.term, audience.Teacher.Email}
+	if audience.Class != "" {
+		rosterQuery = `SELECT student_email FROM enrollments WHERE school_id=$1 AND term=$2 AND class_code=$3`
+		params = []interface{}{store.school, store.term, audience.Class}
+	}
+
+	query := `SELECT student_email FROM (` + rosterQuery + `
+		UNION SELECT email FROM students WHERE school_id=$1 AND (email = ANY($4) OR $7)
+		UNION SELECT student_email FROM enrollments WHERE school_id=$1 AND term=$2 AND class_code = ANY($5)
+		UNION SELECT student_email FROM registered WHERE school_id=$1 AND term=$2 AND teacher_email = ANY($6)
+	) candidates WHERE student_email NOT IN (
+		SELECT student_email FROM suspensions
+		WHERE school_id=$1 AND suspended_at <= $8 AND (suspended_until >= $8 OR suspended_until IS NULL))
+	ORDER BY student_email`
+	params = append(params, pq.Array(audience.Students), pq.Array(audience.Classes), pq.Array(audience.Teachers), audience.AllStudents, now)
 
 	students := []string{}
-	err := store.db.Select(&students, query, teacher.Email, time.Now().UTC())
-
+	err := store.conn().Select(&students, query, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -213,12 +941,57 @@ func (store *Store) GetNotifiableStudentsOfTeacher(teacher *Teacher) ([]string,
 	return students, nil
 }
 
+// GetExistingClasses returns which of the class codes are classes of the school, sorted
+func (store *Store) GetExistingClasses(codes []string) ([]string, error) {
+	query := `SELECT code FROM classes WHERE school_id=$1 AND code = ANY($2) ORDER BY code`
+
+	classes := []string{}
+	err := store.conn().Select(&classes, query, store.school, pq.Array(codes))
+	if err != nil {
+		return nil, err
+	}
+
+	return classes, nil
+}
+
+// GetExistingTeachers returns which of the emails are teachers of the school, sorted
+func (store *Store) GetExistingTeachers(emails []string) ([]string, error) {
+	query := `SELECT email FROM teachers WHERE school_id=$1 AND email = ANY($2) ORDER BY email`
+
+	teachers := []string{}
+	err := store.conn().Select(&teachers, query, store.school, pq.Array(emails))
+	if err != nil {
+		return nil, err
+	}
+
+	return teachers, nil
+}
+
+// ResolveMentions returns, in one query, whether each of the emails is a student and whether they are suspended at
+// the given time, in the order given
+func (store *Store) ResolveMentions(emails []string, now time.Time) ([]*MentionStatus, error) {
+	query := `SELECT m.email, s.email IS NOT NULL AS student_exists,
+	EXISTS (SELECT 1 FROM suspensions WHERE school_id=$1 AND student_email=m.email
+		AND suspended_at <= $3 AND (suspended_until >= $3 OR suspended_until IS NULL)) AS suspended
+	FROM unnest($2::text[]) WITH ORDINALITY AS m(email, position)
+	LEFT JOIN students s ON s.school_id=$1 AND s.email=m.email
+	ORDER BY m.position`
+
+	statuses := []*MentionStatus{}
+	err := store.conn().Select(&statuses, query, store.school, pq.Array(emails), now)
+	if err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
 func (store *Store) IsSuspended(email string) (bool, error) {
 	query := `SELECT student_email FROM suspensions
-	WHERE student_email=$1 AND suspended_at <= $2 AND (suspended_until >= $2 OR suspended_until IS NULL)`
+	WHERE school_id=$1 AND student_email=$2 AND suspended_at <= $3 AND (suspended_until >= $3 OR suspended_until IS NULL)`
 
 	students := []string{}
-	err := store.db.Select(&students, query, email, time.Now().UTC())
+	err := store.conn().Select(&students, query, store.school, email, time.Now().UTC())
 
 	if err != nil {
 		return false, err
@@ -226,3 +999,1590 @@ func (store *Store) IsSuspended(email string) (bool, error) {
 
 	return len(students) != 0, nil
 }
+
+func (store *Store) AddAPIKey(apiKey *APIKey) error {
+	query := `INSERT INTO api_keys (name, subject, role, key_prefix, key_hash, created_at, school_id) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+
+	return store.conn().Get(&apiKey.ID, query, apiKey.Name, apiKey.Subject, apiKey.Role, apiKey.KeyPrefix, apiKey.KeyHash, apiKey.CreatedAt, apiKey.School)
+}
+
+// GetActiveAPIKeyByHash returns nil if no unrevoked key has the given hash
+func (store *Store) GetActiveAPIKeyByHash(keyHash string) (*APIKey, error) {
+	query := `SELECT id, name, subject, role, key_prefix, key_hash, created_at, revoked_at, school_id FROM api_keys
+	WHERE key_hash=$1 AND revoked_at IS NULL`
+
+	apiKeys := []*APIKey{}
+	err := store.conn().Select(&apiKeys, query, keyHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(apiKeys) == 0 {
+		return nil, nil
+	}
+
+	return apiKeys[0], nil
+}
+
+func (store *Store) ListAPIKeys() ([]*APIKey, error) {
+	query := `SELECT id, name, subject, role, key_prefix, key_hash, created_at, revoked_at, school_id FROM api_keys ORDER BY id`
+
+	apiKeys := []*APIKey{}
+	err := store.conn().Select(&apiKeys, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return apiKeys, nil
+}
+
+// RevokeAPIKey returns false if there is no active key with the given id
+func (store *Store) RevokeAPIKey(id int64) (bool, error) {
+	query := `UPDATE api_keys SET revoked_at=$2 WHERE id=$1 AND revoked_at IS NULL`
+
+	result, err := store.conn().Exec(query, id, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected != 0, nil
+}
+
+// ReserveIdempotencyKey claims the key for a new request, taking over keys created before expiredBefore.
+// Returns false if the key is already held by another request.
+func (store *Store) ReserveIdempotencyKey(record *IdempotencyRecord, expiredBefore time.Time) (bool, error) {
+	query := `INSERT INTO idempotency_keys (caller, idempotency_key, request_hash, created_at) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (caller, idempotency_key) DO UPDATE
+	SET request_hash=EXCLUDED.request_hash, created_at=EXCLUDED.created_at, status_code=0, content_type='', response_body=NULL, completed_at=NULL
+	WHERE idempotency_keys.created_at < $5`
+
+	result, err := store.conn().Exec(query, record.Caller, record.Key, record.RequestHash, record.CreatedAt, expiredBefore)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected != 0, nil
+}
+
+// GetIdempotencyRecord returns nil if the key has not been used by the caller
+func (store *Store) GetIdempotencyRecord(caller string, key string) (*IdempotencyRecord, error) {
+	query := `SELECT caller, idempotency_key, request_hash, status_code, content_type, response_body, created_at, completed_at
+	FROM idempotency_keys WHERE caller=$1 AND idempotency_key=$2`
+
+	records := []*IdempotencyRecord{}
+	err := store.conn().Select(&records, query, caller, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	return records[0], nil
+}
+
+func (store *Store) CompleteIdempotencyKey(record *IdempotencyRecord) error {
+	query := `UPDATE idempotency_keys SET status_code=$3, content_type=$4, response_body=$5, completed_at=$6
+	WHERE caller=$1 AND idempotency_key=$2`
+
+	_, err := store.conn().Exec(query, record.Caller, record.Key, record.StatusCode, record.ContentType, record.ResponseBody, time.Now().UTC())
+	return err
+}
+
+func (store *Store) ReleaseIdempotencyKey(record *IdempotencyRecord) error {
+	query := `DELETE FROM idempotency_keys WHERE caller=$1 AND idempotency_key=$2 AND completed_at IS NULL`
+
+	_, err := store.conn().Exec(query, record.Caller, record.Key)
+	return err
+}
+
+// TakeRateLimitToken takes a token from the bucket, locking its row so concurrent replicas don't race
+func (store *Store) TakeRateLimitToken(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	tx, err := store.db.Beginx()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	defer tx.Rollback()
+
+	// Make sure the row exists so that it can be locked
+	insertQuery := `INSERT INTO rate_limit_buckets (bucket_key, tokens, updated_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+	if _, err := tx.Exec(insertQuery, key, limit.Capacity, now); err != nil {
+		return RateLimitResult{}, err
+	}
+
+	var bucket struct {
+		Tokens    float64   `db:"tokens"`
+		UpdatedAt time.Time `db:"updated_at"`
+	}
+	selectQuery := `SELECT tokens, updated_at FROM rate_limit_buckets WHERE bucket_key=$1 FOR UPDATE`
+	if err := tx.Get(&bucket, selectQuery, key); err != nil {
+		return RateLimitResult{}, err
+	}
+
+	tokens, result := takeToken(bucket.Tokens, bucket.UpdatedAt, limit, now)
+
+	updateQuery := `UPDATE rate_limit_buckets SET tokens=$2, updated_at=$3 WHERE bucket_key=$1`
+	if _, err := tx.Exec(updateQuery, key, tokens, now); err != nil {
+		return RateLimitResult{}, err
+	}
+
+	return result, tx.Commit()
+}
+
+// GetRegisteredStudents returns which of the given students are registered to the teacher
+func (store *Store) GetRegisteredStudents(teacher *Teacher, studentEmails []string) ([]string, error) {
+	query := `SELECT student_email FROM registered WHERE school_id=$1 AND term=$2 AND teacher_email=$3 AND student_email = ANY($4) ORDER BY student_email`
+
+	students := []string{}
+	err := store.conn().Select(&students, query, store.school, store.term, teacher.Email, pq.Array(studentEmails))
+	if err != nil {
+		return nil, err
+	}
+
+	return students, nil
+}
+
+func (store *Store) AddAuditEntry(entry *AuditEntry) error {
+	query := `INSERT INTO audit_log (occurred_at, actor, actor_role, action, teacher_email, student_emails, before, after, request_id, school_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`
+
+	return store.conn().Get(&entry.ID, query, entry.OccurredAt, entry.Actor, entry.ActorRole, entry.Action,
+		entry.TeacherEmail, entry.StudentEmails, entry.Before, entry.After, entry.RequestID, store.school)
+}
+
+// GetAuditEntries returns matching entries, newest first
+func (store *Store) GetAuditEntries(filter AuditFilter) ([]*AuditEntry, error) {
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString(`SELECT id, occurred_at, actor, actor_role, action, teacher_email, student_emails, before, after, request_id
+	FROM audit_log WHERE school_id=$1`)
+	params := []interface{}{store.school}
+
+	addCondition := func(condition string, param interface{}) {
+		params = append(params, param)
+		queryBuilder.WriteString(fmt.Sprintf(" AND "+condition, len(params)))
+	}
+
+	if filter.Actor != "" {
+		addCondition("actor=$%d", filter.Actor)
+	}
+	if filter.Action != "" {
+		addCondition("action=$%d", filter.Action)
+	}
+	if filter.TeacherEmail != "" {
+		addCondition("teacher_email=$%d", filter.TeacherEmail)
+	}
+	if filter.StudentEmail != "" {
+		addCondition("$%d = ANY(student_emails)", filter.StudentEmail)
+	}
+	if filter.Since != nil {
+		addCondition("occurred_at >= $%d", *filter.Since)
+	}
+	if filter.Until != nil {
+		addCondition("occurred_at < $%d", *filter.Until)
+	}
+	if filter.BeforeID > 0 {
+		addCondition("id < $%d", filter.BeforeID)
+	}
+
+	params = append(params, filter.Limit)
+	queryBuilder.WriteString(fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(params)))
+
+	entries := []*AuditEntry{}
+	err := store.conn().Select(&entries, queryBuilder.String(), params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+const (
+	teacherColumns = `email, given_name, family_name, preferred_name, sis_id, homeroom, active`
+	studentColumns = `email, given_name, family_name, preferred_name, sis_id, homeroom, active, grade_level`
+)
+
+func (store *Store) ListTeachers(filter ProfileFilter) ([]*Teacher, error) {
+	query, params := buildProfileQuery("teachers", teacherColumns, store.school, filter)
+
+	teachers := []*Teacher{}
+	err := store.conn().Select(&teachers, query, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return teachers, nil
+}
+
+func (store *Store) ListStudents(filter ProfileFilter) ([]*Student, error) {
+	query, params := buildProfileQuery("students", studentColumns, store.school, filter)
+
+	students := []*Student{}
+	err := store.conn().Select(&students, query, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return students, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func buildProfileQuery(table string, columns string, schoolID string, filter ProfileFilter) (string, []interface{}) {
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString(fmt.Sprintf("SELECT %s FROM %s WHERE school_id=$1", columns, table))
+	params := []interface{}{schoolID}
+
+	addCondition := func(condition string, param interface{}) {
+		params = append(params, param)
+		queryBuilder.WriteString(fmt.Sprintf(" AND "+condition, len(params)))
+	}
+
+	if filter.Query != "" {
+		addCondition(`(email ILIKE $%[1]d OR given_name ILIKE $%[1]d OR family_name ILIKE $%[1]d OR preferred_name ILIKE $%[1]d
+		OR given_name || ' ' || family_name ILIKE $%[1]d OR sis_id ILIKE $%[1]d)`, "%"+likeEscaper.Replace(filter.Query)+"%")
+	}
+	if filter.Active != nil {
+		addCondition("active=$%d", *filter.Active)
+	}
+	if filter.Homeroom != "" {
+		addCondition("homeroom=$%d", filter.Homeroom)
+	}
+	if filter.GradeLevel != "" {
+		addCondition("grade_level=$%d", filter.GradeLevel)
+	}
+	if filter.Emails != nil {
+		addCondition("email = ANY($%d)", pq.Array(filter.Emails))
+	}
+
+	queryBuilder.WriteString(" ORDER BY email")
+	return queryBuilder.String(), params
+}
+
+// GetTeacher returns nil if the teacher does not exist
+func (store *Store) GetTeacher(email string) (*Teacher, error) {
+	query := `SELECT ` + teacherColumns + ` FROM teachers WHERE school_id=$1 AND email=$2`
+
+	teacher := &Teacher{}
+	err := store.conn().Get(teacher, query, store.school, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return teacher, nil
+}
+
+// GetStudent returns nil if the student does not exist
+func (store *Store) GetStudent(email string) (*Student, error) {
+	query := `SELECT ` + studentColumns + ` FROM students WHERE school_id=$1 AND email=$2`
+
+	student := &Student{}
+	err := store.conn().Get(student, query, store.school, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return student, nil
+}
+
+// UpdateTeacher applies the non nil fields of the update and returns the updated teacher, or nil if they do not exist
+func (store *Store) UpdateTeacher(email string, update *ProfileUpdate) (*Teacher, error) {
+	query := `UPDATE teachers SET given_name=COALESCE($3, given_name), family_name=COALESCE($4, family_name),
+	preferred_name=COALESCE($5, preferred_name), sis_id=COALESCE($6, sis_id), homeroom=COALESCE($7, homeroom), active=COALESCE($8, active)
+	WHERE school_id=$1 AND email=$2 RETURNING ` + teacherColumns
+
+	teacher := &Teacher{}
+	err := store.conn().Get(teacher, query, store.school, email, update.GivenName, update.FamilyName, update.PreferredName, update.SISID, update.Homeroom, update.Active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return teacher, nil
+}
+
+// UpdateStudent applies the non nil fields of the update and returns the updated student, or nil if they do not exist
+func (store *Store) UpdateStudent(email string, update *StudentProfileUpdate) (*Student, error) {
+	query := `UPDATE students SET given_name=COALESCE($3, given_name), family_name=COALESCE($4, family_name),
+	preferred_name=COALESCE($5, preferred_name), sis_id=COALESCE($6, sis_id), homeroom=COALESCE($7, homeroom), active=COALESCE($8, active),
+	grade_level=COALESCE($9, grade_level)
+	WHERE school_id=$1 AND email=$2 RETURNING ` + studentColumns
+
+	student := &Student{}
+	err := store.conn().Get(student, query, store.school, email, update.GivenName, update.FamilyName, update.PreferredName, update.SISID, update.Homeroom, update.Active, update.GradeLevel)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return student, nil
+}
+
+// IsUniqueViolation reports whether err was caused by a unique constraint, such as a duplicate SIS ID
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func (store *Store) GetStudentsOfTeacher(teacher *Teacher) ([]string, error) {
+	query := `SELECT student_email FROM registered WHERE school_id=$1 AND term=$2 AND teacher_email=$3 ORDER BY student_email`
+
+	students := []string{}
+	err := store.conn().Select(&students, query, store.school, store.term, teacher.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	return students, nil
+}
+
+func (store *Store) AddStudent(student *Student) error {
+	query := `INSERT INTO students (school_id, email) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+
+	_, err := store.conn().Exec(query, store.school, student.Email)
+	return err
+}
+
+// Unregister removes the student from all classes of the teacher in the term. Returns false if the student was not
+// registered to the teacher.
+func (store *Store) Unregister(pair *TeacherStudentPair) (bool, error) {
+	query := `DELETE FROM enrollments WHERE school_id=$1 AND term=$2 AND student_email=$3
+	AND class_code IN (SELECT class_code FROM class_teachers WHERE school_id=$1 AND teacher_email=$4)`
+
+	return store.execAffectsRows(query, store.school, store.term, pair.StudentEmail, pair.TeacherEmail)
+}
+
+// DeleteTeacher also deletes the teacher's registrations. Returns false if the teacher does not exist.
+func (store *Store) DeleteTeacher(teacher *Teacher) (bool, error) {
+	query := `DELETE FROM teachers WHERE school_id=$1 AND email=$2`
+
+	return store.execAffectsRows(query, store.school, teacher.Email)
+}
+
+// DeleteStudent also deletes the student's registrations and suspensions. Returns false if the student does not exist.
+func (store *Store) DeleteStudent(student *Student) (bool, error) {
+	query := `DELETE FROM students WHERE school_id=$1 AND email=$2`
+
+	return store.execAffectsRows(query, store.school, student.Email)
+}
+
+func (store *Store) GetSuspensions(email string) ([]*Suspension, error) {
+	query := `SELECT student_email, suspended_at, suspended_until FROM suspensions WHERE school_id=$1 AND student_email=$2 ORDER BY suspended_at DESC`
+
+	suspensions := []*Suspension{}
+	err := store.conn().Select(&suspensions, query, store.school, email)
+	if err != nil {
+		return nil, err
+	}
+
+	return suspensions, nil
+}
+
+// LiftSuspensions ends the student's suspensions in force at the given time. Returns false if there were none.
+func (store *Store) LiftSuspensions(email string, at time.Time) (bool, error) {
+	query := `UPDATE suspensions SET suspended_until=$3
+	WHERE school_id=$1 AND student_email=$2 AND suspended_at <= $3 AND (suspended_until >= $3 OR suspended_until IS NULL)`
+
+	return store.execAffectsRows(query, store.school, email, at)
+}
+
+func (store *Store) execAffectsRows(query string, params ...interface{}) (bool, error) {
+	result, err := store.conn().Exec(query, params...)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected != 0, nil
+}
+
+func (store *Store) AddTeachers(teachers []*Teacher) error {
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString("INSERT INTO teachers (school_id, email) VALUES ")
+	params := []interface{}{store.school}
+	for _, teacher := range teachers {
+		params = append(params, teacher.Email)
+
+		queryBuilder.WriteString(fmt.Sprintf("($1, $%d),", len(params)))
+	}
+
+	// drop last comma
+	query := queryBuilder.String()
+	query = query[:len(query)-1] + " ON CONFLICT DO NOTHING"
+
+	_, err := store.conn().Exec(query, params...)
+	return err
+}
+
+func (store *Store) AddSuspensions(suspensions []*Suspension) error {
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString("INSERT INTO suspensions (school_id, student_email, suspended_at, suspended_until) VALUES ")
+	params := []interface{}{store.school}
+	for _, suspension := range suspensions {
+		params = append(params, suspension.Email, suspension.SuspendedAt, suspension.SuspendedUntil)
+
+		queryBuilder.WriteString(fmt.Sprintf("($1, $%d, $%d, $%d),", len(params)-2, len(params)-1, len(params)))
+	}
+
+	// drop last comma
+	query := queryBuilder.String()
+	query = query[:len(query)-1] + " ON CONFLICT DO NOTHING"
+
+	_, err := store.conn().Exec(query, params...)
+	return err
+}
+
+func (store *Store) AddImportJob(job *ImportJob) error {
+	query := `INSERT INTO import_jobs (id, status, dry_run, created_by, errors, created_at, school_id) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := store.conn().Exec(query, job.ID, job.Status, job.DryRun, job.CreatedBy, job.Errors, job.CreatedAt, store.school)
+	return err
+}
+
+func (store *Store) UpdateImportJob(job *ImportJob) error {
+	query := `UPDATE import_jobs SET status=$2, rows_processed=$3, rows_imported=$4, rows_failed=$5, errors=$6, message=$7, finished_at=$8
+	WHERE id=$1 AND school_id=$9`
+
+	_, err := store.conn().Exec(query, job.ID, job.Status, job.RowsProcessed, job.RowsImported, job.RowsFailed, job.Errors, job.Message, job.FinishedAt, store.school)
+	return err
+}
+
+// GetImportJob returns nil if there is no job with the given id
+func (store *Store) GetImportJob(id string) (*ImportJob, error) {
+	query := `SELECT id, status, dry_run, created_by, rows_processed, rows_imported, rows_failed, errors, message, created_at, finished_at
+	FROM import_jobs WHERE school_id=$1 AND id=$2`
+
+	jobs := []*ImportJob{}
+	err := store.conn().Select(&jobs, query, store.school, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+
+	return jobs[0], nil
+}
+
+// FailInterruptedImportJobs marks jobs of all schools left unfinished by a previous run of the server as failed
+func (store *Store) FailInterruptedImportJobs() error {
+	query := `UPDATE import_jobs SET status=$1, message='interrupted by a server restart', finished_at=$2
+	WHERE status IN ($3, $4)`
+
+	_, err := store.conn().Exec(query, ImportJobFailed, time.Now().UTC(), ImportJobPending, ImportJobRunning)
+	return err
+}
+
+// streamRows scans the rows of a query one at a time into fn, so that large results are never held in memory
+func streamRows[T any](store *Store, fn func(row *T) error, query string, params ...interface{}) error {
+	rows, err := store.conn().Queryx(query, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := new(T)
+		if err := rows.StructScan(row); err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// StreamRegistrations calls fn for every registration of the given teachers, or of all teachers if none are given
+func (store *Store) StreamRegistrations(teacherEmails []string, fn func(pair *TeacherStudentPair) error) error {
+	query := `SELECT teacher_email, student_email FROM registered WHERE school_id=$1 AND term=$2`
+	params := []interface{}{store.school, store.term}
+	if len(teacherEmails) > 0 {
+		query += ` AND teacher_email = ANY($3)`
+		params = append(params, pq.Array(teacherEmails))
+	}
+	query += ` ORDER BY teacher_email, student_email`
+
+	return streamRows(store, fn, query, params...)
+}
+
+// StreamStudents calls fn for every student registered to the given teachers, or every student if none are given,
+// with their suspension status as of now
+func (store *Store) StreamStudents(teacherEmails []string, fn func(student *StudentExportRow) error) error {
+	query := `SELECT s.email, current.suspended, CASE WHEN current.indefinite THEN NULL ELSE current.until END AS suspended_until
+	FROM students s
+	CROSS JOIN LATERAL (
+		SELECT COUNT(*) > 0 AS suspended, BOOL_OR(suspended_until IS NULL) AS indefinite, MAX(suspended_until) AS until
+		FROM suspensions
+		WHERE school_id=s.school_id AND student_email=s.email AND suspended_at <= $1 AND (suspended_until >= $1 OR suspended_until IS NULL)
+	) current
+	WHERE s.school_id=$2`
+	params := []interface{}{time.Now().UTC(), store.school}
+	if len(teacherEmails) > 0 {
+		query += ` AND s.email IN (SELECT student_email FROM registered WHERE school_id=$2 AND term=$3 AND teacher_email = ANY($4))`
+		params = append(params, store.term, pq.Array(teacherEmails))
+	}
+	query += ` ORDER BY s.email`
+
+	return streamRows(store, fn, query, params...)
+}
+
+// StreamNotifications calls fn for every notification sent by the given teachers, or by all teachers if none are given,
+// oldest first. Notifications are read back from the audit log.
+func (store *Store) StreamNotifications(teacherEmails []string, fn func(notification *NotificationExportRow) error) error {
+	query := `SELECT occurred_at AS sent_at, teacher_email, COALESCE(after->>'notification', '') AS notification, student_emails AS recipients
+	FROM audit_log WHERE school_id=$1 AND action=$2`
+	params := []interface{}{store.school, AuditActionNotify}
+	if len(teacherEmails) > 0 {
+		query += ` AND teacher_email = ANY($3)`
+		params = append(params, pq.Array(teacherEmails))
+	}
+	query += ` ORDER BY id`
+
+	return streamRows(store, fn, query, params...)
+}
+
+const classColumns = `classes.code, classes.name, classes.term, classes.default_for_teacher IS NOT NULL AS is_default,
+	ARRAY(SELECT teacher_email FROM class_teachers WHERE school_id=classes.school_id AND class_code=classes.code ORDER BY teacher_email) AS teachers`
+
+// AddClass creates the class and its teachers, failing with a unique violation if the code is taken
+func (store *Store) AddClass(class *Class) error {
+	query := `INSERT INTO classes (school_id, code, name, term) VALUES ($1, $2, $3, $4)`
+
+	return store.WithTx(func(txStore *Store) error {
+		if _, err := txStore.conn().Exec(query, store.school, class.Code, class.Name, class.Term); err != nil {
+			return err
+		}
+		return txStore.SetClassTeachers(class.Code, class.Teachers)
+	})
+}
+
+// SetClassTeachers replaces the teachers of the class
+func (store *Store) SetClassTeachers(classCode string, teacherEmails []string) error {
+	deleteQuery := `DELETE FROM class_teachers WHERE school_id=$1 AND class_code=$2`
+	insertQuery := `INSERT INTO class_teachers (school_id, class_code, teacher_email) SELECT $1, $2, unnest($3::text[]) ON CONFLICT DO NOTHING`
+
+	return store.WithTx(func(txStore *Store) error {
+		if _, err := txStore.conn().Exec(deleteQuery, store.school, classCode); err != nil {
+			return err
+		}
+		_, err := txStore.conn().Exec(insertQuery, store.school, classCode, pq.Array(teacherEmails))
+		return err
+	})
+}
+
+// UpdateClass saves the name and term of the class. Returns false if the class does not exist.
+func (store *Store) UpdateClass(class *Class) (bool, error) {
+	query := `UPDATE classes SET name=$3, term=$4 WHERE school_id=$1 AND code=$2`
+
+	return store.execAffectsRows(query, store.school, class.Code, class.Name, class.Term)
+}
+
+// GetClass returns nil if the class does not exist
+func (store *Store) GetClass(code string) (*Class, error) {
+	query := `SELECT ` + classColumns + ` FROM classes WHERE school_id=$1 AND code=$2`
+
+	classes := []*Class{}
+	err := store.conn().Select(&classes, query, store.school, code)
+	if err != nil {
+		return nil, err
+	}
+	if len(classes) == 0 {
+		return nil, nil
+	}
+
+	return classes[0], nil
+}
+
+// ListClasses lists the classes of the teacher, or all classes if no teacher is given
+func (store *Store) ListClasses(teacherEmail string) ([]*Class, error) {
+	query := `SELECT ` + classColumns + ` FROM classes WHERE school_id=$1`
+	params := []interface{}{store.school}
+	if teacherEmail != "" {
+		query += ` AND code IN (SELECT class_code FROM class_teachers WHERE school_id=$1 AND teacher_email=$2)`
+		params = append(params, teacherEmail)
+	}
+	query += ` ORDER BY classes.code`
+
+	classes := []*Class{}
+	err := store.conn().Select(&classes, query, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return classes, nil
+}
+
+// DeleteClass also deletes the class's enrollments. Returns false if the class does not exist.
+func (store *Store) DeleteClass(code string) (bool, error) {
+	query := `DELETE FROM classes WHERE school_id=$1 AND code=$2`
+
+	return store.execAffectsRows(query, store.school, code)
+}
+
+func (store *Store) GetStudentsOfClass(code string) ([]string, error) {
+	query := `SELECT student_email FROM enrollments WHERE school_id=$1 AND term=$2 AND class_code=$3 ORDER BY student_email`
+
+	students := []string{}
+	err := store.conn().Select(&students, query, store.school, store.term, code)
+	if err != nil {
+		return nil, err
+	}
+
+	return students, nil
+}
+
+// AddSchool fails with a unique violation if the id is taken
+func (store *Store) AddSchool(school *School) error {
+	query := `INSERT INTO schools (id, name, allowed_domains) VALUES ($1, $2, $3)`
+
+	_, err := store.conn().Exec(query, school.ID, school.Name, school.AllowedDomains)
+	return err
+}
+
+// GetSchool returns nil if the school does not exist
+func (store *Store) GetSchool(id string) (*School, error) {
+	query := `SELECT id, name, allowed_domains FROM schools WHERE id=$1`
+
+	schools := []*School{}
+	err := store.conn().Select(&schools, query, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(schools) == 0 {
+		return nil, nil
+	}
+
+	return schools[0], nil
+}
+
+func (store *Store) ListSchools() ([]*School, error) {
+	query := `SELECT id, name, allowed_domains FROM schools ORDER BY id`
+
+	schools := []*School{}
+	err := store.conn().Select(&schools, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return schools, nil
+}
+
+// UpdateSchool saves the name and allowed domains of the school. Returns false if the school does not exist.
+func (store *Store) UpdateSchool(school *School) (bool, error) {
+	query := `UPDATE schools SET name=$2, allowed_domains=$3 WHERE id=$1`
+
+	return store.execAffectsRows(query, school.ID, school.Name, school.AllowedDomains)
+}
+
+const termColumns = `code, name, to_char(starts_on, 'YYYY-MM-DD') AS starts_on, to_char(ends_on, 'YYYY-MM-DD') AS ends_on`
+
+// AddTerm fails with a unique violation if the code is taken
+func (store *Store) AddTerm(term *Term) error {
+	query := `INSERT INTO terms (school_id, code, name, starts_on, ends_on) VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := store.conn().Exec(query, store.school, term.Code, term.Name, term.StartsOn, term.EndsOn)
+	return err
+}
+
+// GetTerm returns nil if the term does not exist
+func (store *Store) GetTerm(code string) (*Term, error) {
+	query := `SELECT ` + termColumns + ` FROM terms WHERE school_id=$1 AND code=$2`
+
+	terms := []*Term{}
+	err := store.conn().Select(&terms, query, store.school, code)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	return terms[0], nil
+}
+
+// GetCurrentTerm returns the term the given day falls in, or nil if it falls before, between or after the terms
+func (store *Store) GetCurrentTerm(today time.Time) (*Term, error) {
+	query := `SELECT ` + termColumns + ` FROM terms WHERE school_id=$1 AND starts_on <= $2 AND ends_on >= $2 ORDER BY starts_on DESC LIMIT 1`
+
+	terms := []*Term{}
+	err := store.conn().Select(&terms, query, store.school, today.Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	return terms[0], nil
+}
+
+// GetPreviousTerm returns the latest term to have started before the given day, or nil if there is none
+func (store *Store) GetPreviousTerm(day string) (*Term, error) {
+	query := `SELECT ` + termColumns + ` FROM terms WHERE school_id=$1 AND starts_on < $2 ORDER BY starts_on DESC LIMIT 1`
+
+	terms := []*Term{}
+	err := store.conn().Select(&terms, query, store.school, day)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	return terms[0], nil
+}
+
+// HasTerms tells whether the school has any term, which schools that do not use terms lack
+func (store *Store) HasTerms() (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM terms WHERE school_id=$1)`
+
+	var exists bool
+	err := store.conn().Get(&exists, query, store.school)
+	return exists, err
+}
+
+func (store *Store) ListTerms() ([]*Term, error) {
+	query := `SELECT ` + termColumns + ` FROM terms WHERE school_id=$1 ORDER BY starts_on`
+
+	terms := []*Term{}
+	err := store.conn().Select(&terms, query, store.school)
+	if err != nil {
+		return nil, err
+	}
+
+	return terms, nil
+}
+
+// GetOverlappingTerms returns the codes of the terms that share a day with the given dates
+func (store *Store) GetOverlappingTerms(startsOn string, endsOn string) ([]string, error) {
+	query := `SELECT code FROM terms WHERE school_id=$1 AND starts_on <= $3 AND ends_on >= $2 ORDER BY starts_on`
+
+	codes := []string{}
+	err := store.conn().Select(&codes, query, store.school, startsOn, endsOn)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// GetRoster returns every enrollment of the term, by class
+func (store *Store) GetRoster() ([]*RosterEntry, error) {
+	query := `SELECT e.class_code, e.student_email, s.active FROM enrollments e
+	JOIN students s ON s.school_id=e.school_id AND s.email=e.student_email
+	WHERE e.school_id=$1 AND e.term=$2 ORDER BY e.class_code, e.student_email`
+
+	roster := []*RosterEntry{}
+	err := store.conn().Select(&roster, query, store.school, store.term)
+	if err != nil {
+		return nil, err
+	}
+
+	return roster, nil
+}
+
+// LinkGuardian adds the guardian, or renames them, and links them to the student, replacing any existing link
+func (store *Store) LinkGuardian(studentEmail string, guardian *Guardian) error {
+	guardianQuery := `INSERT INTO guardians (school_id, email, name) VALUES ($1, $2, $3)
+	ON CONFLICT (school_id, email) DO UPDATE SET name=EXCLUDED.name`
+
+	if _, err := store.conn().Exec(guardianQuery, store.school, guardian.Email, guardian.Name); err != nil {
+		return err
+	}
+
+	linkQuery := `INSERT INTO student_guardians (school_id, student_email, guardian_email, relationship, opted_in) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (school_id, student_email, guardian_email) DO UPDATE SET relationship=EXCLUDED.relationship, opted_in=EXCLUDED.opted_in`
+
+	_, err := store.conn().Exec(linkQuery, store.school, studentEmail, guardian.Email, guardian.Relationship, guardian.OptedIn)
+	return err
+}
+
+// UnlinkGuardian returns false if the guardian was not linked to the student. The guardian is kept for their other students.
+func (store *Store) UnlinkGuardian(studentEmail string, guardianEmail string) (bool, error) {
+	query := `DELETE FROM student_guardians WHERE school_id=$1 AND student_email=$2 AND guardian_email=$3`
+
+	return store.execAffectsRows(query, store.school, studentEmail, guardianEmail)
+}
+
+func (store *Store) GetGuardiansOfStudent(studentEmail string) ([]*Guardian, error) {
+	query := `SELECT g.email, g.name, sg.relationship, sg.opted_in FROM student_guardians sg
+	JOIN guardians g ON g.school_id=sg.school_id AND g.email=sg.guardian_email
+	WHERE sg.school_id=$1 AND sg.student_email=$2 ORDER BY g.email`
+
+	guardians := []*Guardian{}
+	err := store.conn().Select(&guardians, query, store.school, studentEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	return guardians, nil
+}
+
+// GetOptedInGuardians returns the guardians opted in to the notifications of any of the students
+func (store *Store) GetOptedInGuardians(studentEmails []string) ([]string, error) {
+	query := `SELECT DISTINCT guardian_email FROM student_guardians
+	WHERE school_id=$1 AND opted_in AND student_email = ANY($2) ORDER BY guardian_email`
+
+	guardianEmails := []string{}
+	err := store.conn().Select(&guardianEmails, query, store.school, pq.Array(studentEmails))
+	if err != nil {
+		return nil, err
+	}
+
+	return guardianEmails, nil
+}
+
+// GetNotificationPreferences returns the saved preferences of the students, by email. Students without any are left out.
+func (store *Store) GetNotificationPreferences(studentEmails []string) (map[string]*NotificationPreferences, error) {
+	query := `SELECT student_email, channels, muted_categories, quiet_hours_start, quiet_hours_end, time_zone
+	FROM notification_preferences WHERE school_id=$1 AND student_email = ANY($2)`
+
+	rows := []struct {
+		StudentEmail string `db:"student_email"`
+		NotificationPreferences
+	}{}
+	err := store.conn().Select(&rows, query, store.school, pq.Array(studentEmails))
+	if err != nil {
+		return nil, err
+	}
+
+	preferences := map[string]*NotificationPreferences{}
+	for i := range rows {
+		preferences[rows[i].StudentEmail] = &rows[i].NotificationPreferences
+	}
+	return preferences, nil
+}
+
+func (store *Store) SetNotificationPreferences(studentEmail string, preferences *NotificationPreferences) error {
+	query := `INSERT INTO notification_preferences (school_id, student_email, channels, muted_categories, quiet_hours_start, quiet_hours_end, time_zone)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (school_id, student_email) DO UPDATE SET channels=EXCLUDED.channels, muted_categories=EXCLUDED.muted_categories,
+	quiet_hours_start=EXCLUDED.quiet_hours_start, quiet_hours_end=EXCLUDED.quiet_hours_end, time_zone=EXCLUDED.time_zone`
+
+	_, err := store.conn().Exec(query, store.school, studentEmail, preferences.Channels, preferences.MutedCategories,
+		preferences.QuietHoursStart, preferences.QuietHoursEnd, preferences.TimeZone)
+	return err
+}
+
+// GetActiveSuspensions returns the suspension of each of the students who is suspended at the given time, by email.
+// Overlapping suspensions are merged, indefinite ones have no end.
+func (store *Store) GetActiveSuspensions(studentEmails []string, now time.Time) (map[string]*Suspension, error) {
+	query := `SELECT student_email, MIN(suspended_at) AS suspended_at,
+	CASE WHEN BOOL_OR(suspended_until IS NULL) THEN NULL ELSE MAX(suspended_until) END AS suspended_until
+	FROM suspensions WHERE school_id=$1 AND student_email = ANY($2) AND suspended_at <= $3 AND (suspended_until >= $3 OR suspended_until IS NULL)
+	GROUP BY student_email`
+
+	suspensions := []*Suspension{}
+	err := store.conn().Select(&suspensions, query, store.school, pq.Array(studentEmails), now)
+	if err != nil {
+		return nil, err
+	}
+
+	suspensionsByEmail := map[string]*Suspension{}
+	for _, suspension := range suspensions {
+		suspensionsByEmail[suspension.Email] = suspension
+	}
+	return suspensionsByEmail, nil
+}
+
+const scheduledNotificationColumns = `id, teacher_email, term, request, send_at, status, created_by, created_by_role, request_id,
+	created_at, sent_at, result, message, recurring_id, school_id, attempts`
+
+func (store *Store) AddScheduledNotification(notification *ScheduledNotification) error {
+	query := `INSERT INTO scheduled_notifications (id, school_id, teacher_email, term, request, send_at, status, created_by, created_by_role, request_id, created_at, recurring_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+
+	_, err := store.conn().Exec(query, notification.ID, store.school, notification.Teacher, notification.Term, notification.Request,
+		notification.SendAt, notification.Status, notification.CreatedBy, notification.CreatedByRole, notification.RequestID, notification.CreatedAt,
+		notification.RecurringID)
+	return err
+}
+
+// GetScheduledNotification returns nil if there is no scheduled notification with the given id
+func (store *Store) GetScheduledNotification(id string) (*ScheduledNotification, error) {
+	query := `SELECT ` + scheduledNotificationColumns + ` FROM scheduled_notifications WHERE school_id=$1 AND id=$2`
+
+	notifications := []*ScheduledNotification{}
+	err := store.conn().Select(&notifications, query, store.school, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(notifications) == 0 {
+		return nil, nil
+	}
+
+	return notifications[0], nil
+}
+
+// ListScheduledNotifications lists scheduled notifications by send time, only those of the teacher and with the
+// status if given
+func (store *Store) ListScheduledNotifications(teacherEmail string, status string) ([]*ScheduledNotification, error) {
+	query := `SELECT ` + scheduledNotificationColumns + ` FROM scheduled_notifications
+	WHERE school_id=$1 AND ($2::VARCHAR = '' OR teacher_email=$2) AND ($3 = '' OR status=$3) ORDER BY send_at, id`
+
+	notifications := []*ScheduledNotification{}
+	err := store.conn().Select(&notifications, query, store.school, teacherEmail, status)
+	if err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// UpdateScheduledNotification changes a notification that is still pending, returning false if there is none
+func (store *Store) UpdateScheduledNotification(notification *ScheduledNotification) (bool, error) {
+	query := `UPDATE scheduled_notifications SET teacher_email=$3, term=$4, request=$5, send_at=$6
+	WHERE school_id=$1 AND id=$2 AND status=$7`
+
+	return store.execAffectsRows(query, store.school, notification.ID, notification.Teacher, notification.Term, notification.Request,
+		notification.SendAt, ScheduledNotificationPending)
+}
+
+// CancelScheduledNotification cancels a notification that is still pending, returning false if there is none
+func (store *Store) CancelScheduledNotification(id string) (bool, error) {
+	query := `UPDATE scheduled_notifications SET status=$3 WHERE school_id=$1 AND id=$2 AND status=$4`
+
+	return store.execAffectsRows(query, store.school, id, ScheduledNotificationCancelled, ScheduledNotificationPending)
+}
+
+// ClaimDueScheduledNotification locks the earliest pending notification of any school due at the given time until the
+// end of the transaction, or returns nil if there is none. Notifications locked by other servers are skipped.
+func (store *Store) ClaimDueScheduledNotification(now time.Time) (*ScheduledNotification, error) {
+	query := `SELECT ` + scheduledNotificationColumns + ` FROM scheduled_notifications
+	WHERE status=$1 AND send_at <= $2 ORDER BY send_at LIMIT 1 FOR UPDATE SKIP LOCKED`
+
+	notifications := []*ScheduledNotification{}
+	err := store.conn().Select(&notifications, query, ScheduledNotificationPending, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(notifications) == 0 {
+		return nil, nil
+	}
+
+	return notifications[0], nil
+}
+
+// MarkScheduledNotificationSent records the response of a notification of any school
+func (store *Store) MarkScheduledNotificationSent(id string, result types.JSONText, sentAt time.Time) error {
+	query := `UPDATE scheduled_notifications SET status=$2, result=$3, sent_at=$4 WHERE id=$1`
+
+	_, err := store.conn().Exec(query, id, ScheduledNotificationSent, result, sentAt)
+	return err
+}
+
+// MarkScheduledNotificationFailed records why a pending notification of any school could not be sent
+func (store *Store) MarkScheduledNotificationFailed(id string, message string) error {
+	query := `UPDATE scheduled_notifications SET status=$2, message=$3, attempts=attempts+1 WHERE id=$1 AND status=$4`
+
+	_, err := store.conn().Exec(query, id, ScheduledNotificationFailed, message, ScheduledNotificationPending)
+	return err
+}
+
+// RetryScheduledNotification records a failed attempt to send a pending notification of any school and when to
+// try again
+func (store *Store) RetryScheduledNotification(id string, message string, sendAt time.Time) error {
+	query := `UPDATE scheduled_notifications SET message=$2, send_at=$3, attempts=attempts+1 WHERE id=$1 AND status=$4`
+
+	_, err := store.conn().Exec(query, id, message, sendAt, ScheduledNotificationPending)
+	return err
+}
+
+const recurringNotificationColumns = `id, teacher_email, request, schedule, time_zone, starts_at, COALESCE(to_char(ends_on, 'YYYY-MM-DD'), '') AS ends_on,
+	max_occurrences, occurrences, next_at, status, created_by, created_by_role, request_id, created_at, school_id`
+
+func (store *Store) AddRecurringNotification(notification *RecurringNotification) error {
+	query := `INSERT INTO recurring_notifications (id, school_id, teacher_email, request, schedule, time_zone, starts_at, ends_on, max_occurrences,
+	status, created_by, created_by_role, request_id, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, '')::date, $9, $10, $11, $12, $13, $14)`
+
+	_, err := store.conn().Exec(query, notification.ID, store.school, notification.Teacher, notification.Request, notification.Schedule,
+		notification.TimeZone, notification.StartsAt, notification.EndsOn, notification.Count, notification.Status, notification.CreatedBy,
+		notification.CreatedByRole, notification.RequestID, notification.CreatedAt)
+	return err
+}
+
+// GetRecurringNotification returns nil if there is no recurring notification with the given id
+func (store *Store) GetRecurringNotification(id string) (*RecurringNotification, error) {
+	query := `SELECT ` + recurringNotificationColumns + ` FROM recurring_notifications WHERE school_id=$1 AND id=$2`
+
+	notifications := []*RecurringNotification{}
+	err := store.conn().Select(&notifications, query, store.school, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(notifications) == 0 {
+		return nil, nil
+	}
+
+	return notifications[0], nil
+}
+
+// ListRecurringNotifications lists recurring notifications by creation, only those of the teacher and with the status
+// if given
+func (store *Store) ListRecurringNotifications(teacherEmail string, status string) ([]*RecurringNotification, error) {
+	query := `SELECT ` + recurringNotificationColumns + ` FROM recurring_notifications
+	WHERE school_id=$1 AND ($2::VARCHAR = '' OR teacher_email=$2) AND ($3 = '' OR status=$3) ORDER BY created_at, id`
+
+	notifications := []*RecurringNotification{}
+	err := store.conn().Select(&notifications, query, store.school, teacherEmail, status)
+	if err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// UpdateRecurringNotificationProgress saves the next occurrence, the number of occurrences and the status
+func (store *Store) UpdateRecurringNotificationProgress(notification *RecurringNotification) error {
+	query := `UPDATE recurring_notifications SET next_at=$3, occurrences=$4, status=$5 WHERE school_id=$1 AND id=$2`
+
+	_, err := store.conn().Exec(query, store.school, notification.ID, notification.NextAt, notification.Occurrences, notification.Status)
+	return err
+}
+
+// CancelRecurringNotification cancels an active recurring notification and its pending occurrence, returning false
+// if there is no such recurring notification
+func (store *Store) CancelRecurringNotification(id string) (bool, error) {
+	query := `UPDATE recurring_notifications SET status=$3, next_at=NULL WHERE school_id=$1 AND id=$2 AND status=$4`
+
+	cancelled, err := store.execAffectsRows(query, store.school, id, RecurringNotificationCancelled, RecurringNotificationActive)
+	if err != nil || !cancelled {
+		return cancelled, err
+	}
+
+	occurrenceQuery := `UPDATE scheduled_notifications SET status=$3 WHERE school_id=$1 AND recurring_id=$2 AND status=$4`
+
+	_, err = store.conn().Exec(occurrenceQuery, store.school, id, ScheduledNotificationCancelled, ScheduledNotificationPending)
+	return true, err
+}
+
+// ClaimDueRecurringNotification locks an active recurring notification of any school whose next occurrence is due at
+// the given time until the end of the transaction, or returns nil if there is none
+func (store *Store) ClaimDueRecurringNotification(now time.Time) (*RecurringNotification, error) {
+	query := `SELECT ` + recurringNotificationColumns + ` FROM recurring_notifications
+	WHERE status=$1 AND next_at <= $2 ORDER BY next_at LIMIT 1 FOR UPDATE SKIP LOCKED`
+
+	notifications := []*RecurringNotification{}
+	err := store.conn().Select(&notifications, query, RecurringNotificationActive, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(notifications) == 0 {
+		return nil, nil
+	}
+
+	return notifications[0], nil
+}
+
+func (store *Store) SetHoliday(holiday *Holiday) error {
+	query := `INSERT INTO holidays (school_id, date, name) VALUES ($1, $2, $3)
+	ON CONFLICT (school_id, date) DO UPDATE SET name=EXCLUDED.name`
+
+	_, err := store.conn().Exec(query, store.school, holiday.Date, holiday.Name)
+	return err
+}
+
+func (store *Store) DeleteHoliday(date string) (bool, error) {
+	query := `DELETE FROM holidays WHERE school_id=$1 AND date=$2`
+
+	return store.execAffectsRows(query, store.school, date)
+}
+
+// ListHolidays lists the school's holidays by date
+func (store *Store) ListHolidays() ([]*Holiday, error) {
+	query := `SELECT to_char(date, 'YYYY-MM-DD') AS date, name FROM holidays WHERE school_id=$1 ORDER BY date`
+
+	holidays := []*Holiday{}
+	err := store.conn().Select(&holidays, query, store.school)
+	if err != nil {
+		return nil, err
+	}
+
+	return holidays, nil
+}
+
+// CancelOccurrencesOnHoliday cancels the pending occurrences of recurring notifications that fall on the date in their
+// time zone, which then no longer count towards their count, and returns how many were cancelled
+func (store *Store) CancelOccurrencesOnHoliday(date string, message string) (int64, error) {
+	query := `WITH cancelled AS (
+		UPDATE scheduled_notifications s SET status=$3, message=$4 FROM recurring_notifications r
+		WHERE s.school_id=$1 AND s.status=$5 AND r.school_id=$1 AND r.id=s.recurring_id AND (s.send_at AT TIME ZONE r.time_zone)::date = $2
+		RETURNING s.recurring_id
+	)
+	UPDATE recurring_notifications r SET occurrences=r.occurrences - 1 FROM cancelled WHERE r.school_id=$1 AND r.id=cancelled.recurring_id`
+
+	result, err := store.conn().Exec(query, store.school, date, ScheduledNotificationCancelled, message, ScheduledNotificationPending)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const templateColumns = `id, name, body, created_by, created_at, updated_at`
+
+func (store *Store) AddTemplate(notificationTemplate *NotificationTemplate) error {
+	query := `INSERT INTO notification_templates (id, school_id, name, body, created_by, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := store.conn().Exec(query, notificationTemplate.ID, store.school, notificationTemplate.Name, notificationTemplate.Body,
+		notificationTemplate.CreatedBy, notificationTemplate.CreatedAt, notificationTemplate.UpdatedAt)
+	return err
+}
+
+// GetTemplate returns nil if there is no template with the given id
+func (store *Store) GetTemplate(id string) (*NotificationTemplate, error) {
+	query := `SELECT ` + templateColumns + ` FROM notification_templates WHERE school_id=$1 AND id=$2`
+
+	templates := []*NotificationTemplate{}
+	err := store.conn().Select(&templates, query, store.school, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(templates) == 0 {
+		return nil, nil
+	}
+
+	return templates[0], nil
+}
+
+// ListTemplates lists the school's templates by name
+func (store *Store) ListTemplates() ([]*NotificationTemplate, error) {
+	query := `SELECT ` + templateColumns + ` FROM notification_templates WHERE school_id=$1 ORDER BY name`
+
+	templates := []*NotificationTemplate{}
+	err := store.conn().Select(&templates, query, store.school)
+	if err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+func (store *Store) UpdateTemplate(notificationTemplate *NotificationTemplate) (bool, error) {
+	query := `UPDATE notification_templates SET name=$3, body=$4, updated_at=$5 WHERE school_id=$1 AND id=$2`
+
+	return store.execAffectsRows(query, store.school, notificationTemplate.ID, notificationTemplate.Name, notificationTemplate.Body,
+		notificationTemplate.UpdatedAt)
+}
+
+func (store *Store) DeleteTemplate(id string) (bool, error) {
+	query := `DELETE FROM notification_templates WHERE school_id=$1 AND id=$2`
+
+	return store.execAffectsRows(query, store.school, id)
+}
+
+const studentNotificationColumns = `id, notification_id, student_email, teacher_email, notification, category, urgent, created_at, read_at, school_id`
+
+// Student notifications inserted per statement, seven parameters each
+const studentNotificationChunkSize = 5000
+
+// AddStudentNotifications saves the notifications, setting their ids, and publishes them to the streams of their
+// students once all are saved
+func (store *Store) AddStudentNotifications(notifications []*StudentNotification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	// Postgres takes at most 65535 parameters per statement, so notifications to a whole school are inserted in chunks
+	for start := 0; start < len(notifications); start += studentNotificationChunkSize {
+		end := min(start+studentNotificationChunkSize, len(notifications))
+		if err := store.insertStudentNotifications(notifications[start:end]); err != nil {
+			return err
+		}
+	}
+
+	if store.broker != nil {
+		// Streams catch up on what they missed when they reconnect, so a failure must not fail the notification
+		if err := store.broker.Publish(notifications); err != nil {
+			log.Printf("Failed to publish notifications: %v", err)
+		}
+	}
+	return nil
+}
+
+func (store *Store) insertStudentNotifications(notifications []*StudentNotification) error {
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString("INSERT INTO student_notifications (school_id, notification_id, student_email, teacher_email, notification, category, urgent, created_at) VALUES ")
+	params := []interface{}{store.school}
+	for _, notification := range notifications {
+		params = append(params, notification.NotificationID, notification.StudentEmail, notification.Teacher, notification.Notification,
+			notification.Category, notification.Urgent, notification.CreatedAt)
+		n := len(params)
+		queryBuilder.WriteString(fmt.Sprintf("($1, $%d, $%d, $%d, $%d, $%d, $%d, $%d),", n-6, n-5, n-4, n-3, n-2, n-1, n))
+	}
+
+	// drop last comma
+	query := queryBuilder.String()
+	query = query[:len(query)-1] + " RETURNING id, student_email"
+
+	rows, err := store.conn().Queryx(query, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	ids := map[string]int64{}
+	for rows.Next() {
+		var id int64
+		var studentEmail string
+		if err := rows.Scan(&id, &studentEmail); err != nil {
+			return err
+		}
+		ids[studentEmail] = id
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, notification := range notifications {
+		notification.ID = ids[notification.StudentEmail]
+		notification.SchoolID = store.school
+	}
+	return nil
+}
+
+// ListStudentNotificationsAfter lists the notifications of the student after the given id, oldest first
+func (store *Store) ListStudentNotificationsAfter(studentEmail string, afterID int64, limit int) ([]*StudentNotification, error) {
+	query := `SELECT ` + studentNotificationColumns + ` FROM student_notifications
+	WHERE school_id=$1 AND student_email=$2 AND id > $3 ORDER BY id LIMIT $4`
+
+	notifications := []*StudentNotification{}
+	err := store.conn().Select(&notifications, query, store.school, studentEmail, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// GetStreamResumeID returns the id to list the notifications of the student after when their stream resumes after
+// lastID. It is below the notifications created within the window before lastID, which may have committed after it.
+func (store *Store) GetStreamResumeID(studentEmail string, lastID int64, window time.Duration) (int64, error) {
+	query := `SELECT COALESCE(MIN(n.id) - 1, $3::BIGINT) FROM student_notifications n
+	JOIN student_notifications last ON last.school_id=n.school_id AND last.student_email=n.student_email AND last.id=$3
+	WHERE n.school_id=$1 AND n.student_email=$2 AND n.id < $3 AND n.created_at >= last.created_at - make_interval(secs => $4)`
+
+	var resumeID int64
+	err := store.conn().Get(&resumeID, query, store.school, studentEmail, lastID, window.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return resumeID, nil
+}
+
+// GetStudentNotificationsByID returns the notifications of any school with the given ids
+func (store *Store) GetStudentNotificationsByID(ids []int64) ([]*StudentNotification, error) {
+	query := `SELECT ` + studentNotificationColumns + ` FROM student_notifications WHERE id = ANY($1) ORDER BY id`
+
+	notifications := []*StudentNotification{}
+	err := store.conn().Select(&notifications, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// ListInbox lists the notifications of the student, newest first
+func (store *Store) ListInbox(studentEmail string, filter InboxFilter) ([]*StudentNotification, error) {
+	var queryBuilder strings.Builder
+	queryBuilder.WriteString(`SELECT ` + studentNotificationColumns + ` FROM student_notifications WHERE school_id=$1 AND student_email=$2`)
+	params := []interface{}{store.school, studentEmail}
+
+	if filter.UnreadOnly {
+		queryBuilder.WriteString(" AND read_at IS NULL")
+	}
+	if filter.BeforeID > 0 {
+		params = append(params, filter.BeforeID)
+		queryBuilder.WriteString(fmt.Sprintf(" AND id < $%d", len(params)))
+	}
+
+	params = append(params, filter.Limit)
+	queryBuilder.WriteString(fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(params)))
+
+	notifications := []*StudentNotification{}
+	err := store.conn().Select(&notifications, queryBuilder.String(), params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// CountUnreadByCategory counts the unread notifications of the student by category
+func (store *Store) CountUnreadByCategory(studentEmail string) (map[string]int, error) {
+	query := `SELECT category, COUNT(*) FROM student_notifications
+	WHERE school_id=$1 AND student_email=$2 AND read_at IS NULL GROUP BY category`
+
+	rows, err := store.conn().Queryx(query, store.school, studentEmail)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var category string
+		var count int
+		if err := rows.Scan(&category, &count); err != nil {
+			return nil, err
+		}
+		counts[category] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// MarkStudentNotificationRead marks a notification of the student as read, keeping when it was first read. Returns
+// nil if the student has no such notification.
+func (store *Store) MarkStudentNotificationRead(studentEmail string, id int64, readAt time.Time) (*StudentNotification, error) {
+	query := `UPDATE student_notifications SET read_at = COALESCE(read_at, $4)
+	WHERE school_id=$1 AND student_email=$2 AND id=$3 RETURNING ` + studentNotificationColumns
+
+	notifications := []*StudentNotification{}
+	err := store.conn().Select(&notifications, query, store.school, studentEmail, id, readAt)
+	if err != nil {
+		return nil, err
+	}
+	if len(notifications) == 0 {
+		return nil, nil
+	}
+
+	return notifications[0], nil
+}
+
+// MarkAllStudentNotificationsRead marks the unread notifications of the student up to the given id as read, or all of
+// them if it is 0, returning how many were marked
+func (store *Store) MarkAllStudentNotificationsRead(studentEmail string, upToID int64, readAt time.Time) (int64, error) {
+	query := `UPDATE student_notifications SET read_at = $3
+	WHERE school_id=$1 AND student_email=$2 AND read_at IS NULL AND ($4::BIGINT = 0 OR id <= $4)`
+
+	result, err := store.conn().Exec(query, store.school, studentEmail, readAt, upToID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+const sentNotificationColumns = `notification_id, teacher_email, (ARRAY_AGG(notification ORDER BY id))[1] AS notification,
+	category, urgent, created_at, COUNT(*) AS recipients, COUNT(read_at) AS read, MIN(id) AS first_id`
+
+// ListSentNotifications lists the notifications sent by the teacher, or by every teacher if empty, newest first
+func (store *Store) ListSentNotifications(teacherEmail string, beforeID int64, limit int) ([]*SentNotification, error) {
+	query := `SELECT ` + sentNotificationColumns + ` FROM student_notifications
+	WHERE school_id=$1 AND ($2::VARCHAR = '' OR teacher_email=$2)
+	GROUP BY notification_id, teacher_email, category, urgent, created_at
+	HAVING $3::BIGINT = 0 OR MIN(id) < $3
+	ORDER BY first_id DESC LIMIT $4`
+
+	notifications := []*SentNotification{}
+	err := store.conn().Select(&notifications, query, store.school, teacherEmail, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// GetSentNotification returns the notification with its read receipts, sorted by student, or nil if it does not
+// exist
+func (store *Store) GetSentNotification(notificationID string) (*SentNotification, error) {
+	query := `SELECT ` + sentNotificationColumns + ` FROM student_notifications
+	WHERE school_id=$1 AND notification_id=$2
+	GROUP BY notification_id, teacher_email, category, urgent, created_at`
+
+	notifications := []*SentNotification{}
+	if err := store.conn().Select(&notifications, query, store.school, notificationID); err != nil {
+		return nil, err
+	}
+	if len(notifications) == 0 {
+		return nil, nil
+	}
+
+	notification := notifications[0]
+	receiptQuery := `SELECT student_email, read_at FROM student_notifications
+	WHERE school_id=$1 AND notification_id=$2 ORDER BY student_email`
+
+	notification.Receipts = []*ReadReceipt{}
+	if err := store.conn().Select(&notification.Receipts, receiptQuery, store.school, notificationID); err != nil {
+		return nil, err
+	}
+
+	return notification, nil
+}
+
+// Secrets are left out, they are only returned when set
+const webhookColumns = `id, url, event_types, active, created_by, created_at, updated_at`
+
+func (store *Store) AddWebhook(webhook *Webhook) error {
+	query := `INSERT INTO webhooks (id, school_id, url, event_types, secret, active, created_by, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	_, err := store.conn().Exec(query, webhook.ID, store.school, webhook.URL, webhook.Events, webhook.Secret, webhook.Active,
+		webhook.CreatedBy, webhook.CreatedAt, webhook.UpdatedAt)
+	return err
+}
+
+// GetWebhook returns nil if there is no webhook with the given id
+func (store *Store) GetWebhook(id string) (*Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE school_id=$1 AND id=$2`
+
+	webhooks := []*Webhook{}
+	err := store.conn().Select(&webhooks, query, store.school, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(webhooks) == 0 {
+		return nil, nil
+	}
+
+	return webhooks[0], nil
+}
+
+// ListWebhooks lists the school's webhooks by creation time
+func (store *Store) ListWebhooks() ([]*Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE school_id=$1 ORDER BY created_at, id`
+
+	webhooks := []*Webhook{}
+	err := store.conn().Select(&webhooks, query, store.school)
+	if err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// UpdateWebhook saves the webhook, keeping its secret if the webhook has none
+func (store *Store) UpdateWebhook(webhook *Webhook) (bool, error) {
+	query := `UPDATE webhooks SET url=$3, event_types=$4, secret=COALESCE(NULLIF($5, ''), secret), active=$6, updated_at=$7
+	WHERE school_id=$1 AND id=$2`
+
+	return store.execAffectsRows(query, store.school, webhook.ID, webhook.URL, webhook.Events, webhook.Secret, webhook.Active,
+		webhook.UpdatedAt)
+}
+
+// DeleteWebhook deletes the webhook with its deliveries
+func (store *Store) DeleteWebhook(id string) (bool, error) {
+	query := `DELETE FROM webhooks WHERE school_id=$1 AND id=$2`
+
+	return store.execAffectsRows(query, store.school, id)
+}
+
+// AddWebhookEvent adds a pending delivery of the event for each active webhook of the school subscribed to its type
+func (store *Store) AddWebhookEvent(event *WebhookEvent, payload []byte) error {
+	query := `INSERT INTO webhook_deliveries (school_id, webhook_id, event_id, event_type, payload, status, next_attempt_at, created_at)
+	SELECT $1, id, $2, $3, $4::JSONB, $5, $6::TIMESTAMPTZ, $6 FROM webhooks WHERE school_id=$1 AND active AND $3 = ANY(event_types)`
+
+	_, err := store.conn().Exec(query, store.school, event.ID, event.Type, types.JSONText(payload), WebhookDeliveryPending, event.OccurredAt)
+	return err
+}
+
+const webhookDeliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_attempt_at,
+	response_status, last_error, redelivery_of, created_at, delivered_at`
+
+// ClaimDueWebhookDeliveries claims pending deliveries of any school due at the given time, of active webhooks, until
+// leaseUntil so that no other server attempts them meanwhile. Deliveries whose outcome is never recorded are
+// attempted again once the lease ends.
+func (store *Store) ClaimDueWebhookDeliveries(now time.Time, leaseUntil time.Time, limit int) ([]*DueWebhookDelivery, error) {
+	query := `UPDATE webhook_deliveries d SET next_attempt_at=$3 FROM webhooks w
+	WHERE w.id = d.webhook_id AND d.id IN (
+		SELECT pending.id FROM webhook_deliveries pending JOIN webhooks subscribed ON subscribed.id = pending.webhook_id
+		WHERE pending.status=$1 AND pending.next_attempt_at <= $2 AND subscribed.active
+		ORDER BY pending.next_attempt_at LIMIT $4 FOR UPDATE OF pending SKIP LOCKED
+	)
+	RETURNING d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at, d.last_attempt_at,
+	d.response_status, d.last_error, d.redelivery_of, d.created_at, d.delivered_at, w.url, w.secret`
+
+	deliveries := []*DueWebhookDelivery{}
+	err := store.conn().Select(&deliveries, query, WebhookDeliveryPending, now, leaseUntil, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RecordWebhookAttempt saves the outcome of the last attempt of a delivery of any school
+func (store *Store) RecordWebhookAttempt(delivery *WebhookDelivery) error {
+	query := `UPDATE webhook_deliveries SET status=$2, attempts=$3, next_attempt_at=$4, last_attempt_at=$5, response_status=$6,
+	last_error=$7, delivered_at=$8 WHERE id=$1`
+
+	_, err := store.conn().Exec(query, delivery.ID, delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastAttemptAt,
+		delivery.ResponseStatus, delivery.LastError, delivery.DeliveredAt)
+	return err
+}
+
+// ListWebhookDeliveries lists the deliveries of the webhook, newest first, only those with the status if given
+func (store *Store) ListWebhookDeliveries(webhookID string, status string, beforeID int64, limit int) ([]*WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries
+	WHERE school_id=$1 AND webhook_id=$2 AND ($3::VARCHAR = '' OR status=$3) AND ($4::BIGINT = 0 OR id < $4)
+	ORDER BY id DESC LIMIT $5`
+
+	deliveries := []*WebhookDelivery{}
+	err := store.conn().Select(&deliveries, query, store.school, webhookID, status, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// GetWebhookDelivery returns nil if the webhook has no delivery with the given id
+func (store *Store) GetWebhookDelivery(webhookID string, id int64) (*WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE school_id=$1 AND webhook_id=$2 AND id=$3`
+
+	deliveries := []*WebhookDelivery{}
+	err := store.conn().Select(&deliveries, query, store.school, webhookID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, nil
+	}
+
+	return deliveries[0], nil
+}
+
+// AddWebhookRedelivery adds a pending delivery sending the event of the given one again. Returns nil if the webhook
+// has no such delivery.
+func (store *Store) AddWebhookRedelivery(webhookID string, id int64, now time.Time) (*WebhookDelivery, error) {
+	query := `INSERT INTO webhook_deliveries (school_id, webhook_id, event_id, event_type, payload, status, next_attempt_at, redelivery_of, created_at)
+	SELECT school_id, webhook_id, event_id, event_type, payload, $4, $5::TIMESTAMPTZ, id, $5 FROM webhook_deliveries
+	WHERE school_id=$1 AND webhook_id=$2 AND id=$3
+	RETURNING ` + webhookDeliveryColumns
+
+	deliveries := []*WebhookDelivery{}
+	err := store.conn().Select(&deliveries, query, store.school, webhookID, id, WebhookDeliveryPending, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, nil
+	}
+
+	return deliveries[0], nil
+}
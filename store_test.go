@@ -1,11 +1,17 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/require"
 )
 
@@ -23,10 +29,10 @@ func TestAddTeacher(t *testing.T) {
 	db, mock := NewMockDB()
 	defer db.Close()
 
-	store := &Store{db: db}
+	store := &Store{db: db, school: DefaultSchoolID}
 
 	teacher := NewTeacher("teacher@example.com")
-	mock.ExpectExec("INSERT INTO teachers").WithArgs(teacher.Email).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO teachers").WithArgs(DefaultSchoolID, teacher.Email).WillReturnResult(sqlmock.NewResult(1, 1))
 
 	err := store.AddTeacher(teacher)
 	require.NoError(t, err)
@@ -38,11 +44,11 @@ func TestAddStudents(t *testing.T) {
 	db, mock := NewMockDB()
 	defer db.Close()
 
-	store := &Store{db: db}
+	store := &Store{db: db, school: DefaultSchoolID}
 
 	students := []*Student{NewStudent("student1@gmail.com"), NewStudent("student2@gmail.com")}
 
-	mock.ExpectExec("INSERT INTO students \\(email\\)").WithArgs(students[0].Email, students[1].Email).WillReturnResult(sqlmock.NewResult(1, int64(len(students))))
+	mock.ExpectExec("INSERT INTO students \\(school_id, email\\)").WithArgs(DefaultSchoolID, students[0].Email, students[1].Email).WillReturnResult(sqlmock.NewResult(1, int64(len(students))))
 
 	err := store.AddStudents(students)
 	require.NoError(t, err)
@@ -54,12 +60,21 @@ func TestRegister(t *testing.T) {
 	db, mock := NewMockDB()
 	defer db.Close()
 
-	store := &Store{db: db}
+	store := &Store{db: db, school: DefaultSchoolID}
 	pairs := []*TeacherStudentPair{NewTeacherStudentPair("teacher@gmail.com", "student1@gmail.com"), NewTeacherStudentPair("teacher@gmail.com", "student2@gmail.com")}
 
-	mock.ExpectExec("INSERT INTO registered \\(student_email, teacher_email\\) VALUES").
-		WithArgs(pairs[0].StudentEmail, pairs[0].TeacherEmail, pairs[1].StudentEmail, pairs[1].TeacherEmail).
+	// Students are enrolled in the default class of the teacher
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO classes \\(school_id, code, name, default_for_teacher\\) VALUES \\(\\$1, \\$2, \\$3, \\$3\\) ON CONFLICT DO NOTHING").
+		WithArgs(DefaultSchoolID, DefaultClassCode("teacher@gmail.com"), "teacher@gmail.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO class_teachers \\(school_id, class_code, teacher_email\\) VALUES").
+		WithArgs(DefaultSchoolID, DefaultClassCode("teacher@gmail.com"), "teacher@gmail.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO enrollments \\(school_id, term, class_code, student_email\\) VALUES").
+		WithArgs(DefaultSchoolID, "", DefaultClassCode(pairs[0].TeacherEmail), pairs[0].StudentEmail, DefaultClassCode(pairs[1].TeacherEmail), pairs[1].StudentEmail).
 		WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectCommit()
 
 	err := store.Register(pairs)
 	require.NoError(t, err)
@@ -71,7 +86,7 @@ func TestGetCommonStudents(t *testing.T) {
 	db, mock := NewMockDB()
 	defer db.Close()
 
-	store := &Store{db: db}
+	store := &Store{db: db, school: DefaultSchoolID, term: "2026-T1"}
 
 	teacher1 := NewTeacher("teacher1@example.com")
 	teacher2 := NewTeacher("teacher2@example.com")
@@ -81,10 +96,10 @@ func TestGetCommonStudents(t *testing.T) {
 	studentEmail2 := "student2@example.com"
 	expected := mock.NewRows([]string{"email"}).AddRow(studentEmail1).AddRow(studentEmail2)
 
-	mock.ExpectQuery("SELECT student_email AS email FROM registered WHERE teacher_email IN \\(.+\\) GROUP BY student_email HAVING COUNT\\(DISTINCT teacher_email\\) = \\$").
-		WithArgs(teachers[0].Email, teachers[1].Email, len(teachers)).WillReturnRows(expected)
+	mock.ExpectQuery("SELECT student_email AS email FROM registered WHERE school_id=\\$1 AND term=\\$2 AND teacher_email IN \\(.+\\) GROUP BY student_email HAVING COUNT\\(DISTINCT teacher_email\\) = \\$").
+		WithArgs(DefaultSchoolID, "2026-T1", teachers[0].Email, teachers[1].Email, len(teachers)).WillReturnRows(expected)
 
-	commonStudents, err := store.GetCommonStudents(teachers)
+	commonStudents, err := store.GetCommonStudents(teachers, "")
 
 	require.NoError(t, err)
 	require.Equal(t, []string{studentEmail1, studentEmail2}, commonStudents)
@@ -92,14 +107,33 @@ func TestGetCommonStudents(t *testing.T) {
 	require.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestGetCommonStudentsOfClass(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	teachers := []*Teacher{NewTeacher("teacher1@example.com")}
+	mock.ExpectQuery("WHERE school_id=\\$1 AND term=\\$2 AND teacher_email IN \\(\\$3\\) AND student_email IN \\(SELECT student_email FROM enrollments WHERE school_id=\\$1 AND term=\\$2 AND class_code=\\$4\\) GROUP BY student_email HAVING COUNT\\(DISTINCT teacher_email\\) = \\$5").
+		WithArgs(DefaultSchoolID, "", teachers[0].Email, "MATH-1", len(teachers)).
+		WillReturnRows(mock.NewRows([]string{"email"}).AddRow("student1@example.com"))
+
+	commonStudents, err := store.GetCommonStudents(teachers, "MATH-1")
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"student1@example.com"}, commonStudents)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestAddSuspension(t *testing.T) {
 	db, mock := NewMockDB()
 	defer db.Close()
 
-	store := &Store{db: db}
+	store := &Store{db: db, school: DefaultSchoolID}
 
 	suspension := NewSuspension("student_suspened@example.com")
-	mock.ExpectExec("INSERT INTO suspensions").WithArgs(suspension.Email, suspension.SuspendedAt).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO suspensions").WithArgs(DefaultSchoolID, suspension.Email, suspension.SuspendedAt).WillReturnResult(sqlmock.NewResult(1, 1))
 
 	err := store.AddSuspension(suspension)
 	require.NoError(t, err)
@@ -111,10 +145,10 @@ func TestStudentExists(t *testing.T) {
 	db, mock := NewMockDB()
 	defer db.Close()
 
-	store := &Store{db: db}
+	store := &Store{db: db, school: DefaultSchoolID}
 	expected := mock.NewRows([]string{"email"}).AddRow("student@example.com")
 	studentEmail := "student@example.com"
-	mock.ExpectQuery("SELECT email FROM students WHERE").WithArgs(studentEmail).WillReturnRows(expected)
+	mock.ExpectQuery("SELECT email FROM students WHERE").WithArgs(DefaultSchoolID, studentEmail).WillReturnRows(expected)
 
 	ifStudentExists, err := store.IfStudentExists(studentEmail)
 
@@ -127,10 +161,10 @@ func TestStudentDoesNotExist(t *testing.T) {
 	db, mock := NewMockDB()
 	defer db.Close()
 
-	store := &Store{db: db}
+	store := &Store{db: db, school: DefaultSchoolID}
 	expected := mock.NewRows([]string{"email"})
 	studentEmail := "student@example.com"
-	mock.ExpectQuery("SELECT email FROM students WHERE").WithArgs(studentEmail).WillReturnRows(expected)
+	mock.ExpectQuery("SELECT email FROM students WHERE").WithArgs(DefaultSchoolID, studentEmail).WillReturnRows(expected)
 
 	ifStudentExists, err := store.IfStudentExists(studentEmail)
 
@@ -143,10 +177,10 @@ func TestTeacherExists(t *testing.T) {
 	db, mock := NewMockDB()
 	defer db.Close()
 
-	store := &Store{db: db}
+	store := &Store{db: db, school: DefaultSchoolID}
 	expected := mock.NewRows([]string{"email"}).AddRow("teacher@example.com")
 	teacherEmail := "teacher@example.com"
-	mock.ExpectQuery("SELECT email FROM teachers WHERE").WithArgs(teacherEmail).WillReturnRows(expected)
+	mock.ExpectQuery("SELECT email FROM teachers WHERE").WithArgs(DefaultSchoolID, teacherEmail).WillReturnRows(expected)
 
 	ifStudentExists, err := store.IfTeacherExists(teacherEmail)
 
@@ -159,10 +193,10 @@ func TestTeacherDoesNotExist(t *testing.T) {
 	db, mock := NewMockDB()
 	defer db.Close()
 
-	store := &Store{db: db}
+	store := &Store{db: db, school: DefaultSchoolID}
 	expected := mock.NewRows([]string{"email"})
 	teacherEmail := "teacher@example.com"
-	mock.ExpectQuery("SELECT email FROM teachers WHERE").WithArgs(teacherEmail).WillReturnRows(expected)
+	mock.ExpectQuery("SELECT email FROM teachers WHERE").WithArgs(DefaultSchoolID, teacherEmail).WillReturnRows(expected)
 
 	ifStudentExists, err := store.IfTeacherExists(teacherEmail)
 
@@ -170,3 +204,800 @@ func TestTeacherDoesNotExist(t *testing.T) {
 	require.Equal(t, ifStudentExists, false)
 	require.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestAddAPIKey(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	apiKey := NewAPIKey("ci", "admin", RoleAdmin, "gds_0123456789abcdef")
+	mock.ExpectQuery("INSERT INTO api_keys").
+		WithArgs(apiKey.Name, apiKey.Subject, apiKey.Role, apiKey.KeyPrefix, apiKey.KeyHash, apiKey.CreatedAt, apiKey.School).
+		WillReturnRows(mock.NewRows([]string{"id"}).AddRow(7))
+
+	err := store.AddAPIKey(apiKey)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), apiKey.ID)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetActiveAPIKeyByHashNotFound(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	keyHash := HashAPIKey("gds_unknown")
+	mock.ExpectQuery("SELECT (.+) FROM api_keys WHERE key_hash=\\$1 AND revoked_at IS NULL").
+		WithArgs(keyHash).
+		WillReturnRows(mock.NewRows([]string{"id", "name", "subject", "role", "key_prefix", "key_hash", "created_at", "revoked_at", "school_id"}))
+
+	apiKey, err := store.GetActiveAPIKeyByHash(keyHash)
+	require.NoError(t, err)
+	require.Nil(t, apiKey)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	mock.ExpectExec("UPDATE api_keys SET revoked_at").WithArgs(int64(3), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	revoked, err := store.RevokeAPIKey(3)
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReserveIdempotencyKeyAlreadyHeld(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	record := NewIdempotencyRecord("api_key:admin", "retry-1", HashAPIKey("body"))
+	expiredBefore := record.CreatedAt.Add(-idempotencyKeyTTL)
+	mock.ExpectExec("INSERT INTO idempotency_keys (.+) ON CONFLICT \\(caller, idempotency_key\\) DO UPDATE").
+		WithArgs(record.Caller, record.Key, record.RequestHash, record.CreatedAt, expiredBefore).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	reserved, err := store.ReserveIdempotencyKey(record, expiredBefore)
+	require.NoError(t, err)
+	require.False(t, reserved)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCompleteIdempotencyKey(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	record := NewIdempotencyRecord("api_key:admin", "retry-1", HashAPIKey("body"))
+	record.StatusCode = 200
+	record.ContentType = "application/json; charset=utf-8"
+	record.ResponseBody = []byte(`{"recipients":[]}`)
+	mock.ExpectExec("UPDATE idempotency_keys SET status_code").
+		WithArgs(record.Caller, record.Key, record.StatusCode, record.ContentType, record.ResponseBody, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := store.CompleteIdempotencyKey(record)
+	require.NoError(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTakeRateLimitToken(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	limit := RateLimit{Capacity: 10, Period: time.Minute}
+	now := time.Now().UTC()
+	key := "key:1:read"
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO rate_limit_buckets").WithArgs(key, limit.Capacity, now).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT tokens, updated_at FROM rate_limit_buckets WHERE bucket_key=\\$1 FOR UPDATE").
+		WithArgs(key).
+		WillReturnRows(mock.NewRows([]string{"tokens", "updated_at"}).AddRow(0.5, now))
+	mock.ExpectExec("UPDATE rate_limit_buckets SET tokens").WithArgs(key, 0.5, now).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := store.TakeRateLimitToken(key, limit, now)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Equal(t, 3*time.Second, result.RetryAfter)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddAuditEntry(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	entry := NewAuditEntry("admin", RoleAdmin, AuditActionRegister, "request-1")
+	entry.TeacherEmail = "teacher@example.com"
+	entry.StudentEmails = []string{"student1@example.com"}
+	mock.ExpectQuery("INSERT INTO audit_log").
+		WithArgs(entry.OccurredAt, entry.Actor, entry.ActorRole, entry.Action, entry.TeacherEmail, entry.StudentEmails, entry.Before, entry.After, entry.RequestID, DefaultSchoolID).
+		WillReturnRows(mock.NewRows([]string{"id"}).AddRow(1))
+
+	err := store.AddAuditEntry(entry)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), entry.ID)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAuditEntriesWithFilters(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	filter := AuditFilter{Action: AuditActionSuspend, StudentEmail: "student1@example.com", BeforeID: 10, Limit: 51}
+	mock.ExpectQuery("FROM audit_log WHERE school_id=\\$1 AND action=\\$2 AND \\$3 = ANY\\(student_emails\\) AND id < \\$4 ORDER BY id DESC LIMIT \\$5").
+		WithArgs(DefaultSchoolID, filter.Action, filter.StudentEmail, filter.BeforeID, filter.Limit).
+		WillReturnRows(mock.NewRows([]string{"id", "occurred_at", "actor", "actor_role", "action", "teacher_email", "student_emails", "before", "after", "request_id"}).
+			AddRow(9, time.Now(), "admin", RoleAdmin, AuditActionSuspend, "", "{student1@example.com}", "null", `{"suspended":true}`, ""))
+
+	entries, err := store.GetAuditEntries(filter)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, []string{"student1@example.com"}, []string(entries[0].StudentEmails))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListInboxWithFilters(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	filter := InboxFilter{UnreadOnly: true, BeforeID: 10, Limit: 21}
+	mock.ExpectQuery("FROM student_notifications WHERE school_id=\\$1 AND student_email=\\$2 AND read_at IS NULL AND id < \\$3 ORDER BY id DESC LIMIT \\$4").
+		WithArgs(DefaultSchoolID, "student1@example.com", filter.BeforeID, filter.Limit).
+		WillReturnRows(mock.NewRows([]string{"id", "notification_id", "student_email", "teacher_email", "notification", "category", "urgent", "created_at", "read_at", "school_id"}).
+			AddRow(9, "abc", "student1@example.com", "teacher@example.com", "Hello", "", false, time.Now(), nil, DefaultSchoolID))
+
+	notifications, err := store.ListInbox("student1@example.com", filter)
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	require.Nil(t, notifications[0].ReadAt)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetStreamResumeID(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	// Resumes below the notifications created within the window before the last one received
+	mock.ExpectQuery("SELECT COALESCE\\(MIN\\(n.id\\) - 1, \\$3::BIGINT\\) FROM student_notifications n (.+) AND n.id < \\$3 AND n.created_at >= last.created_at - make_interval\\(secs => \\$4\\)").
+		WithArgs(DefaultSchoolID, "student1@example.com", int64(12), float64(30)).
+		WillReturnRows(mock.NewRows([]string{"coalesce"}).AddRow(9))
+
+	resumeID, err := store.GetStreamResumeID("student1@example.com", 12, 30*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, int64(9), resumeID)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddWebhookEvent(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	// One delivery per active webhook subscribed to the event type
+	event := &WebhookEvent{ID: "abc", Type: WebhookEventStudentRegistered, OccurredAt: time.Now().UTC(), School: DefaultSchoolID}
+	payload := []byte(`{"id":"abc"}`)
+	mock.ExpectExec("INSERT INTO webhook_deliveries (.+) SELECT (.+) FROM webhooks WHERE school_id=\\$1 AND active AND \\$3 = ANY\\(event_types\\)").
+		WithArgs(DefaultSchoolID, event.ID, event.Type, types.JSONText(payload), WebhookDeliveryPending, event.OccurredAt).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	require.NoError(t, store.AddWebhookEvent(event, payload))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEnrollInChunks(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID, term: "2020-T1"}
+
+	enrollments := []*Enrollment{}
+	for i := 0; i < enrollChunkSize+1; i++ {
+		enrollments = append(enrollments, NewEnrollment("3A-MATH", fmt.Sprintf("student%d@example.com", i)))
+	}
+	mock.ExpectExec("INSERT INTO enrollments").WillReturnResult(sqlmock.NewResult(0, enrollChunkSize))
+	mock.ExpectExec("INSERT INTO enrollments \\(school_id, term, class_code, student_email\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\) ON CONFLICT DO NOTHING").
+		WithArgs(DefaultSchoolID, "2020-T1", "3A-MATH", fmt.Sprintf("student%d@example.com", enrollChunkSize)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, store.Enroll(enrollments))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddStudentNotificationsInChunks(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	broker := NewMemoryBroker()
+	store := &Store{db: db, school: DefaultSchoolID, broker: broker}
+	first := broker.Subscribe(DefaultSchoolID, "student0@example.com")
+	defer first.Close()
+
+	notifications := []*StudentNotification{}
+	firstRows := mock.NewRows([]string{"id", "student_email"})
+	for i := 0; i < studentNotificationChunkSize+1; i++ {
+		email := fmt.Sprintf("student%d@example.com", i)
+		notifications = append(notifications, &StudentNotification{NotificationID: "abc", StudentEmail: email, Teacher: "teacher@example.com", Notification: "Hello"})
+		if i < studentNotificationChunkSize {
+			firstRows.AddRow(i+1, email)
+		}
+	}
+
+	// Nothing is published unless every chunk is saved
+	mock.ExpectQuery("INSERT INTO student_notifications").WillReturnRows(firstRows)
+	mock.ExpectQuery("INSERT INTO student_notifications \\(.+\\) VALUES \\(\\$1, \\$2, \\$3, \\$4, \\$5, \\$6, \\$7, \\$8\\) RETURNING id, student_email").
+		WillReturnError(errors.New("connection reset"))
+	require.Error(t, store.AddStudentNotifications(notifications))
+	require.Empty(t, first.Notifications)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnregister(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	pair := NewTeacherStudentPair("teacher@example.com", "student1@example.com")
+	mock.ExpectExec("DELETE FROM enrollments WHERE school_id=\\$1 AND term=\\$2 AND student_email=\\$3\\s+AND class_code IN \\(SELECT class_code FROM class_teachers WHERE school_id=\\$1 AND teacher_email=\\$4\\)").
+		WithArgs(DefaultSchoolID, "", pair.StudentEmail, pair.TeacherEmail).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	unregistered, err := store.Unregister(pair)
+	require.NoError(t, err)
+	require.False(t, unregistered)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUnenroll(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID, term: "2026-T1"}
+
+	enrollment := NewEnrollment("3A-MATH", "student1@example.com")
+	mock.ExpectExec("DELETE FROM enrollments WHERE school_id=\\$1 AND term=\\$2 AND class_code=\\$3 AND student_email=\\$4").
+		WithArgs(DefaultSchoolID, "2026-T1", enrollment.ClassCode, enrollment.StudentEmail).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	unenrolled, err := store.Unenroll(enrollment)
+	require.NoError(t, err)
+	require.True(t, unenrolled)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLiftSuspensions(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	studentEmail := "student1@example.com"
+	liftedAt := time.Now().UTC()
+	mock.ExpectExec("UPDATE suspensions SET suspended_until=\\$3").WithArgs(DefaultSchoolID, studentEmail, liftedAt).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	lifted, err := store.LiftSuspensions(studentEmail, liftedAt)
+	require.NoError(t, err)
+	require.True(t, lifted)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetSuspensions(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	studentEmail := "student1@example.com"
+	suspendedAt := time.Now().UTC()
+	mock.ExpectQuery("SELECT student_email, suspended_at, suspended_until FROM suspensions WHERE school_id=\\$1 AND student_email=\\$2").
+		WithArgs(DefaultSchoolID, studentEmail).
+		WillReturnRows(mock.NewRows([]string{"student_email", "suspended_at", "suspended_until"}).AddRow(studentEmail, suspendedAt, nil))
+
+	suspensions, err := store.GetSuspensions(studentEmail)
+	require.NoError(t, err)
+	require.Equal(t, []*Suspension{{Email: studentEmail, SuspendedAt: suspendedAt}}, suspensions)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddTeachers(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	teachers := []*Teacher{NewTeacher("teacher1@example.com"), NewTeacher("teacher2@example.com")}
+	mock.ExpectExec("INSERT INTO teachers \\(school_id, email\\) VALUES \\(\\$1, \\$2\\),\\(\\$1, \\$3\\) ON CONFLICT DO NOTHING").
+		WithArgs(DefaultSchoolID, teachers[0].Email, teachers[1].Email).
+		WillReturnResult(sqlmock.NewResult(1, int64(len(teachers))))
+
+	err := store.AddTeachers(teachers)
+	require.NoError(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetImportJob(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	createdAt := time.Now().UTC()
+	columns := []string{"id", "status", "dry_run", "created_by", "rows_processed", "rows_imported", "rows_failed", "errors", "message", "created_at", "finished_at"}
+	mock.ExpectQuery("SELECT (.+) FROM import_jobs WHERE school_id=\\$1 AND id=\\$2").
+		WithArgs(DefaultSchoolID, "job1").
+		WillReturnRows(mock.NewRows(columns).AddRow("job1", ImportJobRunning, false, "admin", 500, 499, 1, []byte(`[{"row":3,"message":"invalid"}]`), "", createdAt, nil))
+	mock.ExpectQuery("SELECT (.+) FROM import_jobs WHERE school_id=\\$1 AND id=\\$2").
+		WithArgs(DefaultSchoolID, "missing").
+		WillReturnRows(mock.NewRows(columns))
+
+	job, err := store.GetImportJob("job1")
+	require.NoError(t, err)
+	require.Equal(t, ImportJobRunning, job.Status)
+	require.Equal(t, 499, job.RowsImported)
+	require.JSONEq(t, `[{"row":3,"message":"invalid"}]`, job.Errors.String())
+
+	job, err = store.GetImportJob("missing")
+	require.NoError(t, err)
+	require.Nil(t, job)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStreamRegistrations(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	mock.ExpectQuery("SELECT teacher_email, student_email FROM registered WHERE school_id=\\$1 AND term=\\$2 AND teacher_email = ANY\\(\\$3\\)").
+		WillReturnRows(mock.NewRows([]string{"teacher_email", "student_email"}).
+			AddRow("teacher@example.com", "student1@example.com").
+			AddRow("teacher@example.com", "student2@example.com"))
+
+	pairs := []*TeacherStudentPair{}
+	err := store.StreamRegistrations([]string{"teacher@example.com"}, func(pair *TeacherStudentPair) error {
+		pairs = append(pairs, pair)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []*TeacherStudentPair{
+		NewTeacherStudentPair("teacher@example.com", "student1@example.com"),
+		NewTeacherStudentPair("teacher@example.com", "student2@example.com"),
+	}, pairs)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListStudentsWithFilter(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	active := true
+	filter := ProfileFilter{Query: "50%", Active: &active, GradeLevel: "7", Emails: []string{"student1@example.com"}}
+	mock.ExpectQuery("SELECT (.+) FROM students WHERE school_id=\\$1 AND \\(email ILIKE \\$2 (.+) AND active=\\$3 AND grade_level=\\$4 AND email = ANY\\(\\$5\\) ORDER BY email").
+		WithArgs(DefaultSchoolID, `%50\%%`, true, "7", sqlmock.AnyArg()).
+		WillReturnRows(mock.NewRows([]string{"email", "given_name", "family_name", "preferred_name", "sis_id", "homeroom", "active", "grade_level"}).
+			AddRow("student1@example.com", "Jon", "Tan", "", "S123", "7A", true, "7"))
+
+	students, err := store.ListStudents(filter)
+	require.NoError(t, err)
+	require.Len(t, students, 1)
+	require.Equal(t, "Jon", students[0].GivenName)
+	require.Equal(t, "7", students[0].GradeLevel)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateTeacher(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	givenName := "Ken"
+	columns := []string{"email", "given_name", "family_name", "preferred_name", "sis_id", "homeroom", "active"}
+	mock.ExpectQuery("UPDATE teachers SET given_name=COALESCE\\(\\$3, given_name\\)").
+		WithArgs(DefaultSchoolID, "teacher@example.com", &givenName, nil, nil, nil, nil, nil).
+		WillReturnRows(mock.NewRows(columns).AddRow("teacher@example.com", "Ken", "", "", "", "", true))
+	mock.ExpectQuery("UPDATE teachers").
+		WithArgs(DefaultSchoolID, "missing@example.com", nil, nil, nil, nil, nil, nil).
+		WillReturnRows(mock.NewRows(columns))
+
+	teacher, err := store.UpdateTeacher("teacher@example.com", &ProfileUpdate{GivenName: &givenName})
+	require.NoError(t, err)
+	require.Equal(t, "Ken", teacher.GivenName)
+	require.True(t, teacher.Active)
+
+	teacher, err = store.UpdateTeacher("missing@example.com", &ProfileUpdate{})
+	require.NoError(t, err)
+	require.Nil(t, teacher)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetClass(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	columns := []string{"code", "name", "term", "is_default", "teachers"}
+	mock.ExpectQuery("SELECT (.+) FROM classes WHERE school_id=\\$1 AND code=\\$2").
+		WithArgs(DefaultSchoolID, "MATH-1").
+		WillReturnRows(mock.NewRows(columns).AddRow("MATH-1", "Mathematics", "2026-T1", false, "{teacher1@example.com,teacher2@example.com}"))
+	mock.ExpectQuery("SELECT (.+) FROM classes WHERE school_id=\\$1 AND code=\\$2").
+		WithArgs(DefaultSchoolID, "MISSING").
+		WillReturnRows(mock.NewRows(columns))
+
+	class, err := store.GetClass("MATH-1")
+	require.NoError(t, err)
+	require.Equal(t, NewClass("MATH-1", "Mathematics", "2026-T1", []string{"teacher1@example.com", "teacher2@example.com"}), class)
+
+	class, err = store.GetClass("MISSING")
+	require.NoError(t, err)
+	require.Nil(t, class)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetSchool(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db}
+
+	columns := []string{"id", "name", "allowed_domains"}
+	mock.ExpectQuery("SELECT id, name, allowed_domains FROM schools WHERE id=\\$1").
+		WithArgs("school1").
+		WillReturnRows(mock.NewRows(columns).AddRow("school1", "First school", "{school.edu}"))
+	mock.ExpectQuery("SELECT id, name, allowed_domains FROM schools WHERE id=\\$1").
+		WithArgs("missing").
+		WillReturnRows(mock.NewRows(columns))
+
+	school, err := store.GetSchool("school1")
+	require.NoError(t, err)
+	require.Equal(t, NewSchool("school1", "First school", []string{"school.edu"}), school)
+
+	school, err = store.GetSchool("missing")
+	require.NoError(t, err)
+	require.Nil(t, school)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetCurrentTerm(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	today := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT (.+) FROM terms WHERE school_id=\\$1 AND starts_on <= \\$2 AND ends_on >= \\$2 ORDER BY starts_on DESC LIMIT 1").
+		WithArgs(DefaultSchoolID, "2026-03-01").
+		WillReturnRows(mock.NewRows([]string{"code", "name", "starts_on", "ends_on"}).AddRow("2026-T1", "Term 1", "2026-01-05", "2026-03-13"))
+
+	term, err := store.GetCurrentTerm(today)
+	require.NoError(t, err)
+	require.Equal(t, NewTerm("2026-T1", "Term 1", "2026-01-05", "2026-03-13"), term)
+
+	// No term is current between terms
+	mock.ExpectQuery("SELECT (.+) FROM terms WHERE school_id=\\$1 AND starts_on <= \\$2 AND ends_on >= \\$2").
+		WithArgs(DefaultSchoolID, "2026-03-20").
+		WillReturnRows(mock.NewRows([]string{"code", "name", "starts_on", "ends_on"}))
+
+	term, err = store.GetCurrentTerm(today.AddDate(0, 0, 19))
+	require.NoError(t, err)
+	require.Nil(t, term)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOptedInGuardians(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	mock.ExpectQuery("SELECT DISTINCT guardian_email FROM student_guardians\\s+WHERE school_id=\\$1 AND opted_in AND student_email = ANY\\(\\$2\\)").
+		WithArgs(DefaultSchoolID, sqlmock.AnyArg()).
+		WillReturnRows(mock.NewRows([]string{"guardian_email"}).AddRow("parent1@example.com").AddRow("parent2@example.com"))
+
+	guardians, err := store.GetOptedInGuardians([]string{"student1@example.com", "student2@example.com"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"parent1@example.com", "parent2@example.com"}, guardians)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetNotificationPreferences(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	mock.ExpectQuery("SELECT (.+) FROM notification_preferences WHERE school_id=\\$1 AND student_email = ANY\\(\\$2\\)").
+		WithArgs(DefaultSchoolID, sqlmock.AnyArg()).
+		WillReturnRows(mock.NewRows([]string{"student_email", "channels", "muted_categories", "quiet_hours_start", "quiet_hours_end", "time_zone"}).
+			AddRow("student1@example.com", "{email,push}", "{sports}", "22:00", "07:00", "Asia/Singapore"))
+
+	preferences, err := store.GetNotificationPreferences([]string{"student1@example.com", "student2@example.com"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]*NotificationPreferences{
+		"student1@example.com": {
+			Channels:        pq.StringArray{"email", "push"},
+			MutedCategories: pq.StringArray{"sports"},
+			QuietHoursStart: "22:00",
+			QuietHoursEnd:   "07:00",
+			TimeZone:        "Asia/Singapore",
+		},
+	}, preferences)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetActiveSuspensions(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	now := time.Now().UTC()
+	until := now.Add(24 * time.Hour)
+	mock.ExpectQuery("FROM suspensions WHERE school_id=\\$1 AND student_email = ANY\\(\\$2\\) AND suspended_at <= \\$3 (.+) GROUP BY student_email").
+		WithArgs(DefaultSchoolID, sqlmock.AnyArg(), now).
+		WillReturnRows(mock.NewRows([]string{"student_email", "suspended_at", "suspended_until"}).
+			AddRow("student1@example.com", now, until).
+			AddRow("student2@example.com", now, nil))
+
+	suspensions, err := store.GetActiveSuspensions([]string{"student1@example.com", "student2@example.com", "student3@example.com"}, now)
+	require.NoError(t, err)
+	require.Equal(t, map[string]*Suspension{
+		"student1@example.com": {Email: "student1@example.com", SuspendedAt: now, SuspendedUntil: &until},
+		"student2@example.com": {Email: "student2@example.com", SuspendedAt: now},
+	}, suspensions)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestResolveMentions(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	now := time.Now().UTC()
+	mock.ExpectQuery("FROM unnest\\(\\$2::text\\[\\]\\) WITH ORDINALITY AS m\\(email, position\\)\\s+LEFT JOIN students s ON s.school_id=\\$1 AND s.email=m.email").
+		WithArgs(DefaultSchoolID, sqlmock.AnyArg(), now).
+		WillReturnRows(mock.NewRows([]string{"email", "student_exists", "suspended"}).
+			AddRow("student1@example.com", true, false).
+			AddRow("missing@example.com", false, false).
+			AddRow("student2@example.com", true, true))
+
+	statuses, err := store.ResolveMentions([]string{"student1@example.com", "missing@example.com", "student2@example.com"}, now)
+	require.NoError(t, err)
+	require.Equal(t, []*MentionStatus{
+		{Email: "student1@example.com", Exists: true},
+		{Email: "missing@example.com"},
+		{Email: "student2@example.com", Exists: true, Suspended: true},
+	}, statuses)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetNotificationRecipients(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	now := time.Now().UTC()
+	teacher := NewTeacher("teacher@example.com")
+	mock.ExpectQuery("SELECT student_email FROM \\(SELECT student_email FROM registered WHERE school_id=\\$1 AND term=\\$2 AND teacher_email=\\$3\\s+UNION SELECT email FROM students WHERE school_id=\\$1 AND \\(email = ANY\\(\\$4\\) OR \\$7\\)\\s+UNION SELECT student_email FROM enrollments WHERE school_id=\\$1 AND term=\\$2 AND class_code = ANY\\(\\$5\\)\\s+UNION SELECT student_email FROM registered WHERE school_id=\\$1 AND term=\\$2 AND teacher_email = ANY\\(\\$6\\)\\s+\\) candidates WHERE student_email NOT IN").
+		WithArgs(DefaultSchoolID, "", teacher.Email, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), false, now).
+		WillReturnRows(mock.NewRows([]string{"student_email"}).AddRow("student1@example.com").AddRow("student4@example.com"))
+	mock.ExpectQuery("SELECT student_email FROM \\(SELECT student_email FROM enrollments WHERE school_id=\\$1 AND term=\\$2 AND class_code=\\$3").
+		WithArgs(DefaultSchoolID, "", "3A-MATH", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), true, now).
+		WillReturnRows(mock.NewRows([]string{"student_email"}))
+
+	recipients, err := store.GetNotificationRecipients(&NotificationAudience{Teacher: teacher, Students: []string{"student4@example.com"}}, now)
+	require.NoError(t, err)
+	require.Equal(t, []string{"student1@example.com", "student4@example.com"}, recipients)
+
+	recipients, err = store.GetNotificationRecipients(&NotificationAudience{Teacher: teacher, Class: "3A-MATH", AllStudents: true}, now)
+	require.NoError(t, err)
+	require.Empty(t, recipients)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetExistingClassesAndTeachers(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	mock.ExpectQuery("SELECT code FROM classes WHERE school_id=\\$1 AND code = ANY\\(\\$2\\) ORDER BY code").
+		WithArgs(DefaultSchoolID, sqlmock.AnyArg()).
+		WillReturnRows(mock.NewRows([]string{"code"}).AddRow("3A-MATH"))
+	mock.ExpectQuery("SELECT email FROM teachers WHERE school_id=\\$1 AND email = ANY\\(\\$2\\) ORDER BY email").
+		WithArgs(DefaultSchoolID, sqlmock.AnyArg()).
+		WillReturnRows(mock.NewRows([]string{"email"}))
+
+	classes, err := store.GetExistingClasses([]string{"3A-MATH", "3B-MATH"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"3A-MATH"}, classes)
+
+	teachers, err := store.GetExistingTeachers([]string{"teacher@example.com"})
+	require.NoError(t, err)
+	require.Empty(t, teachers)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSendDueNotificationsFailsClientErrors(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db}
+	now := time.Now().UTC()
+	columns := []string{"id", "teacher_email", "term", "request", "send_at", "status", "created_by", "created_by_role", "request_id",
+		"created_at", "sent_at", "result", "message", "recurring_id", "school_id", "attempts"}
+
+	// The notification's school is gone, which retrying will not fix, so it fails and the next one is claimed
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM scheduled_notifications\\s+WHERE status=\\$1 AND send_at <= \\$2 ORDER BY send_at LIMIT 1 FOR UPDATE SKIP LOCKED").
+		WithArgs(ScheduledNotificationPending, now).
+		WillReturnRows(mock.NewRows(columns).AddRow("abc", "teacher@example.com", "", `{}`, now, ScheduledNotificationPending, "teacher@example.com",
+			RoleTeacher, "", now, nil, "null", "", "", "gone", 0))
+	mock.ExpectQuery("SELECT id, name, allowed_domains FROM schools WHERE id=\\$1").WithArgs("gone").
+		WillReturnRows(mock.NewRows([]string{"id", "name", "allowed_domains"}))
+	mock.ExpectRollback()
+	mock.ExpectExec("UPDATE scheduled_notifications SET status=\\$2, message=\\$3, attempts=attempts\\+1 WHERE id=\\$1 AND status=\\$4").
+		WithArgs("abc", ScheduledNotificationFailed, "The school of the notification does not exist.", ScheduledNotificationPending).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM scheduled_notifications").WithArgs(ScheduledNotificationPending, now).WillReturnRows(mock.NewRows(columns))
+	mock.ExpectCommit()
+
+	count, err := sendDueNotifications(store, now)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSendDueNotificationsRetriesServerErrors(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db}
+	now := time.Now().UTC()
+	columns := []string{"id", "teacher_email", "term", "request", "send_at", "status", "created_by", "created_by_role", "request_id",
+		"created_at", "sent_at", "result", "message", "recurring_id", "school_id", "attempts"}
+	due := func(id string, attempts int) *sqlmock.Rows {
+		return mock.NewRows(columns).AddRow(id, "teacher@example.com", "", `{}`, now, ScheduledNotificationPending, "teacher@example.com",
+			RoleTeacher, "", now, nil, "null", "", "", DefaultSchoolID, attempts)
+	}
+
+	// A server error is retried later with backoff and the next notification is claimed
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM scheduled_notifications").WithArgs(ScheduledNotificationPending, now).WillReturnRows(due("abc", 1))
+	mock.ExpectQuery("SELECT id, name, allowed_domains FROM schools WHERE id=\\$1").WithArgs(DefaultSchoolID).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+	mock.ExpectExec("UPDATE scheduled_notifications SET message=\\$2, send_at=\\$3, attempts=attempts\\+1 WHERE id=\\$1 AND status=\\$4").
+		WithArgs("abc", "failed to get school.", now.Add(2*time.Minute), ScheduledNotificationPending).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// It fails once out of attempts
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM scheduled_notifications").WithArgs(ScheduledNotificationPending, now).WillReturnRows(due("def", scheduledMaxAttempts-1))
+	mock.ExpectQuery("SELECT id, name, allowed_domains FROM schools WHERE id=\\$1").WithArgs(DefaultSchoolID).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+	mock.ExpectExec("UPDATE scheduled_notifications SET status=\\$2, message=\\$3, attempts=attempts\\+1 WHERE id=\\$1 AND status=\\$4").
+		WithArgs("def", ScheduledNotificationFailed, "failed to get school.", ScheduledNotificationPending).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM scheduled_notifications").WithArgs(ScheduledNotificationPending, now).WillReturnRows(mock.NewRows(columns))
+	mock.ExpectCommit()
+
+	count, err := sendDueNotifications(store, now)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestNextOccurrenceSkipsHolidays(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+	startsAt := time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)
+	notification := &RecurringNotification{Schedule: "0 7 * * MON", TimeZone: "Asia/Singapore", StartsAt: startsAt, EndsOn: "2026-12-21"}
+	holidays := func() *sqlmock.Rows {
+		return mock.NewRows([]string{"date", "name"}).AddRow("2026-12-07", "Term break")
+	}
+
+	// Monday 7 December is a holiday, so the first occurrence is on the 14th at 7am in Singapore
+	mock.ExpectQuery("SELECT (.+) FROM holidays WHERE school_id=\\$1 ORDER BY date").WithArgs(DefaultSchoolID).WillReturnRows(holidays())
+	next, err := nextOccurrence(store, notification, startsAt)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 12, 13, 23, 0, 0, 0, time.UTC), *next)
+
+	// The occurrence on the 28th is after endsOn
+	mock.ExpectQuery("SELECT (.+) FROM holidays").WithArgs(DefaultSchoolID).WillReturnRows(holidays())
+	next, err = nextOccurrence(store, notification, time.Date(2026, 12, 21, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Nil(t, next)
+
+	// No holidays are read once the count is reached
+	notification.Count, notification.Occurrences = 2, 2
+	next, err = nextOccurrence(store, notification, startsAt)
+	require.NoError(t, err)
+	require.Nil(t, next)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSendScheduledNotificationUsesCurrentTerm(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db}
+	notification := &ScheduledNotification{ID: "abc", SchoolID: DefaultSchoolID, Request: []byte(`{}`)}
+
+	// Without a term of its own, the notification is sent in the term current when it is sent
+	mock.ExpectQuery("SELECT id, name, allowed_domains FROM schools WHERE id=\\$1").WithArgs(DefaultSchoolID).
+		WillReturnRows(mock.NewRows([]string{"id", "name", "allowed_domains"}).AddRow(DefaultSchoolID, "Default", "{}"))
+	mock.ExpectQuery("SELECT (.+) FROM terms WHERE school_id=\\$1").WithArgs(DefaultSchoolID, sqlmock.AnyArg()).
+		WillReturnError(errors.New("connection reset"))
+
+	_, apiErr := sendScheduledNotification(store, notification)
+	require.NotNil(t, apiErr)
+	require.Equal(t, http.StatusInternalServerError, apiErr.Status)
+	require.Equal(t, "failed to get current term.", apiErr.Message)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
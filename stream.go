@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	lastEventIDHeader = "Last-Event-ID"
+	// Query parameter resuming streams of clients that cannot set headers, such as WebSockets
+	lastEventIDQueryParam = "lastEventId"
+	// Notifications read from the database at a time when a stream resumes
+	streamBacklogPageSize = 100
+	// Longest a notification may take to commit. Ids are taken before the commit, so notifications created this long
+	// before the last one a client received may have committed after it and are sent again when it resumes.
+	streamResumeWindow = 30 * time.Second
+
+	StreamMessageNotification = "notification"
+	StreamMessageKeepAlive    = "keep-alive"
+)
+
+func LoadStreamHeartbeatInterval() time.Duration {
+	return time.Duration(getEnvInt("STREAM_HEARTBEAT_SECONDS", 15)) * time.Second
+}
+
+func registerStreamRoutes(api *gin.RouterGroup, store *Store, reads gin.HandlerFunc) {
+	api.GET("/students/:email/notifications/stream", reads, RequireRole(RoleAdmin, RoleStudent), makeHandleFunc(handleNotificationStream, store))
+	api.GET("/students/:email/notifications/ws", reads, RequireRole(RoleAdmin, RoleStudent), makeHandleFunc(handleNotificationWebSocket, store))
+}
+
+// notificationStream sends the notifications of a student: those after the last one the client received, then those
+// published until the context ends or the stream falls behind
+type notificationStream struct {
+	store        *Store
+	studentEmail string
+	// The backlog is listed after resumeID, which is lastID or lower to send late commits again
+	lastID    int64
+	resumeID  int64
+	heartbeat time.Duration
+}
+
+// openNotificationStream checks that the student may be streamed and where to resume from, writing the error response
+// and returning nil if not
+func openNotificationStream(c *gin.Context, store *Store) *notificationStream {
+	studentEmail := c.Param("email")
+	if !requireStudent(c, store, studentEmail) {
+		return nil
+	}
+
+	lastEventID := c.GetHeader(lastEventIDHeader)
+	if lastEventID == "" {
+		lastEventID = c.Query(lastEventIDQueryParam)
+	}
+	var lastID int64
+	if lastEventID != "" {
+		var err error
+		if lastID, err = strconv.ParseInt(lastEventID, 10, 64); err != nil || lastID < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Last-Event-ID must be the id of a notification."})
+			return nil
+		}
+	}
+
+	resumeID := lastID
+	if lastID > 0 {
+		var err error
+		if resumeID, err = store.GetStreamResumeID(studentEmail, lastID, streamResumeWindow); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to resume stream."})
+			return nil
+		}
+	}
+
+	return &notificationStream{store: store, studentEmail: studentEmail, lastID: lastID, resumeID: resumeID, heartbeat: LoadStreamHeartbeatInterval()}
+}
+
+// run sends notifications with send and calls keepAlive when there were none for a heartbeat, until the context ends
+// or either fails
+func (stream *notificationStream) run(ctx context.Context, send func(*StudentNotification) error, keepAlive func() error) error {
+	// Subscribe first so that nothing published while the backlog is read is missed
+	subscription := stream.store.broker.Subscribe(stream.store.school, stream.studentEmail)
+	defer subscription.Close()
+
+	// The backlog is listed in id order, so each notification is sent once, less the last one the client received
+	lastSentID := stream.resumeID
+	for {
+		backlog, err := stream.store.ListStudentNotificationsAfter(stream.studentEmail, lastSentID, streamBacklogPageSize)
+		if err != nil {
+			return err
+		}
+		for _, notification := range backlog {
+			lastSentID = notification.ID
+			if notification.ID == stream.lastID {
+				continue
+			}
+			if err := send(notification); err != nil {
+				return err
+			}
+		}
+		if len(backlog) < streamBacklogPageSize {
+			break
+		}
+	}
+
+	ticker := time.NewTicker(stream.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notification, open := <-subscription.Notifications:
+			if !open {
+				// Fell behind, the client resumes from the last notification it received when it reconnects
+				return nil
+			}
+			// Published while the backlog was read and already sent with it. Later notifications are all sent, even
+			// those committing out of order, so lastSentID stays at the end of the backlog.
+			if notification.ID <= lastSentID {
+				continue
+			}
+			if err := send(notification); err != nil {
+				return err
+			}
+			ticker.Reset(stream.heartbeat)
+		case <-ticker.C:
+			if err := keepAlive(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handleNotificationStream streams the notifications of a student as Server-Sent Events, resuming after the
+// Last-Event-ID header given by reconnecting clients
+func handleNotificationStream(c *gin.Context, store *Store) {
+	stream := openNotificationStream(c, store)
+	if stream == nil {
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Proxies must not buffer the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	send := func(notification *StudentNotification) error {
+		data, err := json.Marshal(notification)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: notification\ndata: %s\n\n", notification.ID, data); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+	keepAlive := func() error {
+		if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+
+	// The response has started, so errors can only end the stream
+	if err := stream.run(c.Request.Context(), send, keepAlive); err != nil {
+		c.Error(err)
+	}
+}
+
+// handleNotificationWebSocket streams the notifications of a student over a WebSocket as JSON messages, of type
+// notification or keep-alive, resuming after the lastEventId query parameter
+func handleNotificationWebSocket(c *gin.Context, store *Store) {
+	stream := openNotificationStream(c, store)
+	if stream == nil {
+		return
+	}
+
+	server := websocket.Server{
+		// Clients authenticate like any other request, so connections from other origins are allowed
+		Handshake: func(config *websocket.Config, req *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+
+			// Clients only send to close the connection
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				defer cancel()
+				var message string
+				for websocket.Message.Receive(conn, &message) == nil {
+				}
+			}()
+
+			send := func(notification *StudentNotification) error {
+				return websocket.JSON.Send(conn, StreamMessage{Type: StreamMessageNotification, Notification: notification})
+			}
+			keepAlive := func() error {
+				return websocket.JSON.Send(conn, StreamMessage{Type: StreamMessageKeepAlive})
+			}
+			if err := stream.run(ctx, send, keepAlive); err != nil {
+				c.Error(err)
+			}
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotificationStreamSendsEachNotificationOnce(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	broker := NewMemoryBroker()
+	store := &Store{db: db, school: DefaultSchoolID, broker: broker}
+	stream := &notificationStream{store: store, studentEmail: "student1@example.com", lastID: 3, resumeID: 2, heartbeat: time.Hour}
+	notification := func(id int64) *StudentNotification {
+		return &StudentNotification{ID: id, SchoolID: DefaultSchoolID, StudentEmail: "student1@example.com"}
+	}
+
+	columns := []string{"id", "notification_id", "student_email", "teacher_email", "notification", "category", "urgent", "created_at", "read_at", "school_id"}
+	mock.ExpectQuery("SELECT (.+) FROM student_notifications\\s+WHERE school_id=\\$1 AND student_email=\\$2 AND id > \\$3 ORDER BY id LIMIT \\$4").
+		WithArgs(DefaultSchoolID, "student1@example.com", int64(2), streamBacklogPageSize).
+		WillReturnRows(mock.NewRows(columns).
+			AddRow(3, "a", "student1@example.com", "teacher@example.com", "Hello", "", false, time.Now(), nil, DefaultSchoolID).
+			AddRow(4, "b", "student1@example.com", "teacher@example.com", "Hello", "", false, time.Now(), nil, DefaultSchoolID))
+
+	// The client already has 3, 4 is published while the backlog is read, and 6 commits before 5
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sent := []int64{}
+	err := stream.run(ctx, func(sentNotification *StudentNotification) error {
+		sent = append(sent, sentNotification.ID)
+		switch sentNotification.ID {
+		case 4:
+			require.NoError(t, broker.Publish([]*StudentNotification{notification(4), notification(6), notification(5)}))
+		case 5:
+			cancel()
+		}
+		return nil
+	}, func() error { return nil })
+	require.NoError(t, err)
+	require.Equal(t, []int64{4, 6, 5}, sent)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Templates are Go text/templates rendered for each recipient with:
+//
+//	.Student.Name, .Student.GivenName, .Student.FamilyName, .Student.Email
+//	.Teacher.Name, .Teacher.GivenName, .Teacher.FamilyName, .Teacher.Email
+//	.Class.Code, .Class.Name
+//	.School, the school's name
+//	.Date, today in UTC as 2006-01-02
+//	.Vars.name, the variables of the request, which must all be given
+//
+// Templates may only use if, else and with, the functions below and the comparison builtins, so that rendering
+// cannot loop, call functions of the data or produce unbounded output.
+
+const (
+	AuditActionCreateTemplate = "create_template"
+	AuditActionUpdateTemplate = "update_template"
+	AuditActionDeleteTemplate = "delete_template"
+)
+
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+	"title": titleCase,
+	// default "there" .Student.GivenName
+	"default": func(fallback string, value string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	},
+	// formatDate "Monday 2 January" .Date
+	"formatDate": func(layout string, date string) (string, error) {
+		day, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			return "", err
+		}
+		return day.Format(layout), nil
+	},
+}
+
+var templateBuiltins = []string{"and", "or", "not", "eq", "ne", "lt", "le", "gt", "ge", "len", "index"}
+
+type templatePerson struct {
+	Email      string
+	Name       string
+	GivenName  string
+	FamilyName string
+}
+
+type templateClass struct {
+	Code string
+	Name string
+}
+
+type templateData struct {
+	Student templatePerson
+	Teacher templatePerson
+	Class   templateClass
+	School  string
+	Date    string
+	Vars    map[string]string
+}
+
+func registerTemplateRoutes(v2 *gin.RouterGroup, store *Store, reads gin.HandlerFunc, writes gin.HandlerFunc) {
+	v2.GET("/templates", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleListTemplates, store))
+	v2.POST("/templates", writes, RequireRole(RoleAdmin, RoleTeacher), makeHandleFunc(handleCreateTemplate, store))
+	v2.POST("/templates/preview", reads, RequireRole(RoleAdmin, RoleTeacher), makeHandleFunc(handlePreviewTemplate, store))
+	v2.GET("/templates/:id", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleGetTemplate, store))
+	v2.PUT("/templates/:id", writes, RequireRole(RoleAdmin, RoleTeacher), makeHandleFunc(handleUpdateTemplate, store))
+	v2.DELETE("/templates/:id", writes, RequireRole(RoleAdmin, RoleTeacher), makeHandleFunc(handleDeleteTemplate, store))
+}
+
+// ParseNotificationTemplate parses the body of a template, failing if it uses anything but the allowed actions and
+// functions
+func ParseNotificationTemplate(body string) (*template.Template, error) {
+	tmpl, err := template.New("notification").Option("missingkey=error").Funcs(templateFuncs).Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(tmpl.Templates()) > 1 {
+		return nil, fmt.Errorf("define and block are not allowed")
+	}
+	if tmpl.Tree == nil {
+		return tmpl, nil
+	}
+	if err := checkTemplateNode(tmpl.Tree.Root); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func checkTemplateNode(node parse.Node) error {
+	switch node := node.(type) {
+	case nil, *parse.TextNode, *parse.CommentNode:
+		return nil
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, child := range node.Nodes {
+			if err := checkTemplateNode(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *parse.ActionNode:
+		return checkTemplatePipe(node.Pipe)
+	case *parse.IfNode:
+		return checkTemplateBranch(&node.BranchNode)
+	case *parse.WithNode:
+		return checkTemplateBranch(&node.BranchNode)
+	case *parse.RangeNode:
+		return fmt.Errorf("range is not allowed")
+	case *parse.TemplateNode:
+		return fmt.Errorf("template is not allowed")
+	default:
+		return fmt.Errorf("%s is not allowed", node)
+	}
+}
+
+func checkTemplateBranch(branch *parse.BranchNode) error {
+	if err := checkTemplatePipe(branch.Pipe); err != nil {
+		return err
+	}
+	if err := checkTemplateNode(branch.List); err != nil {
+		return err
+	}
+	return checkTemplateNode(branch.ElseList)
+}
+
+func checkTemplatePipe(pipe *parse.PipeNode) error {
+	if pipe == nil {
+		return nil
+	}
+	for _, command := range pipe.Cmds {
+		for _, arg := range command.Args {
+			switch arg := arg.(type) {
+			case *parse.IdentifierNode:
+				if _, allowed := templateFuncs[arg.Ident]; !allowed && !slices.Contains(templateBuiltins, arg.Ident) {
+					return fmt.Errorf("function %s is not allowed", arg.Ident)
+				}
+			case *parse.PipeNode:
+				if err := checkTemplatePipe(arg); err != nil {
+					return err
+				}
+			case *parse.FieldNode, *parse.VariableNode, *parse.DotNode, *parse.StringNode, *parse.NumberNode, *parse.BoolNode, *parse.NilNode:
+			default:
+				return fmt.Errorf("%s is not allowed", arg)
+			}
+		}
+	}
+	return nil
+}
+
+func renderTemplate(tmpl *template.Template, data *templateData) (string, error) {
+	var builder strings.Builder
+	if err := tmpl.Execute(&builder, data); err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+func titleCase(text string) string {
+	runes := []rune(text)
+	for i := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) {
+			runes[i] = unicode.ToUpper(runes[i])
+		}
+	}
+	return string(runes)
+}
+
+// templatePersonOf names a person by their preferred name, or else their given and family names, or else their email
+func templatePersonOf(email string, profile *Profile) templatePerson {
+	person := templatePerson{Email: email, Name: email}
+	if profile == nil {
+		return person
+	}
+	person.GivenName, person.FamilyName = profile.GivenName, profile.FamilyName
+	if name := strings.TrimSpace(profile.GivenName + " " + profile.FamilyName); profile.PreferredName != "" {
+		person.Name = profile.PreferredName
+	} else if name != "" {
+		person.Name = name
+	}
+	return person
+}
+
+// loadTemplate returns the parsed stored template, or a 400 error if there is none
+func loadTemplate(store *Store, id string) (*template.Template, *apiError) {
+	notificationTemplate, err := store.GetTemplate(id)
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, err, "failed to get template.")
+	}
+	if notificationTemplate == nil {
+		return nil, newAPIError(http.StatusBadRequest, nil, fmt.Sprintf("Template (%s) does not exist.", id))
+	}
+	tmpl, err := ParseNotificationTemplate(notificationTemplate.Body)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, err, "Template is invalid: "+err.Error())
+	}
+	return tmpl, nil
+}
+
+// newTemplateData returns the data of a template before the student is known
+func newTemplateData(store *Store, school *School, teacherEmail string, classCode string, variables map[string]string) (*templateData, *apiError) {
+	data := &templateData{Date: time.Now().UTC().Format(time.DateOnly), Vars: map[string]string{}}
+	if school != nil {
+		data.School = school.Name
+	}
+	for name, value := range variables {
+		data.Vars[name] = value
+	}
+
+	if teacherEmail != "" {
+		teacher, err := store.GetTeacher(teacherEmail)
+		if err != nil {
+			return nil, newAPIError(http.StatusInternalServerError, err, "failed to get teacher.")
+		}
+		var profile *Profile
+		if teacher != nil {
+			profile = &teacher.Profile
+		}
+		data.Teacher = templatePersonOf(teacherEmail, profile)
+	}
+	if classCode != "" {
+		class, err := store.GetClass(classCode)
+		if err != nil {
+			return nil, newAPIError(http.StatusInternalServerError, err, "failed to get class.")
+		}
+		data.Class = templateClass{Code: classCode}
+		if class != nil {
+			data.Class.Name = class.Name
+		}
+	}
+	return data, nil
+}
+
+// renderForRecipients renders the template for each of the students
+func renderForRecipients(store *Store, tmpl *template.Template, data *templateData, studentEmails []string) ([]*RenderedNotification, *apiError) {
+	rendered := []*RenderedNotification{}
+	if len(studentEmails) == 0 {
+		return rendered, nil
+	}
+	students, err := store.ListStudents(ProfileFilter{Emails: studentEmails})
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, err, "failed to get students.")
+	}
+
+	for _, email := range studentEmails {
+		var profile *Profile
+		if index := slices.IndexFunc(students, func(student *Student) bool { return student.Email == email }); index != -1 {
+			profile = &students[index].Profile
+		}
+		studentData := *data
+		studentData.Student = templatePersonOf(email, profile)
+		notification, err := renderTemplate(tmpl, &studentData)
+		if err != nil {
+			return nil, newAPIError(http.StatusBadRequest, err, "Template could not be rendered: "+err.Error())
+		}
+		rendered = append(rendered, &RenderedNotification{Recipient: email, Notification: notification})
+	}
+	return rendered, nil
+}
+
+// authorizeTemplateChange allows admins to change any template and teachers only their own
+func authorizeTemplateChange(c *gin.Context, notificationTemplate *NotificationTemplate) bool {
+	principal := GetPrincipal(c)
+	if principal == nil || principal.Role != RoleTeacher || principal.Subject == notificationTemplate.CreatedBy {
+		return true
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{"error": errForbidden.Error(), "message": "Teachers may only change their own templates."})
+	return false
+}
+
+func getTemplate(store *Store, id string) (*NotificationTemplate, *apiError) {
+	notificationTemplate, err := store.GetTemplate(id)
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, err, "failed to get template.")
+	}
+	if notificationTemplate == nil {
+		return nil, newAPIError(http.StatusNotFound, errNotFound, "Given template does not exist.")
+	}
+	return notificationTemplate, nil
+}
+
+func handleListTemplates(c *gin.Context, store *Store) {
+	templates, err := store.ListTemplates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to list templates."})
+		return
+	}
+
+	c.JSON(http.StatusOK, TemplatesResponse{Templates: templates})
+}
+
+func handleGetTemplate(c *gin.Context, store *Store) {
+	notificationTemplate, apiErr := getTemplate(store, c.Param("id"))
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, notificationTemplate)
+}
+
+func handleCreateTemplate(c *gin.Context, store *Store) {
+	var input TemplateRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+	if _, err := ParseNotificationTemplate(input.Body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "Template is invalid: " + err.Error()})
+		return
+	}
+
+	id, err := newRandomID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to create template."})
+		return
+	}
+	entry := newAuditEntry(c, AuditActionCreateTemplate)
+	notificationTemplate := NewNotificationTemplate(id, input, entry.Actor)
+	entry.After = toAuditPayload(notificationTemplate)
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		if err := txStore.AddTemplate(notificationTemplate); err != nil {
+			if IsUniqueViolation(err) {
+				return newAPIError(http.StatusConflict, err, fmt.Sprintf("Template (%s) already exists.", notificationTemplate.Name))
+			}
+			return newAPIError(http.StatusInternalServerError, err, "failed to create template.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Header("Location", "/api/v2/templates/"+notificationTemplate.ID)
+	c.JSON(http.StatusCreated, notificationTemplate)
+}
+
+func handleUpdateTemplate(c *gin.Context, store *Store) {
+	var input TemplateRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+
+	existing, apiErr := getTemplate(store, c.Param("id"))
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+	if !authorizeTemplateChange(c, existing) {
+		return
+	}
+	if _, err := ParseNotificationTemplate(input.Body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "Template is invalid: " + err.Error()})
+		return
+	}
+
+	updated := *existing
+	updated.Name, updated.Body, updated.UpdatedAt = input.Name, input.Body, time.Now().UTC()
+	entry := newAuditEntry(c, AuditActionUpdateTemplate)
+	entry.Before = toAuditPayload(existing)
+	entry.After = toAuditPayload(updated)
+
+	apiErr = withAudit(store, entry, func(txStore *Store) *apiError {
+		changed, err := txStore.UpdateTemplate(&updated)
+		if err != nil {
+			if IsUniqueViolation(err) {
+				return newAPIError(http.StatusConflict, err, fmt.Sprintf("Template (%s) already exists.", updated.Name))
+			}
+			return newAPIError(http.StatusInternalServerError, err, "failed to update template.")
+		}
+		if !changed {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given template does not exist.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+func handleDeleteTemplate(c *gin.Context, store *Store) {
+	existing, apiErr := getTemplate(store, c.Param("id"))
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+	if !authorizeTemplateChange(c, existing) {
+		return
+	}
+
+	entry := newAuditEntry(c, AuditActionDeleteTemplate)
+	entry.Before = toAuditPayload(existing)
+
+	apiErr = withAudit(store, entry, func(txStore *Store) *apiError {
+		deleted, err := txStore.DeleteTemplate(existing.ID)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to delete template.")
+		}
+		if !deleted {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given template does not exist.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func handlePreviewTemplate(c *gin.Context, store *Store) {
+	var input PreviewTemplateRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+	if (input.TemplateID == "") == (input.Body == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Give either a templateId or a body."})
+		return
+	}
+
+	var tmpl *template.Template
+	if input.TemplateID != "" {
+		var apiErr *apiError
+		if tmpl, apiErr = loadTemplate(store, input.TemplateID); apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+	} else {
+		var err error
+		if tmpl, err = ParseNotificationTemplate(input.Body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "Template is invalid: " + err.Error()})
+			return
+		}
+	}
+
+	// Teachers preview as themselves by default
+	teacherEmail := input.Teacher
+	if principal := GetPrincipal(c); teacherEmail == "" && principal != nil && principal.Role == RoleTeacher {
+		teacherEmail = principal.Subject
+	}
+	data, apiErr := newTemplateData(store, GetSchool(c), teacherEmail, input.Class, input.Variables)
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+	if input.Student != "" {
+		student, err := store.GetStudent(input.Student)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get student."})
+			return
+		}
+		var profile *Profile
+		if student != nil {
+			profile = &student.Profile
+		}
+		data.Student = templatePersonOf(input.Student, profile)
+	}
+
+	notification, err := renderTemplate(tmpl, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "Template could not be rendered: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, PreviewTemplateResponse{Notification: notification, Mentions: ParseMentions(notification)})
+}
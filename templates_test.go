@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	tmpl, err := ParseNotificationTemplate(`Dear {{default "student" .Student.GivenName}}, {{.Class.Name | upper}} homework is due on {{formatDate "Monday 2 January" .Vars.due}}.{{if .Vars.note}} {{.Vars.note}}{{end}} - {{.Teacher.Name}}`)
+	require.NoError(t, err)
+
+	data := &templateData{
+		Student: templatePersonOf("student1@example.com", &Profile{GivenName: "Ah Kow", FamilyName: "Tan"}),
+		Teacher: templatePersonOf("teacher@example.com", &Profile{GivenName: "Mei", FamilyName: "Lim", PreferredName: "Ms Lim"}),
+		Class:   templateClass{Code: "3A-MATH", Name: "Maths"},
+		Vars:    map[string]string{"due": "2026-10-19", "note": ""},
+	}
+	notification, err := renderTemplate(tmpl, data)
+	require.NoError(t, err)
+	require.Equal(t, "Dear Ah Kow, MATHS homework is due on Monday 19 October. - Ms Lim", notification)
+
+	// Students without a profile are named by their email
+	data.Student = templatePersonOf("student2@example.com", nil)
+	notification, err = renderTemplate(tmpl, data)
+	require.NoError(t, err)
+	require.Equal(t, "Dear student, MATHS homework is due on Monday 19 October. - Ms Lim", notification)
+	require.Equal(t, "student2@example.com", data.Student.Name)
+
+	// Variables must all be given
+	delete(data.Vars, "note")
+	_, err = renderTemplate(tmpl, data)
+	require.ErrorContains(t, err, `map has no entry for key "note"`)
+}
+
+func TestParseNotificationTemplateIsSandboxed(t *testing.T) {
+	for _, body := range []string{
+		`{{range .Vars}}{{.}}{{end}}`,
+		`{{define "x"}}x{{end}}{{template "x"}}`,
+		`{{block "x" .}}x{{end}}`,
+		`{{printf "%0100000000d" 1}}`,
+		`{{call .Vars.f}}`,
+		`{{if true}}{{println "x"}}{{end}}`,
+		`{{with .Student}}{{html .Name}}{{end}}`,
+		`{{upper (print .Student.Name)}}`,
+		`{{.Student.Name`,
+	} {
+		_, err := ParseNotificationTemplate(body)
+		require.Error(t, err, body)
+	}
+
+	_, err := ParseNotificationTemplate(`{{/* greeting */}}{{$name := .Student.Name}}{{if and (ne $name "") (eq .Class.Code "3A")}}Hi {{title $name}}{{else}}Hi{{end}}{{len .Vars}}{{index .Vars "due date"}}`)
+	require.NoError(t, err)
+}
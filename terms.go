@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Enrollments belong to one of the school's academic terms, so registrations end with their term. Requests act in
+// the term given by the term query param, or else the current term. Schools without terms keep their enrollments in
+// the empty term. Between terms, requests that act on enrollments must give a term.
+
+const (
+	termQueryParam = "term"
+	termContextKey = "term"
+
+	RolloverActionCopy    = "copy"
+	RolloverActionArchive = "archive"
+
+	AuditActionCreateTerm   = "create_term"
+	AuditActionRolloverTerm = "rollover_term"
+)
+
+var errNoCurrentTerm = errors.New("no current term")
+
+// ResolveTerm attaches the term of the request to the Gin context. Must run after ResolveSchool.
+func ResolveTerm(store *Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		school := GetSchool(c)
+		if school == nil {
+			return
+		}
+
+		schoolStore := store.ForSchool(school.ID)
+		code := c.Query(termQueryParam)
+		var term *Term
+		var err error
+		if code == "" {
+			term, err = schoolStore.GetCurrentTerm(time.Now().UTC())
+		} else {
+			term, err = schoolStore.GetTerm(code)
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get term."})
+			return
+		}
+		if term == nil && code != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errNotFound.Error(), "message": fmt.Sprintf("Term (%s) does not exist.", code)})
+			return
+		}
+
+		if term != nil {
+			c.Set(termContextKey, term)
+		}
+	}
+}
+
+// RequireTerm rejects the requests of a school with terms that fall between its terms and do not give one, rather
+// than act on the empty term of schools without terms. Must run after ResolveTerm.
+func RequireTerm(store *Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		school := GetSchool(c)
+		if school == nil {
+			return
+		}
+
+		scopedStore := store.ForSchool(school.ID)
+		if term := GetTerm(c); term != nil {
+			scopedStore = scopedStore.ForTerm(term.Code)
+		}
+		if apiErr := requireTerm(scopedStore); apiErr != nil {
+			c.Abort()
+			apiErr.respond(c)
+		}
+	}
+}
+
+// requireTerm fails with a 409 error when the store is not scoped to a term but its school has terms
+func requireTerm(store *Store) *apiError {
+	if store.term != "" {
+		return nil
+	}
+	hasTerms, err := store.HasTerms()
+	if err != nil {
+		return newAPIError(http.StatusInternalServerError, err, "failed to get terms.")
+	}
+	if hasTerms {
+		return newAPIError(http.StatusConflict, errNoCurrentTerm, "No term is current, give one with ?term=.")
+	}
+	return nil
+}
+
+// GetTerm returns the term attached by ResolveTerm, or nil if the school has no current term
+func GetTerm(c *gin.Context) *Term {
+	value, exists := c.Get(termContextKey)
+	if !exists {
+		return nil
+	}
+	term, _ := value.(*Term)
+	return term
+}
+
+func registerTermRoutes(v2 *gin.RouterGroup, store *Store, reads gin.HandlerFunc, writes gin.HandlerFunc) {
+	v2.GET("/terms", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleListTerms, store))
+	v2.POST("/terms", writes, RequireRole(RoleAdmin), makeHandleFunc(handleCreateTerm, store))
+	v2.POST("/terms/:code/rollover", writes, RequireRole(RoleAdmin), makeHandleFunc(handleRolloverTerm, store))
+}
+
+func handleListTerms(c *gin.Context, store *Store) {
+	terms, err := store.ListTerms()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to list terms."})
+		return
+	}
+
+	// Terms do not overlap, so at most one is current, and none between terms
+	today := time.Now().UTC().Format(time.DateOnly)
+	for _, term := range terms {
+		term.Current = term.StartsOn <= today && today <= term.EndsOn
+	}
+
+	c.JSON(http.StatusOK, TermsResponse{Terms: terms})
+}
+
+func handleCreateTerm(c *gin.Context, store *Store) {
+	var input CreateTermRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+	if !IsValidTermCode(input.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "code must be 1 to 50 letters, digits, dots, dashes or underscores."})
+		return
+	}
+	if len(input.Name) > maxProfileFieldLength {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("name must be at most %d characters.", maxProfileFieldLength)})
+		return
+	}
+	startsOn, err1 := time.Parse(time.DateOnly, input.StartsOn)
+	endsOn, err2 := time.Parse(time.DateOnly, input.EndsOn)
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "startsOn and endsOn must be dates formatted as YYYY-MM-DD."})
+		return
+	}
+	if endsOn.Before(startsOn) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "endsOn must not be before startsOn."})
+		return
+	}
+
+	term := NewTerm(input.Code, input.Name, input.StartsOn, input.EndsOn)
+	entry := newAuditEntry(c, AuditActionCreateTerm)
+	entry.After = toAuditPayload(term)
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		overlapping, err := txStore.GetOverlappingTerms(term.StartsOn, term.EndsOn)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to get terms.")
+		}
+		if len(overlapping) > 0 {
+			return newAPIError(http.StatusConflict, nil, fmt.Sprintf("Term overlaps with other terms (%s).", strings.Join(overlapping, ", ")))
+		}
+		if err := txStore.AddTerm(term); err != nil {
+			if IsUniqueViolation(err) {
+				return newAPIError(http.StatusConflict, err, fmt.Sprintf("Term (%s) already exists.", term.Code))
+			}
+			return newAPIError(http.StatusInternalServerError, err, "failed to add term.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusCreated, term)
+}
+
+// getRolloverSource returns the code of the term to roll over into the given term: the one asked for, or else the
+// term before it, or else the empty term of schools that did not have terms
+func getRolloverSource(store *Store, to *Term, from string) (string, *apiError) {
+	if from != "" {
+		term, err := store.GetTerm(from)
+		if err != nil {
+			return "", newAPIError(http.StatusInternalServerError, err, "failed to get term.")
+		}
+		if term == nil {
+			return "", newAPIError(http.StatusBadRequest, errNotFound, fmt.Sprintf("Term (%s) does not exist.", from))
+		}
+		if term.Code == to.Code {
+			return "", newAPIError(http.StatusBadRequest, nil, "A term cannot be rolled over into itself.")
+		}
+		return term.Code, nil
+	}
+
+	previous, err := store.GetPreviousTerm(to.StartsOn)
+	if err != nil {
+		return "", newAPIError(http.StatusInternalServerError, err, "failed to get term.")
+	}
+	if previous == nil {
+		return "", nil
+	}
+	return previous.Code, nil
+}
+
+// planRollover reports what rolling the roster over does with each of its classes, and returns the enrollments to
+// add to the new term
+func planRollover(roster []*RosterEntry, defaultAction string, classActions map[string]string) ([]*RolloverClass, []*Enrollment) {
+	classes := []*RolloverClass{}
+	enrollments := []*Enrollment{}
+
+	// The roster is sorted by class
+	var class *RolloverClass
+	for _, entry := range roster {
+		if class == nil || class.Code != entry.ClassCode {
+			action, ok := classActions[entry.ClassCode]
+			if !ok {
+				action = defaultAction
+			}
+			class = &RolloverClass{Code: entry.ClassCode, Action: action, Skipped: []string{}}
+			classes = append(classes, class)
+		}
+
+		class.Students++
+		if class.Action == RolloverActionArchive {
+			continue
+		}
+		if !entry.Active {
+			class.Skipped = append(class.Skipped, entry.StudentEmail)
+			continue
+		}
+		class.Copied++
+		enrollments = append(enrollments, NewEnrollment(entry.ClassCode, entry.StudentEmail))
+	}
+
+	return classes, enrollments
+}
+
+func handleRolloverTerm(c *gin.Context, store *Store) {
+	var input RolloverRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+	for code, action := range input.Classes {
+		if action != RolloverActionCopy && action != RolloverActionArchive {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Action (%s) of class %s must be copy or archive.", action, code)})
+			return
+		}
+	}
+	if input.Action == "" {
+		input.Action = RolloverActionCopy
+	}
+
+	to, err := store.GetTerm(c.Param("code"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get term."})
+		return
+	}
+	if to == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errNotFound.Error(), "message": "Given term does not exist."})
+		return
+	}
+	from, apiErr := getRolloverSource(store, to, input.From)
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	roster, err := store.ForTerm(from).GetRoster()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get roster."})
+		return
+	}
+	report := RolloverReport{From: from, To: to.Code, DryRun: input.DryRun}
+	var enrollments []*Enrollment
+	report.Classes, enrollments = planRollover(roster, input.Action, input.Classes)
+	if input.DryRun {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	entry := newAuditEntry(c, AuditActionRolloverTerm)
+	entry.After = toAuditPayload(report)
+
+	apiErr = withAudit(store, entry, func(txStore *Store) *apiError {
+		if len(enrollments) == 0 {
+			return nil
+		}
+		if err := txStore.ForTerm(to.Code).Enroll(enrollments); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to enroll students.")
+		}
+
+		classes, err := txStore.ListClasses("")
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to list classes.")
+		}
+		if err := enqueueEnrollmentWebhookEvents(txStore, classes, enrollments); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to queue webhook event.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanRollover(t *testing.T) {
+	roster := []*RosterEntry{
+		{ClassCode: "3A-MATH", StudentEmail: "student1@example.com", Active: true},
+		{ClassCode: "3A-MATH", StudentEmail: "student2@example.com", Active: false},
+		{ClassCode: "3B-MATH", StudentEmail: "student3@example.com", Active: true},
+		{ClassCode: "teacher@example.com", StudentEmail: "student1@example.com", Active: true},
+	}
+
+	classes, enrollments := planRollover(roster, RolloverActionCopy, map[string]string{"3B-MATH": RolloverActionArchive})
+	require.Equal(t, []*RolloverClass{
+		{Code: "3A-MATH", Action: RolloverActionCopy, Students: 2, Copied: 1, Skipped: []string{"student2@example.com"}},
+		{Code: "3B-MATH", Action: RolloverActionArchive, Students: 1, Copied: 0, Skipped: []string{}},
+		{Code: "teacher@example.com", Action: RolloverActionCopy, Students: 1, Copied: 1, Skipped: []string{}},
+	}, classes)
+	require.Equal(t, []*Enrollment{
+		NewEnrollment("3A-MATH", "student1@example.com"),
+		NewEnrollment("teacher@example.com", "student1@example.com"),
+	}, enrollments)
+
+	classes, enrollments = planRollover(roster, RolloverActionArchive, nil)
+	require.Len(t, classes, 3)
+	require.Empty(t, enrollments)
+}
+
+func TestRequireTerm(t *testing.T) {
+	db, mock := NewMockDB()
+	defer db.Close()
+
+	store := &Store{db: db, school: DefaultSchoolID}
+
+	// Stores scoped to a term, and those of schools without terms, may act on enrollments
+	require.Nil(t, requireTerm(store.ForTerm("2026-T1")))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM terms WHERE school_id=\\$1\\)").WithArgs(DefaultSchoolID).
+		WillReturnRows(mock.NewRows([]string{"exists"}).AddRow(false))
+	require.Nil(t, requireTerm(store))
+
+	// Schools with terms must give one between terms
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM terms WHERE school_id=\\$1\\)").WithArgs(DefaultSchoolID).
+		WillReturnRows(mock.NewRows([]string{"exists"}).AddRow(true))
+	apiErr := requireTerm(store)
+	require.NotNil(t, apiErr)
+	require.Equal(t, http.StatusConflict, apiErr.Status)
+	require.Equal(t, errNoCurrentTerm, apiErr.Err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,30 +1,197 @@
 package main
 
-import "time"
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+)
+
+// Schools each have their own teachers, students and classes. Data of one school is never visible to another.
+type School struct {
+	ID   string `json:"id" db:"id"`
+	Name string `json:"name" db:"name"`
+	// Domains that emails of the school's teachers and students must be in, any domain if empty
+	AllowedDomains pq.StringArray `json:"allowedDomains" db:"allowed_domains"`
+}
+
+// Rows created before schools belong to the default school, which is also used when a request names none
+const DefaultSchoolID = "default"
+
+func NewSchool(id string, name string, allowedDomains []string) *School {
+	return &School{
+		ID:             id,
+		Name:           name,
+		AllowedDomains: allowedDomains,
+	}
+}
+
+// Optional profile data shared by teachers and students
+type Profile struct {
+	GivenName     string `json:"givenName,omitempty" db:"given_name"`
+	FamilyName    string `json:"familyName,omitempty" db:"family_name"`
+	PreferredName string `json:"preferredName,omitempty" db:"preferred_name"`
+	SISID         string `json:"sisId,omitempty" db:"sis_id"`
+	Homeroom      string `json:"homeroom,omitempty" db:"homeroom"`
+	Active        bool   `json:"active" db:"active"`
+}
 
 type Teacher struct {
-	Email string `json:"email"`
+	Email string `json:"email" db:"email"`
+	Profile
 }
 
 func NewTeacher(email string) *Teacher {
 	return &Teacher{
-		Email: email,
+		Email:   email,
+		Profile: Profile{Active: true},
 	}
 }
 
 type Student struct {
-	Email string `json:"email"`
+	Email string `json:"email" db:"email"`
+	Profile
+	GradeLevel string `json:"gradeLevel,omitempty" db:"grade_level"`
 }
 
 func NewStudent(email string) *Student {
 	return &Student{
-		Email: email,
+		Email:   email,
+		Profile: Profile{Active: true},
 	}
 }
 
+type Class struct {
+	Code     string         `json:"code" db:"code"`
+	Name     string         `json:"name" db:"name"`
+	Term     string         `json:"term" db:"term"`
+	Teachers pq.StringArray `json:"teachers" db:"teachers" format:"email"`
+	// Default classes hold the students registered to a teacher without a class
+	IsDefault bool `json:"isDefault" db:"is_default"`
+}
+
+func NewClass(code string, name string, term string, teacherEmails []string) *Class {
+	return &Class{
+		Code:     code,
+		Name:     name,
+		Term:     term,
+		Teachers: teacherEmails,
+	}
+}
+
+// The code of a teacher's default class is their email, which codes of other classes may not contain
+func DefaultClassCode(teacherEmail string) string {
+	return teacherEmail
+}
+
+type Enrollment struct {
+	ClassCode    string `json:"classCode" db:"class_code"`
+	StudentEmail string `json:"studentEmail" db:"student_email"`
+}
+
+func NewEnrollment(classCode string, studentEmail string) *Enrollment {
+	return &Enrollment{
+		ClassCode:    classCode,
+		StudentEmail: studentEmail,
+	}
+}
+
+// Guardians are parents or other contacts of students. A guardian may be linked to several students, and only gets
+// their notifications when opted in.
+type Guardian struct {
+	Email        string `json:"email" db:"email"`
+	Name         string `json:"name" db:"name"`
+	Relationship string `json:"relationship" db:"relationship"`
+	OptedIn      bool   `json:"optedIn" db:"opted_in"`
+}
+
+func NewGuardian(email string, name string, relationship string, optedIn bool) *Guardian {
+	return &Guardian{
+		Email:        email,
+		Name:         name,
+		Relationship: relationship,
+		OptedIn:      optedIn,
+	}
+}
+
+// A student's choice of which non-urgent notifications they get. Students without saved preferences get every
+// notification, see NewNotificationPreferences.
+type NotificationPreferences struct {
+	// Channels the student may be notified through
+	Channels        pq.StringArray `json:"channels" db:"channels"`
+	MutedCategories pq.StringArray `json:"mutedCategories" db:"muted_categories"`
+	// Daily window, as HH:MM in the time zone, during which the student is not notified. May wrap past midnight.
+	QuietHoursStart string `json:"quietHoursStart,omitempty" db:"quiet_hours_start"`
+	QuietHoursEnd   string `json:"quietHoursEnd,omitempty" db:"quiet_hours_end"`
+	TimeZone        string `json:"timeZone" db:"time_zone"`
+}
+
+func NewNotificationPreferences() *NotificationPreferences {
+	return &NotificationPreferences{
+		Channels:        slices.Clone(NotificationChannels),
+		MutedCategories: pq.StringArray{},
+		TimeZone:        "UTC",
+	}
+}
+
+// Terms are the school's academic terms. Enrollments belong to a term, so rosters start over every term unless
+// they are rolled over.
+type Term struct {
+	Code     string `json:"code" db:"code"`
+	Name     string `json:"name" db:"name"`
+	StartsOn string `json:"startsOn" db:"starts_on" format:"date"`
+	EndsOn   string `json:"endsOn" db:"ends_on" format:"date"`
+	// Whether this is the term requests default to, the latest one to have started
+	Current bool `json:"current" db:"-"`
+}
+
+func NewTerm(code string, name string, startsOn string, endsOn string) *Term {
+	return &Term{
+		Code:     code,
+		Name:     name,
+		StartsOn: startsOn,
+		EndsOn:   endsOn,
+	}
+}
+
+// A student enrolled in a class in some term, with whether they are still active
+type RosterEntry struct {
+	ClassCode    string `db:"class_code"`
+	StudentEmail string `db:"student_email"`
+	Active       bool   `db:"active"`
+}
+
+// Changes to a profile. Fields left out are unchanged, empty strings clear them.
+type ProfileUpdate struct {
+	GivenName     *string `json:"givenName,omitempty"`
+	FamilyName    *string `json:"familyName,omitempty"`
+	PreferredName *string `json:"preferredName,omitempty"`
+	SISID         *string `json:"sisId,omitempty"`
+	Homeroom      *string `json:"homeroom,omitempty"`
+	Active        *bool   `json:"active,omitempty"`
+}
+
+type StudentProfileUpdate struct {
+	ProfileUpdate
+	GradeLevel *string `json:"gradeLevel,omitempty"`
+}
+
+// Filters of teacher and student listings, zero values match everything
+type ProfileFilter struct {
+	// Matched against emails, names and SIS IDs
+	Query      string
+	Active     *bool
+	Homeroom   string
+	GradeLevel string
+	// Only list these, nil for no restriction
+	Emails []string
+}
+
 type TeacherStudentPair struct {
-	TeacherEmail string `json:"teacherEmail"`
-	StudentEmail string `json:"studentEmail"`
+	TeacherEmail string `json:"teacherEmail" db:"teacher_email"`
+	StudentEmail string `json:"studentEmail" db:"student_email"`
 }
 
 func NewTeacherStudentPair(teacherEmail string, studentEmail string) *TeacherStudentPair {
@@ -34,10 +201,43 @@ func NewTeacherStudentPair(teacherEmail string, studentEmail string) *TeacherStu
 	}
 }
 
+// Whether a mentioned email is a student and whether they are suspended, see ResolveMentions
+type MentionStatus struct {
+	Email     string `db:"email"`
+	Exists    bool   `db:"student_exists"`
+	Suspended bool   `db:"suspended"`
+}
+
+// A mention found in a notification
+type Mention struct {
+	Kind string `json:"kind"`
+	// Email of student and teacher mentions, code of class mentions, name of group mentions
+	Value string `json:"value"`
+	// Display name of @"Name" <email> mentions
+	Name string `json:"name,omitempty"`
+	Text string `json:"text"`
+	// Offsets of the mention in the text counted in Unicode code points, so an emoji counts once where JavaScript
+	// strings, which count UTF-16 code units, count it twice
+	Start int `json:"start" description:"Offset of the first code point of the mention, in Unicode code points rather than UTF-16 code units"`
+	End   int `json:"end" description:"Offset after the last code point of the mention, in Unicode code points rather than UTF-16 code units"`
+}
+
+// Who a notification is addressed to, see GetNotificationRecipients
+type NotificationAudience struct {
+	Teacher *Teacher
+	// Only the students of this class of the teacher instead of all those registered to them
+	Class string
+	// Mentioned students, classes and teachers, and whether @all-suspended-excluded was mentioned
+	Students    []string
+	Classes     []string
+	Teachers    []string
+	AllStudents bool
+}
+
 type Suspension struct {
-	Email          string    `json:"email"`
-	SuspendedAt    time.Time `json:"suspendedAt"`
-	SuspendedUntil time.Time `json:"suspendedUntil"`
+	Email          string     `json:"email" db:"student_email"`
+	SuspendedAt    time.Time  `json:"suspendedAt" db:"suspended_at"`
+	SuspendedUntil *time.Time `json:"suspendedUntil" db:"suspended_until"`
 }
 
 func NewSuspension(email string) *Suspension {
@@ -46,3 +246,750 @@ func NewSuspension(email string) *Suspension {
 		SuspendedAt: time.Now().UTC(),
 	}
 }
+
+type APIKey struct {
+	ID        int64      `json:"id" db:"id"`
+	Name      string     `json:"name" db:"name"`
+	Subject   string     `json:"subject" db:"subject"`
+	Role      string     `json:"role" db:"role"`
+	KeyPrefix string     `json:"keyPrefix" db:"key_prefix"`
+	KeyHash   string     `json:"-" db:"key_hash"`
+	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
+	RevokedAt *time.Time `json:"revokedAt" db:"revoked_at"`
+	// Empty for keys that may act in any school
+	School string `json:"school" db:"school_id"`
+}
+
+func NewAPIKey(name string, subject string, role string, key string) *APIKey {
+	return &APIKey{
+		Name:      name,
+		Subject:   subject,
+		Role:      role,
+		KeyPrefix: key[:len(apiKeyPrefix)+6],
+		KeyHash:   HashAPIKey(key),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+type IdempotencyRecord struct {
+	Caller       string     `json:"caller" db:"caller"`
+	Key          string     `json:"key" db:"idempotency_key"`
+	RequestHash  string     `json:"requestHash" db:"request_hash"`
+	StatusCode   int        `json:"statusCode" db:"status_code"`
+	ContentType  string     `json:"contentType" db:"content_type"`
+	ResponseBody []byte     `json:"-" db:"response_body"`
+	CreatedAt    time.Time  `json:"createdAt" db:"created_at"`
+	CompletedAt  *time.Time `json:"completedAt" db:"completed_at"`
+}
+
+func NewIdempotencyRecord(caller string, key string, requestHash string) *IdempotencyRecord {
+	return &IdempotencyRecord{
+		Caller:      caller,
+		Key:         key,
+		RequestHash: requestHash,
+		CreatedAt:   time.Now().UTC(),
+	}
+}
+
+type AuditEntry struct {
+	ID            int64          `json:"id" db:"id"`
+	OccurredAt    time.Time      `json:"occurredAt" db:"occurred_at"`
+	Actor         string         `json:"actor" db:"actor"`
+	ActorRole     string         `json:"actorRole" db:"actor_role"`
+	Action        string         `json:"action" db:"action"`
+	TeacherEmail  string         `json:"teacher" db:"teacher_email"`
+	StudentEmails pq.StringArray `json:"students" db:"student_emails"`
+	Before        types.JSONText `json:"before" db:"before"`
+	After         types.JSONText `json:"after" db:"after"`
+	RequestID     string         `json:"requestId" db:"request_id"`
+}
+
+func NewAuditEntry(actor string, actorRole string, action string, requestID string) *AuditEntry {
+	return &AuditEntry{
+		OccurredAt:    time.Now().UTC(),
+		Actor:         actor,
+		ActorRole:     actorRole,
+		Action:        action,
+		StudentEmails: pq.StringArray{},
+		Before:        types.JSONText("null"),
+		After:         types.JSONText("null"),
+		RequestID:     requestID,
+	}
+}
+
+type AuditFilter struct {
+	Actor        string
+	Action       string
+	TeacherEmail string
+	StudentEmail string
+	Since        *time.Time
+	Until        *time.Time
+	// Only return entries with an id lower than this, for cursor pagination
+	BeforeID int64
+	Limit    int
+}
+
+// Request and response bodies of the API, also used to generate the OpenAPI document
+
+type RegisterRequest struct {
+	Teacher  string   `json:"teacher" binding:"required" format:"email"`
+	Students []string `json:"students" format:"email"`
+	// Enroll the students in this class of the teacher instead of their default class
+	Class string `json:"class,omitempty"`
+	// Optional profile changes saved along with the registration, student profiles are keyed by email
+	TeacherProfile  *ProfileUpdate                   `json:"teacherProfile,omitempty"`
+	StudentProfiles map[string]*StudentProfileUpdate `json:"studentProfiles,omitempty"`
+}
+
+type SuspendRequest struct {
+	Student string `json:"student" binding:"required" format:"email"`
+}
+
+type RetrieveNotificationsRequest struct {
+	Teacher string `json:"teacher" binding:"required" format:"email"`
+	// The text of the notification, or the id of a template rendered for each recipient with the variables
+	Notification string            `json:"notification" binding:"required_without=TemplateID"`
+	TemplateID   string            `json:"templateId,omitempty"`
+	Variables    map[string]string `json:"variables,omitempty"`
+	// Only notify the students of this class of the teacher, besides those mentioned
+	Class string `json:"class,omitempty"`
+	// Also return the opted-in guardians of the students notified
+	IncludeGuardians bool `json:"includeGuardians,omitempty"`
+	// Students who muted the category, disabled the channel or are in their quiet hours are not notified,
+	// unless the notification is urgent
+	Category string `json:"category,omitempty"`
+	Channel  string `json:"channel,omitempty" binding:"omitempty,oneof=email sms push"`
+	Urgent   bool   `json:"urgent,omitempty"`
+	// Explain why each student was included or excluded, without changing who is notified
+	Verbose bool `json:"verbose,omitempty"`
+	// What to do with mentions of unknown students: strict fails the request (the default), skip leaves them out and
+	// auto_register adds them as students, also registered to the teacher with registerMentioned. Both warn about them.
+	UnknownMentions   string `json:"unknownMentions,omitempty" binding:"omitempty,oneof=strict skip auto_register"`
+	RegisterMentioned bool   `json:"registerMentioned,omitempty"`
+}
+
+type ParseMentionsRequest struct {
+	Text string `json:"text" binding:"required"`
+}
+
+type MentionsResponse struct {
+	Mentions []*Mention `json:"mentions"`
+}
+
+type CommonStudentsResponse struct {
+	Students []string `json:"students" format:"email"`
+}
+
+type RetrieveNotificationsResponse struct {
+	Recipients []string `json:"recipients" format:"email"`
+	// Opted-in guardians of the recipients, only given with includeGuardians and left out when there are none
+	GuardianRecipients []string `json:"guardianRecipients,omitempty" format:"email"`
+	// Students left out because of their notification preferences
+	Excluded []*ExcludedRecipient `json:"excluded,omitempty"`
+	// Unknown mentions skipped or registered, see unknownMentions
+	Warnings []*NotificationWarning `json:"warnings,omitempty"`
+	// Every student considered, sorted by email, and the mentions of the notification, only given with verbose
+	Explanations []*RecipientExplanation `json:"explanations,omitempty"`
+	Mentions     []*Mention              `json:"mentions,omitempty"`
+	// The template rendered for each recipient, only given with templateId
+	RenderedNotifications []*RenderedNotification `json:"renderedNotifications,omitempty"`
+}
+
+type RenderedNotification struct {
+	Recipient    string `json:"recipient" format:"email"`
+	Notification string `json:"notification"`
+}
+
+// Why a student is or is not a recipient. Students with any exclusion reason are not.
+type RecipientExplanation struct {
+	Email    string `json:"email" format:"email"`
+	Included bool   `json:"included"`
+	// registered, class, mentioned, mentioned_class, mentioned_teacher, mentioned_group or mention_registered
+	InclusionReasons []string           `json:"inclusionReasons"`
+	ExclusionReasons []*ExclusionReason `json:"exclusionReasons"`
+}
+
+type ExclusionReason struct {
+	// suspended, unregistered_mention, or one of the reasons of ExcludedRecipient
+	Reason string `json:"reason"`
+	// End of the suspension, left out for indefinite ones
+	Until *time.Time `json:"until,omitempty"`
+}
+
+type NotificationWarning struct {
+	// unknown_mention_skipped or mention_registered
+	Code string `json:"code"`
+	// Email of the student or teacher, or code of the class, mentioned
+	Mention string `json:"mention"`
+	Message string `json:"message"`
+}
+
+type ExcludedRecipient struct {
+	Email  string `json:"email" format:"email"`
+	Reason string `json:"reason"`
+}
+
+type AuditLogResponse struct {
+	Entries    []*AuditEntry `json:"entries"`
+	NextCursor *string       `json:"nextCursor"`
+}
+
+type ErrorResponse struct {
+	Error   string `json:"error,omitempty"`
+	Message string `json:"message"`
+}
+
+type CreateTeacherRequest struct {
+	Email string `json:"email" binding:"required" format:"email"`
+	ProfileUpdate
+}
+
+type CreateStudentRequest struct {
+	Email string `json:"email" binding:"required" format:"email"`
+	StudentProfileUpdate
+}
+
+type CreateClassRequest struct {
+	Code     string   `json:"code" binding:"required"`
+	Name     string   `json:"name"`
+	Term     string   `json:"term"`
+	Teachers []string `json:"teachers" binding:"required" format:"email"`
+}
+
+// Fields left out are unchanged
+type UpdateClassRequest struct {
+	Name     *string  `json:"name,omitempty"`
+	Term     *string  `json:"term,omitempty"`
+	Teachers []string `json:"teachers,omitempty" format:"email"`
+}
+
+type ClassesResponse struct {
+	Classes []*Class `json:"classes"`
+}
+
+// Fields left out are unchanged
+type UpdateSchoolRequest struct {
+	Name           *string  `json:"name,omitempty"`
+	AllowedDomains []string `json:"allowedDomains,omitempty"`
+}
+
+// Links the guardian to the student, or changes the link. Guardians are created on their first link.
+type LinkGuardianRequest struct {
+	Name         string `json:"name"`
+	Relationship string `json:"relationship"`
+	OptedIn      bool   `json:"optedIn"`
+}
+
+type GuardiansResponse struct {
+	Guardians []*Guardian `json:"guardians"`
+}
+
+type ScheduleNotificationRequest struct {
+	RetrieveNotificationsRequest
+	SendAt time.Time `json:"sendAt" binding:"required"`
+}
+
+type ScheduledNotificationsResponse struct {
+	ScheduledNotifications []*ScheduledNotification `json:"scheduledNotifications"`
+}
+
+type RecurringNotificationRequest struct {
+	RetrieveNotificationsRequest
+	// A cron expression such as "0 7 * * MON", or an RRULE such as "FREQ=WEEKLY;BYDAY=MO;BYHOUR=7;BYMINUTE=0"
+	Schedule string `json:"schedule" binding:"required"`
+	// Time zone of the schedule, UTC by default
+	TimeZone string `json:"timeZone,omitempty"`
+	// No occurrence is sent before this time, now by default. RRULEs take their default day and time from it.
+	StartsAt *time.Time `json:"startsAt,omitempty"`
+	// Last day of occurrences in the time zone
+	EndsOn string `json:"endsOn,omitempty" format:"date"`
+	// Most occurrences to send, holidays skipped are not counted
+	Count int `json:"count,omitempty" binding:"omitempty,min=1"`
+}
+
+type RecurringNotificationsResponse struct {
+	RecurringNotifications []*RecurringNotification `json:"recurringNotifications"`
+}
+
+type SetHolidayRequest struct {
+	Name string `json:"name"`
+}
+
+type HolidaysResponse struct {
+	Holidays []*Holiday `json:"holidays"`
+}
+
+type TemplateRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+	// A Go text/template, see the README for its variables and functions
+	Body string `json:"body" binding:"required"`
+}
+
+type TemplatesResponse struct {
+	Templates []*NotificationTemplate `json:"templates"`
+}
+
+// Renders a stored template, or the given body, as it would be for the student
+type PreviewTemplateRequest struct {
+	TemplateID string            `json:"templateId,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	Teacher    string            `json:"teacher,omitempty" format:"email"`
+	Class      string            `json:"class,omitempty"`
+	Student    string            `json:"student,omitempty" format:"email"`
+	Variables  map[string]string `json:"variables,omitempty"`
+}
+
+type PreviewTemplateResponse struct {
+	Notification string     `json:"notification"`
+	Mentions     []*Mention `json:"mentions"`
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url" binding:"required,url,max=500"`
+	Events []string `json:"events" binding:"required,min=1,dive,oneof=student.registered student.suspended student.unsuspended student.notified"`
+	// Generated when not given
+	Secret string `json:"secret,omitempty" binding:"omitempty,min=16,max=200"`
+	// Defaults to true
+	Active *bool `json:"active,omitempty"`
+}
+
+// Only the given fields are changed
+type UpdateWebhookRequest struct {
+	URL    *string  `json:"url,omitempty" binding:"omitempty,url,max=500"`
+	Events []string `json:"events,omitempty" binding:"omitempty,min=1,dive,oneof=student.registered student.suspended student.unsuspended student.notified"`
+	Secret *string  `json:"secret,omitempty" binding:"omitempty,min=16,max=200"`
+	Active *bool    `json:"active,omitempty"`
+}
+
+type WebhooksResponse struct {
+	Webhooks []*Webhook `json:"webhooks"`
+}
+
+type WebhookDeliveriesResponse struct {
+	Deliveries []*WebhookDelivery `json:"deliveries"`
+	NextCursor *string            `json:"nextCursor"`
+}
+
+type CreateTermRequest struct {
+	Code     string `json:"code" binding:"required"`
+	Name     string `json:"name"`
+	StartsOn string `json:"startsOn" binding:"required" format:"date"`
+	EndsOn   string `json:"endsOn" binding:"required" format:"date"`
+}
+
+type TermsResponse struct {
+	Terms []*Term `json:"terms"`
+}
+
+type RolloverRequest struct {
+	// Term to copy rosters from, the current term if left out
+	From string `json:"from"`
+	// copy or archive, what to do with classes not in Classes. Defaults to copy.
+	Action string `json:"action" binding:"omitempty,oneof=copy archive"`
+	// Action per class code
+	Classes map[string]string `json:"classes"`
+	// Only report what would be rolled over
+	DryRun bool `json:"dryRun"`
+}
+
+type RolloverReport struct {
+	From    string           `json:"from"`
+	To      string           `json:"to"`
+	DryRun  bool             `json:"dryRun"`
+	Classes []*RolloverClass `json:"classes"`
+}
+
+// What a rollover does with one class. Archived classes keep their roster in the old term only, copied classes
+// get their active students enrolled in the new term too.
+type RolloverClass struct {
+	Code     string `json:"code"`
+	Action   string `json:"action"`
+	Students int    `json:"students"`
+	Copied   int    `json:"copied"`
+	// Inactive students, who are never copied
+	Skipped []string `json:"skipped" format:"email"`
+}
+
+type TeachersResponse struct {
+	Teachers []*Teacher `json:"teachers"`
+}
+
+type StudentsResponse struct {
+	Students []*Student `json:"students"`
+}
+
+type SuspensionsResponse struct {
+	Suspensions []*Suspension `json:"suspensions"`
+}
+
+const (
+	ImportJobPending   = "pending"
+	ImportJobRunning   = "running"
+	ImportJobSucceeded = "succeeded"
+	ImportJobFailed    = "failed"
+)
+
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+type ImportJob struct {
+	ID            string         `json:"id" db:"id"`
+	Status        string         `json:"status" db:"status"`
+	DryRun        bool           `json:"dryRun" db:"dry_run"`
+	CreatedBy     string         `json:"createdBy" db:"created_by"`
+	RowsProcessed int            `json:"rowsProcessed" db:"rows_processed"`
+	RowsImported  int            `json:"rowsImported" db:"rows_imported"`
+	RowsFailed    int            `json:"rowsFailed" db:"rows_failed"`
+	Errors        types.JSONText `json:"errors" db:"errors"`
+	Message       string         `json:"message,omitempty" db:"message"`
+	CreatedAt     time.Time      `json:"createdAt" db:"created_at"`
+	FinishedAt    *time.Time     `json:"finishedAt" db:"finished_at"`
+}
+
+func NewImportJob(id string, createdBy string, dryRun bool) *ImportJob {
+	return &ImportJob{
+		ID:        id,
+		Status:    ImportJobPending,
+		DryRun:    dryRun,
+		CreatedBy: createdBy,
+		Errors:    types.JSONText("[]"),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+const (
+	ScheduledNotificationPending   = "pending"
+	ScheduledNotificationSent      = "sent"
+	ScheduledNotificationFailed    = "failed"
+	ScheduledNotificationCancelled = "cancelled"
+)
+
+// A notification sent by the scheduler at SendAt. Its recipients are only resolved then, in its term.
+type ScheduledNotification struct {
+	ID      string `json:"id" db:"id"`
+	Teacher string `json:"teacher" db:"teacher_email" format:"email"`
+	// Only set when scheduled with ?term=, otherwise the notification is sent in the term current at that time
+	Term string `json:"term" db:"term"`
+	// The RetrieveNotificationsRequest to send
+	Request       types.JSONText `json:"request" db:"request"`
+	SendAt        time.Time      `json:"sendAt" db:"send_at"`
+	Status        string         `json:"status" db:"status"`
+	CreatedBy     string         `json:"createdBy" db:"created_by"`
+	CreatedByRole string         `json:"-" db:"created_by_role"`
+	RequestID     string         `json:"-" db:"request_id"`
+	CreatedAt     time.Time      `json:"createdAt" db:"created_at"`
+	SentAt        *time.Time     `json:"sentAt" db:"sent_at"`
+	// The RetrieveNotificationsResponse once sent
+	Result  types.JSONText `json:"result" db:"result"`
+	Message string         `json:"message,omitempty" db:"message"`
+	// Attempts that failed, server errors are retried later with backoff
+	Attempts int `json:"attempts" db:"attempts"`
+	// The recurring notification this is an occurrence of, if any
+	RecurringID string `json:"recurringId,omitempty" db:"recurring_id"`
+	SchoolID    string `json:"-" db:"school_id"`
+}
+
+// NewScheduledNotification returns a pending notification created by the actor of the audit entry
+func NewScheduledNotification(id string, term string, input ScheduleNotificationRequest, entry *AuditEntry) (*ScheduledNotification, error) {
+	// Scheduled notifications are never verbose, there is no one to read the explanations
+	input.Verbose = false
+	request, err := json.Marshal(input.RetrieveNotificationsRequest)
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduledNotification{
+		ID:            id,
+		Teacher:       input.Teacher,
+		Term:          term,
+		Request:       types.JSONText(request),
+		SendAt:        input.SendAt.UTC(),
+		Status:        ScheduledNotificationPending,
+		CreatedBy:     entry.Actor,
+		CreatedByRole: entry.ActorRole,
+		RequestID:     entry.RequestID,
+		CreatedAt:     time.Now().UTC(),
+		Result:        types.JSONText("null"),
+	}, nil
+}
+
+const (
+	RecurringNotificationActive    = "active"
+	RecurringNotificationFinished  = "finished"
+	RecurringNotificationCancelled = "cancelled"
+)
+
+// A notification sent on a schedule. Its next occurrence is always pending as a ScheduledNotification, so that it is
+// sent, changed and cancelled like any other.
+type RecurringNotification struct {
+	ID      string `json:"id" db:"id"`
+	Teacher string `json:"teacher" db:"teacher_email" format:"email"`
+	// The RetrieveNotificationsRequest sent at every occurrence
+	Request  types.JSONText `json:"request" db:"request"`
+	Schedule string         `json:"schedule" db:"schedule"`
+	TimeZone string         `json:"timeZone" db:"time_zone"`
+	StartsAt time.Time      `json:"startsAt" db:"starts_at"`
+	// Last day of occurrences in the time zone, none if empty
+	EndsOn string `json:"endsOn,omitempty" db:"ends_on" format:"date"`
+	// Most occurrences to schedule, unlimited if 0
+	Count         int        `json:"count,omitempty" db:"max_occurrences"`
+	Occurrences   int        `json:"occurrences" db:"occurrences"`
+	NextAt        *time.Time `json:"nextAt" db:"next_at"`
+	Status        string     `json:"status" db:"status"`
+	CreatedBy     string     `json:"createdBy" db:"created_by"`
+	CreatedByRole string     `json:"-" db:"created_by_role"`
+	RequestID     string     `json:"-" db:"request_id"`
+	CreatedAt     time.Time  `json:"createdAt" db:"created_at"`
+	SchoolID      string     `json:"-" db:"school_id"`
+}
+
+// NewRecurringNotification returns an active recurring notification created by the actor of the audit entry, starting
+// now unless the input says otherwise
+func NewRecurringNotification(id string, input RecurringNotificationRequest, entry *AuditEntry) (*RecurringNotification, error) {
+	input.Verbose = false
+	request, err := json.Marshal(input.RetrieveNotificationsRequest)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	startsAt := now
+	if input.StartsAt != nil {
+		startsAt = input.StartsAt.UTC()
+	}
+	timeZone := input.TimeZone
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+	return &RecurringNotification{
+		ID:            id,
+		Teacher:       input.Teacher,
+		Request:       types.JSONText(request),
+		Schedule:      input.Schedule,
+		TimeZone:      timeZone,
+		StartsAt:      startsAt,
+		EndsOn:        input.EndsOn,
+		Count:         input.Count,
+		Status:        RecurringNotificationActive,
+		CreatedBy:     entry.Actor,
+		CreatedByRole: entry.ActorRole,
+		RequestID:     entry.RequestID,
+		CreatedAt:     now,
+	}, nil
+}
+
+// A notification text with placeholders, shared by the teachers of a school
+type NotificationTemplate struct {
+	ID        string    `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	Body      string    `json:"body" db:"body"`
+	CreatedBy string    `json:"createdBy" db:"created_by"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+func NewNotificationTemplate(id string, input TemplateRequest, createdBy string) *NotificationTemplate {
+	now := time.Now().UTC()
+	return &NotificationTemplate{
+		ID:        id,
+		Name:      input.Name,
+		Body:      input.Body,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// A notification as received by one of its recipients, rendered for them if it was sent with a template. Ids
+// increase, so that streams resume after the last one they received.
+type StudentNotification struct {
+	ID int64 `json:"id" db:"id"`
+	// Shared by the recipients of the same notification
+	NotificationID string    `json:"notificationId" db:"notification_id"`
+	StudentEmail   string    `json:"student" db:"student_email" format:"email"`
+	Teacher        string    `json:"teacher" db:"teacher_email" format:"email"`
+	Notification   string    `json:"notification" db:"notification"`
+	Category       string    `json:"category,omitempty" db:"category"`
+	Urgent         bool      `json:"urgent,omitempty" db:"urgent"`
+	CreatedAt      time.Time `json:"createdAt" db:"created_at"`
+	// Null until the student reads it
+	ReadAt   *time.Time `json:"readAt" db:"read_at"`
+	SchoolID string     `json:"-" db:"school_id"`
+}
+
+// A message of notification WebSockets
+type StreamMessage struct {
+	Type         string               `json:"type"`
+	Notification *StudentNotification `json:"notification,omitempty"`
+}
+
+// Filters notifications of a student's inbox, newest first
+type InboxFilter struct {
+	UnreadOnly bool
+	// Only return notifications with an id lower than this, for cursor pagination
+	BeforeID int64
+	Limit    int
+}
+
+type InboxResponse struct {
+	Notifications []*StudentNotification `json:"notifications"`
+	// Unread notifications of the whole inbox
+	Unread     int     `json:"unread"`
+	NextCursor *string `json:"nextCursor"`
+}
+
+type UnreadCountResponse struct {
+	Unread int `json:"unread"`
+	// Unread notifications by category, those without one under ""
+	Categories map[string]int `json:"categories"`
+}
+
+type MarkReadResponse struct {
+	Marked int64 `json:"marked"`
+}
+
+// A notification as sent by a teacher, with how many of its recipients have read it
+type SentNotification struct {
+	NotificationID string `json:"notificationId" db:"notification_id"`
+	Teacher        string `json:"teacher" db:"teacher_email" format:"email"`
+	// What the first recipient received, templated notifications differ by recipient
+	Notification string    `json:"notification" db:"notification"`
+	Category     string    `json:"category,omitempty" db:"category"`
+	Urgent       bool      `json:"urgent,omitempty" db:"urgent"`
+	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
+	Recipients   int       `json:"recipients" db:"recipients"`
+	Read         int       `json:"read" db:"read"`
+	// Only given for a single notification
+	Receipts []*ReadReceipt `json:"receipts,omitempty"`
+	// Id of the first recipient's notification, for cursor pagination
+	FirstID int64 `json:"-" db:"first_id"`
+}
+
+type SentNotificationsResponse struct {
+	SentNotifications []*SentNotification `json:"sentNotifications"`
+	NextCursor        *string             `json:"nextCursor"`
+}
+
+type ReadReceipt struct {
+	StudentEmail string     `json:"student" db:"student_email" format:"email"`
+	ReadAt       *time.Time `json:"readAt" db:"read_at"`
+}
+
+// A day of a school on which recurring notifications are not sent
+type Holiday struct {
+	Date string `json:"date" db:"date" format:"date"`
+	Name string `json:"name" db:"name"`
+}
+
+type StudentExportRow struct {
+	Email          string     `json:"email" db:"email"`
+	Suspended      bool       `json:"suspended" db:"suspended"`
+	SuspendedUntil *time.Time `json:"suspendedUntil" db:"suspended_until"`
+}
+
+type NotificationExportRow struct {
+	SentAt       time.Time      `json:"sentAt" db:"sent_at"`
+	TeacherEmail string         `json:"teacher" db:"teacher_email"`
+	Notification string         `json:"notification" db:"notification"`
+	Recipients   pq.StringArray `json:"recipients" db:"recipients"`
+}
+
+const (
+	WebhookEventStudentRegistered  = "student.registered"
+	WebhookEventStudentSuspended   = "student.suspended"
+	WebhookEventStudentUnsuspended = "student.unsuspended"
+	WebhookEventStudentNotified    = "student.notified"
+)
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// A URL of a school receiving the events it subscribed to. The secret signs deliveries and is only returned when set.
+type Webhook struct {
+	ID        string         `json:"id" db:"id"`
+	URL       string         `json:"url" db:"url"`
+	Events    pq.StringArray `json:"events" db:"event_types"`
+	Active    bool           `json:"active" db:"active"`
+	Secret    string         `json:"secret,omitempty" db:"secret"`
+	CreatedBy string         `json:"createdBy" db:"created_by"`
+	CreatedAt time.Time      `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time      `json:"updatedAt" db:"updated_at"`
+}
+
+func NewWebhook(id string, input CreateWebhookRequest, secret string, createdBy string) *Webhook {
+	now := time.Now().UTC()
+	active := input.Active == nil || *input.Active
+	return &Webhook{
+		ID:        id,
+		URL:       input.URL,
+		Events:    input.Events,
+		Active:    active,
+		Secret:    secret,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// The body of webhook deliveries. Redeliveries and retries send the same event, with the same id.
+type WebhookEvent struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	OccurredAt time.Time   `json:"occurredAt"`
+	School     string      `json:"school"`
+	Data       interface{} `json:"data"`
+}
+
+// Data of student.registered events
+type WebhookRegistrationData struct {
+	Teacher  string   `json:"teacher"`
+	Students []string `json:"students"`
+	// Given when the students were enrolled in a class of the teacher
+	Class string `json:"class,omitempty"`
+}
+
+// Data of student.suspended and student.unsuspended events
+type WebhookSuspensionData struct {
+	Student        string     `json:"student"`
+	SuspendedUntil *time.Time `json:"suspendedUntil,omitempty"`
+}
+
+// Data of student.notified events
+type WebhookNotificationData struct {
+	NotificationID string   `json:"notificationId"`
+	Teacher        string   `json:"teacher"`
+	Notification   string   `json:"notification"`
+	Category       string   `json:"category,omitempty"`
+	Urgent         bool     `json:"urgent,omitempty"`
+	Recipients     []string `json:"recipients"`
+}
+
+// An event to deliver to a webhook, written to the outbox in the transaction of the change, and the outcome of its
+// last attempt
+type WebhookDelivery struct {
+	ID             int64          `json:"id" db:"id"`
+	WebhookID      string         `json:"webhookId" db:"webhook_id"`
+	EventID        string         `json:"eventId" db:"event_id"`
+	EventType      string         `json:"eventType" db:"event_type"`
+	Payload        types.JSONText `json:"payload" db:"payload"`
+	Status         string         `json:"status" db:"status"`
+	Attempts       int            `json:"attempts" db:"attempts"`
+	NextAttemptAt  *time.Time     `json:"nextAttemptAt" db:"next_attempt_at"`
+	LastAttemptAt  *time.Time     `json:"lastAttemptAt" db:"last_attempt_at"`
+	ResponseStatus int            `json:"responseStatus,omitempty" db:"response_status"`
+	LastError      string         `json:"lastError,omitempty" db:"last_error"`
+	// Id of the delivery this one sends again
+	RedeliveryOf *int64     `json:"redeliveryOf,omitempty" db:"redelivery_of"`
+	CreatedAt    time.Time  `json:"createdAt" db:"created_at"`
+	DeliveredAt  *time.Time `json:"deliveredAt" db:"delivered_at"`
+}
+
+// A delivery claimed by the dispatcher, with where to send it and how to sign it
+type DueWebhookDelivery struct {
+	WebhookDelivery
+	URL    string `db:"url"`
+	Secret string `db:"secret"`
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/mail"
 	"os"
+	"regexp"
 
 	"github.com/joho/godotenv"
 )
@@ -32,3 +33,31 @@ func IsValidEmail(email string) bool {
 	_, err := mail.ParseAddress(email)
 	return err == nil
 }
+
+func GetEnvOrDefault(key string, defaultValue string) string {
+	value, exists := os.LookupEnv(key)
+
+	if !exists {
+		return defaultValue
+	}
+
+	return value
+}
+
+var classCodePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,50}$`)
+
+// Class codes may not contain @ so that they never clash with default class codes, which are teacher emails
+func IsValidClassCode(code string) bool {
+	return classCodePattern.MatchString(code)
+}
+
+// Term codes follow the same rules as class codes
+func IsValidTermCode(code string) bool {
+	return classCodePattern.MatchString(code)
+}
+
+var schoolIDPattern = regexp.MustCompile(`^[a-z0-9-]{1,50}$`)
+
+func IsValidSchoolID(id string) bool {
+	return schoolIDPattern.MatchString(id)
+}
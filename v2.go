@@ -0,0 +1,515 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Resource style routes of /api/v2. They share the operations and Store of the v1 routes.
+
+const (
+	AuditActionUnregister    = "unregister"
+	AuditActionUnsuspend     = "unsuspend"
+	AuditActionDeleteTeacher = "delete_teacher"
+	AuditActionDeleteStudent = "delete_student"
+	AuditActionUpdateTeacher = "update_teacher"
+	AuditActionUpdateStudent = "update_student"
+	AuditActionCreateTeacher = "create_teacher"
+	AuditActionCreateStudent = "create_student"
+)
+
+var errNotFound = errors.New("not found")
+
+func registerV2Routes(api *gin.RouterGroup, store *Store, reads gin.HandlerFunc, writes gin.HandlerFunc, notifications gin.HandlerFunc) {
+	v2 := api.Group("/v2")
+
+	v2.GET("/teachers", reads, RequireRole(RoleAdmin, RoleAuditor), makeHandleFunc(handleListTeachersV2, store))
+	v2.POST("/teachers", writes, RequireRole(RoleAdmin), makeHandleFunc(handleCreateTeacherV2, store))
+	v2.PATCH("/teachers/:email", writes, RequireRole(RoleAdmin, RoleTeacher), makeHandleFunc(handleUpdateTeacherV2, store))
+	v2.DELETE("/teachers/:email", writes, RequireRole(RoleAdmin), makeHandleFunc(handleDeleteTeacherV2, store))
+	v2.GET("/teachers/:email/students", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), RequireTerm(store), makeHandleFunc(handleListStudentsOfTeacherV2, store))
+	v2.PUT("/teachers/:email/students/:student", writes, RequireRole(RoleAdmin, RoleTeacher), RequireTerm(store), makeHandleFunc(handleRegisterStudentV2, store))
+	v2.DELETE("/teachers/:email/students/:student", writes, RequireRole(RoleAdmin, RoleTeacher), RequireTerm(store), makeHandleFunc(handleUnregisterStudentV2, store))
+
+	v2.GET("/students", reads, RequireRole(RoleAdmin, RoleTeacher, RoleAuditor), makeHandleFunc(handleListStudentsV2, store))
+	v2.POST("/students", writes, RequireRole(RoleAdmin), makeHandleFunc(handleCreateStudentV2, store))
+	v2.PATCH("/students/:email", writes, RequireRole(RoleAdmin), makeHandleFunc(handleUpdateStudentV2, store))
+	v2.DELETE("/students/:email", writes, RequireRole(RoleAdmin), makeHandleFunc(handleDeleteStudentV2, store))
+	v2.GET("/students/:email/suspensions", reads, RequireRole(RoleAdmin, RoleAuditor), makeHandleFunc(handleListSuspensionsV2, store))
+	v2.POST("/students/:email/suspensions", writes, RequireRole(RoleAdmin), makeHandleFunc(handleSuspendStudentV2, store))
+	v2.DELETE("/students/:email/suspensions", writes, RequireRole(RoleAdmin), makeHandleFunc(handleUnsuspendStudentV2, store))
+
+	registerClassRoutes(v2, store, reads, writes)
+	registerSchoolRoutes(v2, store, reads, writes)
+	registerTermRoutes(v2, store, reads, writes)
+	registerGuardianRoutes(v2, store, reads, writes)
+	registerPreferenceRoutes(v2, store, reads, writes)
+	registerScheduledNotificationRoutes(v2, store, reads, writes)
+	registerRecurringNotificationRoutes(v2, store, reads, writes)
+	registerTemplateRoutes(v2, store, reads, writes)
+	registerReadReceiptRoutes(v2, store, reads)
+	registerWebhookRoutes(v2, store, reads, writes)
+
+	// Same request and response bodies as their v1 counterparts
+	v2.POST("/registrations", writes, RequireRole(RoleAdmin, RoleTeacher), RequireTerm(store), makeHandleFunc(handleRegister, store))
+	v2.POST("/notifications", notifications, RequireRole(RoleAdmin, RoleTeacher), RequireTerm(store), makeHandleFunc(handleRetrieveNotifications, store))
+	v2.POST("/notifications/mentions", reads, RequireRole(RoleAdmin, RoleTeacher), makeHandleFunc(handleParseMentions, store))
+}
+
+// When the v1 routes were deprecated, sent in the Deprecation header (RFC 9745)
+var v1DeprecatedAt = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+
+// Deprecated marks a v1 route as deprecated in favour of its v2 successor
+func Deprecated(successor string) gin.HandlerFunc {
+	deprecation := fmt.Sprintf("@%d", v1DeprecatedAt.Unix())
+
+	return func(c *gin.Context) {
+		c.Header("Deprecation", deprecation)
+		c.Header("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+		c.Next()
+	}
+}
+
+// parseProfileFilter reads the search params of teacher and student listings, writing a 400 response if any is invalid
+func parseProfileFilter(c *gin.Context) (ProfileFilter, bool) {
+	filter := ProfileFilter{
+		Query:      c.Query("q"),
+		Homeroom:   c.Query("homeroom"),
+		GradeLevel: c.Query("gradeLevel"),
+	}
+
+	if value := c.Query("active"); value != "" {
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "active must be true or false."})
+			return filter, false
+		}
+		filter.Active = &active
+	}
+
+	return filter, true
+}
+
+func handleListTeachersV2(c *gin.Context, store *Store) {
+	filter, ok := parseProfileFilter(c)
+	if !ok {
+		return
+	}
+
+	teachers, err := store.ListTeachers(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to list teachers."})
+		return
+	}
+
+	c.JSON(http.StatusOK, TeachersResponse{Teachers: teachers})
+}
+
+func handleCreateTeacherV2(c *gin.Context, store *Store) {
+	var input CreateTeacherRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "email field is missing or invalid."})
+		return
+	}
+	if !IsValidEmail(input.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Teacher's email (%s) is invalid.", input.Email)})
+		return
+	}
+	if apiErr := checkAllowedDomains(c, input.Email); apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	if apiErr := validateProfileUpdate(&input.ProfileUpdate); apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	entry := newAuditEntry(c, AuditActionCreateTeacher)
+	entry.TeacherEmail = input.Email
+
+	var teacher *Teacher
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		if err := txStore.AddTeacher(NewTeacher(input.Email)); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to add teacher.")
+		}
+
+		var err error
+		if teacher, err = txStore.UpdateTeacher(input.Email, &input.ProfileUpdate); err != nil {
+			apiErr := profileUpdateError(err)
+			if apiErr.Status == http.StatusInternalServerError {
+				apiErr.Message = "failed to add teacher."
+			}
+			return apiErr
+		}
+
+		entry.After = toAuditPayload(teacher)
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusCreated, teacher)
+}
+
+func handleUpdateTeacherV2(c *gin.Context, store *Store) {
+	teacherEmail := c.Param("email")
+	if !authorizeAsTeacher(c, teacherEmail) {
+		return
+	}
+
+	var input ProfileUpdate
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+	if apiErr := validateProfileUpdate(&input); apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	entry := newAuditEntry(c, AuditActionUpdateTeacher)
+	entry.TeacherEmail = teacherEmail
+
+	var teacher *Teacher
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		before, err := txStore.GetTeacher(teacherEmail)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to get teacher.")
+		}
+		if before == nil {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given teacher is not registered.")
+		}
+
+		teacher, err = txStore.UpdateTeacher(teacherEmail, &input)
+		if err != nil {
+			return profileUpdateError(err)
+		}
+
+		entry.Before = toAuditPayload(before)
+		entry.After = toAuditPayload(teacher)
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, teacher)
+}
+
+func handleDeleteTeacherV2(c *gin.Context, store *Store) {
+	teacher := NewTeacher(c.Param("email"))
+
+	entry := newAuditEntry(c, AuditActionDeleteTeacher)
+	entry.TeacherEmail = teacher.Email
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		students, err := txStore.GetStudentsOfTeacher(teacher)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to get registered students.")
+		}
+
+		deleted, err := txStore.DeleteTeacher(teacher)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to delete teacher.")
+		}
+		if !deleted {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given teacher is not registered.")
+		}
+
+		entry.StudentEmails = students
+		entry.Before = toAuditPayload(gin.H{"teacher": teacher, "registeredStudents": students})
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func handleListStudentsOfTeacherV2(c *gin.Context, store *Store) {
+	teacherEmail := c.Param("email")
+	if !authorizeAsTeacher(c, teacherEmail) {
+		return
+	}
+
+	isTeacherExists, err := store.IfTeacherExists(teacherEmail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "Something went wrong when checking if teacher is registered."})
+		return
+	}
+	if !isTeacherExists {
+		c.JSON(http.StatusNotFound, gin.H{"error": errNotFound.Error(), "message": "Given teacher is not registered."})
+		return
+	}
+
+	filter, ok := parseProfileFilter(c)
+	if !ok {
+		return
+	}
+
+	studentEmails, err := store.GetStudentsOfTeacher(NewTeacher(teacherEmail))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get registered students."})
+		return
+	}
+
+	filter.Emails = studentEmails
+	students, err := store.ListStudents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to list students."})
+		return
+	}
+
+	c.JSON(http.StatusOK, StudentsResponse{Students: students})
+}
+
+func handleRegisterStudentV2(c *gin.Context, store *Store) {
+	input := RegisterRequest{Teacher: c.Param("email"), Students: []string{c.Param("student")}}
+	if !authorizeAsTeacher(c, input.Teacher) {
+		return
+	}
+
+	if apiErr := registerStudents(c, store, input); apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func handleUnregisterStudentV2(c *gin.Context, store *Store) {
+	pair := NewTeacherStudentPair(c.Param("email"), c.Param("student"))
+	if !authorizeAsTeacher(c, pair.TeacherEmail) {
+		return
+	}
+
+	entry := newAuditEntry(c, AuditActionUnregister)
+	entry.TeacherEmail = pair.TeacherEmail
+	entry.StudentEmails = []string{pair.StudentEmail}
+	entry.Before = toAuditPayload(gin.H{"registered": true})
+	entry.After = toAuditPayload(gin.H{"registered": false})
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		unregistered, err := txStore.Unregister(pair)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to unregister student.")
+		}
+		if !unregistered {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given student is not registered to the teacher.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func handleListStudentsV2(c *gin.Context, store *Store) {
+	filter, ok := parseProfileFilter(c)
+	if !ok {
+		return
+	}
+
+	// Filtering by teachers returns the students common to all of them
+	if teacherEmails := c.QueryArray("teacher"); len(teacherEmails) != 0 {
+		studentEmails, apiErr := getCommonStudents(c, store, teacherEmails, c.Query("class"))
+		if apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+		filter.Emails = studentEmails
+	} else if principal := GetPrincipal(c); principal != nil && principal.Role == RoleTeacher {
+		c.JSON(http.StatusForbidden, gin.H{"error": errForbidden.Error(), "message": "Teachers may only list their own students."})
+		return
+	}
+
+	students, err := store.ListStudents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to list students."})
+		return
+	}
+
+	c.JSON(http.StatusOK, StudentsResponse{Students: students})
+}
+
+func handleCreateStudentV2(c *gin.Context, store *Store) {
+	var input CreateStudentRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "email field is missing or invalid."})
+		return
+	}
+	if !IsValidEmail(input.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Student's email (%s) is invalid.", input.Email)})
+		return
+	}
+	if apiErr := checkAllowedDomains(c, input.Email); apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	if apiErr := validateStudentProfileUpdate(&input.StudentProfileUpdate); apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	entry := newAuditEntry(c, AuditActionCreateStudent)
+	entry.StudentEmails = []string{input.Email}
+
+	var student *Student
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		if err := txStore.AddStudent(NewStudent(input.Email)); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to add student.")
+		}
+
+		var err error
+		if student, err = txStore.UpdateStudent(input.Email, &input.StudentProfileUpdate); err != nil {
+			apiErr := profileUpdateError(err)
+			if apiErr.Status == http.StatusInternalServerError {
+				apiErr.Message = "failed to add student."
+			}
+			return apiErr
+		}
+
+		entry.After = toAuditPayload(student)
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusCreated, student)
+}
+
+func handleUpdateStudentV2(c *gin.Context, store *Store) {
+	studentEmail := c.Param("email")
+
+	var input StudentProfileUpdate
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+	if apiErr := validateStudentProfileUpdate(&input); apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	entry := newAuditEntry(c, AuditActionUpdateStudent)
+	entry.StudentEmails = []string{studentEmail}
+
+	var student *Student
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		before, err := txStore.GetStudent(studentEmail)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to get student.")
+		}
+		if before == nil {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given student is not registered.")
+		}
+
+		student, err = txStore.UpdateStudent(studentEmail, &input)
+		if err != nil {
+			return profileUpdateError(err)
+		}
+
+		entry.Before = toAuditPayload(before)
+		entry.After = toAuditPayload(student)
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, student)
+}
+
+func handleDeleteStudentV2(c *gin.Context, store *Store) {
+	student := NewStudent(c.Param("email"))
+
+	entry := newAuditEntry(c, AuditActionDeleteStudent)
+	entry.StudentEmails = []string{student.Email}
+	entry.Before = toAuditPayload(gin.H{"student": student})
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		deleted, err := txStore.DeleteStudent(student)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to delete student.")
+		}
+		if !deleted {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given student is not registered.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func handleListSuspensionsV2(c *gin.Context, store *Store) {
+	suspensions, err := store.GetSuspensions(c.Param("email"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get suspensions."})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuspensionsResponse{Suspensions: suspensions})
+}
+
+func handleSuspendStudentV2(c *gin.Context, store *Store) {
+	if apiErr := suspendStudent(c, store, c.Param("email")); apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusCreated)
+}
+
+func handleUnsuspendStudentV2(c *gin.Context, store *Store) {
+	studentEmail := c.Param("email")
+	liftedAt := time.Now().UTC()
+
+	entry := newAuditEntry(c, AuditActionUnsuspend)
+	entry.StudentEmails = []string{studentEmail}
+	entry.Before = toAuditPayload(gin.H{"suspended": true})
+	entry.After = toAuditPayload(gin.H{"suspended": false, "suspendedUntil": liftedAt})
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		lifted, err := txStore.LiftSuspensions(studentEmail, liftedAt)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to unsuspend student.")
+		}
+		if !lifted {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given student is not suspended.")
+		}
+		if err := enqueueWebhookEvent(txStore, WebhookEventStudentUnsuspended, WebhookSuspensionData{Student: studentEmail}); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to queue webhook event.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
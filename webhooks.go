@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Webhooks receive the events of their school as signed JSON POSTs. Events are written to the webhook_deliveries
+// outbox in the transaction of the change, then sent by the dispatcher of every server, retrying with exponential
+// backoff until the webhook responds with a 2xx. Deliveries are at least once, receivers dedupe by event id.
+
+const (
+	AuditActionCreateWebhook    = "create_webhook"
+	AuditActionUpdateWebhook    = "update_webhook"
+	AuditActionDeleteWebhook    = "delete_webhook"
+	AuditActionRedeliverWebhook = "redeliver_webhook"
+
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookSignatureHeader = "X-Webhook-Signature"
+
+	// Deliveries claimed by the dispatcher at a time, and sent concurrently
+	webhookBatchSize = 20
+	// Longest error kept in the delivery log
+	maxWebhookErrorLength = 500
+)
+
+var webhookDeliveryStatuses = []string{WebhookDeliveryPending, WebhookDeliveryDelivered, WebhookDeliveryFailed}
+
+type WebhookConfig struct {
+	Interval    time.Duration
+	Timeout     time.Duration
+	MaxAttempts int
+	// Delay before the first retry, doubled after each failed attempt up to MaxRetryDelay
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+}
+
+func LoadWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		Interval:      time.Duration(getEnvInt("WEBHOOK_INTERVAL_SECONDS", 5)) * time.Second,
+		Timeout:       time.Duration(getEnvInt("WEBHOOK_TIMEOUT_SECONDS", 10)) * time.Second,
+		MaxAttempts:   getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		RetryDelay:    time.Duration(getEnvInt("WEBHOOK_RETRY_SECONDS", 30)) * time.Second,
+		MaxRetryDelay: time.Duration(getEnvInt("WEBHOOK_MAX_RETRY_SECONDS", 3600)) * time.Second,
+	}
+}
+
+func registerWebhookRoutes(v2 *gin.RouterGroup, store *Store, reads gin.HandlerFunc, writes gin.HandlerFunc) {
+	v2.GET("/webhooks", reads, RequireRole(RoleAdmin, RoleAuditor), makeHandleFunc(handleListWebhooks, store))
+	v2.POST("/webhooks", writes, RequireRole(RoleAdmin), makeHandleFunc(handleCreateWebhook, store))
+	v2.GET("/webhooks/:id", reads, RequireRole(RoleAdmin, RoleAuditor), makeHandleFunc(handleGetWebhook, store))
+	v2.PATCH("/webhooks/:id", writes, RequireRole(RoleAdmin), makeHandleFunc(handleUpdateWebhook, store))
+	v2.DELETE("/webhooks/:id", writes, RequireRole(RoleAdmin), makeHandleFunc(handleDeleteWebhook, store))
+	v2.GET("/webhooks/:id/deliveries", reads, RequireRole(RoleAdmin, RoleAuditor), makeHandleFunc(handleListWebhookDeliveries, store))
+	v2.GET("/webhooks/:id/deliveries/:delivery", reads, RequireRole(RoleAdmin, RoleAuditor), makeHandleFunc(handleGetWebhookDelivery, store))
+	v2.POST("/webhooks/:id/deliveries/:delivery/redeliver", writes, RequireRole(RoleAdmin), makeHandleFunc(handleRedeliverWebhook, store))
+}
+
+// enqueueWebhookEvent writes the event to the outbox of the webhooks subscribed to it. It must be given the store of
+// the change's transaction, so that events are only delivered for committed changes.
+func enqueueWebhookEvent(store *Store, eventType string, data interface{}) error {
+	id, err := newRandomID()
+	if err != nil {
+		return err
+	}
+	event := &WebhookEvent{ID: id, Type: eventType, OccurredAt: time.Now().UTC(), School: store.school, Data: data}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return store.AddWebhookEvent(event, payload)
+}
+
+// SignWebhookPayload returns the hex HMAC-SHA256 of the timestamp and payload joined by a dot, sent as
+// sha256=<signature> so that receivers can check both
+func SignWebhookPayload(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// runWebhookDispatcher sends the webhook deliveries that are due every interval. Every server runs it, each delivery
+// is attempted by whichever claims it first.
+func runWebhookDispatcher(store *Store, config WebhookConfig) {
+	client := NewWebhookClient(config)
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := deliverDueWebhooks(store, client, config, time.Now().UTC()); err != nil {
+			log.Printf("Failed to deliver webhooks: %v", err)
+		}
+	}
+}
+
+func NewWebhookClient(config WebhookConfig) *http.Client {
+	return &http.Client{
+		Timeout: config.Timeout,
+		// Redirects are failures, so that deliveries only go to the configured URL
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// deliverDueWebhooks attempts the deliveries of all schools due at the given time and returns how many were attempted
+func deliverDueWebhooks(store *Store, client *http.Client, config WebhookConfig, now time.Time) (int, error) {
+	count := 0
+	for {
+		// Claimed deliveries are attempted again by any server if their outcome is not recorded before the lease ends
+		deliveries, err := store.ClaimDueWebhookDeliveries(now, time.Now().UTC().Add(2*config.Timeout), webhookBatchSize)
+		if err != nil {
+			return count, err
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func(delivery *DueWebhookDelivery) {
+				defer wg.Done()
+				attemptWebhookDelivery(client, config, delivery, time.Now().UTC())
+				if err := store.RecordWebhookAttempt(&delivery.WebhookDelivery); err != nil {
+					log.Printf("Failed to record webhook delivery %d: %v", delivery.ID, err)
+				}
+			}(delivery)
+		}
+		wg.Wait()
+
+		count += len(deliveries)
+		if len(deliveries) < webhookBatchSize {
+			return count, nil
+		}
+	}
+}
+
+// attemptWebhookDelivery sends the delivery and sets the outcome of the attempt, scheduling a retry if it failed and
+// attempts remain
+func attemptWebhookDelivery(client *http.Client, config WebhookConfig, delivery *DueWebhookDelivery, now time.Time) {
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.NextAttemptAt = nil
+
+	status, err := sendWebhook(client, delivery, now)
+	delivery.ResponseStatus = status
+	if err == nil {
+		delivery.Status = WebhookDeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if len(delivery.LastError) > maxWebhookErrorLength {
+		delivery.LastError = delivery.LastError[:maxWebhookErrorLength]
+	}
+	if delivery.Attempts >= config.MaxAttempts {
+		delivery.Status = WebhookDeliveryFailed
+		return
+	}
+	next := now.Add(webhookRetryDelay(config, delivery.Attempts))
+	delivery.NextAttemptAt = &next
+}
+
+// webhookRetryDelay returns how long to wait after the given number of failed attempts
+func webhookRetryDelay(config WebhookConfig, attempts int) time.Duration {
+	delay := config.RetryDelay
+	for i := 1; i < attempts && delay < config.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, config.MaxRetryDelay)
+}
+
+// sendWebhook posts the signed payload of the delivery, returning the response status and an error unless it is 2xx
+func sendWebhook(client *http.Client, delivery *DueWebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, delivery.EventType)
+	req.Header.Set(webhookDeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+SignWebhookPayload(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain some of the body so that the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// validateWebhookURL only allows absolute http and https URLs
+func validateWebhookURL(rawURL string) *apiError {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return newAPIError(http.StatusBadRequest, nil, "url must be an absolute http or https URL.")
+	}
+	return nil
+}
+
+// withoutSecret returns a copy of the webhook to audit, which must never include its secret
+func withoutSecret(webhook *Webhook) Webhook {
+	audited := *webhook
+	audited.Secret = ""
+	return audited
+}
+
+func getWebhook(store *Store, id string) (*Webhook, *apiError) {
+	webhook, err := store.GetWebhook(id)
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, err, "failed to get webhook.")
+	}
+	if webhook == nil {
+		return nil, newAPIError(http.StatusNotFound, errNotFound, "Given webhook does not exist.")
+	}
+	return webhook, nil
+}
+
+func handleListWebhooks(c *gin.Context, store *Store) {
+	webhooks, err := store.ListWebhooks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to list webhooks."})
+		return
+	}
+
+	c.JSON(http.StatusOK, WebhooksResponse{Webhooks: webhooks})
+}
+
+func handleGetWebhook(c *gin.Context, store *Store) {
+	webhook, apiErr := getWebhook(store, c.Param("id"))
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+// handleCreateWebhook returns the webhook with its secret, which is not returned again
+func handleCreateWebhook(c *gin.Context, store *Store) {
+	var input CreateWebhookRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+	if apiErr := validateWebhookURL(input.URL); apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	id, err := newRandomID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to create webhook."})
+		return
+	}
+	secret := input.Secret
+	if secret == "" {
+		if secret, err = newRandomID(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to create webhook."})
+			return
+		}
+	}
+	entry := newAuditEntry(c, AuditActionCreateWebhook)
+	webhook := NewWebhook(id, input, secret, entry.Actor)
+	entry.After = toAuditPayload(withoutSecret(webhook))
+
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		if err := txStore.AddWebhook(webhook); err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to create webhook.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Header("Location", "/api/v2/webhooks/"+webhook.ID)
+	c.JSON(http.StatusCreated, webhook)
+}
+
+// handleUpdateWebhook changes the given fields, returning the secret only if it was changed
+func handleUpdateWebhook(c *gin.Context, store *Store) {
+	var input UpdateWebhookRequest
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "message": "One or more fields are missing or invalid."})
+		return
+	}
+	if input.URL != nil {
+		if apiErr := validateWebhookURL(*input.URL); apiErr != nil {
+			apiErr.respond(c)
+			return
+		}
+	}
+
+	existing, apiErr := getWebhook(store, c.Param("id"))
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	updated := *existing
+	if input.URL != nil {
+		updated.URL = *input.URL
+	}
+	if input.Events != nil {
+		updated.Events = input.Events
+	}
+	if input.Secret != nil {
+		updated.Secret = *input.Secret
+	}
+	if input.Active != nil {
+		updated.Active = *input.Active
+	}
+	updated.UpdatedAt = time.Now().UTC()
+
+	entry := newAuditEntry(c, AuditActionUpdateWebhook)
+	entry.Before = toAuditPayload(existing)
+	after := gin.H{"webhook": withoutSecret(&updated), "secretChanged": input.Secret != nil}
+	entry.After = toAuditPayload(after)
+
+	apiErr = withAudit(store, entry, func(txStore *Store) *apiError {
+		changed, err := txStore.UpdateWebhook(&updated)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to update webhook.")
+		}
+		if !changed {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given webhook does not exist.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+func handleDeleteWebhook(c *gin.Context, store *Store) {
+	existing, apiErr := getWebhook(store, c.Param("id"))
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	entry := newAuditEntry(c, AuditActionDeleteWebhook)
+	entry.Before = toAuditPayload(existing)
+
+	apiErr = withAudit(store, entry, func(txStore *Store) *apiError {
+		deleted, err := txStore.DeleteWebhook(existing.ID)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to delete webhook.")
+		}
+		if !deleted {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given webhook does not exist.")
+		}
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func handleListWebhookDeliveries(c *gin.Context, store *Store) {
+	status := c.Query("status")
+	if status != "" && !slices.Contains(webhookDeliveryStatuses, status) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("status must be one of %v.", webhookDeliveryStatuses)})
+		return
+	}
+	limit, beforeID, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	webhook, apiErr := getWebhook(store, c.Param("id"))
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	// Fetch one extra delivery to know whether there is a next page
+	deliveries, err := store.ListWebhookDeliveries(webhook.ID, status, beforeID, limit+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to list webhook deliveries."})
+		return
+	}
+
+	response := WebhookDeliveriesResponse{Deliveries: deliveries}
+	if len(deliveries) > limit {
+		response.Deliveries = deliveries[:limit]
+		cursor := strconv.FormatInt(deliveries[limit-1].ID, 10)
+		response.NextCursor = &cursor
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// parseDeliveryID returns the delivery id of the path, writing a 404 response if it cannot be one
+func parseDeliveryID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("delivery"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": errNotFound.Error(), "message": "Given delivery does not exist."})
+		return 0, false
+	}
+	return id, true
+}
+
+func handleGetWebhookDelivery(c *gin.Context, store *Store) {
+	id, ok := parseDeliveryID(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := store.GetWebhookDelivery(c.Param("id"), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "message": "failed to get webhook delivery."})
+		return
+	}
+	if delivery == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": errNotFound.Error(), "message": "Given delivery does not exist."})
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+// handleRedeliverWebhook sends the event of a delivery again as a new delivery, keeping the log of the original
+func handleRedeliverWebhook(c *gin.Context, store *Store) {
+	id, ok := parseDeliveryID(c)
+	if !ok {
+		return
+	}
+	webhookID := c.Param("id")
+
+	entry := newAuditEntry(c, AuditActionRedeliverWebhook)
+	var delivery *WebhookDelivery
+	apiErr := withAudit(store, entry, func(txStore *Store) *apiError {
+		var err error
+		delivery, err = txStore.AddWebhookRedelivery(webhookID, id, time.Now().UTC())
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, err, "failed to redeliver webhook.")
+		}
+		if delivery == nil {
+			return newAPIError(http.StatusNotFound, errNotFound, "Given delivery does not exist.")
+		}
+
+		entry.After = toAuditPayload(gin.H{"webhookId": webhookID, "deliveryId": delivery.ID, "redeliveryOf": id, "eventId": delivery.EventID})
+		return nil
+	})
+	if apiErr != nil {
+		apiErr.respond(c)
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/api/v2/webhooks/%s/deliveries/%d", webhookID, delivery.ID))
+	c.JSON(http.StatusCreated, delivery)
+}
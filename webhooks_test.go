@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttemptWebhookDelivery(t *testing.T) {
+	config := WebhookConfig{Timeout: time.Second, MaxAttempts: 3, RetryDelay: time.Minute, MaxRetryDelay: time.Hour}
+	now := time.Now().UTC()
+	payload := types.JSONText(`{"id":"abc","type":"student.suspended"}`)
+
+	status := http.StatusOK
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	delivery := &DueWebhookDelivery{
+		WebhookDelivery: WebhookDelivery{ID: 7, EventType: WebhookEventStudentSuspended, Payload: payload, Status: WebhookDeliveryPending},
+		URL:             server.URL,
+		Secret:          "0123456789abcdef",
+	}
+
+	// Signed with the timestamp so that receivers can reject replays
+	attemptWebhookDelivery(NewWebhookClient(config), config, delivery, now)
+	require.Equal(t, WebhookDeliveryDelivered, delivery.Status)
+	require.Equal(t, 1, delivery.Attempts)
+	require.Equal(t, http.StatusOK, delivery.ResponseStatus)
+	require.Nil(t, delivery.NextAttemptAt)
+	require.Equal(t, []byte(payload), body)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	require.Equal(t, timestamp, received.Header.Get(webhookTimestampHeader))
+	require.Equal(t, "7", received.Header.Get(webhookDeliveryHeader))
+	require.Equal(t, WebhookEventStudentSuspended, received.Header.Get(webhookEventHeader))
+	require.Equal(t, "sha256="+SignWebhookPayload("0123456789abcdef", timestamp, payload), received.Header.Get(webhookSignatureHeader))
+
+	// Failures are retried with backoff until the attempts run out
+	status = http.StatusServiceUnavailable
+	delivery.Status, delivery.Attempts = WebhookDeliveryPending, 1
+	attemptWebhookDelivery(NewWebhookClient(config), config, delivery, now)
+	require.Equal(t, WebhookDeliveryPending, delivery.Status)
+	require.Equal(t, http.StatusServiceUnavailable, delivery.ResponseStatus)
+	require.Equal(t, "webhook responded with 503 Service Unavailable", delivery.LastError)
+	require.Equal(t, now.Add(2*time.Minute), *delivery.NextAttemptAt)
+
+	attemptWebhookDelivery(NewWebhookClient(config), config, delivery, now)
+	require.Equal(t, WebhookDeliveryFailed, delivery.Status)
+	require.Equal(t, 3, delivery.Attempts)
+	require.Nil(t, delivery.NextAttemptAt)
+
+	// Redirects are not followed
+	redirect := httptest.NewServer(http.RedirectHandler(server.URL, http.StatusFound))
+	defer redirect.Close()
+	delivery.URL, delivery.Status, delivery.Attempts = redirect.URL, WebhookDeliveryPending, 0
+	attemptWebhookDelivery(NewWebhookClient(config), config, delivery, now)
+	require.Equal(t, http.StatusFound, delivery.ResponseStatus)
+	require.Equal(t, now.Add(time.Minute), *delivery.NextAttemptAt)
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	config := WebhookConfig{RetryDelay: 30 * time.Second, MaxRetryDelay: 5 * time.Minute}
+
+	require.Equal(t, 30*time.Second, webhookRetryDelay(config, 1))
+	require.Equal(t, time.Minute, webhookRetryDelay(config, 2))
+	require.Equal(t, 4*time.Minute, webhookRetryDelay(config, 4))
+	require.Equal(t, 5*time.Minute, webhookRetryDelay(config, 5))
+	require.Equal(t, 5*time.Minute, webhookRetryDelay(config, 100))
+}